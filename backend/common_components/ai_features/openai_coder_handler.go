@@ -4,6 +4,7 @@ package openai
 import (
 	"context"
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/embeddings"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -14,7 +15,6 @@ import (
 	"strings"
 	"time"
 
-	pgvector "github.com/pgvector/pgvector-go"
 	"github.com/sashabaranov/go-openai"
 )
 
@@ -275,32 +275,14 @@ func removeJsonFence(s string) string {
 func do_semantic_search_in_file_structure(ctx context.Context, user_query string) ([]string, error) {
 	db := backend.Db
 
-	openai_key := os.Getenv("OPENAI_API_KEY")
-	if openai_key == "" {
-		return nil, fmt.Errorf("missing OPENAI_API_KEY")
-	}
-	embedding_model := os.Getenv("OPENAI_EMBEDDING_MODEL")
-	if embedding_model == "" {
-		embedding_model = "text-embedding-ada-002"
-	}
-
-	client := openai.NewClient(openai_key)
-
-	embed_req := openai.EmbeddingRequest{
-		Model: openai.EmbeddingModel(embedding_model),
-		Input: []string{user_query},
-	}
-	embed_resp, err := client.CreateEmbeddings(ctx, embed_req)
+	vector_val, provider, err := embeddings.EmbedText(ctx, user_query)
 	if err != nil {
-		return nil, fmt.Errorf("createEmbeddings error: %w", err)
+		return nil, fmt.Errorf("embedding error: %w", err)
 	}
-	if len(embed_resp.Data) == 0 {
-		return nil, fmt.Errorf("createEmbeddings returned no data")
+	if err := embeddings.CheckColumnModel("file_structure", "openai_embedding", provider, len(vector_val.Slice())); err != nil {
+		return nil, err
 	}
 
-	embedding := embed_resp.Data[0].Embedding
-	vector_val := pgvector.NewVector(embedding)
-
 	query := `
         SELECT name, parent_folder
         FROM file_structure
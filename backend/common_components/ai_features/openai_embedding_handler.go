@@ -4,21 +4,22 @@ import (
 	"context"
 	"database/sql"
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/embeddings"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	pgvector "github.com/pgvector/pgvector-go"
-	"github.com/sashabaranov/go-openai"
 )
 
 // OpenAIEmbeddingStreamHandler lukee kaikki sarakkeet jokaiselta riviltä,
 // kokoaa vain tekstisarakkeet (VARCHAR/TEXT) yhdeksi tekstilauseeksi ja generoi
 // embeddingin openai_embedding-sarakkeeseen (lisää sarakkeen jos sitä ei ole).
+// Embedding-palvelu valitaan ympäristömuuttujista (ks. embeddings.FromEnv).
 func OpenaiEmbeddingStreamHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "only GET method allowed for SSE", http.StatusMethodNotAllowed)
@@ -31,17 +32,12 @@ func OpenaiEmbeddingStreamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	openaiKey := os.Getenv("OPENAI_API_KEY")
-	if openaiKey == "" {
-		http.Error(w, "missing OPENAI_API_KEY", http.StatusInternalServerError)
+	provider, err := embeddings.FromEnv()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	embeddingModel := os.Getenv("OPENAI_EMBEDDING_MODEL")
-	if embeddingModel == "" {
-		embeddingModel = "text-embedding-ada-002"
-	}
-
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -67,7 +63,7 @@ func OpenaiEmbeddingStreamHandler(w http.ResponseWriter, r *http.Request) {
 		  AND column_name = 'openai_embedding'
 	`
 	var existingColumn string
-	err := db.QueryRow(colCheckQuery, tableName).Scan(&existingColumn)
+	err = db.QueryRow(colCheckQuery, tableName).Scan(&existingColumn)
 	if err == sql.ErrNoRows {
 		// Luodaan sarake, jos ei löydy
 		alterQuery := fmt.Sprintf("ALTER TABLE %s ADD COLUMN openai_embedding vector", tableName)
@@ -144,12 +140,17 @@ func OpenaiEmbeddingStreamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := openai.NewClient(openaiKey)
 	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
 	defer cancel()
 
 	var totalRows int
 	var embeddedCount int
+	var failedCount int
+	modelChecked := false
+	// staged: sarakkeen malli vaihtuu, joten vektorit kirjoitetaan välitauluun ja
+	// vaihdetaan vasta, kun koko taulu on käyty läpi ilman virheitä.
+	staged := false
+	var embeddingDim int
 
 	numCols := len(columns)
 
@@ -164,6 +165,7 @@ func OpenaiEmbeddingStreamHandler(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(ptrs...); err != nil {
 			log.Printf("scan error: %v", err)
 			sendSSE("error", escape_for_sse(fmt.Sprintf("scan error row=%d: %v", totalRows, err)))
+			failedCount++
 			continue
 		}
 
@@ -178,6 +180,7 @@ func OpenaiEmbeddingStreamHandler(w http.ResponseWriter, r *http.Request) {
 			rowID = v
 		default:
 			sendSSE("error", escape_for_sse(fmt.Sprintf("row has non-int id: %v", rowIDVal)))
+			failedCount++
 			continue
 		}
 
@@ -193,26 +196,36 @@ func OpenaiEmbeddingStreamHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		embedReq := openai.EmbeddingRequest{
-			Model: openai.EmbeddingModel(embeddingModel),
-			Input: []string{rowText},
-		}
-		embedResp, err := client.CreateEmbeddings(ctx, embedReq)
+		vectors, err := provider.Embed(ctx, []string{rowText})
 		if err != nil {
 			log.Printf("embedding error (id=%d): %v", rowID, err)
 			sendSSE("error", escape_for_sse(fmt.Sprintf("row=%d: %v", rowID, err)))
-			continue
-		}
-		if len(embedResp.Data) == 0 {
-			log.Printf("no embedding data returned for row %d", rowID)
-			sendSSE("error", escape_for_sse(fmt.Sprintf("no embedding data row=%d", rowID)))
+			failedCount++
 			continue
 		}
 
-		embeddingVec := embedResp.Data[0].Embedding
-		if err := storeEmbeddingInDB(db, tableName, rowID, embeddingVec); err != nil {
+		embeddingVec := vectors[0]
+		if !modelChecked {
+			// Ensimmäisen vektorin jälkeen tiedetään ulottuvuus. Jos sarake on tehty eri
+			// mallilla, vanhat vektorit jäävät käyttöön uudelleenlaskennan ajaksi.
+			embeddingDim = len(embeddingVec)
+			staged, err = columnModelChanged(tableName, provider, embeddingDim, sendSSE)
+			if err != nil {
+				log.Printf("embedding model error: %v", err)
+				sendSSE("error", escape_for_sse(fmt.Sprintf("embedding model error: %v", err)))
+				return
+			}
+			modelChecked = true
+		}
+		if staged {
+			err = embeddings.StageEmbedding(tableName, int64(rowID), embeddingVec)
+		} else {
+			err = storeEmbeddingInDB(db, tableName, rowID, embeddingVec)
+		}
+		if err != nil {
 			log.Printf("store embedding error (id=%d): %v", rowID, err)
 			sendSSE("error", escape_for_sse(fmt.Sprintf("row=%d: %v", rowID, err)))
+			failedCount++
 			continue
 		}
 
@@ -222,6 +235,23 @@ func OpenaiEmbeddingStreamHandler(w http.ResponseWriter, r *http.Request) {
 	if err := rows.Err(); err != nil && err != io.EOF {
 		log.Printf("rows iteration error: %v", err)
 		sendSSE("error", escape_for_sse(fmt.Sprintf("rows error: %v", err)))
+		failedCount++
+	}
+
+	if staged {
+		if failedCount > 0 {
+			// Osittaista uudelleenlaskentaa ei oteta käyttöön; vanhat vektorit jäävät voimaan.
+			if err := embeddings.DiscardStagedEmbeddings(tableName); err != nil {
+				log.Printf("discard staged embeddings error: %v", err)
+			}
+			sendSSE("error", escape_for_sse(fmt.Sprintf("%d rows failed; existing embeddings kept", failedCount)))
+			return
+		}
+		if err := embeddings.CommitStagedEmbeddings(tableName, "openai_embedding", provider, embeddingDim); err != nil {
+			log.Printf("commit staged embeddings error: %v", err)
+			sendSSE("error", escape_for_sse(fmt.Sprintf("embedding model error: %v", err)))
+			return
+		}
 	}
 
 	sendSSE("done", escape_for_sse(fmt.Sprintf("embedding finished. total=%d, embedded=%d", totalRows, embeddedCount)))
@@ -263,6 +293,21 @@ func buildRowText(columns []string, values []interface{}, textColumnsSet map[str
 	return sb.String()
 }
 
+// columnModelChanged tallentaa sarakkeen embedding-mallin, jos sitä ei vielä ole. Jos
+// sarakkeeseen on tallennettu eri malli, palauttaa true: uudet vektorit välitallennetaan
+// ja aiemmat keskeneräiset välitallennukset poistetaan.
+func columnModelChanged(tableName string, provider embeddings.EmbeddingProvider, dim int, sendSSE func(eventName, data string)) (bool, error) {
+	err := embeddings.RecordColumnModel(tableName, "openai_embedding", provider, dim)
+	if !errors.Is(err, embeddings.ErrModelMismatch) {
+		return false, err
+	}
+	sendSSE("progress", escape_for_sse(fmt.Sprintf("%v; existing embeddings are replaced after all rows are done", err)))
+	if err := embeddings.DiscardStagedEmbeddings(tableName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func storeEmbeddingInDB(db *sql.DB, tableName string, rowID int, embedding []float32) error {
 	vectorVal := pgvector.NewVector(embedding)
 	sqlStr := fmt.Sprintf("UPDATE %s SET openai_embedding = $1 WHERE id = $2", tableName)
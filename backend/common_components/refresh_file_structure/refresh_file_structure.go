@@ -19,9 +19,9 @@ import (
 	"time"
 
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/embeddings"
 
 	pgvectorgo "github.com/pgvector/pgvector-go"
-	"github.com/sashabaranov/go-openai"
 )
 
 var base_path string
@@ -304,36 +304,20 @@ func read_file_content_as_string(file_path string) (string, error) {
 }
 
 // fetch_embedding_from_openai hakee annetulle tekstisisällölle embeddings
-// ja palauttaa sen pgvectorgo.Vector -muodossa.
+// ympäristön embedding-palvelulla ja palauttaa sen pgvectorgo.Vector -muodossa.
+// Nimi on säilytetty, vaikka palvelu voi olla myös paikallinen (ks. embeddings.FromEnv).
 func fetch_embedding_from_openai(file_content string) (pgvectorgo.Vector, error) {
-	openai_key := os.Getenv("OPENAI_API_KEY")
-	if openai_key == "" {
-		return pgvectorgo.Vector{}, logError("missing OPENAI_API_KEY")
-	}
-	embedding_model := os.Getenv("OPENAI_EMBEDDING_MODEL")
-	if embedding_model == "" {
-		embedding_model = "text-embedding-ada-002"
-	}
-
-	client := openai.NewClient(openai_key)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	req := openai.EmbeddingRequest{
-		Model: openai.EmbeddingModel(embedding_model),
-		Input: []string{file_content},
-	}
-
-	resp, err := client.CreateEmbeddings(ctx, req)
+	vector_val, provider, err := embeddings.EmbedText(ctx, file_content)
 	if err != nil {
-		return pgvectorgo.Vector{}, logError("openai embedding error: %v", err)
+		return pgvectorgo.Vector{}, logError("embedding error: %v", err)
 	}
-	if len(resp.Data) == 0 {
-		return pgvectorgo.Vector{}, logError("empty embedding response")
+	if err := embeddings.RecordColumnModel("file_structure", "openai_embedding", provider, len(vector_val.Slice())); err != nil {
+		return pgvectorgo.Vector{}, logError("%v", err)
 	}
-
-	embedding_data := resp.Data[0].Embedding
-	return pgvectorgo.NewVector(embedding_data), nil
+	return vector_val, nil
 }
 
 // get_existing_md5 hakee kannasta olemassa olevan md5-summan, jos rivi on jo siellä.
@@ -18,6 +18,9 @@ var (
 	DbConfidential *sql.DB
 	DbBasic        *sql.DB
 	DbGuest        *sql.DB
+
+	// DbAdminConnStr tarvitaan pq.NewListenerille, joka avaa oman yhteytensä (LISTEN/NOTIFY)
+	DbAdminConnStr string
 )
 
 func InitDB() error {
@@ -97,6 +100,9 @@ func InitDB() error {
 		}
 
 		*conn.dbPointer = dbInstance
+		if conn.dbPointer == &DbAdmin {
+			DbAdminConnStr = connectionString
+		}
 	}
 
 	// Sovelluksen käynnistyessä otetaan käyttöön DbGuest
@@ -0,0 +1,131 @@
+// file: column_model.go
+package embeddings
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	backend "easelect/backend/core_components"
+)
+
+// Embedding-sarakkeen (openai_embedding) vektorit ovat vertailukelpoisia vain saman
+// mallin kesken. Malli, palvelu ja ulottuvuus tallennetaan sarakkeen
+// system_column_details-riville ensimmäisen tallennuksen yhteydessä; myöhemmät
+// tallennukset ja haut tarkistetaan sitä vasten. Jos taulua ei ole system_db_tables-
+// taulussa, tarkistusta ei tehdä.
+
+// ErrModelMismatch palautetaan, kun sarakkeen vektorit on tehty eri mallilla.
+var ErrModelMismatch = errors.New("embedding-sarakkeen malli ei vastaa käytettyä mallia")
+
+// ColumnModel on sarakkeelle tallennettu embedding-malli.
+type ColumnModel struct {
+	Provider string
+	Model    string
+	Dim      int
+}
+
+// EnsureEmbeddingModelColumns lisää system_column_details-tauluun embedding-mallin
+// sarakkeet, jos niitä ei vielä ole. Kutsutaan käynnistyksen yhteydessä.
+func EnsureEmbeddingModelColumns() error {
+	_, err := backend.Db.Exec(`
+		ALTER TABLE system_column_details
+		ADD COLUMN IF NOT EXISTS embedding_provider TEXT,
+		ADD COLUMN IF NOT EXISTS embedding_model TEXT,
+		ADD COLUMN IF NOT EXISTS embedding_dim INTEGER
+	`)
+	if err != nil {
+		return fmt.Errorf("embedding-mallin sarakkeiden lisäys epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// fetchColumnModel palauttaa sarakkeen tallennetun mallin. found on false, jos
+// saraketta ei ole system_column_details-taulussa; model.Model on tyhjä, jos
+// mallia ei ole vielä tallennettu.
+func fetchColumnModel(tableName, columnName string) (model ColumnModel, found bool, err error) {
+	var provider, modelName sql.NullString
+	var dim sql.NullInt64
+	err = backend.Db.QueryRow(`
+		SELECT scd.embedding_provider, scd.embedding_model, scd.embedding_dim
+		FROM system_column_details scd
+		JOIN system_db_tables sdt ON sdt.table_uid = scd.table_uid
+		WHERE sdt.table_name = $1
+		  AND scd.column_name = $2
+		LIMIT 1
+	`, tableName, columnName).Scan(&provider, &modelName, &dim)
+	if err == sql.ErrNoRows {
+		return ColumnModel{}, false, nil
+	}
+	if err != nil {
+		return ColumnModel{}, false, fmt.Errorf("embedding-mallin haku sarakkeelle %s.%s epäonnistui: %w", tableName, columnName, err)
+	}
+	return ColumnModel{Provider: provider.String, Model: modelName.String, Dim: int(dim.Int64)}, true, nil
+}
+
+// matches kertoo, ovatko vektorit vertailukelpoisia: malli ja ulottuvuus täsmäävät.
+func (m ColumnModel) matches(provider EmbeddingProvider, dim int) bool {
+	return m.Model == provider.Model() && m.Dim == dim
+}
+
+func mismatchError(tableName, columnName string, recorded ColumnModel, provider EmbeddingProvider, dim int) error {
+	return fmt.Errorf("%w: %s.%s on tehty mallilla %s/%s (%d), käytössä %s/%s (%d)",
+		ErrModelMismatch, tableName, columnName,
+		recorded.Provider, recorded.Model, recorded.Dim,
+		provider.Name(), provider.Model(), dim,
+	)
+}
+
+// CheckColumnModel tarkistaa ennen hakua, että sarakkeen vektorit on tehty samalla
+// mallilla. Jos mallia ei ole tallennettu, tarkistus hyväksyy.
+func CheckColumnModel(tableName, columnName string, provider EmbeddingProvider, dim int) error {
+	recorded, _, err := fetchColumnModel(tableName, columnName)
+	if err != nil {
+		return err
+	}
+	if recorded.Model == "" || recorded.matches(provider, dim) {
+		return nil
+	}
+	return mismatchError(tableName, columnName, recorded, provider, dim)
+}
+
+// RecordColumnModel tarkistaa ennen tallennusta, että sarakkeeseen ei sekoiteta
+// eri mallien vektoreita, ja tallentaa mallin, jos sitä ei vielä ole.
+func RecordColumnModel(tableName, columnName string, provider EmbeddingProvider, dim int) error {
+	recorded, found, err := fetchColumnModel(tableName, columnName)
+	if err != nil || !found {
+		return err
+	}
+	if recorded.Model != "" {
+		if recorded.matches(provider, dim) {
+			return nil
+		}
+		return mismatchError(tableName, columnName, recorded, provider, dim)
+	}
+	return SetColumnModel(tableName, columnName, provider, dim)
+}
+
+// SetColumnModel korvaa sarakkeen mallin. Käytetään, kun koko sarake lasketaan uudelleen.
+func SetColumnModel(tableName, columnName string, provider EmbeddingProvider, dim int) error {
+	return setColumnModel(backend.Db, tableName, columnName, provider, dim)
+}
+
+// execer on *sql.DB tai *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func setColumnModel(q execer, tableName, columnName string, provider EmbeddingProvider, dim int) error {
+	_, err := q.Exec(`
+		UPDATE system_column_details scd
+		SET embedding_provider = $3, embedding_model = $4, embedding_dim = $5
+		FROM system_db_tables sdt
+		WHERE sdt.table_uid = scd.table_uid
+		  AND sdt.table_name = $1
+		  AND scd.column_name = $2
+	`, tableName, columnName, provider.Name(), provider.Model(), dim)
+	if err != nil {
+		return fmt.Errorf("embedding-mallin tallennus sarakkeelle %s.%s epäonnistui: %w", tableName, columnName, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// file: embeddings.go
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	pgvector "github.com/pgvector/pgvector-go"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Embedding-palvelu valitaan ympäristömuuttujilla:
+//
+//	EMBEDDING_PROVIDER=openai (oletus)  OPENAI_API_KEY, OPENAI_EMBEDDING_MODEL
+//	EMBEDDING_PROVIDER=local            EMBEDDING_BASE_URL (esim. http://localhost:11434/v1),
+//	                                    EMBEDDING_MODEL, EMBEDDING_API_KEY (valinnainen)
+//	EMBEDDING_PROVIDER=hash             EMBEDDING_DIM (oletus 384); deterministinen,
+//	                                    ei verkkoyhteyttä (testit ja suljetut ympäristöt)
+//
+// local-palvelu on OpenAI-yhteensopiva /embeddings-rajapinta (Ollama, llama.cpp server).
+
+const (
+	ProviderOpenAI = "openai"
+	ProviderLocal  = "local"
+	ProviderHash   = "hash"
+
+	defaultOpenAIModel = "text-embedding-ada-002"
+	defaultLocalModel  = "nomic-embed-text"
+	defaultHashDim     = 384
+)
+
+// EmbeddingProvider muuntaa tekstit vektoreiksi.
+type EmbeddingProvider interface {
+	// Name palauttaa palvelun tyypin (openai, local, hash).
+	Name() string
+	// Model palauttaa mallin nimen, joka tallennetaan sarakkeen tietoihin.
+	Model() string
+	// Embed palauttaa yhden vektorin jokaista syötettä kohden samassa järjestyksessä.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// FromEnv palauttaa ympäristömuuttujien mukaisen palvelun.
+func FromEnv() (EmbeddingProvider, error) {
+	switch name := strings.ToLower(strings.TrimSpace(os.Getenv("EMBEDDING_PROVIDER"))); name {
+	case "", ProviderOpenAI:
+		apiKey := strings.TrimSpace(os.Getenv("OPENAI_API_KEY"))
+		if apiKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY puuttuu ympäristömuuttujista")
+		}
+		return &openAIProvider{
+			name:   ProviderOpenAI,
+			model:  envOrDefault("OPENAI_EMBEDDING_MODEL", defaultOpenAIModel),
+			client: openai.NewClient(apiKey),
+		}, nil
+
+	case ProviderLocal:
+		baseURL := strings.TrimSpace(os.Getenv("EMBEDDING_BASE_URL"))
+		if baseURL == "" {
+			return nil, fmt.Errorf("EMBEDDING_BASE_URL puuttuu ympäristömuuttujista")
+		}
+		config := openai.DefaultConfig(os.Getenv("EMBEDDING_API_KEY"))
+		config.BaseURL = strings.TrimRight(baseURL, "/")
+		return &openAIProvider{
+			name:   ProviderLocal,
+			model:  envOrDefault("EMBEDDING_MODEL", defaultLocalModel),
+			client: openai.NewClientWithConfig(config),
+		}, nil
+
+	case ProviderHash:
+		dim := defaultHashDim
+		if raw := strings.TrimSpace(os.Getenv("EMBEDDING_DIM")); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("virheellinen EMBEDDING_DIM: %q", raw)
+			}
+			dim = parsed
+		}
+		return NewHashProvider(dim), nil
+
+	default:
+		return nil, fmt.Errorf("tuntematon EMBEDDING_PROVIDER: %q", name)
+	}
+}
+
+// EmbedText muodostaa yhden tekstin vektorin ympäristön mukaisella palvelulla.
+// Palvelu palautetaan, jotta kutsuja voi tarkistaa sarakkeen mallin (ks. CheckColumnModel).
+func EmbedText(ctx context.Context, text string) (pgvector.Vector, EmbeddingProvider, error) {
+	provider, err := FromEnv()
+	if err != nil {
+		return pgvector.Vector{}, nil, err
+	}
+	vectors, err := provider.Embed(ctx, []string{text})
+	if err != nil {
+		return pgvector.Vector{}, provider, err
+	}
+	return pgvector.NewVector(vectors[0]), provider, nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// openAIProvider käyttää OpenAI:n tai sen kanssa yhteensopivan palvelun embeddings-rajapintaa.
+type openAIProvider struct {
+	name   string
+	model  string
+	client *openai.Client
+}
+
+func (p *openAIProvider) Name() string  { return p.name }
+func (p *openAIProvider) Model() string { return p.model }
+
+func (p *openAIProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := p.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Model: openai.EmbeddingModel(p.model),
+		Input: texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s embedding error: %w", p.name, err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("%s embedding palautti %d vektoria, odotettiin %d", p.name, len(resp.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, item := range resp.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("%s embedding palautti virheellisen indeksin %d", p.name, item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,75 @@
+// file: hash_provider.go
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// hashProvider muodostaa vektorin piirrehajautuksella: jokainen sana ja sanan
+// kolmen merkin pätkä hajautetaan FNV-1a:lla yhteen ulottuvuuteen (etumerkki
+// hajautuksen ylimmästä bitistä), ja tulos normalisoidaan yksikköpituiseksi.
+// Sama teksti tuottaa aina saman vektorin; samankaltaiset tekstit ovat lähellä
+// toisiaan sanaston tasolla, eivät merkityksen.
+type hashProvider struct {
+	dim int
+}
+
+// NewHashProvider palauttaa deterministisen paikallisen palvelun annetulla ulottuvuudella.
+func NewHashProvider(dim int) EmbeddingProvider {
+	return &hashProvider{dim: dim}
+}
+
+func (p *hashProvider) Name() string  { return ProviderHash }
+func (p *hashProvider) Model() string { return fmt.Sprintf("feature-hash-v1-%d", p.dim) }
+
+func (p *hashProvider) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = p.embedOne(text)
+	}
+	return vectors, nil
+}
+
+func (p *hashProvider) embedOne(text string) []float32 {
+	vector := make([]float64, p.dim)
+	add := func(feature string) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		if sum>>63 == 1 {
+			vector[sum%uint64(p.dim)]--
+		} else {
+			vector[sum%uint64(p.dim)]++
+		}
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		add("w:" + word)
+		runes := []rune("#" + word + "#")
+		for j := 0; j+3 <= len(runes); j++ {
+			add("t:" + string(runes[j:j+3]))
+		}
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+
+	result := make([]float32, p.dim)
+	for i, v := range vector {
+		if norm > 0 {
+			result[i] = float32(v / norm)
+		}
+	}
+	return result
+}
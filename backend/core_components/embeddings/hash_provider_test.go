@@ -0,0 +1,92 @@
+package embeddings
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func TestHashProviderEmbed(t *testing.T) {
+	provider := NewHashProvider(64)
+	tests := []struct {
+		name     string
+		text     string
+		wantZero bool
+	}{
+		{name: "words", text: "Helsinki harbour"},
+		{name: "punctuation only", text: "-- !! ..", wantZero: true},
+		{name: "empty", text: "", wantZero: true},
+		{name: "digits", text: "invoice 2024"},
+		{name: "non-ascii", text: "Äänekoski öljy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vectors, err := provider.Embed(context.Background(), []string{tt.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vectors) != 1 || len(vectors[0]) != 64 {
+				t.Fatalf("Embed(%q) shape = %d vectors, want 1 of dim 64", tt.text, len(vectors))
+			}
+			norm := math.Sqrt(dot(vectors[0], vectors[0]))
+			if tt.wantZero {
+				if norm != 0 {
+					t.Errorf("Embed(%q) norm = %v, want 0", tt.text, norm)
+				}
+				return
+			}
+			if math.Abs(norm-1) > 1e-5 {
+				t.Errorf("Embed(%q) norm = %v, want 1", tt.text, norm)
+			}
+		})
+	}
+}
+
+func TestHashProviderDeterministic(t *testing.T) {
+	first, _ := NewHashProvider(128).Embed(context.Background(), []string{"Red apple", "green pear"})
+	second, _ := NewHashProvider(128).Embed(context.Background(), []string{"red APPLE!", "green pear"})
+	if !reflect.DeepEqual(first, second) {
+		t.Error("same words with different case and punctuation produced different vectors")
+	}
+	if reflect.DeepEqual(first[0], first[1]) {
+		t.Error("different texts produced the same vector")
+	}
+}
+
+func TestHashProviderSimilarity(t *testing.T) {
+	vectors, _ := NewHashProvider(384).Embed(context.Background(), []string{
+		"customer invoice overdue",
+		"overdue customer invoices",
+		"mountain bicycle tyre",
+	})
+	near := dot(vectors[0], vectors[1])
+	far := dot(vectors[0], vectors[2])
+	if near <= far {
+		t.Errorf("dot(similar) = %v, dot(unrelated) = %v, want similar to be higher", near, far)
+	}
+}
+
+func TestHashProviderModel(t *testing.T) {
+	tests := []struct {
+		dim  int
+		want string
+	}{
+		{384, "feature-hash-v1-384"},
+		{16, "feature-hash-v1-16"},
+	}
+	for _, tt := range tests {
+		p := NewHashProvider(tt.dim)
+		if p.Name() != ProviderHash || p.Model() != tt.want {
+			t.Errorf("NewHashProvider(%d) = %s/%s, want %s/%s", tt.dim, p.Name(), p.Model(), ProviderHash, tt.want)
+		}
+	}
+}
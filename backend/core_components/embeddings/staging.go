@@ -0,0 +1,85 @@
+// file: staging.go
+package embeddings
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+	pgvector "github.com/pgvector/pgvector-go"
+
+	backend "easelect/backend/core_components"
+)
+
+// Kun taulun embeddingit lasketaan uudelleen eri mallilla, uudet vektorit kirjoitetaan
+// ensin system_embedding_staging-tauluun. Vanhat vektorit ja sarakkeen malli pysyvät
+// käytössä, kunnes koko taulu on käyty läpi onnistuneesti; vasta silloin vektorit
+// vaihdetaan ja malli korvataan yhdessä transaktiossa.
+
+// EnsureEmbeddingStagingTable luo uudelleenlaskennan välitaulun, jos sitä ei vielä ole.
+// Kutsutaan käynnistyksen yhteydessä.
+func EnsureEmbeddingStagingTable() error {
+	_, err := backend.Db.Exec(`
+		CREATE TABLE IF NOT EXISTS system_embedding_staging (
+			table_name TEXT NOT NULL,
+			row_id BIGINT NOT NULL,
+			embedding vector NOT NULL,
+			PRIMARY KEY (table_name, row_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("system_embedding_staging-taulun luonti epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// StageEmbedding tallentaa rivin uuden vektorin odottamaan vaihtoa.
+func StageEmbedding(tableName string, rowID int64, embedding []float32) error {
+	_, err := backend.Db.Exec(`
+		INSERT INTO system_embedding_staging (table_name, row_id, embedding)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (table_name, row_id) DO UPDATE SET embedding = EXCLUDED.embedding
+	`, tableName, rowID, pgvector.NewVector(embedding))
+	if err != nil {
+		return fmt.Errorf("embeddingin välitallennus epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// DiscardStagedEmbeddings poistaa taulun odottavat vektorit.
+func DiscardStagedEmbeddings(tableName string) error {
+	_, err := backend.Db.Exec(`DELETE FROM system_embedding_staging WHERE table_name = $1`, tableName)
+	if err != nil {
+		return fmt.Errorf("odottavien embeddingien poisto epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// CommitStagedEmbeddings vaihtaa taulun kaikki vektorit odottaviin ja korvaa sarakkeen
+// mallin. Rivit, joille ei ole odottavaa vektoria, saavat NULLin, koska niiden vanha
+// vektori on tehty eri mallilla. Taulun pääavaimen on oltava id.
+func CommitStagedEmbeddings(tableName, columnName string, provider EmbeddingProvider, dim int) error {
+	tx, err := backend.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	safeTable := pq.QuoteIdentifier(tableName)
+	safeColumn := pq.QuoteIdentifier(columnName)
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE %[1]s SET %[2]s = (
+			SELECT s.embedding FROM system_embedding_staging s
+			WHERE s.table_name = $1 AND s.row_id = %[1]s.id
+		)
+	`, safeTable, safeColumn), tableName)
+	if err != nil {
+		return fmt.Errorf("embeddingien vaihto taululle %s epäonnistui: %w", tableName, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM system_embedding_staging WHERE table_name = $1`, tableName); err != nil {
+		return fmt.Errorf("odottavien embeddingien poisto epäonnistui: %w", err)
+	}
+	if err := setColumnModel(tx, tableName, columnName, provider, dim); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
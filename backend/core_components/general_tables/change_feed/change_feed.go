@@ -0,0 +1,266 @@
+// file: change_feed.go
+package change_feed
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// changeFeedChannel on NOTIFY-kanava, johon taulujen triggerit kirjoittavat.
+const changeFeedChannel = "easelect_table_changes"
+
+// changeFeedTriggerName on jokaiseen system_db_tables-tauluun lisättävän triggerin nimi.
+const changeFeedTriggerName = "easelect_change_feed"
+
+// TableChange on yksi rivimuutos. Kuormassa on vain taulu, operaatio ja rivin avain,
+// jotta NOTIFY-kuorman 8000 tavun raja ei tule vastaan ja rivin sisältö haetaan aina
+// GetResultsin kautta (sarake- ja rivioikeudet huomioiden). ID on avaimen tekstimuoto
+// (row_key.RowKey.String): yksisarakkeisella pääavaimella arvo, muuten JSON-olio.
+type TableChange struct {
+	Table     string `json:"table"`
+	Operation string `json:"op"` // INSERT, UPDATE, DELETE
+	ID        string `json:"id,omitempty"`
+}
+
+// notifyPayload on triggerin NOTIFY-kuorma. Avainsarakkeet tulevat triggerin
+// argumenteista pääavaimen järjestyksessä ja arvot samassa järjestyksessä.
+type notifyPayload struct {
+	Table      string    `json:"table"`
+	Operation  string    `json:"op"`
+	KeyColumns []string  `json:"key_columns"`
+	KeyValues  []*string `json:"key_values"`
+}
+
+// parseNotification muuntaa triggerin kuorman muutokseksi.
+func parseNotification(extra string) (TableChange, error) {
+	var payload notifyPayload
+	if err := json.Unmarshal([]byte(extra), &payload); err != nil {
+		return TableChange{}, err
+	}
+	change := TableChange{Table: payload.Table, Operation: payload.Operation}
+	if len(payload.KeyColumns) == 0 || len(payload.KeyValues) != len(payload.KeyColumns) {
+		return change, nil
+	}
+	key := row_key.RowKey{Columns: payload.KeyColumns, Values: make([]string, len(payload.KeyValues))}
+	for i, v := range payload.KeyValues {
+		if v == nil {
+			return change, nil
+		}
+		key.Values[i] = *v
+	}
+	change.ID = key.String()
+	return change, nil
+}
+
+// EnsureChangeFeedTriggers luo NOTIFY-funktion ja lisää triggerin kaikkiin
+// system_db_tables-tauluihin rekisteröityihin tauluihin sekä metatietotauluihin, joista
+// metadata_cache lataa arvoja, jotta niiden muutokset tyhjentävät välimuistin.
+func EnsureChangeFeedTriggers(db *sql.DB) error {
+	_, err := db.Exec(changeFeedFunctionSQL())
+	if err != nil {
+		return fmt.Errorf("virhe muutossyötteen trigger-funktion luomisessa: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT sdt.table_name
+		FROM system_db_tables sdt
+		JOIN information_schema.tables t
+			ON t.table_name = sdt.table_name
+			AND t.table_schema = 'public'
+			AND t.table_type = 'BASE TABLE'
+		UNION
+		SELECT t.table_name
+		FROM information_schema.tables t
+		WHERE t.table_name = ANY($1)
+			AND t.table_schema = 'public'
+			AND t.table_type = 'BASE TABLE'
+	`, pq.Array(metadata_cache.MetadataTables()))
+	if err != nil {
+		return fmt.Errorf("virhe taulujen haussa muutossyötteelle: %w", err)
+	}
+	var tableNames []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			rows.Close()
+			return fmt.Errorf("virhe taulujen luvussa muutossyötteelle: %w", err)
+		}
+		tableNames = append(tableNames, tableName)
+	}
+	rows.Close()
+
+	for _, tableName := range tableNames {
+		if err := EnsureChangeFeedTrigger(db, tableName); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		}
+	}
+	return nil
+}
+
+// EnsureChangeFeedTrigger lisää (tai korvaa) muutossyötteen triggerin yhdelle taululle.
+// Taulun pääavainsarakkeet annetaan triggerille argumentteina, joten trigger on
+// asennettava uudelleen, jos pääavain muuttuu. Ilman pääavainta muutokset lähetetään
+// ilman id:tä.
+func EnsureChangeFeedTrigger(db *sql.DB, tableName string) error {
+	keyColumns, err := row_key.PrimaryKeyColumns(tableName)
+	if err != nil && !errors.Is(err, row_key.ErrNoPrimaryKey) {
+		return fmt.Errorf("virhe muutossyötteen avaimen haussa taululle %s: %w", tableName, err)
+	}
+	_, err = db.Exec(changeFeedTriggerSQL(tableName, keyColumns))
+	if err != nil {
+		return fmt.Errorf("virhe muutossyötteen triggerin luomisessa taululle %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// changeFeedFunctionSQL palauttaa NOTIFY-trigger-funktion luontilauseen.
+func changeFeedFunctionSQL() string {
+	return fmt.Sprintf(`
+		CREATE OR REPLACE FUNCTION easelect_notify_table_change()
+		RETURNS TRIGGER AS $$
+		DECLARE
+			changed_row JSONB;
+			key_values TEXT[];
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				changed_row := to_jsonb(OLD);
+			ELSE
+				changed_row := to_jsonb(NEW);
+			END IF;
+			-- Triggerin argumentteina ovat taulun pääavainsarakkeet järjestyksessä
+			key_values := ARRAY(
+				SELECT changed_row->>k.col
+				FROM unnest(TG_ARGV) WITH ORDINALITY AS k(col, ord)
+				ORDER BY k.ord
+			);
+			PERFORM pg_notify(%s, json_build_object(
+				'table', TG_TABLE_NAME,
+				'op', TG_OP,
+				'key_columns', TG_ARGV,
+				'key_values', key_values
+			)::text);
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+	`, pq.QuoteLiteral(changeFeedChannel))
+}
+
+// changeFeedTriggerSQL palauttaa triggerin asennuslauseet.
+func changeFeedTriggerSQL(tableName string, keyColumns []string) string {
+	args := make([]string, len(keyColumns))
+	for i, col := range keyColumns {
+		args[i] = pq.QuoteLiteral(col)
+	}
+	return fmt.Sprintf(`
+		DROP TRIGGER IF EXISTS %[1]s ON %[2]s;
+		CREATE TRIGGER %[1]s
+		AFTER INSERT OR UPDATE OR DELETE ON %[2]s
+		FOR EACH ROW EXECUTE PROCEDURE easelect_notify_table_change(%[3]s);
+	`, pq.QuoteIdentifier(changeFeedTriggerName), pq.QuoteIdentifier(tableName), strings.Join(args, ", "))
+}
+
+// subscriber on yksi SSE-asiakas ja sen tilaamat taulut.
+type subscriber struct {
+	tables  map[string]bool
+	changes chan TableChange
+}
+
+// changeHub jakaa kuuntelijalta tulevat muutokset tilaajille.
+type changeHub struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+var hub = &changeHub{subscribers: make(map[*subscriber]struct{})}
+
+// subscribe rekisteröi uuden tilaajan. Palautettu funktio poistaa tilauksen.
+func (h *changeHub) subscribe(tables []string) (*subscriber, func()) {
+	sub := &subscriber{
+		tables:  make(map[string]bool, len(tables)),
+		changes: make(chan TableChange, 64),
+	}
+	for _, t := range tables {
+		sub.tables[t] = true
+	}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub, func() {
+		h.mu.Lock()
+		delete(h.subscribers, sub)
+		h.mu.Unlock()
+	}
+}
+
+// broadcast välittää muutoksen kaikille tilaajille, jotka seuraavat taulua.
+// Hidas tilaaja ei saa jumittaa kuuntelijaa, joten täyden puskurin muutos pudotetaan.
+func (h *changeHub) broadcast(change TableChange) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subscribers {
+		if change.Table != "" && !sub.tables[change.Table] {
+			continue
+		}
+		select {
+		case sub.changes <- change:
+		default:
+		}
+	}
+}
+
+// StartChangeListener käynnistää jaetun LISTEN-gorutiinin. Yhteyskatkon jälkeen
+// tilaajille lähetetään tyhjä muutos, jotta ne voivat ladata näkymänsä uudelleen.
+func StartChangeListener(connStr string) error {
+	if connStr == "" {
+		return fmt.Errorf("muutossyötteen kuuntelija: yhteysmerkkijono puuttuu")
+	}
+
+	listener := pq.NewListener(connStr, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("\033[31mvirhe muutossyötteen kuuntelijassa: %s\033[0m\n", err.Error())
+		}
+	})
+	if err := listener.Listen(changeFeedChannel); err != nil {
+		listener.Close()
+		return fmt.Errorf("muutossyötteen LISTEN epäonnistui: %w", err)
+	}
+
+	go func() {
+		for {
+			select {
+			case notification := <-listener.Notify:
+				if notification == nil {
+					// Yhteys palautui: muutoksia on voinut jäädä välistä
+					metadata_cache.InvalidateAll()
+					hub.broadcast(TableChange{Operation: "RESYNC"})
+					continue
+				}
+				change, err := parseNotification(notification.Extra)
+				if err != nil {
+					log.Printf("\033[31mvirhe muutossyötteen kuorman jäsentämisessä: %s\033[0m\n", err.Error())
+					continue
+				}
+				// Metatietotaulujen muutokset (myös muista prosesseista) tyhjentävät välimuistin
+				metadata_cache.InvalidateForTableChange(change.Table)
+				hub.broadcast(change)
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	fmt.Printf("\033[36m[StartChangeListener] kuunnellaan kanavaa %s\033[0m\n", changeFeedChannel)
+	return nil
+}
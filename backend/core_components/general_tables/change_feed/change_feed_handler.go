@@ -0,0 +1,178 @@
+// file: change_feed_handler.go
+package change_feed
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/row_policies"
+	"easelect/backend/core_components/middlewares"
+	e_sessions "easelect/backend/core_components/sessions"
+)
+
+// getResultsFunctionName on funktio, jonka taulukohtainen oikeus vaaditaan tilaukseen.
+const getResultsFunctionName = "gt_1_row_read.GetResultsHandlerWrapper"
+
+// ChangeFeedHandler avaa SSE-yhteyden, jossa asiakas saa tilaamiensa taulujen rivimuutokset.
+//
+//	GET /api/change-feed?subscribe=service_catalog,customers
+//
+// Tapahtumat:
+//
+//	event: subscribed  data: ["service_catalog","customers"]
+//	event: change      data: {"table":"customers","op":"UPDATE","id":"12"}  (id puuttuu rivisäännön rajaamalta)
+//	event: resync      (kuuntelijan yhteys katkesi, näkymät kannattaa ladata uudelleen)
+//
+// Parametri on tarkoituksella subscribe eikä tables, jotta WithAccessControl tarkistaa
+// vain funktiotason oikeuden; taulukohtainen oikeus tarkistetaan GetResultsin nimissä.
+//
+// Jos käyttäjää rajaa taululla rivitason sääntö tai (muulla kuin adminilla)
+// must_be_true-sarake, muutokset lähetetään ilman id:tä (pelkkä tieto taulun
+// muuttumisesta), koska poistetun tai näkyvyydestä poistuneen rivin ehtoa ei voi
+// arvioida. Rajaus luetaan uudelleen jokaisella ping-välillä.
+func ChangeFeedHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := e_sessions.GetUserIDFromSession(r)
+	if err != nil || userID <= 0 {
+		http.Error(w, "Unauthorized: tarvitset kirjautumisen", http.StatusUnauthorized)
+		return
+	}
+
+	var tables []string
+	for _, t := range strings.Split(r.URL.Query().Get("subscribe"), ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !middlewares.UserHasFunctionPermission(userID, getResultsFunctionName, t) {
+			http.Error(w, fmt.Sprintf("403 - Forbidden (%s)", t), http.StatusForbidden)
+			return
+		}
+		tables = append(tables, t)
+	}
+	if len(tables) == 0 {
+		http.Error(w, "subscribe-parametri puuttuu", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "server does not support streaming", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	sendSSE := func(eventName, data string) {
+		fmt.Fprintf(w, "event: %s\n", eventName)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	session, err := e_sessions.GetStore().Get(r, "session")
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe session haussa", http.StatusInternalServerError)
+		return
+	}
+	userRole, _ := session.Values["user_role"].(string)
+
+	restricted, err := restrictedTables(userID, userRole, tables)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe rivisääntöjen haussa", http.StatusInternalServerError)
+		return
+	}
+
+	sub, unsubscribe := hub.subscribe(tables)
+	defer unsubscribe()
+
+	subscribedJSON, _ := json.Marshal(tables)
+	sendSSE("subscribed", string(subscribedJSON))
+
+	keepAlive := time.NewTicker(25 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case change := <-sub.changes:
+			if change.Operation == "RESYNC" {
+				sendSSE("resync", "")
+				continue
+			}
+			if restricted[change.Table] {
+				change.ID = ""
+			}
+			payload, err := json.Marshal(change)
+			if err != nil {
+				log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+				continue
+			}
+			sendSSE("change", string(payload))
+		case <-keepAlive.C:
+			// SSE-kommentti pitää välityspalvelimet yhteydessä
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+			if refreshed, err := restrictedTables(userID, userRole, tables); err != nil {
+				// Virhetilanteessa rajataan kaikki taulut, kunnes haku onnistuu
+				log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+				for _, t := range tables {
+					restricted[t] = true
+				}
+			} else {
+				restricted = refreshed
+			}
+		}
+	}
+}
+
+// restrictedTables palauttaa taulut, joilla käyttäjää rajaa jokin rivitason sääntö tai
+// must_be_true-sarake. Muutosten id:t piilotetaan näillä tauluilla.
+func restrictedTables(userID int, userRole string, tables []string) (map[string]bool, error) {
+	restricted := make(map[string]bool, len(tables))
+	if userRole != "admin" {
+		// must_be_true ei koske adminia, kuten GetResultsissa
+		rows, err := backend.Db.Query(`
+			SELECT DISTINCT sdt.table_name
+			FROM system_db_tables sdt
+			JOIN system_column_details scd ON sdt.table_uid = scd.table_uid
+			WHERE sdt.table_name = ANY($1)
+			  AND scd.must_be_true = true
+		`, pq.Array(tables))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t string
+			if err := rows.Scan(&t); err != nil {
+				return nil, err
+			}
+			restricted[t] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range tables {
+		if restricted[t] {
+			continue
+		}
+		cond, _, err := row_policies.BuildCondition(userID, t, pq.QuoteIdentifier(t), 1)
+		if err != nil {
+			return nil, err
+		}
+		restricted[t] = cond != ""
+	}
+	return restricted, nil
+}
@@ -0,0 +1,107 @@
+package change_feed
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestParseNotification(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra string
+		want  TableChange
+	}{
+		{
+			"single column key",
+			`{"table":"customers","op":"UPDATE","key_columns":["id"],"key_values":["12"]}`,
+			TableChange{Table: "customers", Operation: "UPDATE", ID: "12"},
+		},
+		{
+			"uuid key",
+			`{"table":"tickets","op":"INSERT","key_columns":["ticket_uuid"],"key_values":["5f0c8a6e-11ee-4d2b-9b1e-0242ac120002"]}`,
+			TableChange{Table: "tickets", Operation: "INSERT", ID: "5f0c8a6e-11ee-4d2b-9b1e-0242ac120002"},
+		},
+		{
+			"composite key keeps primary key order",
+			`{"table":"order_lines","op":"DELETE","key_columns":["order_id","line_no"],"key_values":["12","3"]}`,
+			TableChange{Table: "order_lines", Operation: "DELETE", ID: `{"order_id":"12","line_no":"3"}`},
+		},
+		{
+			"table without primary key",
+			`{"table":"log","op":"INSERT","key_columns":[],"key_values":[]}`,
+			TableChange{Table: "log", Operation: "INSERT"},
+		},
+		{
+			"null key value",
+			`{"table":"log","op":"INSERT","key_columns":["id"],"key_values":[null]}`,
+			TableChange{Table: "log", Operation: "INSERT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNotification(tt.extra)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseNotification() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseNotification("not json"); err == nil {
+		t.Error("parseNotification(invalid) err = nil")
+	}
+}
+
+// TestTriggerNotifiesPrimaryKey asentaa triggerin yhdistelmäavaimelliseen tauluun ja
+// tarkistaa NOTIFY-kuorman. Ohitetaan, jos EASELECT_TEST_DATABASE_URL ei ole asetettu.
+func TestTriggerNotifiesPrimaryKey(t *testing.T) {
+	dsn := os.Getenv("EASELECT_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("EASELECT_TEST_DATABASE_URL ei ole asetettu")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1) // väliaikainen taulu näkyy vain samalle yhteydelle
+
+	listener := pq.NewListener(dsn, time.Second, time.Second, nil)
+	defer listener.Close()
+	if err := listener.Listen(changeFeedChannel); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(changeFeedFunctionSQL()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TEMP TABLE change_feed_test_lines (order_id INT, line_no INT, note TEXT, PRIMARY KEY (order_id, line_no))`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(changeFeedTriggerSQL("change_feed_test_lines", []string{"order_id", "line_no"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO change_feed_test_lines VALUES (12, 3, 'x')`); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case n := <-listener.Notify:
+		got, err := parseNotification(n.Extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := TableChange{Table: "change_feed_test_lines", Operation: "INSERT", ID: `{"order_id":"12","line_no":"3"}`}
+		if got != want {
+			t.Errorf("notification = %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("NOTIFY-kuormaa ei tullut")
+	}
+}
@@ -0,0 +1,467 @@
+// file: column_defaults.go
+package column_defaults
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/models"
+	"easelect/backend/core_components/general_tables/row_policies"
+)
+
+// Sarakkeiden oletusarvolausekkeet tallennetaan system_column_details.default_expression
+// -sarakkeeseen. Lauseke lasketaan jokaisessa rivinlisäyksessä, jos sarakkeelle ei
+// anneta arvoa, ja rivinlisäyslomake esitäytetään get-add-row-metadatan arvoilla.
+// Tuetut lausekkeet:
+//
+//	current_user                        kirjautuneen käyttäjän id
+//	current_username                    kirjautuneen käyttäjän nimi
+//	current_user_group                  käyttäjän ryhmä (pienin ryhmä-id)
+//	today, today + 14 days              päivämäärä; yksiköt day, week, month, year
+//	now, now - 2 hours                  aikaleima; lisäksi yksiköt hour ja minute
+//	sequence('invoice_seq', 'INV-', 5)  sekvenssin seuraava arvo, etuliite ja nollilla täyttö
+//	parent(customer_id.payment_terms)   arvo viiteavaimen osoittamalta riviltä
+//	'teksti'                            vakioarvo
+//
+// Sekvenssit lasketaan erikseen (EvaluateSequences) vasta validoinnin jälkeen
+// lisäystransaktiossa, jottei hylätty lomake kuluta numeroa. parent()-lauseke lukee
+// käyttäjän antaman viiteavaimen rivin roolin yhteydellä rivitason säännöt huomioiden.
+
+// Lausekkeiden lajit.
+const (
+	KindCurrentUser      = "current_user"
+	KindCurrentUsername  = "current_username"
+	KindCurrentUserGroup = "current_user_group"
+	KindToday            = "today"
+	KindNow              = "now"
+	KindSequence         = "sequence"
+	KindParent           = "parent"
+	KindLiteral          = "literal"
+)
+
+var (
+	dateExprPattern     = regexp.MustCompile(`^(today|now)\s*(?:([+-])\s*(\d+)\s*([a-z]+))?$`)
+	sequenceExprPattern = regexp.MustCompile(`^sequence\(\s*'?([A-Za-z_][A-Za-z0-9_.]*)'?\s*(?:,\s*'([^']*)'\s*(?:,\s*(\d+)\s*)?)?\)$`)
+	parentExprPattern   = regexp.MustCompile(`^parent\(\s*([A-Za-z_][A-Za-z0-9_]*)\.([A-Za-z_][A-Za-z0-9_]*)\s*\)$`)
+)
+
+// dateUnits ovat päivämäärälausekkeen yksiköt yksikkömuodossa.
+var dateUnits = map[string]string{
+	"day": "day", "days": "day",
+	"week": "week", "weeks": "week",
+	"month": "month", "months": "month",
+	"year": "year", "years": "year",
+	"hour": "hour", "hours": "hour",
+	"minute": "minute", "minutes": "minute",
+}
+
+// Expression on jäsennetty oletusarvolauseke.
+type Expression struct {
+	Kind       string
+	Offset     int    // today/now: etumerkillinen määrä
+	Unit       string // today/now: day, week, month, year, hour, minute
+	Sequence   string // sequence: sekvenssin nimi
+	Prefix     string // sequence: etuliite
+	Width      int    // sequence: numero-osan vähimmäispituus
+	ForeignKey string // parent: viiteavainsarake
+	Column     string // parent: kopioitava sarake
+	Literal    string // literal: arvo
+}
+
+// Context on lausekkeiden laskennan tila. RoleDb on käyttäjän roolin yhteys, jolla
+// parent()-lauseke lukee viitatun rivin; ilman sitä parent()-arvoja ei lasketa.
+// TrustedParent on viiteavainsarake, jonka rivi on lisätty samassa transaktiossa
+// (lapsirivin viittaus päätauluun); se luetaan laskennan omalla yhteydellä.
+type Context struct {
+	UserID        int
+	Username      string
+	Now           time.Time
+	RoleDb        *sql.DB
+	TrustedParent string
+}
+
+// Queryer on *sql.DB tai *sql.Tx; lapsirivin parent()-lauseke lukee samassa
+// transaktiossa lisätyn päärivin.
+type Queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// EnsureDefaultExpressionColumn lisää default_expression-sarakkeen system_column_details-tauluun.
+func EnsureDefaultExpressionColumn() error {
+	_, err := backend.Db.Exec(`
+		ALTER TABLE system_column_details
+		ADD COLUMN IF NOT EXISTS default_expression TEXT
+	`)
+	if err != nil {
+		return fmt.Errorf("default_expression-sarakkeen lisäys epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// Parse jäsentää oletusarvolausekkeen.
+func Parse(raw string) (*Expression, error) {
+	text := strings.TrimSpace(raw)
+	lower := strings.ToLower(text)
+
+	switch lower {
+	case KindCurrentUser:
+		return &Expression{Kind: KindCurrentUser}, nil
+	case KindCurrentUsername:
+		return &Expression{Kind: KindCurrentUsername}, nil
+	case KindCurrentUserGroup:
+		return &Expression{Kind: KindCurrentUserGroup}, nil
+	}
+
+	if m := dateExprPattern.FindStringSubmatch(lower); m != nil {
+		expr := &Expression{Kind: m[1]}
+		if m[2] != "" {
+			unit, ok := dateUnits[m[4]]
+			if !ok {
+				return nil, fmt.Errorf("tuntematon aikayksikkö: %s", m[4])
+			}
+			if expr.Kind == KindToday && (unit == "hour" || unit == "minute") {
+				return nil, fmt.Errorf("today-lausekkeessa ei voi käyttää yksikköä %s", unit)
+			}
+			amount, err := strconv.Atoi(m[3])
+			if err != nil {
+				return nil, fmt.Errorf("virheellinen määrä: %s", m[3])
+			}
+			if m[2] == "-" {
+				amount = -amount
+			}
+			expr.Offset = amount
+			expr.Unit = unit
+		}
+		return expr, nil
+	}
+
+	if m := sequenceExprPattern.FindStringSubmatch(text); m != nil {
+		expr := &Expression{Kind: KindSequence, Sequence: m[1], Prefix: m[2]}
+		if m[3] != "" {
+			width, err := strconv.Atoi(m[3])
+			if err != nil || width > 30 {
+				return nil, fmt.Errorf("virheellinen sekvenssin pituus: %s", m[3])
+			}
+			expr.Width = width
+		}
+		return expr, nil
+	}
+
+	if m := parentExprPattern.FindStringSubmatch(text); m != nil {
+		return &Expression{Kind: KindParent, ForeignKey: m[1], Column: m[2]}, nil
+	}
+
+	if len(text) >= 2 && strings.HasPrefix(text, "'") && strings.HasSuffix(text, "'") {
+		return &Expression{Kind: KindLiteral, Literal: text[1 : len(text)-1]}, nil
+	}
+
+	return nil, fmt.Errorf("tuntematon oletusarvolauseke: %s", raw)
+}
+
+// Evaluate laskee oletusarvot sarakkeille, joilla on lauseke ja joiden arvo rivillä on
+// tyhjä. parent()-lausekkeet lasketaan viimeisenä, jotta viiteavaimenkin oletusarvo on
+// käytettävissä. sequence()-lausekkeet ohitetaan (ks. EvaluateSequences). Palauttaa vain
+// lasketut arvot; riviä ei muokata. Virheelliset lausekkeet ohitetaan lokituksen kera.
+func Evaluate(q Queryer, columnsInfo []models.AddRowColumnInfo, row map[string]interface{}, ctx Context) (map[string]interface{}, error) {
+	if ctx.Now.IsZero() {
+		ctx.Now = time.Now()
+	}
+	values := make(map[string]interface{})
+	current := func(column string) interface{} {
+		if v, ok := values[column]; ok {
+			return v
+		}
+		return row[column]
+	}
+
+	var parents []models.AddRowColumnInfo
+	parsed := make(map[string]*Expression)
+	for _, col := range columnsInfo {
+		if col.DefaultExpression == "" || !isEmpty(row[col.ColumnName]) {
+			continue
+		}
+		expr, err := Parse(col.DefaultExpression)
+		if err != nil {
+			log.Printf("\033[31mvirhe: sarakkeen %s oletusarvolauseke ohitetaan: %s\033[0m\n", col.ColumnName, err.Error())
+			continue
+		}
+		parsed[col.ColumnName] = expr
+		if expr.Kind == KindSequence {
+			continue
+		}
+		if expr.Kind == KindParent {
+			parents = append(parents, col)
+			continue
+		}
+		value, err := expr.eval(q, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("sarakkeen %s oletusarvo: %w", col.ColumnName, err)
+		}
+		if value != nil {
+			values[col.ColumnName] = value
+		}
+	}
+
+	for _, col := range parents {
+		expr := parsed[col.ColumnName]
+		fkColumn, ok := findColumn(columnsInfo, expr.ForeignKey)
+		if !ok || fkColumn.ForeignTableName == "" {
+			log.Printf("\033[31mvirhe: sarakkeen %s oletusarvolauseke ohitetaan: %s ei ole viiteavain\033[0m\n", col.ColumnName, expr.ForeignKey)
+			continue
+		}
+		value, err := parentValue(q, ctx, fkColumn, expr.Column, current(expr.ForeignKey))
+		if err != nil {
+			return nil, fmt.Errorf("sarakkeen %s oletusarvo: %w", col.ColumnName, err)
+		}
+		if value != nil {
+			values[col.ColumnName] = value
+		}
+	}
+	return values, nil
+}
+
+// Apply laskee oletusarvot ja asettaa ne riville.
+func Apply(q Queryer, columnsInfo []models.AddRowColumnInfo, row map[string]interface{}, ctx Context) error {
+	values, err := Evaluate(q, columnsInfo, row, ctx)
+	if err != nil {
+		return err
+	}
+	for column, value := range values {
+		row[column] = value
+	}
+	return nil
+}
+
+// eval laskee muun kuin parent()-lausekkeen arvon. nil tarkoittaa, ettei arvoa aseteta.
+func (e *Expression) eval(q Queryer, ctx Context) (interface{}, error) {
+	switch e.Kind {
+	case KindCurrentUser:
+		if ctx.UserID <= 0 {
+			return nil, nil
+		}
+		return ctx.UserID, nil
+	case KindCurrentUsername:
+		if ctx.Username == "" {
+			return nil, nil
+		}
+		return ctx.Username, nil
+	case KindCurrentUserGroup:
+		return currentUserGroup(q, ctx.UserID)
+	case KindToday:
+		return e.shift(ctx.Now).Format("2006-01-02"), nil
+	case KindNow:
+		return e.shift(ctx.Now).Format("2006-01-02T15:04:05"), nil
+	case KindLiteral:
+		return e.Literal, nil
+	}
+	return nil, fmt.Errorf("lauseketta %s ei voi laskea ilman riviä", e.Kind)
+}
+
+// shift siirtää aikaa lausekkeen määrällä.
+func (e *Expression) shift(t time.Time) time.Time {
+	switch e.Unit {
+	case "day":
+		return t.AddDate(0, 0, e.Offset)
+	case "week":
+		return t.AddDate(0, 0, 7*e.Offset)
+	case "month":
+		return addMonths(t, e.Offset)
+	case "year":
+		return addMonths(t, 12*e.Offset)
+	case "hour":
+		return t.Add(time.Duration(e.Offset) * time.Hour)
+	case "minute":
+		return t.Add(time.Duration(e.Offset) * time.Minute)
+	}
+	return t
+}
+
+// addMonths siirtää aikaa kuukausilla; päivä rajataan kuukauden viimeiseen päivään,
+// jottei 31.1. + 1 month valu maaliskuulle.
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	target := first.AddDate(0, months, 0)
+	lastDay := target.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return target.AddDate(0, 0, day-1)
+}
+
+// SequenceColumns palauttaa sarakkeet, joiden arvo lasketaan sequence()-lausekkeella
+// (lauseke on kelvollinen ja rivin arvo on tyhjä).
+func SequenceColumns(columnsInfo []models.AddRowColumnInfo, row map[string]interface{}) []string {
+	var columns []string
+	for _, col := range columnsInfo {
+		if col.DefaultExpression == "" || !isEmpty(row[col.ColumnName]) {
+			continue
+		}
+		if expr, err := Parse(col.DefaultExpression); err == nil && expr.Kind == KindSequence {
+			columns = append(columns, col.ColumnName)
+		}
+	}
+	return columns
+}
+
+// EvaluateSequences laskee SequenceColumns-sarakkeiden arvot. Kutsutaan vasta, kun rivi
+// on validoitu. Jos peek on annettu, sekvenssejä ei kasvateta, vaan seuraavat arvot
+// ennustetaan (tuonnin koeajo ja perutuksi jäävä tuonti).
+func EvaluateSequences(q Queryer, columnsInfo []models.AddRowColumnInfo, row map[string]interface{}, peek *SequencePeek) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, column := range SequenceColumns(columnsInfo, row) {
+		col, _ := findColumn(columnsInfo, column)
+		expr, _ := Parse(col.DefaultExpression)
+		var next int64
+		var err error
+		if peek != nil {
+			next, err = peek.next(q, expr.Sequence)
+		} else {
+			err = q.QueryRow(`SELECT nextval($1::regclass)`, expr.Sequence).Scan(&next)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sekvenssin %s arvon haku epäonnistui: %w", expr.Sequence, err)
+		}
+		values[column] = expr.formatSequenceValue(next)
+	}
+	return values, nil
+}
+
+// SequencePeek ennustaa sekvenssien seuraavat arvot kasvattamatta niitä.
+type SequencePeek struct {
+	values map[string]int64
+	steps  map[string]int64
+}
+
+// NewSequencePeek luo tyhjän ennustajan.
+func NewSequencePeek() *SequencePeek {
+	return &SequencePeek{values: make(map[string]int64), steps: make(map[string]int64)}
+}
+
+func (p *SequencePeek) next(q Queryer, sequence string) (int64, error) {
+	if value, ok := p.values[sequence]; ok {
+		value += p.steps[sequence]
+		p.values[sequence] = value
+		return value, nil
+	}
+	var value, step int64
+	err := q.QueryRow(`
+		SELECT COALESCE(pg_sequence_last_value($1::regclass) + seqincrement, seqstart), seqincrement
+		FROM pg_sequence
+		WHERE seqrelid = $1::regclass
+	`, sequence).Scan(&value, &step)
+	if err != nil {
+		return 0, err
+	}
+	p.values[sequence] = value
+	p.steps[sequence] = step
+	return value, nil
+}
+
+// formatSequenceValue muotoilee sekvenssin arvon. Ilman etuliitettä ja pituutta arvo on luku.
+func (e *Expression) formatSequenceValue(next int64) interface{} {
+	if e.Prefix == "" && e.Width == 0 {
+		return next
+	}
+	return fmt.Sprintf("%s%0*d", e.Prefix, e.Width, next)
+}
+
+// currentUserGroup palauttaa käyttäjän ryhmistä pienimmän id:n; nil, jos ryhmää ei ole.
+func currentUserGroup(q Queryer, userID int) (interface{}, error) {
+	if userID <= 0 {
+		return nil, nil
+	}
+	var groupID int
+	err := q.QueryRow(`
+		SELECT group_id
+		FROM auth_user_group_memberships
+		WHERE user_id = $1
+		ORDER BY group_id
+		LIMIT 1
+	`, userID).Scan(&groupID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("käyttäjän %d ryhmän haku epäonnistui: %w", userID, err)
+	}
+	return groupID, nil
+}
+
+// parentValue lukee sarakkeen arvon riviltä, johon viiteavain osoittaa. Käyttäjän antama
+// avain luetaan roolin yhteydellä rivitason säännöt huomioiden, joten lauseke ei paljasta
+// rivejä tai sarakkeita, joita käyttäjä ei saa lukea. Jos viiteavaimella ei ole arvoa tai
+// riviä ei löydy tai näy, arvoa ei aseteta.
+func parentValue(q Queryer, ctx Context, fkColumn models.AddRowColumnInfo, column string, key interface{}) (interface{}, error) {
+	if isEmpty(key) {
+		return nil, nil
+	}
+	whereClause := fmt.Sprintf(" WHERE %s = $1", pq.QuoteIdentifier(fkColumn.ForeignColumnName))
+	args := []interface{}{key}
+	reader := q
+	if fkColumn.ColumnName != ctx.TrustedParent {
+		if ctx.RoleDb == nil {
+			return nil, nil
+		}
+		reader = ctx.RoleDb
+		var err error
+		whereClause, args, err = row_policies.AppendCondition(whereClause, args, ctx.UserID, fkColumn.ForeignTableName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	query := fmt.Sprintf(`SELECT %s FROM %s%s LIMIT 1`,
+		pq.QuoteIdentifier(column),
+		pq.QuoteIdentifier(fkColumn.ForeignTableName),
+		whereClause,
+	)
+	var value interface{}
+	err := reader.QueryRow(query, args...).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code == "42501" || pqErr.Code == "22P02") {
+		// Roolilla ei ole lukuoikeutta sarakkeeseen tai avain ei kelpaa sarakkeen tyypiksi
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("arvon %s.%s haku epäonnistui: %w", fkColumn.ForeignTableName, column, err)
+	}
+	switch v := value.(type) {
+	case []byte:
+		return string(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	}
+	return value, nil
+}
+
+func findColumn(columnsInfo []models.AddRowColumnInfo, name string) (models.AddRowColumnInfo, bool) {
+	for _, col := range columnsInfo {
+		if col.ColumnName == name {
+			return col, true
+		}
+	}
+	return models.AddRowColumnInfo{}, false
+}
+
+// isEmpty kertoo, puuttuuko sarakkeen arvo (null tai pelkkiä välilyöntejä).
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if s, ok := value.(string); ok {
+		return strings.TrimSpace(s) == ""
+	}
+	return false
+}
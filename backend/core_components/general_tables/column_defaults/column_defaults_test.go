@@ -0,0 +1,120 @@
+package column_defaults
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    *Expression
+		wantErr bool
+	}{
+		{raw: "current_user", want: &Expression{Kind: KindCurrentUser}},
+		{raw: " Current_Username ", want: &Expression{Kind: KindCurrentUsername}},
+		{raw: "current_user_group", want: &Expression{Kind: KindCurrentUserGroup}},
+		{raw: "today", want: &Expression{Kind: KindToday}},
+		{raw: "today + 14 days", want: &Expression{Kind: KindToday, Offset: 14, Unit: "day"}},
+		{raw: "today-1 month", want: &Expression{Kind: KindToday, Offset: -1, Unit: "month"}},
+		{raw: "now - 2 hours", want: &Expression{Kind: KindNow, Offset: -2, Unit: "hour"}},
+		{raw: "today + 2 hours", wantErr: true},
+		{raw: "today + 2 fortnights", wantErr: true},
+		{raw: "sequence('invoice_seq')", want: &Expression{Kind: KindSequence, Sequence: "invoice_seq"}},
+		{raw: "sequence(invoice_seq, 'INV-', 5)", want: &Expression{Kind: KindSequence, Sequence: "invoice_seq", Prefix: "INV-", Width: 5}},
+		{raw: "sequence('public.invoice_seq', '')", want: &Expression{Kind: KindSequence, Sequence: "public.invoice_seq"}},
+		{raw: "sequence('invoice_seq', 'INV-', 31)", wantErr: true},
+		{raw: "parent(customer_id.payment_terms)", want: &Expression{Kind: KindParent, ForeignKey: "customer_id", Column: "payment_terms"}},
+		{raw: "parent(customer_id)", wantErr: true},
+		{raw: "'open'", want: &Expression{Kind: KindLiteral, Literal: "open"}},
+		{raw: "''", want: &Expression{Kind: KindLiteral, Literal: ""}},
+		{raw: "open", wantErr: true},
+		{raw: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := Parse(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %+v, want error", tt.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.raw, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShift(t *testing.T) {
+	base := time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		expr Expression
+		want time.Time
+	}{
+		{"no offset", Expression{Kind: KindToday}, base},
+		{"days", Expression{Offset: 14, Unit: "day"}, time.Date(2024, time.February, 14, 10, 30, 0, 0, time.UTC)},
+		{"weeks back", Expression{Offset: -1, Unit: "week"}, time.Date(2024, time.January, 24, 10, 30, 0, 0, time.UTC)},
+		{"month clamps to leap day", Expression{Offset: 1, Unit: "month"}, time.Date(2024, time.February, 29, 10, 30, 0, 0, time.UTC)},
+		{"year", Expression{Offset: 1, Unit: "year"}, time.Date(2025, time.January, 31, 10, 30, 0, 0, time.UTC)},
+		{"hours", Expression{Offset: -2, Unit: "hour"}, time.Date(2024, time.January, 31, 8, 30, 0, 0, time.UTC)},
+		{"minutes", Expression{Offset: 45, Unit: "minute"}, time.Date(2024, time.January, 31, 11, 15, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.expr.shift(base); !got.Equal(tt.want) {
+				t.Errorf("shift() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		from   string
+		months int
+		want   string
+	}{
+		{"2024-01-31", 1, "2024-02-29"},
+		{"2023-01-31", 1, "2023-02-28"},
+		{"2024-03-31", -1, "2024-02-29"},
+		{"2024-05-31", 1, "2024-06-30"},
+		{"2024-01-15", 1, "2024-02-15"},
+		{"2024-12-31", 2, "2025-02-28"},
+		{"2024-02-29", 12, "2025-02-28"},
+		{"2024-02-29", -24, "2022-02-28"},
+		{"2024-08-31", 0, "2024-08-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			from, _ := time.Parse("2006-01-02", tt.from)
+			if got := addMonths(from, tt.months).Format("2006-01-02"); got != tt.want {
+				t.Errorf("addMonths(%s, %d) = %s, want %s", tt.from, tt.months, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatSequenceValue(t *testing.T) {
+	tests := []struct {
+		expr Expression
+		next int64
+		want interface{}
+	}{
+		{Expression{}, 42, int64(42)},
+		{Expression{Prefix: "INV-", Width: 5}, 42, "INV-00042"},
+		{Expression{Width: 3}, 1234, "1234"},
+		{Expression{Prefix: "A"}, 7, "A7"},
+	}
+	for _, tt := range tests {
+		if got := tt.expr.formatSequenceValue(tt.next); got != tt.want {
+			t.Errorf("formatSequenceValue(%+v, %d) = %v, want %v", tt.expr, tt.next, got, tt.want)
+		}
+	}
+}
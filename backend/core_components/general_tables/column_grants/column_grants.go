@@ -0,0 +1,65 @@
+// file: column_grants.go
+package column_grants
+
+import (
+	"database/sql"
+	"fmt"
+
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// Roolin sarakeoikeudet luetaan information_schema.column_privileges-näkymästä
+// roolin omalla yhteydellä (grantee = current_user). Oikeudet ovat roolikohtaisia,
+// joten välimuistiavaimessa on yhteys.
+
+// SelectableColumns palauttaa sarakkeet, joihin yhteyden roolilla on SELECT-oikeus.
+func SelectableColumns(db *sql.DB, tableName string) ([]string, error) {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindColumnGrants, fmt.Sprintf("%p", db), tableName),
+		func() (interface{}, error) { return loadSelectableColumns(db, tableName) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), cached.([]string)...), nil
+}
+
+// SelectableSet palauttaa SelectableColumnsin sarakkeet joukkona.
+func SelectableSet(db *sql.DB, tableName string) (map[string]bool, error) {
+	columns, err := SelectableColumns(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		allowed[col] = true
+	}
+	return allowed, nil
+}
+
+func loadSelectableColumns(db *sql.DB, tableName string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT column_name
+		FROM information_schema.column_privileges
+		WHERE table_name = $1
+		  AND privilege_type = 'SELECT'
+		  AND grantee = current_user
+	`, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("sarakeoikeuksien haku taululle %s epäonnistui: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
@@ -0,0 +1,36 @@
+package column_grants
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"easelect/backend/core_components/metadata_cache"
+)
+
+func TestSelectableSetUsesCachedGrants(t *testing.T) {
+	db := &sql.DB{} // ei yhteyttä: arvo tulee välimuistista
+	_, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindColumnGrants, fmt.Sprintf("%p", db), "customers"),
+		func() (interface{}, error) { return []string{"id", "name"}, nil },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := SelectableSet(db, "customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"id": true, "name": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SelectableSet() = %v, want %v", got, want)
+	}
+
+	// Palautettu lista on kopio, joten kutsuja ei voi muuttaa välimuistin arvoa
+	columns, _ := SelectableColumns(db, "customers")
+	columns[0] = "secret"
+	if again, _ := SelectableColumns(db, "customers"); again[0] != "id" {
+		t.Errorf("välimuistin arvo muuttui: %v", again)
+	}
+}
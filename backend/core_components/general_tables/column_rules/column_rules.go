@@ -0,0 +1,475 @@
+// file: column_rules.go
+package column_rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// Sarakkeiden validointisäännöt tallennetaan system_column_details.validation_rules
+// -sarakkeeseen JSON-oliona, esim.
+//
+//	{"required": true, "pattern": "^[A-Z]{2}-\\d+$", "min_length": 3, "max_length": 20}
+//	{"min": 0, "max": 100}
+//	{"min": "2020-01-01", "compare": [{"op": ">=", "column": "start_date"}]}
+//	{"allowed_values": ["open", "closed"], "message": "Tila on open tai closed"}
+//
+// Säännöt tarkistetaan palvelimella rivin lisäyksessä, päivityksessä, massapäivityksessä
+// ja tuonnissa; rivinlisäyslomake saa samat säännöt get-add-row-metadatasta ja tarkistaa
+// ne samoin (validation_rules.js). Tyhjä arvo (null tai pelkkiä välilyöntejä) rikkoo
+// vain required-säännön, muut säännöt ohitetaan tyhjälle arvolle.
+// min ja max vertaavat lukuja tai päivämääriä, compare vertaa arvoa saman rivin
+// toiseen sarakkeeseen. message korvaa oletusvirheilmoituksen.
+
+// Sääntöjen nimet virheissä.
+const (
+	RuleRequired      = "required"
+	RulePattern       = "pattern"
+	RuleMin           = "min"
+	RuleMax           = "max"
+	RuleMinLength     = "min_length"
+	RuleMaxLength     = "max_length"
+	RuleAllowedValues = "allowed_values"
+	RuleCompare       = "compare"
+)
+
+// allowedCompareOps ovat compare-säännön sallitut vertailut.
+var allowedCompareOps = map[string]bool{
+	"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+}
+
+// CompareRule vertaa sarakkeen arvoa saman rivin toisen sarakkeen arvoon.
+type CompareRule struct {
+	Op      string `json:"op"`
+	Column  string `json:"column"`
+	Message string `json:"message,omitempty"`
+}
+
+// Rules ovat yhden sarakkeen validointisäännöt.
+type Rules struct {
+	Required      bool          `json:"required,omitempty"`
+	Pattern       string        `json:"pattern,omitempty"`
+	Min           interface{}   `json:"min,omitempty"`
+	Max           interface{}   `json:"max,omitempty"`
+	MinLength     *int          `json:"min_length,omitempty"`
+	MaxLength     *int          `json:"max_length,omitempty"`
+	AllowedValues []interface{} `json:"allowed_values,omitempty"`
+	Compare       []CompareRule `json:"compare,omitempty"`
+	Message       string        `json:"message,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// FieldError on yhden sarakkeen validointivirhe.
+type FieldError struct {
+	Column  string `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// TableRules ovat taulun säännöt sarakkeittain. Välimuistista saatua arvoa ei saa muokata.
+type TableRules map[string]*Rules
+
+// ErrorMessage on validointivirheiden yhteinen viesti vastauksissa.
+const ErrorMessage = "rivin tiedot eivät täytä sarakkeiden sääntöjä"
+
+// WriteErrors kirjoittaa validointivirheet vastaukseen (422, {"message", "errors"}).
+func WriteErrors(w http.ResponseWriter, errs []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": ErrorMessage,
+		"errors":  errs,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// EnsureValidationRulesColumn lisää validation_rules-sarakkeen system_column_details-tauluun.
+func EnsureValidationRulesColumn() error {
+	_, err := backend.Db.Exec(`
+		ALTER TABLE system_column_details
+		ADD COLUMN IF NOT EXISTS validation_rules JSONB
+	`)
+	if err != nil {
+		return fmt.Errorf("validation_rules-sarakkeen lisäys epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// ForTable palauttaa taulun säännöt. Tyhjä tulos tarkoittaa, ettei taululla ole sääntöjä.
+func ForTable(tableName string) (TableRules, error) {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindColumns, "validation_rules", tableName),
+		func() (interface{}, error) { return loadTableRules(tableName) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	return cached.(TableRules), nil
+}
+
+// loadTableRules lukee säännöt. Virheelliset määritykset ohitetaan lokituksen kera,
+// jotta yksi rikkinäinen sääntö ei estä koko taulun muokkausta.
+func loadTableRules(tableName string) (TableRules, error) {
+	rows, err := backend.Db.Query(`
+		SELECT scd.column_name, scd.validation_rules
+		FROM system_column_details scd
+		JOIN system_db_tables sdt ON sdt.table_uid = scd.table_uid
+		WHERE sdt.table_name = $1
+		  AND scd.validation_rules IS NOT NULL
+	`, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("validointisääntöjen haku taululle %s epäonnistui: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	result := make(TableRules)
+	for rows.Next() {
+		var column string
+		var raw []byte
+		if err := rows.Scan(&column, &raw); err != nil {
+			return nil, fmt.Errorf("validointisääntöjen luku epäonnistui: %w", err)
+		}
+		rules, err := Parse(raw)
+		if err != nil {
+			log.Printf("\033[31mvirhe: sarakkeen %s.%s validointisääntö ohitetaan: %s\033[0m\n", tableName, column, err.Error())
+			continue
+		}
+		if rules != nil {
+			result[column] = rules
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("validointisääntöjen luku epäonnistui: %w", err)
+	}
+	return result, nil
+}
+
+// Parse lukee ja tarkistaa sarakkeen säännöt. JSON null palauttaa nil.
+func Parse(raw []byte) (*Rules, error) {
+	var rules *Rules
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("virheellinen validointisääntö: %w", err)
+	}
+	if rules == nil {
+		return nil, nil
+	}
+	if err := rules.prepare(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// prepare tarkistaa säännöt ja kääntää säännöllisen lausekkeen.
+func (r *Rules) prepare() error {
+	if r.Pattern != "" {
+		compiled, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("virheellinen pattern %q: %w", r.Pattern, err)
+		}
+		r.pattern = compiled
+	}
+	if r.MinLength != nil && *r.MinLength < 0 {
+		return fmt.Errorf("min_length ei voi olla negatiivinen")
+	}
+	if r.MaxLength != nil && *r.MaxLength < 0 {
+		return fmt.Errorf("max_length ei voi olla negatiivinen")
+	}
+	if r.MinLength != nil && r.MaxLength != nil && *r.MinLength > *r.MaxLength {
+		return fmt.Errorf("min_length on suurempi kuin max_length")
+	}
+	for _, bound := range []interface{}{r.Min, r.Max} {
+		if bound == nil {
+			continue
+		}
+		if _, ok := toNumber(bound); ok {
+			continue
+		}
+		if _, ok := toTime(bound); ok {
+			continue
+		}
+		return fmt.Errorf("min- ja max-arvojen on oltava lukuja tai päivämääriä: %v", bound)
+	}
+	for _, cmp := range r.Compare {
+		if !allowedCompareOps[cmp.Op] {
+			return fmt.Errorf("tuntematon compare-vertailu: %s", cmp.Op)
+		}
+		if cmp.Column == "" {
+			return fmt.Errorf("compare-säännöltä puuttuu column")
+		}
+	}
+	return nil
+}
+
+// ValidateRow tarkistaa lisättävän rivin kaikki säännöt. Puuttuva sarake on tyhjä.
+func (t TableRules) ValidateRow(row map[string]interface{}) []FieldError {
+	var errs []FieldError
+	for _, column := range t.columns() {
+		errs = append(errs, t[column].validate(column, row, nil)...)
+	}
+	return errs
+}
+
+// ValidateChanges tarkistaa päivityksen: current on rivin nykytila ja changes muuttuvat
+// sarakkeet. Muuttuvien sarakkeiden kaikki säännöt tarkistetaan, muista sarakkeista vain
+// compare-säännöt, jotka viittaavat muuttuvaan sarakkeeseen.
+func (t TableRules) ValidateChanges(current, changes map[string]interface{}) []FieldError {
+	if len(t) == 0 {
+		return nil
+	}
+	row := make(map[string]interface{}, len(current)+len(changes))
+	for column, value := range current {
+		row[column] = value
+	}
+	for column, value := range changes {
+		row[column] = value
+	}
+
+	var errs []FieldError
+	for _, column := range t.columns() {
+		if _, changed := changes[column]; changed {
+			errs = append(errs, t[column].validate(column, row, nil)...)
+			continue
+		}
+		errs = append(errs, t[column].validate(column, row, changes)...)
+	}
+	return errs
+}
+
+// columns palauttaa sääntöjen sarakkeet aakkosjärjestyksessä, jotta virheiden
+// järjestys on aina sama.
+func (t TableRules) columns() []string {
+	columns := make([]string, 0, len(t))
+	for column := range t {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// validate tarkistaa sarakkeen säännöt. Jos onlyCompareWith on annettu, tarkistetaan
+// vain compare-säännöt, joiden vertailusarake on siinä.
+func (r *Rules) validate(column string, row map[string]interface{}, onlyCompareWith map[string]interface{}) []FieldError {
+	value := row[column]
+	var errs []FieldError
+	fail := func(rule, defaultMessage string) {
+		message := defaultMessage
+		if r.Message != "" {
+			message = r.Message
+		}
+		errs = append(errs, FieldError{Column: column, Rule: rule, Message: message})
+	}
+
+	if onlyCompareWith == nil {
+		if isEmpty(value) {
+			if r.Required {
+				fail(RuleRequired, "kenttä on pakollinen")
+			}
+			return errs
+		}
+
+		text := toText(value)
+		if r.pattern != nil && !r.pattern.MatchString(text) {
+			fail(RulePattern, "arvo ei ole vaaditussa muodossa")
+		}
+		length := utf8.RuneCountInString(text)
+		if r.MinLength != nil && length < *r.MinLength {
+			fail(RuleMinLength, fmt.Sprintf("arvon on oltava vähintään %d merkkiä", *r.MinLength))
+		}
+		if r.MaxLength != nil && length > *r.MaxLength {
+			fail(RuleMaxLength, fmt.Sprintf("arvo saa olla enintään %d merkkiä", *r.MaxLength))
+		}
+		if r.Min != nil {
+			if cmp, ok := compareValues(value, r.Min); !ok || cmp < 0 {
+				fail(RuleMin, fmt.Sprintf("arvon on oltava vähintään %s", toText(r.Min)))
+			}
+		}
+		if r.Max != nil {
+			if cmp, ok := compareValues(value, r.Max); !ok || cmp > 0 {
+				fail(RuleMax, fmt.Sprintf("arvo saa olla enintään %s", toText(r.Max)))
+			}
+		}
+		if len(r.AllowedValues) > 0 && !r.allows(text) {
+			fail(RuleAllowedValues, "arvo ei ole sallittujen arvojen joukossa")
+		}
+	} else if isEmpty(value) {
+		return nil
+	}
+
+	for _, rule := range r.Compare {
+		if onlyCompareWith != nil {
+			if _, ok := onlyCompareWith[rule.Column]; !ok {
+				continue
+			}
+		}
+		other := row[rule.Column]
+		if isEmpty(other) {
+			continue
+		}
+		cmp, ok := compareValues(value, other)
+		if ok && compareHolds(cmp, rule.Op) {
+			continue
+		}
+		message := rule.Message
+		if message == "" {
+			message = fmt.Sprintf("arvon on oltava %s sarakkeen %s arvo", rule.Op, rule.Column)
+		}
+		errs = append(errs, FieldError{Column: column, Rule: RuleCompare, Message: message})
+	}
+	return errs
+}
+
+// allows kertoo, onko arvo sallittujen arvojen joukossa (tekstimuodossa verraten).
+func (r *Rules) allows(text string) bool {
+	for _, allowed := range r.AllowedValues {
+		if toText(allowed) == text {
+			return true
+		}
+	}
+	return false
+}
+
+func compareHolds(cmp int, op string) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// isEmpty kertoo, onko arvo tyhjä (null tai pelkkiä välilyöntejä).
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if s, ok := value.(string); ok {
+		return strings.TrimSpace(s) == ""
+	}
+	return false
+}
+
+// compareValues vertaa arvoja lukuina, sitten päivämäärinä ja lopuksi tekstinä, jos
+// kumpikin on tekstiä. ok on false, jos arvoja ei voi verrata.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if x, ok := toTime(a); ok {
+		if y, ok := toTime(b); ok {
+			return x.Compare(y), true
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	}
+	if x, ok := a.(bool); ok {
+		if y, ok := b.(bool); ok && x == y {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	return 0, false
+}
+
+// dateLayouts ovat lomakkeelta, tuonnista ja Postgresin JSON-muodosta tulevat muodot.
+var dateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+}
+
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// toText palauttaa arvon tekstimuodon; luvut ilman turhia desimaaleja.
+func toText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case json.Number:
+		return v.String()
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
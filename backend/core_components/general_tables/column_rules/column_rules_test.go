@@ -0,0 +1,165 @@
+package column_rules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mustParse lukee testin säännöt ja keskeyttää, jos ne ovat virheelliset.
+func mustParse(t *testing.T, raw string) *Rules {
+	t.Helper()
+	rules, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse(%s) error: %v", raw, err)
+	}
+	return rules
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "null", raw: `null`, wantNil: true},
+		{name: "all rules", raw: `{"required": true, "pattern": "^[A-Z]{2}-\\d+$", "min_length": 3, "max_length": 20, "allowed_values": ["AB-1"], "message": "x"}`},
+		{name: "numeric bounds", raw: `{"min": 0, "max": 100.5}`},
+		{name: "date bounds", raw: `{"min": "2020-01-01", "max": "2030-12-31T23:59:59Z"}`},
+		{name: "compare", raw: `{"compare": [{"op": ">=", "column": "start_date"}]}`},
+		{name: "invalid json", raw: `{"required": `, wantErr: true},
+		{name: "unknown field", raw: `{"requried": true}`, wantErr: true},
+		{name: "invalid pattern", raw: `{"pattern": "("}`, wantErr: true},
+		{name: "negative length", raw: `{"min_length": -1}`, wantErr: true},
+		{name: "min length above max", raw: `{"min_length": 5, "max_length": 2}`, wantErr: true},
+		{name: "text bound", raw: `{"min": "abc"}`, wantErr: true},
+		{name: "unknown compare op", raw: `{"compare": [{"op": "~", "column": "x"}]}`, wantErr: true},
+		{name: "compare without column", raw: `{"compare": [{"op": "<"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Parse([]byte(tt.raw))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%s) = %+v, want error", tt.raw, rules)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%s) error: %v", tt.raw, err)
+			}
+			if (rules == nil) != tt.wantNil {
+				t.Errorf("Parse(%s) = %+v, want nil: %v", tt.raw, rules, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestValidateRow(t *testing.T) {
+	rules := TableRules{
+		"code":     mustParse(t, `{"required": true, "pattern": "^[A-Z]{2}-\\d+$", "max_length": 6}`),
+		"qty":      mustParse(t, `{"min": 1, "max": 10}`),
+		"status":   mustParse(t, `{"allowed_values": ["open", "closed"], "message": "Tila on open tai closed"}`),
+		"name":     mustParse(t, `{"min_length": 2}`),
+		"end_date": mustParse(t, `{"min": "2020-01-01", "compare": [{"op": ">=", "column": "start_date"}]}`),
+	}
+	tests := []struct {
+		name string
+		row  map[string]interface{}
+		want []FieldError
+	}{
+		{
+			name: "valid",
+			row: map[string]interface{}{
+				"code": "AB-12", "qty": "10", "status": "open", "name": "Öljy",
+				"start_date": "2024-01-01", "end_date": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "required missing, other empty values skipped",
+			row:  map[string]interface{}{"code": "  ", "qty": nil, "status": ""},
+			want: []FieldError{{Column: "code", Rule: RuleRequired, Message: "kenttä on pakollinen"}},
+		},
+		{
+			name: "pattern, length, bounds and allowed values",
+			row:  map[string]interface{}{"code": "abc-1234", "qty": float64(11), "status": "lost", "name": "x"},
+			want: []FieldError{
+				{Column: "code", Rule: RulePattern, Message: "arvo ei ole vaaditussa muodossa"},
+				{Column: "code", Rule: RuleMaxLength, Message: "arvo saa olla enintään 6 merkkiä"},
+				{Column: "name", Rule: RuleMinLength, Message: "arvon on oltava vähintään 2 merkkiä"},
+				{Column: "qty", Rule: RuleMax, Message: "arvo saa olla enintään 10"},
+				{Column: "status", Rule: RuleAllowedValues, Message: "Tila on open tai closed"},
+			},
+		},
+		{
+			name: "not a number",
+			row:  map[string]interface{}{"code": "AB-1", "qty": "many"},
+			want: []FieldError{
+				{Column: "qty", Rule: RuleMin, Message: "arvon on oltava vähintään 1"},
+				{Column: "qty", Rule: RuleMax, Message: "arvo saa olla enintään 10"},
+			},
+		},
+		{
+			name: "date bound and compare",
+			row:  map[string]interface{}{"code": "AB-1", "start_date": "2024-05-01", "end_date": "2019-12-31"},
+			want: []FieldError{
+				{Column: "end_date", Rule: RuleMin, Message: "arvon on oltava vähintään 2020-01-01"},
+				{Column: "end_date", Rule: RuleCompare, Message: "arvon on oltava >= sarakkeen start_date arvo"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.ValidateRow(tt.row); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateRow() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateChanges(t *testing.T) {
+	rules := TableRules{
+		"code":     mustParse(t, `{"required": true}`),
+		"qty":      mustParse(t, `{"min": 1}`),
+		"end_date": mustParse(t, `{"compare": [{"op": ">", "column": "start_date", "message": "Loppu ennen alkua"}]}`),
+	}
+	current := map[string]interface{}{
+		"code": "", "qty": float64(0), "start_date": "2024-01-01", "end_date": "2024-02-01",
+	}
+	tests := []struct {
+		name    string
+		changes map[string]interface{}
+		want    []FieldError
+	}{
+		{
+			name:    "unchanged invalid columns are not reported",
+			changes: map[string]interface{}{"qty": "5"},
+		},
+		{
+			name:    "changed column checked fully",
+			changes: map[string]interface{}{"code": nil},
+			want:    []FieldError{{Column: "code", Rule: RuleRequired, Message: "kenttä on pakollinen"}},
+		},
+		{
+			name:    "compare rechecked when referenced column changes",
+			changes: map[string]interface{}{"start_date": "2024-03-01"},
+			want:    []FieldError{{Column: "end_date", Rule: RuleCompare, Message: "Loppu ennen alkua"}},
+		},
+		{
+			name:    "compare skipped when other side is empty",
+			changes: map[string]interface{}{"start_date": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.ValidateChanges(current, tt.changes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateChanges(%v) = %+v, want %+v", tt.changes, got, tt.want)
+			}
+		})
+	}
+
+	if got := (TableRules{}).ValidateChanges(current, map[string]interface{}{"code": nil}); got != nil {
+		t.Errorf("ValidateChanges() without rules = %+v, want nil", got)
+	}
+}
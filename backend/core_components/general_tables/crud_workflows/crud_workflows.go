@@ -9,11 +9,13 @@ import (
 	"net/http"
 	"strings"
 
+	"easelect/backend/core_components/general_tables/change_feed"
 	"easelect/backend/core_components/general_tables/gt_2_column_crud"
 	"easelect/backend/core_components/general_tables/gt_2_column_crud/gt_2_column_create"
 	"easelect/backend/core_components/general_tables/gt_2_column_crud/gt_2_column_delete"
 	"easelect/backend/core_components/general_tables/gt_3_table_crud/gt_3_table_create"
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/metadata_cache"
 	"easelect/backend/core_components/security"
 )
 
@@ -130,6 +132,14 @@ func CreateTableHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	metadata_cache.InvalidateAll()
+
+	// Uusi taulu mukaan muutossyötteeseen
+	err = change_feed.EnsureChangeFeedTrigger(backend.Db, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Taulu luotu onnistuneesti"))
 }
@@ -215,6 +225,8 @@ func ModifyColumnsHandler(w http.ResponseWriter, r *http.Request) {
 			} else {
 				fmt.Println("Transaktio commitattu onnistuneesti")
 			}
+			// Sarakekartat ja sarakeoikeudet on luettava uudelleen skeemamuutoksen jälkeen
+			metadata_cache.InvalidateAll()
 		}
 	}()
 
@@ -3,6 +3,7 @@ package foreign_keys
 
 import (
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/metadata_cache"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -70,6 +71,8 @@ func AddForeignKeyHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Error adding foreign key: %v", err), http.StatusInternalServerError)
 		return
 	}
+	metadata_cache.InvalidateTable(requestData.ReferencingTable)
+	metadata_cache.Invalidate(metadata_cache.KindForeignKeys)
 
 	// Return success message
 	w.Header().Set("Content-Type", "application/json")
@@ -9,8 +9,9 @@ import (
 
 // SyncOneToManyFKConstraints lukee kaikki tietokannan ulkoavaimet (FOREIGN KEY)
 // ja synkronoi ne foreign_key_relations_1_m -tauluun.
-// Nyt rajataan mukaan vain ne ulkoavaimet, joissa *lähdetaululla*
-// on yksisarakkeinen PK. "1" viittaa siis lähdetauluun.
+// Mukaan otetaan yksisarakkeiset ulkoavaimet, joiden *lähdetaululla* on
+// pääavain (myös UUID- tai yhdistelmäavain). "1" viittaa siis lähdetauluun.
+// Moniosaisia ulkoavaimia ei voi esittää yhden sarakeparin rivinä, joten ne ohitetaan.
 func SyncOneToManyFKConstraints(db *sql.DB) error {
 	// log.Println("[INFO] Synchronizing 1-to-many foreign keys...")
 
@@ -25,9 +26,10 @@ func SyncOneToManyFKConstraints(db *sql.DB) error {
 		JOIN pg_class t       ON c.conrelid = t.oid
 		JOIN pg_namespace ns  ON ns.oid = t.relnamespace
 		JOIN pg_class ft      ON c.confrelid = ft.oid
-		JOIN pg_attribute a   ON a.attrelid = t.oid  AND a.attnum  = ANY(c.conkey)
-		JOIN pg_attribute fa  ON fa.attrelid = ft.oid AND fa.attnum = ANY(c.confkey)
+		JOIN pg_attribute a   ON a.attrelid = t.oid  AND a.attnum  = c.conkey[1]
+		JOIN pg_attribute fa  ON fa.attrelid = ft.oid AND fa.attnum = c.confkey[1]
 		WHERE c.contype = 'f'
+		  AND array_length(c.conkey, 1) = 1
 		-- HUOM! Emme rajoita target-puolta tässä
 	`
 
@@ -60,14 +62,14 @@ func SyncOneToManyFKConstraints(db *sql.DB) error {
 		key := fmt.Sprintf("%s.%s->%s.%s",
 			c.SourceTable, c.SourceColumn, c.TargetTable, c.TargetColumn)
 
-		// Tarkistetaan, että LÄHDETAULULLA on tasan yksi-sarakkeinen PK
-		singlePK, checkErr := hasSingleColumnPK(db, c.SourceTable)
+		// Tarkistetaan, että LÄHDETAULULLA on pääavain (sarakkeiden määrällä ei väliä)
+		hasPK, checkErr := hasPrimaryKey(db, c.SourceTable)
 		if checkErr != nil {
 			return fmt.Errorf("error checking PK for table %s: %w",
 				c.SourceTable, checkErr)
 		}
-		if !singlePK {
-			// log.Printf("[INFO] Skipping constraint %s: source table %s does NOT have a single-col PK.",
+		if !hasPK {
+			// log.Printf("[INFO] Skipping constraint %s: source table %s does NOT have a PK.",
 			// 	key, c.SourceTable)
 			continue
 		}
@@ -183,10 +185,9 @@ func SyncOneToManyFKConstraints(db *sql.DB) error {
 	return nil
 }
 
-// hasSingleColumnPK palauttaa true, jos annetuilla parametreilla
-// (tableName) on tasan yksi PRIMARY KEY -sarake. Ei vertaa PK-sarakkeen nimeä
-// mihinkään tiettyyn ulkoavainsarakkeeseen – pelkästään lukumäärä ratkaisee.
-func hasSingleColumnPK(db *sql.DB, tableName string) (bool, error) {
+// hasPrimaryKey palauttaa true, jos taululla (tableName) on PRIMARY KEY,
+// oli se yksi- tai monisarakkeinen ja minkä tyyppinen tahansa.
+func hasPrimaryKey(db *sql.DB, tableName string) (bool, error) {
 	q := `
 		SELECT a.attname
 		FROM pg_index i
@@ -213,6 +214,5 @@ func hasSingleColumnPK(db *sql.DB, tableName string) (bool, error) {
 		return false, err
 	}
 
-	// Palautetaan true vain, jos PK-sarakkeita on täsmälleen yksi
-	return len(pkCols) == 1, nil
+	return len(pkCols) > 0, nil
 }
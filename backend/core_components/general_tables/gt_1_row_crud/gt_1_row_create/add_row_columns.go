@@ -11,7 +11,10 @@ import (
 	"strings"
 
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_defaults"
+	"easelect/backend/core_components/general_tables/column_rules"
 	"easelect/backend/core_components/general_tables/models"
+	e_sessions "easelect/backend/core_components/sessions"
 )
 
 // GetAddRowColumnsHandlerWrapper on HTTP-rajapintafunktio, joka hakee lisättävän rivin saraketiedot.
@@ -68,8 +71,9 @@ ColLoop:
 			}
 		}
 
-		// 2) Onko sarake identity tai onko sillä oletus?
-		if strings.ToUpper(col.IsIdentity) == "YES" || col.ColumnDefault != "" {
+		// 2) Onko sarake identity tai onko sillä oletus? Oletusarvolausekkeen sarakkeet
+		// näytetään, jotta käyttäjä näkee esitäytetyn arvon ja voi muuttaa sitä.
+		if strings.ToUpper(col.IsIdentity) == "YES" || (col.ColumnDefault != "" && col.DefaultExpression == "") {
 			continue
 		}
 
@@ -111,10 +115,13 @@ func getAddRowColumnsWithTypes(tableName, schemaName string) ([]models.AddRowCol
 		fk_rel.insert_new_target_with_source,
 		fk_rel.insert_new_source_with_target,
         fk_rel.source_insert_specs,
-		fk_rel.target_insert_specs
+		fk_rel.target_insert_specs,
+        scd.default_expression
     FROM information_schema.columns c
     JOIN system_db_tables sdt
         ON sdt.table_name = c.table_name
+    LEFT JOIN system_column_details scd
+        ON scd.table_uid = sdt.table_uid AND scd.column_name = c.column_name
     LEFT JOIN (
         SELECT
             kcu.column_name,
@@ -163,6 +170,7 @@ func getAddRowColumnsWithTypes(tableName, schemaName string) ([]models.AddRowCol
 
 		var sourceInsertSpecs sql.NullString
 		var targetInsertSpecs sql.NullString
+		var defaultExpression sql.NullString
 
 		if err := rows.Scan(
 			&col.ColumnName,
@@ -179,6 +187,7 @@ func getAddRowColumnsWithTypes(tableName, schemaName string) ([]models.AddRowCol
 			&insertNewSourceWithTarget,
 			&sourceInsertSpecs,
 			&targetInsertSpecs,
+			&defaultExpression,
 		); err != nil {
 			return nil, err
 		}
@@ -193,6 +202,7 @@ func getAddRowColumnsWithTypes(tableName, schemaName string) ([]models.AddRowCol
 		col.InsertNewSourceWithTarget = insertNewSourceWithTarget
 		col.SourceInsertSpecs = sourceInsertSpecs.String
 		col.TargetInsertSpecs = targetInsertSpecs.String
+		col.DefaultExpression = strings.TrimSpace(defaultExpression.String)
 
 		// Jos data_type == "USER-DEFINED" ja udt_name == "geometry", tulkitaan data_type = "geometry"
 		if strings.ToLower(col.DataType) == "user-defined" && strings.ToLower(col.UdtName) == "geometry" {
@@ -257,13 +267,16 @@ func GetAddRowMetadataHandlerWrapper(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := GetAddRowMetadataHandler(w, tableName); err != nil {
+	if err := GetAddRowMetadataHandler(w, r, tableName); err != nil {
 		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error()) // punainen virhe
 		http.Error(w, "virhe rivinlisäysmetadatan haussa", http.StatusInternalServerError)
 	}
 }
 
-func GetAddRowMetadataHandler(w http.ResponseWriter, tableName string) error {
+// GetAddRowMetadataHandler palauttaa rivinlisäyslomakkeen tiedot: sarakkeet, suhteet,
+// validointisäännöt ja oletusarvolausekkeiden esitäyttöarvot. parent()-lausekkeiden
+// viiteavaimet voi antaa kyselyparametreina, esim. ?table=orders&customer_id=5.
+func GetAddRowMetadataHandler(w http.ResponseWriter, r *http.Request, tableName string) error {
 	schemaName := "public"
 
 	// 1) Saraketiedot
@@ -284,17 +297,62 @@ func GetAddRowMetadataHandler(w http.ResponseWriter, tableName string) error {
 		return err
 	}
 
+	// 4) Sarakkeiden validointisäännöt, jotta lomake tarkistaa arvot kuten palvelin
+	validationRules, err := column_rules.ForTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	// 5) Oletusarvolausekkeet ja niiden esitäyttöarvot (sekvenssejä ei lasketa)
+	defaultValues, err := previewDefaultValues(r, columns)
+	if err != nil {
+		return err
+	}
+	defaultExpressions := map[string]string{}
+	for _, col := range columns {
+		if col.DefaultExpression != "" {
+			defaultExpressions[col.ColumnName] = col.DefaultExpression
+		}
+	}
+
 	// Kääritään kaikki yhteen rakenteeseen
 	payload := map[string]interface{}{
 		"columns":            columns,
 		"oneToManyRelations": oneToMany,
 		"manyToManyInfos":    manyToMany,
+		"validationRules":    validationRules,
+		"defaultExpressions": defaultExpressions,
+		"defaultValues":      defaultValues,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(payload)
 }
 
+// previewDefaultValues laskee lomakkeen esitäyttöarvot kirjautuneelle käyttäjälle.
+// Ilman kirjautumista käyttäjään perustuvia arvoja ei esitäytetä. Kyselyparametrit,
+// joiden nimi on sarakkeen nimi, ovat rivin arvoja parent()-lausekkeille; viitattu rivi
+// luetaan roolin yhteydellä rivitason säännöt huomioiden.
+func previewDefaultValues(r *http.Request, columns []models.AddRowColumnInfo) (map[string]interface{}, error) {
+	currentUserID, _ := getCurrentUserID(r)
+	currentUsername, _ := getCurrentUsername(r)
+	roleDb, err := e_sessions.GetRoleDbFromSession(r)
+	if err != nil {
+		roleDb = e_sessions.RoleDb("guest")
+	}
+	row := map[string]interface{}{}
+	for _, col := range columns {
+		if value := r.URL.Query().Get(col.ColumnName); value != "" {
+			row[col.ColumnName] = value
+		}
+	}
+	return column_defaults.Evaluate(backend.Db, columns, row, column_defaults.Context{
+		UserID:   currentUserID,
+		Username: currentUsername,
+		RoleDb:   roleDb,
+	})
+}
+
 // getOneToManyRelations lukee foreign_key_relations_1_m -taulusta, kuten
 // GetOneToManyRelationsHandler, mutta palauttaa arvot suoraan koodissa.
 func getOneToManyRelations(mainTableName string) ([]OneToManyRelation, error) {
@@ -16,15 +16,19 @@ import (
 	"time"
 
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/embeddings"
+	"easelect/backend/core_components/general_tables/column_defaults"
+	"easelect/backend/core_components/general_tables/column_rules"
+	"easelect/backend/core_components/general_tables/models"
+	"easelect/backend/core_components/general_tables/row_audit"
+	"easelect/backend/core_components/general_tables/row_key"
 	gt_triggers "easelect/backend/core_components/general_tables/triggers"
 	e_sessions "easelect/backend/core_components/sessions"
 
 	"github.com/lib/pq"
-	// Huom. lisää nämä moduulit go.mod:iin:
-	//   github.com/sashabaranov/go-openai v1.6.0 (tai uudempi)
+	// Huom. lisää tämä moduuli go.mod:iin:
 	//   github.com/pgvector/pgvector-go   v0.0.0-20230419003241-071478c7611d (tai uudempi)
 	pgvector "github.com/pgvector/pgvector-go"
-	"github.com/sashabaranov/go-openai"
 )
 
 // ChildRowPayload sisältää lapsirivin tiedot
@@ -51,8 +55,8 @@ type ChildInsertResult struct {
 	FieldKey          string // esim. "file_child_0"
 	TableName         string
 	ReferencingColumn string
-	ChildRowID        int64
-	MainRowID         int64
+	ChildKey          row_key.RowKey
+	MainKey           row_key.RowKey
 }
 
 // AddRowMultipartHandlerWrapper hoitaa /api/add-row-multipart?table=... -pyyntöjä
@@ -74,9 +78,9 @@ func AddRowMultipartHandlerWrapper(w http.ResponseWriter, r *http.Request) {
 //   - mahdolliset tiedostot lapsitauluille (file_child_0, file_child_1, ...)
 //
 // Tallennuksen logiikka:
-//  1. luo päärivin (RETURNING id -> mainRowID)
-//  2. luo lapsirivit (RETURNING id -> childRowID) ja kerää talteen ChildInsertResult-listaan
-//  3. tallentaa tiedostot polkuun: media/<tableUID>/<mainRowID>/, nimeksi <tableUID>_<mainRowID>_<childRowID>.ext
+//  1. luo päärivin (RETURNING pääavain -> mainKey)
+//  2. luo lapsirivit (RETURNING pääavain -> childKey) ja kerää talteen ChildInsertResult-listaan
+//  3. tallentaa tiedostot polkuun: media/<tableUID>/<mainKey>/, nimeksi <tableUID>_<mainKey>_<childKey>.ext
 //  4. päivittää lapsirivin "filename" (ja mahdolliset cacheTargets) samalle nimelle
 //  5. Jos taulusta löytyy openai_embedding-sarake, generoi upouuden rivin teksteistä embeddingin
 //     ja tallentaa sen openai_embedding-sarakkeeseen (synkronisesti).
@@ -108,19 +112,19 @@ func AddRowMultipartHandler(w http.ResponseWriter, r *http.Request, tableName st
 		return
 	}
 
-	// 1) Lisätään data kantaan (pää, lapsirivit, M2M) -> saamme mainRowID + lapsirivien tiedot
-	mainRowID, childInsertResults, err := insertDataAccordingToPayload(w, r, tableName, payload)
+	// 1) Lisätään data kantaan (pää, lapsirivit, M2M) -> saamme mainKey + lapsirivien tiedot
+	mainKey, childInsertResults, err := insertDataAccordingToPayload(w, r, tableName, payload)
 	if err != nil {
 		// insertDataAccordingToPayload hoitaa virhevastausten antamisen
 		return
 	}
 
 	// 2) Tallennetaan tiedostot
-	saveUploadedFiles(w, r.MultipartForm.File, "media", tableUID, mainRowID, childInsertResults)
+	saveUploadedFiles(w, r.MultipartForm.File, "media", tableUID, mainKey, childInsertResults)
 
 	// 3) Tarkista, onko taulussa openai_embedding-sarake -> jos kyllä, generoi embedding
 	if hasOpenAIEmbeddingColumn(tableName) {
-		if errEmb := generateOpenAIEmbeddingForSingleRow(tableName, mainRowID); errEmb != nil {
+		if errEmb := generateOpenAIEmbeddingForSingleRow(tableName, mainKey); errEmb != nil {
 			fmt.Printf("\033[31m[add_row_handler.go] [AddRowMultipartHandler -> generateOpenAIEmbeddingForSingleRow] virhe: %s\033[0m\n", errEmb.Error())
 			// ei estä riviä toimimasta, jatketaan
 		}
@@ -133,19 +137,19 @@ func AddRowMultipartHandler(w http.ResponseWriter, r *http.Request, tableName st
 }
 
 // insertDataAccordingToPayload lisää päätaulun rivin, lapsirivit ja M2M-liitokset.
-// Palauttaa luodun päärivin id-arvon (mainRowID) sekä ChildInsertResult-listan lapsiriveistä.
+// Palauttaa luodun päärivin pääavaimen (mainKey) sekä ChildInsertResult-listan lapsiriveistä.
 func insertDataAccordingToPayload(
 	w http.ResponseWriter,
 	r *http.Request,
 	tableName string,
 	payload map[string]interface{},
-) (int64, []ChildInsertResult, error) {
+) (row_key.RowKey, []ChildInsertResult, error) {
 
 	currentUserID, err := getCurrentUserID(r)
 	if err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
 		http.Error(w, "käyttäjätunnusta ei voitu hakea", http.StatusInternalServerError)
-		return 0, nil, err
+		return row_key.RowKey{}, nil, err
 	}
 
 	// Haetaan myös käyttäjänimi sessiosta
@@ -153,7 +157,7 @@ func insertDataAccordingToPayload(
 	if err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
 		http.Error(w, "käyttäjänimen hakeminen sessiosta epäonnistui", http.StatusInternalServerError)
-		return 0, nil, err
+		return row_key.RowKey{}, nil, err
 	}
 
 	// Erota lapsirivit ja M2M
@@ -180,7 +184,7 @@ func insertDataAccordingToPayload(
 	if err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
 		http.Error(w, "virhe sarakkeiden haussa", http.StatusInternalServerError)
-		return 0, nil, err
+		return row_key.RowKey{}, nil, err
 	}
 
 	columnTypeMap := make(map[string]string)
@@ -188,86 +192,67 @@ func insertDataAccordingToPayload(
 		columnTypeMap[col.ColumnName] = col.DataType
 	}
 
-	excludeColumns := []string{"id", "created", "updated", "openai_embedding", "creation_spec"}
-	allowedColumns := map[string]bool{}
-	for _, col := range columnsInfo {
-		colName := col.ColumnName
-		isIdentity := strings.ToUpper(col.IsIdentity) == "YES"
-		if contains(excludeColumns, strings.ToLower(colName)) {
-			continue
-		}
-		if col.GenerationExpression != "" || isIdentity {
-			continue
-		}
-		allowedColumns[colName] = true
+	// Oletusarvolausekkeet sarakkeille, joille ei annettu arvoa. parent() lukee käyttäjän
+	// antaman viiteavaimen rivin roolin yhteydellä; sekvenssit lasketaan vasta validoinnin jälkeen.
+	roleDb, err := e_sessions.GetRoleDbFromSession(r)
+	if err != nil {
+		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe session haussa", http.StatusInternalServerError)
+		return row_key.RowKey{}, nil, err
+	}
+	defaultsCtx := column_defaults.Context{UserID: currentUserID, Username: currentUsername, RoleDb: roleDb}
+	if err := column_defaults.Apply(backend.Db, columnsInfo, payload, defaultsCtx); err != nil {
+		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe oletusarvojen laskennassa", http.StatusInternalServerError)
+		return row_key.RowKey{}, nil, err
 	}
 
 	// Suodatetaan vain sallitut sarakkeet pään riviltä
-	// ja poistetaan "vector" -sarakkeet, eli ei tallenneta niitä käyttöliittymästä
-	filteredRow := map[string]interface{}{}
-	for colName, val := range payload {
-		colType := strings.ToLower(columnTypeMap[colName])
-		// Jos se on vector-sarake, ohitetaan
-		if strings.Contains(colType, "vector") {
-			continue
-		}
-		if allowedColumns[colName] {
-			// Jos tyyppi on integer ja arvo on tyhjä string, muutetaan 0 (dummy).
-			if isIntegerType(colType) {
-				if s, ok := val.(string); ok {
-					trimmed := strings.TrimSpace(s)
-					if trimmed == "" {
-						val = 0
-						fmt.Printf("[INFO] int-sarake '%s' oli tyhjä, asetetaan dummy-arvo 0\n", colName)
-					} else {
-						parsedVal, parseErr := strconv.Atoi(trimmed)
-						if parseErr != nil {
-							fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", parseErr.Error())
-							http.Error(w, "invalid integer value for "+colName, http.StatusBadRequest)
-							return 0, nil, parseErr
-						}
-						val = parsedVal
-					}
-				}
-			}
-			filteredRow[colName] = val
-		}
+	filteredRow, err := prepareMainRow(payload, columnTypeMap, insertableColumns(columnsInfo))
+	if err != nil {
+		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return row_key.RowKey{}, nil, err
 	}
 
-	// TODO: Muuta tämä dynaamiseksi, jotta ei tarvitse erikseen lisätä jokaista saraketta
-	// Tarkistetaan source_insert_specs (esim. user_id = currentUser), jonka avulla voidaan lisätä käyttäjätunnus (fk) pääasialliseen tauluun
-	for _, col := range columnsInfo {
-		if col.SourceInsertSpecs != "" {
-			var specs map[string]string
-			if err := json.Unmarshal([]byte(col.SourceInsertSpecs), &specs); err == nil {
-				// user_id -> currentUser
-				if val, ok := specs["user_id"]; ok && val == "currentUser" && col.ColumnName == "user_id" {
-					filteredRow["user_id"] = currentUserID
-					fmt.Printf("[DEBUG] asetan user_id = '%d' (currentUser)\n", currentUserID)
-				}
-				// cached_username -> currentUserName
-				if val, ok := specs["cached_username"]; ok && val == "currentUserName" {
-					filteredRow["cached_username"] = currentUsername
-					fmt.Printf("[DEBUG] asetan cached_username = '%s' (currentUserName)\n", currentUsername)
-				}
-			}
-		}
+	applySourceInsertSpecs(columnsInfo, filteredRow, currentUserID, currentUsername)
+
+	// Sarakkeiden validointisäännöt (system_column_details.validation_rules)
+	rules, err := column_rules.ForTable(tableName)
+	if err != nil {
+		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe validointisääntöjen haussa", http.StatusInternalServerError)
+		return row_key.RowKey{}, nil, err
+	}
+	// Sekvenssin arvo lasketaan vasta tarkistuksen jälkeen, joten sen sarake ei ole vielä tyhjä
+	sequenceColumns := column_defaults.SequenceColumns(columnsInfo, payload)
+	fieldErrors := withoutRequiredErrors(rules.ValidateRow(rowForValidation(payload, filteredRow)), sequenceColumns)
+	if len(fieldErrors) > 0 {
+		column_rules.WriteErrors(w, fieldErrors)
+		return row_key.RowKey{}, nil, fmt.Errorf("%s: %s", column_rules.ErrorMessage, tableName)
 	}
 
 	tx, err := backend.Db.Begin()
 	if err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
 		http.Error(w, "virhe transaktion aloituksessa", http.StatusInternalServerError)
-		return 0, nil, err
+		return row_key.RowKey{}, nil, err
+	}
+
+	if err := applySequences(tx, columnsInfo, payload, filteredRow, insertableColumns(columnsInfo)); err != nil {
+		tx.Rollback()
+		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe oletusarvojen laskennassa", http.StatusInternalServerError)
+		return row_key.RowKey{}, nil, err
 	}
 
 	// 1) Päärivi
-	mainRowID, err := insertMainRow(tx, tableName, filteredRow, columnTypeMap)
+	mainKey, err := insertMainRow(tx, tableName, filteredRow, columnTypeMap)
 	if err != nil {
 		tx.Rollback()
 		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
 		http.Error(w, "virhe päärivin lisäyksessä", http.StatusInternalServerError)
-		return 0, nil, err
+		return row_key.RowKey{}, nil, err
 	}
 
 	childInsertResults := []ChildInsertResult{}
@@ -280,13 +265,38 @@ func insertDataAccordingToPayload(
 			tx.Rollback()
 			fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err2.Error())
 			http.Error(w, "virhe lapsitaulun sarakkeiden haussa", http.StatusInternalServerError)
-			return 0, nil, err2
+			return row_key.RowKey{}, nil, err2
 		}
 		childTypeMap := make(map[string]string)
 		for _, cc := range childCols {
 			childTypeMap[cc.ColumnName] = cc.DataType
 		}
 
+		// Lapsirivin oletusarvot lasketaan transaktiossa, jotta parent()-lauseke näkee
+		// juuri lisätyn päärivin
+		if child.Data != nil && child.ReferencingColumn != "" {
+			mainRef, refErr := mainKey.SingleValue()
+			if refErr != nil {
+				tx.Rollback()
+				fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", refErr.Error())
+				http.Error(w, "lapsirivi ei voi viitata yhdistelmäavaimeen", http.StatusBadRequest)
+				return row_key.RowKey{}, nil, refErr
+			}
+			child.Data[child.ReferencingColumn] = mainRef
+			childCtx := defaultsCtx
+			childCtx.TrustedParent = child.ReferencingColumn
+			err2 := column_defaults.Apply(tx, childCols, child.Data, childCtx)
+			if err2 == nil {
+				err2 = applySequences(tx, childCols, child.Data, child.Data, insertableColumns(childCols))
+			}
+			if err2 != nil {
+				tx.Rollback()
+				fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err2.Error())
+				http.Error(w, "virhe lapsirivin oletusarvojen laskennassa", http.StatusInternalServerError)
+				return row_key.RowKey{}, nil, err2
+			}
+		}
+
 		for colName, rawVal := range child.Data {
 			colType := strings.ToLower(childTypeMap[colName])
 			if strings.Contains(colType, "vector") {
@@ -306,7 +316,7 @@ func insertDataAccordingToPayload(
 							tx.Rollback()
 							fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", parseErr.Error())
 							http.Error(w, "invalid integer value for "+colName, http.StatusBadRequest)
-							return 0, nil, parseErr
+							return row_key.RowKey{}, nil, parseErr
 						}
 						child.Data[colName] = parsedVal
 					}
@@ -314,12 +324,12 @@ func insertDataAccordingToPayload(
 			}
 		}
 
-		cID, cErr := insertSingleChildRow(tx, mainRowID, child)
+		childKey, cErr := insertSingleChildRow(tx, mainKey, child)
 		if cErr != nil {
 			tx.Rollback()
 			fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", cErr.Error())
 			http.Error(w, "virhe aliobjektin lisäyksessä", http.StatusInternalServerError)
-			return 0, nil, cErr
+			return row_key.RowKey{}, nil, cErr
 		}
 		// esim. "file_child_0"
 		fieldKey := fmt.Sprintf("file_child_%d", i)
@@ -327,8 +337,8 @@ func insertDataAccordingToPayload(
 			FieldKey:          fieldKey,
 			TableName:         child.TableName,
 			ReferencingColumn: child.ReferencingColumn,
-			ChildRowID:        cID,
-			MainRowID:         mainRowID,
+			ChildKey:          childKey,
+			MainKey:           mainKey,
 		})
 	}
 
@@ -336,19 +346,21 @@ func insertDataAccordingToPayload(
 	for _, m2m := range manyToManyRows {
 		var linkValue interface{} = m2m.SelectedValue
 		if m2m.IsNewRow && m2m.NewRowData != nil {
-			newID, errNew := insertNewThirdTableRow(tx, m2m.ThirdTableName, m2m.NewRowData)
+			newKey, errNew := insertNewThirdTableRow(tx, m2m.ThirdTableName, m2m.NewRowData)
+			if errNew == nil {
+				linkValue, errNew = newKey.SingleValue()
+			}
 			if errNew != nil {
 				tx.Rollback()
 				fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", errNew.Error())
 				http.Error(w, "virhe kolmannen taulun lisäyksessä", http.StatusInternalServerError)
-				return 0, nil, errNew
+				return row_key.RowKey{}, nil, errNew
 			}
-			linkValue = newID
 		}
 		if linkValue == nil {
 			continue
 		}
-		if err := insertOneManyToManyRelation(tx, mainRowID, ManyToManyPayload{
+		if err := insertOneManyToManyRelation(tx, mainKey, ManyToManyPayload{
 			LinkTableName:      m2m.LinkTableName,
 			MainTableFkColumn:  m2m.MainTableFkColumn,
 			ThirdTableFkColumn: m2m.ThirdTableFkColumn,
@@ -357,31 +369,187 @@ func insertDataAccordingToPayload(
 			tx.Rollback()
 			fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
 			http.Error(w, "virhe M2M-liitoksen lisäyksessä", http.StatusInternalServerError)
-			return 0, nil, err
+			return row_key.RowKey{}, nil, err
+		}
+	}
+
+	// Muutoshistoria: päärivi ja lapsirivit samassa transaktiossa kuin lisäys
+	auditErr := recordInsertAudit(tx, tableName, mainKey, currentUserID, r.URL.Path)
+	for _, child := range childInsertResults {
+		if auditErr == nil && len(child.ChildKey.Columns) > 0 {
+			auditErr = recordInsertAudit(tx, child.TableName, child.ChildKey, currentUserID, r.URL.Path)
 		}
 	}
+	if auditErr != nil {
+		tx.Rollback()
+		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", auditErr.Error())
+		http.Error(w, "virhe muutoshistorian kirjauksessa", http.StatusInternalServerError)
+		return row_key.RowKey{}, nil, auditErr
+	}
 
 	if err := tx.Commit(); err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertDataAccordingToPayload] virhe: %s\033[0m\n", err.Error())
 		http.Error(w, "virhe transaktion commitissa", http.StatusInternalServerError)
-		return 0, nil, err
+		return row_key.RowKey{}, nil, err
 	}
 
 	// Mahdolliset triggerit
-	insertedRow := map[string]interface{}{"id": mainRowID}
-	if err := gt_triggers.ExecuteTriggers(tableName, insertedRow); err != nil {
+	if err := gt_triggers.ExecuteTriggers(tableName, mainKey.Map()); err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [executeTriggers] virhe: %s\033[0m\n", err.Error())
 		// jatketaan silti
 	}
 
-	return mainRowID, childInsertResults, nil
+	return mainKey, childInsertResults, nil
+}
+
+// insertableColumns palauttaa sarakkeet, joihin lisäys saa kirjoittaa: ei id-, aikaleima-
+// tai embedding-sarakkeita eikä identity- tai generoituja sarakkeita.
+func insertableColumns(columnsInfo []models.AddRowColumnInfo) map[string]bool {
+	excludeColumns := []string{"id", "created", "updated", "openai_embedding", "creation_spec"}
+	allowedColumns := map[string]bool{}
+	for _, col := range columnsInfo {
+		colName := col.ColumnName
+		isIdentity := strings.ToUpper(col.IsIdentity) == "YES"
+		if contains(excludeColumns, strings.ToLower(colName)) {
+			continue
+		}
+		if col.GenerationExpression != "" || isIdentity {
+			continue
+		}
+		allowedColumns[colName] = true
+	}
+	return allowedColumns
+}
+
+// prepareMainRow suodattaa päärivin sallittuihin sarakkeisiin ja muuntaa arvot
+// sarakkeiden tyyppeihin. Vector-sarakkeita ei tallenneta käyttöliittymästä.
+func prepareMainRow(payload map[string]interface{}, columnTypeMap map[string]string, allowedColumns map[string]bool) (map[string]interface{}, error) {
+	filteredRow := map[string]interface{}{}
+	for colName, val := range payload {
+		colType := strings.ToLower(columnTypeMap[colName])
+		// Jos se on vector-sarake, ohitetaan
+		if strings.Contains(colType, "vector") {
+			continue
+		}
+		if allowedColumns[colName] {
+			// Jos tyyppi on integer ja arvo on tyhjä string, muutetaan 0 (dummy).
+			if isIntegerType(colType) {
+				if s, ok := val.(string); ok {
+					trimmed := strings.TrimSpace(s)
+					if trimmed == "" {
+						val = 0
+						fmt.Printf("[INFO] int-sarake '%s' oli tyhjä, asetetaan dummy-arvo 0\n", colName)
+					} else {
+						parsedVal, parseErr := strconv.Atoi(trimmed)
+						if parseErr != nil {
+							return nil, fmt.Errorf("invalid integer value for %s", colName)
+						}
+						val = parsedVal
+					}
+				}
+			}
+			filteredRow[colName] = val
+		}
+	}
+	return filteredRow, nil
+}
+
+// applySourceInsertSpecs asettaa source_insert_specs-määritysten mukaiset arvot
+// (esim. user_id = currentUser), joiden avulla käyttäjätunnus lisätään päätauluun.
+// TODO: Muuta tämä dynaamiseksi, jotta ei tarvitse erikseen lisätä jokaista saraketta
+func applySourceInsertSpecs(columnsInfo []models.AddRowColumnInfo, row map[string]interface{}, currentUserID int, currentUsername string) {
+	for _, col := range columnsInfo {
+		if col.SourceInsertSpecs != "" {
+			var specs map[string]string
+			if err := json.Unmarshal([]byte(col.SourceInsertSpecs), &specs); err == nil {
+				// user_id -> currentUser
+				if val, ok := specs["user_id"]; ok && val == "currentUser" && col.ColumnName == "user_id" {
+					row["user_id"] = currentUserID
+					fmt.Printf("[DEBUG] asetan user_id = '%d' (currentUser)\n", currentUserID)
+				}
+				// cached_username -> currentUserName
+				if val, ok := specs["cached_username"]; ok && val == "currentUserName" {
+					row["cached_username"] = currentUsername
+					fmt.Printf("[DEBUG] asetan cached_username = '%s' (currentUserName)\n", currentUsername)
+				}
+			}
+		}
+	}
+}
+
+// applySequences laskee sequence()-oletusarvot ja asettaa ne lisättävälle riville.
+// payload on käyttäjän antama rivi, josta tyhjät sarakkeet tunnistetaan.
+func applySequences(tx *sql.Tx, columnsInfo []models.AddRowColumnInfo, payload, row map[string]interface{}, allowedColumns map[string]bool) error {
+	values, err := column_defaults.EvaluateSequences(tx, columnsInfo, payload, nil)
+	if err != nil {
+		return err
+	}
+	for column, value := range values {
+		if allowedColumns[column] {
+			row[column] = value
+		}
+	}
+	return nil
+}
+
+// withoutRequiredErrors poistaa required-virheet sarakkeilta, joiden arvo lasketaan
+// myöhemmin (sekvenssit).
+func withoutRequiredErrors(fieldErrors []column_rules.FieldError, columns []string) []column_rules.FieldError {
+	if len(columns) == 0 {
+		return fieldErrors
+	}
+	pending := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		pending[column] = true
+	}
+	result := fieldErrors[:0]
+	for _, fe := range fieldErrors {
+		if fe.Rule == column_rules.RuleRequired && pending[fe.Column] {
+			continue
+		}
+		result = append(result, fe)
+	}
+	return result
+}
+
+// rowForValidation palauttaa lisättävän rivin arvot sääntöjen tarkistusta varten.
+// prepareMainRow korvaa tyhjän kokonaislukukentän nollalla; tarkistuksessa se on tyhjä,
+// jotta required-sääntö toimii myös numerosarakkeille.
+func rowForValidation(payload, filteredRow map[string]interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(filteredRow))
+	for colName, val := range filteredRow {
+		if s, ok := payload[colName].(string); ok && strings.TrimSpace(s) == "" {
+			if i, isInt := val.(int); isInt && i == 0 {
+				val = nil
+			}
+		}
+		row[colName] = val
+	}
+	return row
+}
+
+// recordInsertAudit kirjaa lisätyn rivin muutoshistoriaan (tila lisäyksen jälkeen)
+// lisäyksen transaktiossa.
+func recordInsertAudit(tx *sql.Tx, tableName string, key row_key.RowKey, userID int, endpoint string) error {
+	after, err := row_audit.SnapshotRow(tx, tableName, key)
+	if err != nil {
+		return err
+	}
+	return row_audit.Record(tx, row_audit.Entry{
+		TableName: tableName,
+		RowID:     key.String(),
+		Operation: row_audit.OperationInsert,
+		After:     after,
+		UserID:    userID,
+		Endpoint:  endpoint,
+	})
 }
 
 // saveUploadedFiles tallentaa lomakkeen tiedostokentät levyyn polkuun:
 //
-//	media/<tableUID>/<mainRowID>/
+//	media/<tableUID>/<mainKey>/
 //
-// Nimeää tiedoston <tableUID>_<mainRowID>_<childRowID>.ext
+// Nimeää tiedoston <tableUID>_<mainKey>_<childKey>.ext (ks. keyPathSegment)
 // ja päivittää lapsirivin "filename"-sarakkeen sekä mahdolliset
 // "cacheTargets" (updateCacheTargets).
 func saveUploadedFiles(
@@ -389,7 +557,7 @@ func saveUploadedFiles(
 	fileMap map[string][]*multipart.FileHeader,
 	baseDir string,
 	tableUID string,
-	mainRowID int64,
+	mainKey row_key.RowKey,
 	childInsertResults []ChildInsertResult,
 ) {
 	// Kerätään ChildInsertResult map-muotoon fieldKey -> ChildInsertResult
@@ -417,12 +585,15 @@ func saveUploadedFiles(
 		defer srcFile.Close()
 
 		resInfo := resultMap[fieldName]
-		childRowID := resInfo.ChildRowID
+		childKey := resInfo.ChildKey
+		if len(childKey.Columns) == 0 {
+			continue
+		}
 		childTableName := resInfo.TableName
 		referencingColumn := resInfo.ReferencingColumn
 
-		// Kansion luonti: media/<tableUID>/<mainRowID>/
-		subFolder := filepath.Join(baseDir, tableUID, fmt.Sprintf("%d", mainRowID))
+		// Kansion luonti: media/<tableUID>/<mainKey>/
+		subFolder := filepath.Join(baseDir, tableUID, keyPathSegment(mainKey))
 		err = os.MkdirAll(subFolder, 0755)
 		if err != nil {
 			fmt.Printf("\033[31m[add_row_handler.go] [saveUploadedFiles] virhe: %s\033[0m\n", err.Error())
@@ -432,7 +603,7 @@ func saveUploadedFiles(
 
 		// Uusi tiedostonimi
 		originalExt := filepath.Ext(fh.Filename)
-		newFileName := fmt.Sprintf("%s_%d_%d%s", tableUID, mainRowID, childRowID, originalExt)
+		newFileName := fmt.Sprintf("%s_%s_%s%s", tableUID, keyPathSegment(mainKey), keyPathSegment(childKey), originalExt)
 		savePath := filepath.Join(subFolder, newFileName)
 
 		dstFile, err := os.Create(savePath)
@@ -452,12 +623,16 @@ func saveUploadedFiles(
 		fmt.Printf("[INFO] tallennettu tiedosto: %s\n", savePath)
 
 		// Päivitetään lapsirivin filename-sarake:
-		updateFilenameInChildRow(childTableName, childRowID, newFileName)
+		updateFilenameInChildRow(childTableName, childKey, newFileName)
 
 		// Kutsutaan uudelleen updateCacheTargets, jotta sama nimi päivittyy cache-sarakkeisiin:
 		// Luodaan "childData", jossa relevantit sarakkeet:
+		mainRef, err := mainKey.SingleValue()
+		if err != nil {
+			continue
+		}
 		tempChildData := map[string]interface{}{
-			referencingColumn: mainRowID, // esim. service_id = <mainRowID>
+			referencingColumn: mainRef, // esim. service_id = <mainKey>
 			"filename":        newFileName,
 		}
 		if err := updateCacheTargetsNoTx(childTableName, referencingColumn, tempChildData); err != nil {
@@ -468,13 +643,26 @@ func saveUploadedFiles(
 
 // updateFilenameInChildRow tekee pienen UPDATE-lauseen tallentaakseen
 // uuden tiedostonimen lapsirivin "filename"-sarakkeeseen.
-func updateFilenameInChildRow(childTableName string, childRowID int64, newFileName string) {
-	updateQ := fmt.Sprintf(`UPDATE %s SET filename=$1 WHERE id=$2`, pq.QuoteIdentifier(childTableName))
-	if _, err := backend.Db.Exec(updateQ, newFileName, childRowID); err != nil {
-		fmt.Printf("\033[31m[add_row_handler.go] [updateFilenameInChildRow] virhe: tiedostonimen päivitys tauluun=%s, id=%d: %s\033[0m\n", childTableName, childRowID, err.Error())
+func updateFilenameInChildRow(childTableName string, childKey row_key.RowKey, newFileName string) {
+	keyCond, keyArgs := childKey.Condition("", 2)
+	updateQ := fmt.Sprintf(`UPDATE %s SET filename=$1 WHERE %s`, pq.QuoteIdentifier(childTableName), keyCond)
+	if _, err := backend.Db.Exec(updateQ, append([]interface{}{newFileName}, keyArgs...)...); err != nil {
+		fmt.Printf("\033[31m[add_row_handler.go] [updateFilenameInChildRow] virhe: tiedostonimen päivitys tauluun=%s, avain=%s: %s\033[0m\n", childTableName, childKey.String(), err.Error())
 	}
 }
 
+// keyPathSegment muuntaa rivin avaimen tiedostopolun osaksi: arvot alaviivalla
+// yhdistettynä, ja muut kuin [A-Za-z0-9_-] -merkit korvataan alaviivalla.
+func keyPathSegment(key row_key.RowKey) string {
+	joined := strings.Join(key.Values, "_")
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, joined)
+}
+
 // queryExecer rajapinta, jota sekä *sql.DB että *sql.Tx toteuttavat.
 // Näin voimme kutsua samaa funktiota sekä transaktion sisällä (tx) että ulkopuolella (db).
 type queryExecer interface {
@@ -590,21 +778,25 @@ func updateCacheTargetsBase(
 }
 
 // insertSingleChildRow lisää yksittäisen lapsirivin child.TableName-tauluun
-// ja asettaa referencingColumnin arvoksi mainRowID.
-// Palauttaa lisätyn rivin id-arvon (childRowID).
-func insertSingleChildRow(tx *sql.Tx, mainRowID int64, child ChildRowPayload) (int64, error) {
+// ja asettaa referencingColumnin arvoksi päärivin avaimen.
+// Palauttaa lisätyn rivin pääavaimen (childKey); tyhjän avaimen, jos riviä ei lisätty.
+func insertSingleChildRow(tx *sql.Tx, mainKey row_key.RowKey, child ChildRowPayload) (row_key.RowKey, error) {
 	if child.TableName == "" || child.ReferencingColumn == "" {
-		return 0, fmt.Errorf("puuttuva lapsidatan kenttä: tableName tai referencingColumn")
+		return row_key.RowKey{}, fmt.Errorf("puuttuva lapsidatan kenttä: tableName tai referencingColumn")
 	}
 	if child.Data == nil {
-		return 0, nil
+		return row_key.RowKey{}, nil
 	}
 
 	// Poistetaan _file -kenttä, ettei yritetä SQL:ään
 	delete(child.Data, "_file")
 
-	// Lisätään viite päärivin ID:hen
-	child.Data[child.ReferencingColumn] = mainRowID
+	// Lisätään viite päärivin avaimeen
+	mainRef, err := mainKey.SingleValue()
+	if err != nil {
+		return row_key.RowKey{}, err
+	}
+	child.Data[child.ReferencingColumn] = mainRef
 
 	insertColumns := []string{}
 	placeholders := []string{}
@@ -619,37 +811,36 @@ func insertSingleChildRow(tx *sql.Tx, mainRowID int64, child ChildRowPayload) (i
 	}
 
 	if len(insertColumns) == 0 {
-		return 0, nil
+		return row_key.RowKey{}, nil
 	}
 
 	insertQuery := fmt.Sprintf(
-		`INSERT INTO %s (%s) VALUES (%s) RETURNING id`,
+		`INSERT INTO %s (%s) VALUES (%s)`,
 		pq.QuoteIdentifier(child.TableName),
 		strings.Join(insertColumns, ", "),
 		strings.Join(placeholders, ", "),
 	)
 
-	var childRowID int64
-	err := tx.QueryRow(insertQuery, values...).Scan(&childRowID)
+	childKey, err := row_key.InsertReturning(tx, child.TableName, insertQuery, values...)
 	if err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertSingleChildRow] virhe: %s\033[0m\n", err.Error())
-		return 0, err
+		return row_key.RowKey{}, err
 	}
 
 	// Tämän jälkeen (transaktion sisällä) päivitetään mahdolliset cacheTargets
 	if cacheErr := updateCacheTargets(tx, child.TableName, child.ReferencingColumn, child.Data); cacheErr != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertSingleChildRow -> updateCacheTargets] virhe: %s\033[0m\n", cacheErr.Error())
-		return 0, cacheErr
+		return row_key.RowKey{}, cacheErr
 	}
 
-	return childRowID, nil
+	return childKey, nil
 }
 
 // insertNewThirdTableRow lisää uuden rivin kolmanteen tauluun (m2m), jos
-// sellaista ei vielä ole. Palauttaa luodun rivin ID:n.
-func insertNewThirdTableRow(tx *sql.Tx, tableName string, rowData map[string]interface{}) (int64, error) {
+// sellaista ei vielä ole. Palauttaa luodun rivin pääavaimen.
+func insertNewThirdTableRow(tx *sql.Tx, tableName string, rowData map[string]interface{}) (row_key.RowKey, error) {
 	if len(rowData) == 0 {
-		return 0, nil
+		return row_key.RowKey{}, fmt.Errorf("uuden rivin tiedot puuttuvat taululle %s", tableName)
 	}
 
 	insertCols := []string{}
@@ -665,30 +856,33 @@ func insertNewThirdTableRow(tx *sql.Tx, tableName string, rowData map[string]int
 	}
 
 	query := fmt.Sprintf(
-		`INSERT INTO %s (%s) VALUES (%s) RETURNING id`,
+		`INSERT INTO %s (%s) VALUES (%s)`,
 		pq.QuoteIdentifier(tableName),
 		strings.Join(insertCols, ", "),
 		strings.Join(placeholders, ", "),
 	)
 
-	var newID int64
-	err := tx.QueryRow(query, values...).Scan(&newID)
+	newKey, err := row_key.InsertReturning(tx, tableName, query, values...)
 	if err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertNewThirdTableRow] virhe: %s\033[0m\n", err.Error())
-		return 0, err
+		return row_key.RowKey{}, err
 	}
-	return newID, nil
+	return newKey, nil
 }
 
 // insertOneManyToManyRelation lisää m2m-suhteen linkkitauluun.
-func insertOneManyToManyRelation(tx *sql.Tx, mainRowID int64, m2m ManyToManyPayload) error {
+func insertOneManyToManyRelation(tx *sql.Tx, mainKey row_key.RowKey, m2m ManyToManyPayload) error {
+	mainRef, err := mainKey.SingleValue()
+	if err != nil {
+		return err
+	}
 	insertQuery := fmt.Sprintf(
 		`INSERT INTO %s (%s, %s) VALUES ($1, $2)`,
 		pq.QuoteIdentifier(m2m.LinkTableName),
 		pq.QuoteIdentifier(m2m.MainTableFkColumn),
 		pq.QuoteIdentifier(m2m.ThirdTableFkColumn),
 	)
-	_, err := tx.Exec(insertQuery, mainRowID, m2m.SelectedValue)
+	_, err = tx.Exec(insertQuery, mainRef, m2m.SelectedValue)
 	if err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertOneManyToManyRelation] virhe: %s\033[0m\n", err.Error())
 	}
@@ -748,8 +942,8 @@ func getTableUID(tableName string) (string, error) {
 	return foundUID, nil
 }
 
-// insertMainRow lisää päärivin tauluun ja palauttaa luodun rivin id-arvon
-func insertMainRow(tx *sql.Tx, tableName string, rowData map[string]interface{}, columnTypeMap map[string]string) (int64, error) {
+// insertMainRow lisää päärivin tauluun ja palauttaa luodun rivin pääavaimen
+func insertMainRow(tx *sql.Tx, tableName string, rowData map[string]interface{}, columnTypeMap map[string]string) (row_key.RowKey, error) {
 	insertColumns := []string{}
 	placeholders := []string{}
 	values := []interface{}{}
@@ -776,23 +970,22 @@ func insertMainRow(tx *sql.Tx, tableName string, rowData map[string]interface{},
 	}
 
 	if len(insertColumns) == 0 {
-		return 0, fmt.Errorf("ei validia saraketta lisättäväksi taulussa %s", tableName)
+		return row_key.RowKey{}, fmt.Errorf("ei validia saraketta lisättäväksi taulussa %s", tableName)
 	}
 
 	insertQuery := fmt.Sprintf(
-		`INSERT INTO %s (%s) VALUES (%s) RETURNING id`,
+		`INSERT INTO %s (%s) VALUES (%s)`,
 		pq.QuoteIdentifier(tableName),
 		strings.Join(insertColumns, ", "),
 		strings.Join(placeholders, ", "),
 	)
 
-	var mainRowID int64
-	err := tx.QueryRow(insertQuery, values...).Scan(&mainRowID)
+	mainKey, err := row_key.InsertReturning(tx, tableName, insertQuery, values...)
 	if err != nil {
 		fmt.Printf("\033[31m[add_row_handler.go] [insertMainRow] virhe: %s\033[0m\n", err.Error())
-		return 0, err
+		return row_key.RowKey{}, err
 	}
-	return mainRowID, nil
+	return mainKey, nil
 }
 
 // hasOpenAIEmbeddingColumn palauttaa true, jos taulussa on openai_embedding -sarake.
@@ -816,16 +1009,12 @@ func hasOpenAIEmbeddingColumn(tableName string) bool {
 }
 
 // generateOpenAIEmbeddingForSingleRow hakee rivin tekstisarakkeet, muodostaa embeddingin
-// ja tallentaa sen openai_embedding-sarakkeeseen.
-func generateOpenAIEmbeddingForSingleRow(tableName string, rowID int64) error {
-	// 1) Onko OPENAI_API_KEY asetettu
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if strings.TrimSpace(apiKey) == "" {
-		return fmt.Errorf("OPENAI_API_KEY puuttuu ympäristömuuttujista")
-	}
-	modelName := os.Getenv("OPENAI_EMBEDDING_MODEL")
-	if modelName == "" {
-		modelName = "text-embedding-ada-002"
+// ympäristön embedding-palvelulla ja tallentaa sen openai_embedding-sarakkeeseen.
+func generateOpenAIEmbeddingForSingleRow(tableName string, key row_key.RowKey) error {
+	// 1) Embedding-palvelu ympäristömuuttujista
+	provider, err := embeddings.FromEnv()
+	if err != nil {
+		return err
 	}
 
 	// 2) Haetaan tekstisarakkeiden nimet
@@ -840,8 +1029,9 @@ func generateOpenAIEmbeddingForSingleRow(tableName string, rowID int64) error {
 
 	// 3) Ladataan tämä rivi, vain tekstikolumnit
 	selectCols := strings.Join(textCols, ", ")
-	sqlStr := fmt.Sprintf(`SELECT %s FROM %s WHERE id=$1`, selectCols, pq.QuoteIdentifier(tableName))
-	row := backend.Db.QueryRow(sqlStr, rowID)
+	keyCond, keyArgs := key.Condition("", 1)
+	sqlStr := fmt.Sprintf(`SELECT %s FROM %s WHERE %s`, selectCols, pq.QuoteIdentifier(tableName), keyCond)
+	row := backend.Db.QueryRow(sqlStr, keyArgs...)
 
 	data := make([]interface{}, len(textCols))
 	ptrs := make([]interface{}, len(textCols))
@@ -849,7 +1039,7 @@ func generateOpenAIEmbeddingForSingleRow(tableName string, rowID int64) error {
 		ptrs[i] = &data[i]
 	}
 	if err := row.Scan(ptrs...); err != nil {
-		return fmt.Errorf("rivin (id=%d) lukeminen epäonnistui: %w", rowID, err)
+		return fmt.Errorf("rivin (avain=%s) lukeminen epäonnistui: %w", key.String(), err)
 	}
 
 	// 4) Rakennetaan yksi iso tekstilauseke niistä sarakkeista, joista löytyy arvo
@@ -871,27 +1061,24 @@ func generateOpenAIEmbeddingForSingleRow(tableName string, rowID int64) error {
 		return nil
 	}
 
-	// 5) Kutsutaan OpenAI-embeddings
-	client := openai.NewClient(apiKey)
+	// 5) Muodostetaan embedding ja varmistetaan, ettei sarakkeeseen sekoiteta malleja
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
-		Model: openai.EmbeddingModel(modelName),
-		Input: []string{joinedText},
-	})
+	vectors, err := provider.Embed(ctx, []string{joinedText})
 	if err != nil {
-		return fmt.Errorf("OpenAI embedding error: %w", err)
+		return err
 	}
-	if len(resp.Data) == 0 {
-		return fmt.Errorf("openAI ei palauttanut dataa")
+	embedding := vectors[0]
+	if err := embeddings.RecordColumnModel(tableName, "openai_embedding", provider, len(embedding)); err != nil {
+		return err
 	}
-	embedding := resp.Data[0].Embedding
 
 	// 6) Tallennetaan vektori
 	vectorVal := pgvector.NewVector(embedding)
-	updateQuery := fmt.Sprintf(`UPDATE %s SET openai_embedding = $1 WHERE id = $2`, pq.QuoteIdentifier(tableName))
-	if _, err := backend.Db.Exec(updateQuery, vectorVal, rowID); err != nil {
+	keyCond, keyArgs = key.Condition("", 2)
+	updateQuery := fmt.Sprintf(`UPDATE %s SET openai_embedding = $1 WHERE %s`, pq.QuoteIdentifier(tableName), keyCond)
+	if _, err := backend.Db.Exec(updateQuery, append([]interface{}{vectorVal}, keyArgs...)...); err != nil {
 		return fmt.Errorf("embeddingin tallennus epäonnistui: %w", err)
 	}
 
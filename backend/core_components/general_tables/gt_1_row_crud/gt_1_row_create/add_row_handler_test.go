@@ -0,0 +1,27 @@
+package gt_1_row_create
+
+import (
+	"testing"
+
+	"easelect/backend/core_components/general_tables/row_key"
+)
+
+func TestKeyPathSegment(t *testing.T) {
+	tests := []struct {
+		name string
+		key  row_key.RowKey
+		want string
+	}{
+		{"integer", row_key.RowKey{Columns: []string{"id"}, Values: []string{"42"}}, "42"},
+		{"uuid", row_key.RowKey{Columns: []string{"id"}, Values: []string{"5f0c-11ee"}}, "5f0c-11ee"},
+		{"composite", row_key.RowKey{Columns: []string{"order_id", "line_no"}, Values: []string{"12", "3"}}, "12_3"},
+		{"path characters", row_key.RowKey{Columns: []string{"code"}, Values: []string{"../a b/ä"}}, "___a_b__"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyPathSegment(tt.key); got != tt.want {
+				t.Errorf("keyPathSegment() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
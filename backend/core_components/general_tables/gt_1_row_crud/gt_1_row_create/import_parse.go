@@ -0,0 +1,554 @@
+// file: import_parse.go
+package gt_1_row_create
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// importRow on tuotavan tiedoston yksi datarivi. Line on rivin numero tiedostossa
+// (1 = otsikkorivi), jotta virheet voidaan kohdistaa taulukkoon.
+type importRow struct {
+	Line  int      `json:"line"`
+	Cells []string `json:"cells"`
+}
+
+// parseImportFile lukee CSV- tai XLSX-tiedoston. Ensimmäinen ei-tyhjä rivi on otsikkorivi;
+// tyhjät rivit ohitetaan. Tyhjät otsikot nimetään column_N ja toistuvat saavat päätteen _2, _3...
+// Lukeminen lopetetaan, kun datarivejä on enemmän kuin maxRows, joten kutsuja näkee
+// ylityksen (enintään maxRows+1 riviä) lukematta koko tiedostoa.
+func parseImportFile(fileName string, data []byte, maxRows int) ([]string, []importRow, error) {
+	// Otsikkorivi ja yksi ylimääräinen datarivi ylityksen tunnistamiseksi
+	maxRecords := maxRows + 2
+	var records [][]string
+	var lines []int
+	var err error
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".xlsx":
+		records, lines, err = readXLSXRecords(data, maxRecords)
+	case ".csv", ".txt", ".tsv":
+		records, lines, err = readCSVRecords(data, maxRecords)
+	default:
+		return nil, nil, fmt.Errorf("tuntematon tiedostotyyppi: %s (sallitut .csv ja .xlsx)", fileName)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var headers []string
+	var rows []importRow
+	for i, record := range records {
+		if isEmptyRecord(record) {
+			continue
+		}
+		if headers == nil {
+			headers = normalizeImportHeaders(record)
+			continue
+		}
+		cells := make([]string, len(headers))
+		copy(cells, record)
+		rows = append(rows, importRow{Line: lines[i], Cells: cells})
+	}
+	if headers == nil {
+		return nil, nil, fmt.Errorf("tiedostossa ei ole otsikkoriviä")
+	}
+	return headers, rows, nil
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeImportHeaders(record []string) []string {
+	// Tyhjät otsikot lopusta pois
+	last := len(record)
+	for last > 0 && strings.TrimSpace(record[last-1]) == "" {
+		last--
+	}
+	headers := make([]string, last)
+	seen := make(map[string]int)
+	for i := 0; i < last; i++ {
+		header := strings.TrimSpace(record[i])
+		if header == "" {
+			header = fmt.Sprintf("column_%d", i+1)
+		}
+		seen[header]++
+		if seen[header] > 1 {
+			header = fmt.Sprintf("%s_%d", header, seen[header])
+		}
+		headers[i] = header
+	}
+	return headers
+}
+
+// readCSVRecords lukee CSV:n ja palauttaa tietueiden rivinumerot (tyhjät rivit ohitetaan).
+// Erotin (pilkku, puolipiste tai sarkain) päätellään ensimmäiseltä riviltä; Excelin
+// suomalainen CSV käyttää puolipistettä. Lukeminen lopetetaan maxRecords ei-tyhjän
+// tietueen jälkeen.
+func readCSVRecords(data []byte, maxRecords int) ([][]string, []int, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return nil, nil, fmt.Errorf("CSV-tiedosto ei ole UTF-8-muodossa")
+	}
+	firstLine := data
+	if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+		firstLine = data[:idx]
+	}
+	delimiter := ','
+	best := bytes.Count(firstLine, []byte{','})
+	for _, candidate := range []rune{';', '\t'} {
+		if count := bytes.Count(firstLine, []byte(string(candidate))); count > best {
+			delimiter, best = candidate, count
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	var records [][]string
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("CSV-tiedoston luku epäonnistui: %w", err)
+		}
+		if isEmptyRecord(record) {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+		if len(records) >= maxRecords {
+			break
+		}
+	}
+	return records, lines, nil
+}
+
+// XLSX-tiedostosta luetaan työkirjan ensimmäinen taulukko. Jaetut merkkijonot ja
+// inline-merkkijonot tuetaan; numerosolut, joiden muotoilu on päivämäärä, muunnetaan
+// muotoon 2006-01-02 (tai 2006-01-02 15:04:05, jos arvossa on kellonaika) ja pelkät
+// kellonaika- ja kestomuotoilut muotoon 15:04:05. Taulukko luetaan rivi kerrallaan.
+
+const (
+	// Excelin taulukon enimmäiskoko (XFD1048576)
+	xlsxMaxRows    = 1048576
+	xlsxMaxColumns = 16384
+	// xlsxMaxPartSize rajaa yhden XLSX-osan puretun koon
+	xlsxMaxPartSize = 100 << 20
+)
+
+type xlsxWorkbookXML struct {
+	Sheets []struct {
+		RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationshipsXML struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxRichTextXML struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (r xlsxRichTextXML) text() string {
+	if len(r.R) == 0 {
+		return r.T
+	}
+	var b strings.Builder
+	for _, run := range r.R {
+		b.WriteString(run.T)
+	}
+	return b.String()
+}
+
+type xlsxSharedStringsXML struct {
+	Items []xlsxRichTextXML `xml:"si"`
+}
+
+type xlsxStylesXML struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+// Numerosolun muotoilun laji
+const (
+	xlsxFormatDate = iota + 1
+	xlsxFormatTime
+)
+
+// xlsxCell on taulukon solu luettuna.
+type xlsxCell struct {
+	Ref    string
+	Type   string
+	Style  int
+	Value  strings.Builder
+	Inline strings.Builder
+}
+
+func readXLSXRecords(data []byte, maxRecords int) ([][]string, []int, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("XLSX-tiedoston avaus epäonnistui: %w", err)
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, nil
+		}
+		if f.UncompressedSize64 > xlsxMaxPartSize {
+			return nil, fmt.Errorf("XLSX-osa %s on liian suuri", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		// Otsakkeen kokotieto voi olla väärä, joten luku rajataan myös tässä
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(rc, xlsxMaxPartSize), rc}, nil
+	}
+	decode := func(name string, target interface{}) (bool, error) {
+		rc, err := open(name)
+		if err != nil || rc == nil {
+			return rc != nil, err
+		}
+		defer rc.Close()
+		if err := xml.NewDecoder(rc).Decode(target); err != nil {
+			return true, fmt.Errorf("XLSX-osan %s luku epäonnistui: %w", name, err)
+		}
+		return true, nil
+	}
+
+	sheetPath, err := firstXLSXSheetPath(decode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var shared xlsxSharedStringsXML
+	if _, err := decode("xl/sharedStrings.xml", &shared); err != nil {
+		return nil, nil, err
+	}
+	var styles xlsxStylesXML
+	if _, err := decode("xl/styles.xml", &styles); err != nil {
+		return nil, nil, err
+	}
+	customFormats := make(map[int]string, len(styles.NumFmts))
+	for _, nf := range styles.NumFmts {
+		customFormats[nf.ID] = nf.Code
+	}
+	formats := make(map[int]int)
+	for i, xf := range styles.CellXfs {
+		switch {
+		case isXLSXDateFormat(xf.NumFmtID, customFormats[xf.NumFmtID]):
+			formats[i] = xlsxFormatDate
+		case isXLSXTimeFormat(xf.NumFmtID, customFormats[xf.NumFmtID]):
+			formats[i] = xlsxFormatTime
+		}
+	}
+
+	rc, err := open(sheetPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rc == nil {
+		return nil, nil, fmt.Errorf("XLSX-tiedostosta puuttuu taulukko %s", sheetPath)
+	}
+	defer rc.Close()
+
+	cellText := func(cell *xlsxCell) string {
+		value := cell.Value.String()
+		switch cell.Type {
+		case "s":
+			idx, err := strconv.Atoi(value)
+			if err == nil && idx >= 0 && idx < len(shared.Items) {
+				return shared.Items[idx].text()
+			}
+			return ""
+		case "inlineStr":
+			return cell.Inline.String()
+		case "b":
+			return map[string]string{"1": "true", "0": "false"}[value]
+		case "", "n":
+			switch formats[cell.Style] {
+			case xlsxFormatDate:
+				if formatted, ok := xlsxSerialToDate(value); ok {
+					return formatted
+				}
+			case xlsxFormatTime:
+				if formatted, ok := xlsxSerialToTime(value); ok {
+					return formatted
+				}
+			}
+		}
+		return value
+	}
+
+	records, lines, err := readXLSXSheet(rc, cellText, maxRecords)
+	if err != nil {
+		return nil, nil, fmt.Errorf("XLSX-osan %s luku epäonnistui: %w", sheetPath, err)
+	}
+	return records, lines, nil
+}
+
+// readXLSXSheet lukee taulukon rivit xml.Decoderin Token-rajapinnalla, jottei koko
+// taulukkoa pureta muistiin. Tyhjät rivit ohitetaan, ja lukeminen lopetetaan
+// maxRecords ei-tyhjän rivin jälkeen. Palauttaa rivit ja niiden rivinumerot.
+func readXLSXSheet(r io.Reader, cellText func(*xlsxCell) string, maxRecords int) ([][]string, []int, error) {
+	decoder := xml.NewDecoder(r)
+	var records [][]string
+	var lines []int
+
+	var stack []string
+	var record []string
+	var cell *xlsxCell
+	var capture *strings.Builder
+	rowNum, cellPos := 0, 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			stack = append(stack, t.Name.Local)
+			switch t.Name.Local {
+			case "row":
+				next := rowNum + 1
+				if v := xlsxAttr(t, "r"); v != "" {
+					if n, err := strconv.Atoi(v); err == nil && n > 0 {
+						next = n
+					}
+				}
+				if next > xlsxMaxRows {
+					return nil, nil, fmt.Errorf("XLSX-taulukossa on liikaa rivejä")
+				}
+				rowNum, cellPos, record = next, 0, nil
+			case "c":
+				cell = &xlsxCell{Ref: xlsxAttr(t, "r"), Type: xlsxAttr(t, "t")}
+				cell.Style, _ = strconv.Atoi(xlsxAttr(t, "s"))
+			case "v":
+				if cell != nil && parent == "c" {
+					capture = &cell.Value
+				}
+			case "t":
+				// Inline-merkkijonon teksti on is>t tai is>r>t (ei foneettisia rPh-osia)
+				if cell != nil && (parent == "is" || (parent == "r" && len(stack) >= 3 && stack[len(stack)-3] == "is")) {
+					capture = &cell.Inline
+				}
+			}
+		case xml.CharData:
+			if capture != nil {
+				capture.Write(t)
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			switch t.Name.Local {
+			case "v", "t":
+				capture = nil
+			case "c":
+				if cell == nil {
+					continue
+				}
+				colIdx := cellPos
+				if cell.Ref != "" {
+					colIdx = xlsxColumnIndex(cell.Ref)
+				}
+				cellPos = colIdx + 1
+				if colIdx >= 0 && colIdx < xlsxMaxColumns {
+					for len(record) <= colIdx {
+						record = append(record, "")
+					}
+					record[colIdx] = cellText(cell)
+				}
+				cell = nil
+			case "row":
+				if isEmptyRecord(record) {
+					continue
+				}
+				records = append(records, record)
+				lines = append(lines, rowNum)
+				if len(records) >= maxRecords {
+					return records, lines, nil
+				}
+			}
+		}
+	}
+	return records, lines, nil
+}
+
+// xlsxAttr palauttaa elementin attribuutin arvon nimen perusteella.
+func xlsxAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name && attr.Name.Space == "" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// firstXLSXSheetPath selvittää työkirjan ensimmäisen taulukon polun.
+func firstXLSXSheetPath(decode func(string, interface{}) (bool, error)) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+	var workbook xlsxWorkbookXML
+	if found, err := decode("xl/workbook.xml", &workbook); err != nil || !found || len(workbook.Sheets) == 0 {
+		return fallback, err
+	}
+	var rels xlsxRelationshipsXML
+	if found, err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil || !found {
+		return fallback, err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].RID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return fallback, nil
+}
+
+// xlsxColumnIndex muuntaa soluviittauksen (esim. "AB12") sarakeindeksiksi (0 = A).
+func xlsxColumnIndex(ref string) int {
+	idx := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		idx = idx*26 + int(r-'A'+1)
+	}
+	return idx - 1
+}
+
+// isXLSXDateFormat tunnistaa päivämäärämuotoilut: sisäänrakennetut 14–17 ja 22 sekä
+// mukautetut muotoilut, joissa on päivä-, kuukausi- tai vuosikoodi.
+func isXLSXDateFormat(id int, code string) bool {
+	if (id >= 14 && id <= 17) || id == 22 {
+		return true
+	}
+	if isXLSXBuiltinTimeFormat(id) || code == "" {
+		return false
+	}
+	// Pelkkä m ilman päivää tai vuotta on aikamuotoilussa minuutti (esim. h:mm)
+	plain := xlsxFormatCodes(code)
+	return strings.ContainsAny(plain, "dy") || (strings.Contains(plain, "m") && !strings.ContainsAny(plain, "hs"))
+}
+
+// isXLSXTimeFormat tunnistaa kellonaika- ja kestomuotoilut: sisäänrakennetut 18–21 ja
+// 45–47 sekä mukautetut muotoilut, joissa on tunti- tai sekuntikoodi mutta ei päivämäärää.
+func isXLSXTimeFormat(id int, code string) bool {
+	if isXLSXBuiltinTimeFormat(id) {
+		return true
+	}
+	if code == "" || isXLSXDateFormat(id, code) {
+		return false
+	}
+	return strings.ContainsAny(xlsxFormatCodes(code), "hs")
+}
+
+func isXLSXBuiltinTimeFormat(id int) bool {
+	return (id >= 18 && id <= 21) || (id >= 45 && id <= 47)
+}
+
+// xlsxFormatCodes palauttaa muotoilun koodimerkit pienin kirjaimin. Lainausmerkeissä ja
+// hakasulkeissa oleva teksti ei ole muotoilukoodia, paitsi kestojen [h], [mm] ja [ss],
+// jotka tulkitaan tunniksi.
+func xlsxFormatCodes(code string) string {
+	var b, bracket strings.Builder
+	inQuote, inBracket := false, false
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case r == '[' && !inQuote:
+			inBracket = true
+			bracket.Reset()
+		case r == ']' && !inQuote:
+			inBracket = false
+			if elapsed := bracket.String(); elapsed != "" && strings.Trim(elapsed, "hms") == "" {
+				b.WriteRune('h')
+			}
+		case inBracket:
+			bracket.WriteRune(r)
+		case !inQuote:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// xlsxSerialToDate muuntaa Excelin päivämääräluvun (päiviä 1899-12-30 alkaen) tekstiksi.
+func xlsxSerialToDate(value string) (string, bool) {
+	serial, err := strconv.ParseFloat(value, 64)
+	if err != nil || serial < 0 {
+		return "", false
+	}
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	t := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).
+		AddDate(0, 0, int(days)).
+		Add(time.Duration(seconds) * time.Second)
+	if seconds == 0 {
+		return t.Format("2006-01-02"), true
+	}
+	return t.Format("2006-01-02 15:04:05"), true
+}
+
+// xlsxSerialToTime muuntaa Excelin aika- tai kestoarvon (päivän osina) muotoon 15:04:05.
+// Kesto voi ylittää 24 tuntia, jolloin tunnit jatkuvat (esim. 27:30:00).
+func xlsxSerialToTime(value string) (string, bool) {
+	serial, err := strconv.ParseFloat(value, 64)
+	if err != nil || serial < 0 {
+		return "", false
+	}
+	total := int64(math.Round(serial * 86400))
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, total/60%60, total%60), true
+}
@@ -0,0 +1,236 @@
+package gt_1_row_create
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeImportHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		record []string
+		want   []string
+	}{
+		{"trimmed", []string{" name ", "price"}, []string{"name", "price"}},
+		{"trailing blanks dropped", []string{"name", "", " "}, []string{"name"}},
+		{"blank in the middle", []string{"name", "", "price"}, []string{"name", "column_2", "price"}},
+		{"duplicates", []string{"name", "name", "name"}, []string{"name", "name_2", "name_3"}},
+		{"all blank", []string{"", ""}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeImportHeaders(tt.record); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeImportHeaders(%q) = %q, want %q", tt.record, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestImportMapping(t *testing.T) {
+	columns := []importColumn{
+		{Name: "product_name"},
+		{Name: "supplier_id", ForeignTable: "suppliers"},
+		{Name: "supplier"},
+		{Name: "category_id", ForeignTable: "categories"},
+		{Name: "unit_price"},
+	}
+	tests := []struct {
+		name    string
+		headers []string
+		want    map[string]string
+	}{
+		{
+			name:    "normalized names",
+			headers: []string{"Product name", "Unit-Price"},
+			want:    map[string]string{"Product name": "product_name", "Unit-Price": "unit_price"},
+		},
+		{
+			name:    "exact match wins over foreign key",
+			headers: []string{"Supplier"},
+			want:    map[string]string{"Supplier": "supplier"},
+		},
+		{
+			name:    "foreign key suffix",
+			headers: []string{"Category"},
+			want:    map[string]string{"Category": "category_id"},
+		},
+		{
+			name:    "column used once",
+			headers: []string{"unit price", "Unit price"},
+			want:    map[string]string{"unit price": "unit_price"},
+		},
+		{
+			name:    "unknown header",
+			headers: []string{"colour"},
+			want:    map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestImportMapping(tt.headers, columns); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("suggestImportMapping(%q) = %v, want %v", tt.headers, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXLSXFormats(t *testing.T) {
+	tests := []struct {
+		id       int
+		code     string
+		wantDate bool
+		wantTime bool
+	}{
+		{id: 0},
+		{id: 2},
+		{id: 14, wantDate: true},
+		{id: 17, wantDate: true},
+		{id: 18, wantTime: true},
+		{id: 21, wantTime: true},
+		{id: 22, wantDate: true},
+		{id: 45, wantTime: true},
+		{id: 46, wantTime: true},
+		{id: 47, wantTime: true},
+		{id: 164, code: "d.m.yyyy", wantDate: true},
+		{id: 164, code: "yyyy-mm-dd hh:mm", wantDate: true},
+		{id: 164, code: "mmm", wantDate: true},
+		{id: 164, code: "h:mm", wantTime: true},
+		{id: 164, code: "hh:mm:ss AM/PM", wantTime: true},
+		{id: 164, code: "[h]:mm", wantTime: true},
+		{id: 164, code: "[mm]:ss", wantTime: true},
+		{id: 164, code: `0.00 "days"`},
+		{id: 164, code: "[Red]0.00"},
+		{id: 164, code: "#,##0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := isXLSXDateFormat(tt.id, tt.code); got != tt.wantDate {
+				t.Errorf("isXLSXDateFormat(%d, %q) = %v, want %v", tt.id, tt.code, got, tt.wantDate)
+			}
+			if got := isXLSXTimeFormat(tt.id, tt.code); got != tt.wantTime {
+				t.Errorf("isXLSXTimeFormat(%d, %q) = %v, want %v", tt.id, tt.code, got, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestXLSXSerialToDate(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   string
+		wantOK bool
+	}{
+		{"45292", "2024-01-01", true},
+		{"45292.5", "2024-01-01 12:00:00", true},
+		{"60", "1900-02-28", true},
+		{"1", "1899-12-31", true},
+		{"0.75", "1899-12-30 18:00:00", true},
+		{"-1", "", false},
+		{"abc", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := xlsxSerialToDate(tt.value)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("xlsxSerialToDate(%q) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestXLSXSerialToTime(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   string
+		wantOK bool
+	}{
+		{"0", "00:00:00", true},
+		{"0.5", "12:00:00", true},
+		{"0.75001", "18:00:01", true},
+		{"1.25", "30:00:00", true},
+		{"x", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := xlsxSerialToTime(tt.value)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("xlsxSerialToTime(%q) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// buildTestXLSX kokoaa minimaalisen XLSX-tiedoston annetusta taulukon sheetData-sisällöstä.
+func buildTestXLSX(t *testing.T, sheetData string) []byte {
+	t.Helper()
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Data" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/data.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>name</t></si><si><t>born</t></si><si><r><t>An</t></r><r><t>na</t></r></si></sst>`,
+		"xl/styles.xml":        `<styleSheet><cellXfs><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="21"/></cellXfs></styleSheet>`,
+		"xl/worksheets/data.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			sheetData + `</sheetData></worksheet>`,
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseImportFileXLSX(t *testing.T) {
+	sheet := `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="inlineStr"><is><t>at</t></is></c></row>` +
+		`<row r="2"/>` +
+		`<row r="3"><c r="A3" t="s"><v>2</v></c><c r="B3" s="1"><v>45292</v></c><c r="C3" s="2"><v>0.5</v></c></row>` +
+		`<row r="900000"><c r="A900000" t="inlineStr"><is><r><t>Be</t></r><rPh><t>x</t></rPh><r><t>n</t></r></is></c><c r="C900000" t="b"><v>1</v></c></row>`
+	headers, rows, err := parseImportFile("people.xlsx", buildTestXLSX(t, sheet), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"name", "born", "at"}; !reflect.DeepEqual(headers, want) {
+		t.Errorf("headers = %q, want %q", headers, want)
+	}
+	want := []importRow{
+		{Line: 3, Cells: []string{"Anna", "2024-01-01", "12:00:00"}},
+		{Line: 900000, Cells: []string{"Ben", "", "true"}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %+v, want %+v", rows, want)
+	}
+}
+
+func TestParseImportFileStopsAfterRowLimit(t *testing.T) {
+	var sheet strings.Builder
+	sheet.WriteString(`<row><c t="inlineStr"><is><t>n</t></is></c></row>`)
+	for i := 0; i < 50; i++ {
+		sheet.WriteString(`<row><c><v>1</v></c></row>`)
+	}
+	_, rows, err := parseImportFile("big.xlsx", buildTestXLSX(t, sheet.String()), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 6 {
+		t.Errorf("len(rows) = %d, want 6", len(rows))
+	}
+
+	csv := "n\n" + strings.Repeat("1\n", 50)
+	_, rows, err = parseImportFile("big.csv", []byte(csv), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 6 {
+		t.Errorf("csv len(rows) = %d, want 6", len(rows))
+	}
+}
@@ -0,0 +1,838 @@
+// file: import_rows.go
+package gt_1_row_create
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_defaults"
+	"easelect/backend/core_components/general_tables/column_rules"
+	"easelect/backend/core_components/general_tables/models"
+	"easelect/backend/core_components/general_tables/row_key"
+	gt_triggers "easelect/backend/core_components/general_tables/triggers"
+	e_sessions "easelect/backend/core_components/sessions"
+)
+
+// Taulukkotuonti etenee kolmessa vaiheessa:
+//
+//  1. POST /api/import-upload?table=     (multipart, kenttä "file": .csv tai .xlsx)
+//     Tiedosto jäsennetään ja tallennetaan system_import_jobs-tauluun. Vastauksessa on
+//     otsikot, esimerkkirivit, tuotavat sarakkeet ja ehdotettu sarakevastaavuus.
+//  2. POST /api/import-preview?table=&id= {"mapping": {"otsikko": "sarake", ...}}
+//     Kuivaharjoitus: rivit muunnetaan ja lisätään transaktiossa, joka perutaan aina.
+//     Vastauksessa rivikohtaiset virheet ja esikatselu muunnetuista riveistä.
+//  3. POST /api/import-commit?table=&id= {"mapping": {...}}
+//     Sama ajo, joka tallennetaan vain, jos yksikään rivi ei epäonnistu.
+//
+// Rivit muunnetaan kuten lisäyslomakkeelta (prepareMainRow, insertMainRow). Vierasavain-
+// sarakkeen arvo voi olla viitatun taulun näyttöarvo (getDisplayColumn) tai avain.
+// Tyhjä solu jätetään pois, jolloin sarake saa oletusarvonsa. Tallennuksen jälkeen
+// riveille kirjataan muutoshistoria, ajetaan triggerit ja muodostetaan embeddingit.
+
+const (
+	maxImportFileSize = 20 << 20
+	maxImportRows     = 10000
+	importSampleRows  = 5
+	importPreviewRows = 20
+	maxImportErrors   = 200
+)
+
+// importColumn on taulun sarake, johon tiedoston sarakkeen voi tuoda.
+type importColumn struct {
+	Name         string `json:"column_name"`
+	DataType     string `json:"data_type"`
+	Required     bool   `json:"required"`
+	ForeignTable string `json:"foreign_table,omitempty"`
+}
+
+// importJob on ladattu tiedosto ja sen viimeisin sarakevastaavuus.
+type importJob struct {
+	ID        int64
+	TableName string
+	FileName  string
+	Headers   []string
+	Rows      []importRow
+	Mapping   map[string]string
+	Status    string
+}
+
+// importRowError on yhden rivin virhe. Line viittaa tiedoston riviin ja Rule
+// rikottuun validointisääntöön (ks. column_rules).
+type importRowError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// importReport on esikatselun ja tallennuksen vastaus.
+type importReport struct {
+	ImportID        int64                    `json:"import_id"`
+	DryRun          bool                     `json:"dry_run"`
+	Total           int                      `json:"total"`
+	Valid           int                      `json:"valid"`
+	Invalid         int                      `json:"invalid"`
+	MissingRequired []string                 `json:"missing_required"`
+	Errors          []importRowError         `json:"errors"`
+	ErrorsTruncated bool                     `json:"errors_truncated"`
+	Preview         []map[string]interface{} `json:"preview,omitempty"`
+	Committed       bool                     `json:"committed"`
+	Inserted        int                      `json:"inserted"`
+	IDs             []string                 `json:"ids,omitempty"` // avainten tekstimuodot (RowKey.String)
+
+	keys []row_key.RowKey
+}
+
+// EnsureImportJobsTable luo system_import_jobs-taulun, jos sitä ei vielä ole.
+func EnsureImportJobsTable() error {
+	_, err := backend.Db.Exec(`
+		CREATE TABLE IF NOT EXISTS system_import_jobs (
+			id BIGSERIAL PRIMARY KEY,
+			table_name TEXT NOT NULL,
+			user_id INT NOT NULL,
+			file_name TEXT NOT NULL DEFAULT '',
+			headers JSONB NOT NULL,
+			rows JSONB NOT NULL,
+			mapping JSONB,
+			status TEXT NOT NULL DEFAULT 'uploaded' CHECK (status IN ('uploaded', 'committed')),
+			inserted_count INT,
+			created TIMESTAMPTZ NOT NULL DEFAULT now(),
+			committed_at TIMESTAMPTZ
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("system_import_jobs-taulun luonti epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// ImportUploadHandler vastaanottaa tuotavan tiedoston ja ehdottaa sarakevastaavuutta.
+func ImportUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tableName := r.URL.Query().Get("table")
+	if tableName == "" {
+		http.Error(w, "missing 'table' query parameter", http.StatusBadRequest)
+		return
+	}
+	userID, err := getCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "käyttäjätunnusta ei voitu hakea", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize+(1<<20))
+	if err := r.ParseMultipartForm(maxImportFileSize); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "tiedoston vastaanotto epäonnistui (enintään 20 MB)", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file-kenttä puuttuu", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "tiedoston luku epäonnistui", http.StatusBadRequest)
+		return
+	}
+
+	headers, rows, err := parseImportFile(header.Filename, data, maxImportRows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rows) == 0 {
+		http.Error(w, "tiedostossa ei ole datarivejä", http.StatusBadRequest)
+		return
+	}
+	if len(rows) > maxImportRows {
+		http.Error(w, fmt.Sprintf("tiedostossa on yli %d riviä", maxImportRows), http.StatusBadRequest)
+		return
+	}
+
+	columnsInfo, err := getAddRowColumnsWithTypes(tableName, "public")
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe sarakkeiden haussa", http.StatusInternalServerError)
+		return
+	}
+	if len(columnsInfo) == 0 {
+		http.Error(w, "taulua ei löydy", http.StatusNotFound)
+		return
+	}
+	columns := importableColumns(columnsInfo)
+	mapping := suggestImportMapping(headers, columns)
+
+	importID, err := createImportJob(tableName, userID, header.Filename, headers, rows, mapping)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe tuonnin tallennuksessa", http.StatusInternalServerError)
+		return
+	}
+
+	sample := make([][]string, 0, importSampleRows)
+	for i := 0; i < len(rows) && i < importSampleRows; i++ {
+		sample = append(sample, rows[i].Cells)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"import_id":        importID,
+		"file_name":        header.Filename,
+		"headers":          headers,
+		"row_count":        len(rows),
+		"sample":           sample,
+		"columns":          columns,
+		"mapping":          mapping,
+		"missing_required": missingRequiredColumns(columns, mapping),
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// ImportPreviewHandler ajaa tuonnin kuivaharjoituksena ja palauttaa rivikohtaiset virheet.
+func ImportPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	handleImportRun(w, r, true)
+}
+
+// ImportCommitHandler tallentaa tuonnin yhdessä transaktiossa. Jos yksikin rivi
+// epäonnistuu, mitään ei tallenneta ja vastaus (422) on kuten esikatselussa.
+func ImportCommitHandler(w http.ResponseWriter, r *http.Request) {
+	handleImportRun(w, r, false)
+}
+
+func handleImportRun(w http.ResponseWriter, r *http.Request, dryRun bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tableName := r.URL.Query().Get("table")
+	importID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if tableName == "" || err != nil {
+		http.Error(w, "table- tai id-parametri puuttuu", http.StatusBadRequest)
+		return
+	}
+	userID, err := getCurrentUserID(r)
+	if err != nil {
+		http.Error(w, "käyttäjätunnusta ei voitu hakea", http.StatusUnauthorized)
+		return
+	}
+	username, err := getCurrentUsername(r)
+	if err != nil {
+		http.Error(w, "käyttäjänimen hakeminen sessiosta epäonnistui", http.StatusInternalServerError)
+		return
+	}
+
+	var body struct {
+		Mapping map[string]string `json:"mapping"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			http.Error(w, "virheellinen pyynnön runko", http.StatusBadRequest)
+			return
+		}
+	}
+
+	columnsInfo, err := getAddRowColumnsWithTypes(tableName, "public")
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe sarakkeiden haussa", http.StatusInternalServerError)
+		return
+	}
+	rules, err := column_rules.ForTable(tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe validointisääntöjen haussa", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := backend.Db.Begin()
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe transaktion aloituksessa", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Tallennuksessa työ lukitaan, jotta samaa tiedostoa ei tuoda kahdesti
+	job, err := loadImportJob(tx, importID, tableName, userID, !dryRun)
+	if err == sql.ErrNoRows {
+		http.Error(w, "tuontia ei löydy", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe tuonnin haussa", http.StatusInternalServerError)
+		return
+	}
+	if job.Status == "committed" {
+		http.Error(w, "tuonti on jo tallennettu", http.StatusConflict)
+		return
+	}
+
+	mapping := body.Mapping
+	if mapping == nil {
+		mapping = job.Mapping
+	}
+	roleDb, err := e_sessions.GetRoleDbFromSession(r)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe session haussa", http.StatusInternalServerError)
+		return
+	}
+	defaults := column_defaults.Context{UserID: userID, Username: username, RoleDb: roleDb}
+	plan, err := newImportPlan(tableName, columnsInfo, rules, defaults, job.Headers, mapping)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := plan.resolveForeignKeys(tx, job.Rows); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe viitearvojen haussa", http.StatusInternalServerError)
+		return
+	}
+
+	report, err := plan.run(tx, job.Rows, dryRun)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe tuonnin ajossa", http.StatusInternalServerError)
+		return
+	}
+	report.ImportID = job.ID
+	report.DryRun = dryRun
+	report.MissingRequired = missingRequiredColumns(plan.columns, mapping)
+
+	mappingJSON, _ := json.Marshal(mapping)
+	if dryRun || report.Invalid > 0 {
+		// Lisäykset perutaan; vastaavuus talletetaan seuraavaa ajoa varten
+		tx.Rollback()
+		report.IDs = nil
+		report.keys = nil
+		if _, err := backend.Db.Exec(`UPDATE system_import_jobs SET mapping = $2 WHERE id = $1`, job.ID, mappingJSON); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		}
+		status := http.StatusOK
+		if !dryRun {
+			status = http.StatusUnprocessableEntity
+		}
+		writeImportReport(w, status, report)
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE system_import_jobs
+		SET status = 'committed', mapping = $2, inserted_count = $3, committed_at = now()
+		WHERE id = $1
+	`, job.ID, mappingJSON, len(report.IDs)); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe tuonnin tallennuksessa", http.StatusInternalServerError)
+		return
+	}
+	// Muutoshistoria samassa transaktiossa kuin lisäykset
+	for _, key := range report.keys {
+		if err := recordInsertAudit(tx, tableName, key, userID, r.URL.Path); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe muutoshistorian kirjauksessa", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe transaktion commitissa", http.StatusInternalServerError)
+		return
+	}
+	report.Committed = true
+	report.Inserted = len(report.IDs)
+	fmt.Printf("\033[36m[ImportCommitHandler] taulu %s: tuotu %d riviä (tuonti %d)\033[0m\n", tableName, report.Inserted, job.ID)
+
+	// Triggerit kuten lomakkeelta lisätyille riveille
+	for _, key := range report.keys {
+		if err := gt_triggers.ExecuteTriggers(tableName, key.Map()); err != nil {
+			fmt.Printf("\033[31m[import_rows.go] [executeTriggers] virhe: %s\033[0m\n", err.Error())
+		}
+	}
+	// Embeddingit muodostetaan taustalla, jottei suuri tuonti odota palvelua
+	if hasOpenAIEmbeddingColumn(tableName) {
+		keys := append([]row_key.RowKey(nil), report.keys...)
+		go func() {
+			for _, key := range keys {
+				if err := generateOpenAIEmbeddingForSingleRow(tableName, key); err != nil {
+					fmt.Printf("\033[31m[import_rows.go] [generateOpenAIEmbeddingForSingleRow] virhe: %s\033[0m\n", err.Error())
+				}
+			}
+		}()
+	}
+
+	writeImportReport(w, http.StatusCreated, report)
+}
+
+func writeImportReport(w http.ResponseWriter, status int, report importReport) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// importableColumns palauttaa sarakkeet, joihin tuonti voi kirjoittaa (kuten lisäyslomake).
+func importableColumns(columnsInfo []models.AddRowColumnInfo) []importColumn {
+	allowed := insertableColumns(columnsInfo)
+	columns := make([]importColumn, 0, len(columnsInfo))
+	for _, col := range columnsInfo {
+		if !allowed[col.ColumnName] || strings.Contains(strings.ToLower(col.DataType), "vector") {
+			continue
+		}
+		columns = append(columns, importColumn{
+			Name:         col.ColumnName,
+			DataType:     col.DataType,
+			Required:     strings.ToUpper(col.IsNullable) == "NO" && col.ColumnDefault == "" && col.SourceInsertSpecs == "",
+			ForeignTable: col.ForeignTableName,
+		})
+	}
+	return columns
+}
+
+// normalizeImportName muuttaa otsikon tai sarakkeen nimen vertailumuotoon: pienet
+// kirjaimet, muut kuin kirjaimet ja numerot alaviivoiksi.
+func normalizeImportName(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// suggestImportMapping ehdottaa otsikoille sarakkeita nimen perusteella: ensin suora
+// vastaavuus, sitten vierasavain (otsikko "supplier" → sarake supplier_id).
+func suggestImportMapping(headers []string, columns []importColumn) map[string]string {
+	byName := make(map[string]string, len(columns))
+	for _, col := range columns {
+		byName[normalizeImportName(col.Name)] = col.Name
+	}
+	mapping := make(map[string]string)
+	used := make(map[string]bool)
+	for _, pass := range []string{"", "_id"} {
+		for _, header := range headers {
+			if _, done := mapping[header]; done {
+				continue
+			}
+			column, ok := byName[normalizeImportName(header)+pass]
+			if ok && !used[column] {
+				mapping[header] = column
+				used[column] = true
+			}
+		}
+	}
+	return mapping
+}
+
+// missingRequiredColumns palauttaa pakolliset sarakkeet, joille ei ole otsikkoa.
+func missingRequiredColumns(columns []importColumn, mapping map[string]string) []string {
+	mapped := make(map[string]bool, len(mapping))
+	for _, column := range mapping {
+		mapped[column] = true
+	}
+	missing := make([]string, 0)
+	for _, col := range columns {
+		if col.Required && !mapped[col.Name] {
+			missing = append(missing, col.Name)
+		}
+	}
+	return missing
+}
+
+// createImportJob tallentaa jäsennetyn tiedoston ja poistaa yli viikon vanhat
+// keskeneräiset tuonnit.
+func createImportJob(tableName string, userID int, fileName string, headers []string, rows []importRow, mapping map[string]string) (int64, error) {
+	if _, err := backend.Db.Exec(`
+		DELETE FROM system_import_jobs
+		WHERE status = 'uploaded' AND created < now() - interval '7 days'
+	`); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return 0, err
+	}
+	rowsJSON, err := json.Marshal(rows)
+	if err != nil {
+		return 0, err
+	}
+	mappingJSON, err := json.Marshal(mapping)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = backend.Db.QueryRow(`
+		INSERT INTO system_import_jobs (table_name, user_id, file_name, headers, rows, mapping)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, tableName, userID, fileName, headersJSON, rowsJSON, mappingJSON).Scan(&id)
+	return id, err
+}
+
+// loadImportJob hakee käyttäjän tuonnin. forUpdate lukitsee rivin transaktion ajaksi.
+func loadImportJob(tx *sql.Tx, id int64, tableName string, userID int, forUpdate bool) (*importJob, error) {
+	query := `
+		SELECT id, table_name, file_name, headers, rows, COALESCE(mapping, '{}'::jsonb), status
+		FROM system_import_jobs
+		WHERE id = $1 AND table_name = $2 AND user_id = $3
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	job := &importJob{}
+	var headersJSON, rowsJSON, mappingJSON []byte
+	if err := tx.QueryRow(query, id, tableName, userID).Scan(
+		&job.ID, &job.TableName, &job.FileName, &headersJSON, &rowsJSON, &mappingJSON, &job.Status,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(headersJSON, &job.Headers); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rowsJSON, &job.Rows); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mappingJSON, &job.Mapping); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// importPlan kertoo, mihin sarakkeeseen kunkin tiedoston sarakkeen arvo tuodaan.
+type importPlan struct {
+	tableName     string
+	columnsInfo   []models.AddRowColumnInfo
+	columns       []importColumn
+	columnTypeMap map[string]string
+	allowed       map[string]bool
+	targets       map[int]string // otsikon indeksi → sarake
+	foreignKeys   map[string]*importForeignKey
+	rules         column_rules.TableRules
+	defaults      column_defaults.Context
+	userID        int
+	username      string
+}
+
+// importForeignKey ratkaisee vierasavainsarakkeen arvot viitatun taulun avaimiksi.
+type importForeignKey struct {
+	Table         string
+	Column        string
+	DisplayColumn string
+	byDisplay     map[string][]string // näyttöarvo (pienin kirjaimin) → avaimet
+	byKey         map[string]string   // avain (pienin kirjaimin) → avain
+}
+
+func newImportPlan(tableName string, columnsInfo []models.AddRowColumnInfo, rules column_rules.TableRules, defaults column_defaults.Context, headers []string, mapping map[string]string) (*importPlan, error) {
+	plan := &importPlan{
+		tableName:     tableName,
+		columnsInfo:   columnsInfo,
+		columns:       importableColumns(columnsInfo),
+		columnTypeMap: make(map[string]string, len(columnsInfo)),
+		allowed:       insertableColumns(columnsInfo),
+		targets:       make(map[int]string),
+		foreignKeys:   make(map[string]*importForeignKey),
+		rules:         rules,
+		defaults:      defaults,
+		userID:        defaults.UserID,
+		username:      defaults.Username,
+	}
+	importable := make(map[string]bool, len(plan.columns))
+	for _, col := range plan.columns {
+		importable[col.Name] = true
+	}
+	for _, col := range columnsInfo {
+		plan.columnTypeMap[col.ColumnName] = col.DataType
+		if importable[col.ColumnName] && col.ForeignTableName != "" && col.ForeignColumnName != "" {
+			plan.foreignKeys[col.ColumnName] = &importForeignKey{
+				Table:  col.ForeignTableName,
+				Column: col.ForeignColumnName,
+			}
+		}
+	}
+
+	headerIndex := make(map[string]int, len(headers))
+	for i, header := range headers {
+		headerIndex[header] = i
+	}
+	used := make(map[string]string)
+	for header, column := range mapping {
+		if column == "" {
+			continue
+		}
+		idx, ok := headerIndex[header]
+		if !ok {
+			return nil, fmt.Errorf("tuntematon otsikko vastaavuudessa: %s", header)
+		}
+		if !importable[column] {
+			return nil, fmt.Errorf("saraketta %s ei voi tuoda", column)
+		}
+		if other, dup := used[column]; dup {
+			return nil, fmt.Errorf("sarakkeeseen %s on valittu sekä %s että %s", column, other, header)
+		}
+		used[column] = header
+		plan.targets[idx] = column
+	}
+	if len(plan.targets) == 0 {
+		return nil, fmt.Errorf("sarakevastaavuus puuttuu")
+	}
+	return plan, nil
+}
+
+// resolveForeignKeys hakee kerralla kaikki tiedoston viitearvot vierasavainsarakkeittain.
+func (p *importPlan) resolveForeignKeys(tx *sql.Tx, rows []importRow) error {
+	for idx, column := range p.targets {
+		fk, ok := p.foreignKeys[column]
+		if !ok {
+			continue
+		}
+		valueSet := make(map[string]bool)
+		for _, row := range rows {
+			if idx < len(row.Cells) {
+				if v := strings.TrimSpace(row.Cells[idx]); v != "" {
+					valueSet[strings.ToLower(v)] = true
+				}
+			}
+		}
+		values := make([]string, 0, len(valueSet))
+		for v := range valueSet {
+			values = append(values, v)
+		}
+
+		fk.byDisplay = make(map[string][]string)
+		fk.byKey = make(map[string]string)
+		safeTable := pq.QuoteIdentifier(fk.Table)
+		safeKey := pq.QuoteIdentifier(fk.Column)
+
+		displayColumn, err := getDisplayColumn("public", fk.Table)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		fk.DisplayColumn = displayColumn
+		if displayColumn != "" {
+			safeDisplay := pq.QuoteIdentifier(displayColumn)
+			query := fmt.Sprintf(
+				`SELECT lower(trim(%s::text)), %s::text FROM %s WHERE lower(trim(%s::text)) = ANY($1)`,
+				safeDisplay, safeKey, safeTable, safeDisplay,
+			)
+			if err := collectImportLookup(tx, query, values, func(display, key string) {
+				fk.byDisplay[display] = append(fk.byDisplay[display], key)
+			}); err != nil {
+				return err
+			}
+		}
+		query := fmt.Sprintf(
+			`SELECT lower(%s::text), %s::text FROM %s WHERE lower(%s::text) = ANY($1)`,
+			safeKey, safeKey, safeTable, safeKey,
+		)
+		if err := collectImportLookup(tx, query, values, func(lowerKey, key string) {
+			fk.byKey[lowerKey] = key
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func collectImportLookup(tx *sql.Tx, query string, values []string, collect func(string, string)) error {
+	rows, err := tx.Query(query, pq.Array(values))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a, b string
+		if err := rows.Scan(&a, &b); err != nil {
+			return err
+		}
+		collect(a, b)
+	}
+	return rows.Err()
+}
+
+// resolve palauttaa viitatun rivin avaimen. Näyttöarvo ratkaistaan ensin; jos sitä ei
+// löydy, arvoa käytetään avaimena.
+func (fk *importForeignKey) resolve(value string) (string, error) {
+	lower := strings.ToLower(value)
+	if keys := fk.byDisplay[lower]; len(keys) == 1 {
+		return keys[0], nil
+	} else if len(keys) > 1 {
+		return "", fmt.Errorf("arvo %q vastaa useaa riviä taulussa %s", value, fk.Table)
+	}
+	if key, ok := fk.byKey[lower]; ok {
+		return key, nil
+	}
+	return "", fmt.Errorf("arvoa %q ei löydy taulusta %s", value, fk.Table)
+}
+
+// buildRow muuntaa tiedoston rivin lisättäväksi riviksi, laskee oletusarvolausekkeet
+// (sekvenssejä lukuun ottamatta) ja tarkistaa sarakkeiden validointisäännöt. Palauttaa
+// käyttäjän rivin (sekvenssien laskentaa varten), lisättävän rivin ja rivin kaikki
+// sääntövirheet kerralla.
+func (p *importPlan) buildRow(tx *sql.Tx, row importRow) (map[string]interface{}, map[string]interface{}, []importRowError) {
+	payload := make(map[string]interface{}, len(p.targets))
+	for idx, column := range p.targets {
+		if idx >= len(row.Cells) {
+			continue
+		}
+		value := strings.TrimSpace(row.Cells[idx])
+		if value == "" {
+			continue
+		}
+		if fk, ok := p.foreignKeys[column]; ok {
+			key, err := fk.resolve(value)
+			if err != nil {
+				return nil, nil, []importRowError{{Line: row.Line, Column: column, Message: err.Error()}}
+			}
+			value = key
+		}
+		payload[column] = value
+	}
+
+	if err := column_defaults.Apply(tx, p.columnsInfo, payload, p.defaults); err != nil {
+		return nil, nil, []importRowError{{Line: row.Line, Message: err.Error()}}
+	}
+
+	filteredRow, err := prepareMainRow(payload, p.columnTypeMap, p.allowed)
+	if err != nil {
+		return nil, nil, []importRowError{{Line: row.Line, Message: err.Error()}}
+	}
+	applySourceInsertSpecs(p.columnsInfo, filteredRow, p.userID, p.username)
+	if len(filteredRow) == 0 {
+		return nil, nil, []importRowError{{Line: row.Line, Message: "rivillä ei ole tuotavia arvoja"}}
+	}
+	sequenceColumns := column_defaults.SequenceColumns(p.columnsInfo, payload)
+	fieldErrors := withoutRequiredErrors(p.rules.ValidateRow(rowForValidation(payload, filteredRow)), sequenceColumns)
+	if len(fieldErrors) > 0 {
+		rowErrors := make([]importRowError, len(fieldErrors))
+		for i, fe := range fieldErrors {
+			rowErrors[i] = importRowError{Line: row.Line, Column: fe.Column, Rule: fe.Rule, Message: fe.Message}
+		}
+		return nil, nil, rowErrors
+	}
+	return payload, filteredRow, nil
+}
+
+// run lisää rivit transaktioon, kukin omassa savepointissaan, ja kerää virheet.
+// Kutsuja päättää, tallennetaanko vai perutaanko transaktio. Rivit tarkistetaan ensin
+// kaikki; sekvenssejä kasvatetaan vain tallennuksessa, kun yksikään rivi ei ole
+// virheellinen. Muuten sekvenssien arvot ennustetaan, koska transaktio perutaan.
+func (p *importPlan) run(tx *sql.Tx, rows []importRow, dryRun bool) (importReport, error) {
+	report := importReport{
+		Total:  len(rows),
+		Errors: make([]importRowError, 0),
+	}
+	// Rivi lasketaan virheelliseksi kerran, vaikka siinä olisi useampi virhe
+	addError := func(errs ...importRowError) {
+		report.Invalid++
+		for _, e := range errs {
+			if len(report.Errors) < maxImportErrors {
+				report.Errors = append(report.Errors, e)
+			} else {
+				report.ErrorsTruncated = true
+			}
+		}
+	}
+
+	type builtRow struct {
+		row     importRow
+		payload map[string]interface{}
+		data    map[string]interface{}
+	}
+	built := make([]builtRow, 0, len(rows))
+	for _, row := range rows {
+		payload, rowData, rowErrors := p.buildRow(tx, row)
+		if len(rowErrors) > 0 {
+			addError(rowErrors...)
+			continue
+		}
+		built = append(built, builtRow{row: row, payload: payload, data: rowData})
+	}
+
+	var peek *column_defaults.SequencePeek
+	if dryRun || report.Invalid > 0 {
+		peek = column_defaults.NewSequencePeek()
+	}
+	for _, b := range built {
+		row, rowData := b.row, b.data
+		sequenceValues, err := column_defaults.EvaluateSequences(tx, p.columnsInfo, b.payload, peek)
+		if err != nil {
+			return report, err
+		}
+		for column, value := range sequenceValues {
+			if p.allowed[column] {
+				rowData[column] = value
+			}
+		}
+
+		if _, err := tx.Exec("SAVEPOINT import_row"); err != nil {
+			return report, err
+		}
+		key, err := insertMainRow(tx, p.tableName, rowData, p.columnTypeMap)
+		if err != nil {
+			if _, rbErr := tx.Exec("ROLLBACK TO SAVEPOINT import_row"); rbErr != nil {
+				return report, rbErr
+			}
+			message := err.Error()
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) {
+				message = pqErr.Message
+				if pqErr.Detail != "" {
+					message += ": " + pqErr.Detail
+				}
+			}
+			addError(importRowError{Line: row.Line, Column: importErrorColumn(err), Message: message})
+			continue
+		}
+		if _, err := tx.Exec("RELEASE SAVEPOINT import_row"); err != nil {
+			return report, err
+		}
+
+		report.Valid++
+		report.IDs = append(report.IDs, key.String())
+		report.keys = append(report.keys, key)
+		if len(report.Preview) < importPreviewRows {
+			preview := map[string]interface{}{"_line": row.Line}
+			for col, val := range rowData {
+				preview[col] = val
+			}
+			report.Preview = append(report.Preview, preview)
+		}
+	}
+	return report, nil
+}
+
+// importErrorColumn palauttaa Postgresin virheeseen liittyvän sarakkeen, jos se tiedetään.
+func importErrorColumn(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Column
+	}
+	return ""
+}
@@ -3,6 +3,7 @@ package gt_1_row_create
 
 import (
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/row_key"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -23,10 +24,15 @@ func GetReferencedTableData(w http.ResponseWriter, r *http.Request) {
 	if foreignSchemaName == "" {
 		foreignSchemaName = "public"
 	}
+	// Pääavaimet luetaan row_key-paketista, joka tuntee vain public-skeeman taulut
+	if foreignSchemaName != "public" {
+		http.Error(w, "Vain public-skeeman taulut ovat tuettuja", http.StatusBadRequest)
+		return
+	}
 
 	// Hae viitatun taulun primary key -sarakkeet
-	pkColumns, err := getPrimaryKeyColumns(foreignSchemaName, foreignTableName)
-	if err != nil || len(pkColumns) == 0 {
+	pkColumns, err := row_key.PrimaryKeyColumns(foreignTableName)
+	if err != nil {
 		log.Printf("Virhe haettaessa primary key -sarakkeita taulusta %s: %v", foreignTableName, err)
 		http.Error(w, "Virhe haettaessa primary key -sarakkeita", http.StatusInternalServerError)
 		return
@@ -83,33 +89,6 @@ func GetReferencedTableData(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(options)
 }
 
-func getPrimaryKeyColumns(schemaName, tableName string) ([]string, error) {
-	query := `
-        SELECT kcu.column_name
-        FROM information_schema.table_constraints tc
-        JOIN information_schema.key_column_usage kcu
-            ON tc.constraint_name = kcu.constraint_name
-            AND tc.table_schema = kcu.table_schema
-        WHERE tc.table_name = $1 AND tc.table_schema = $2 AND tc.constraint_type = 'PRIMARY KEY'
-        ORDER BY kcu.ordinal_position;
-    `
-	rows, err := backend.Db.Query(query, tableName, schemaName)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var pkColumns []string
-	for rows.Next() {
-		var columnName string
-		if err := rows.Scan(&columnName); err != nil {
-			return nil, err
-		}
-		pkColumns = append(pkColumns, columnName)
-	}
-	return pkColumns, nil
-}
-
 func getDisplayColumn(schemaName, tableName string) (string, error) {
 	query := `
         SELECT column_name
@@ -4,11 +4,17 @@ package gt_1_row_delete
 
 import (
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/row_audit"
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/general_tables/row_policies"
+	"easelect/backend/core_components/general_tables/trash"
+	"easelect/backend/core_components/metadata_cache"
+	e_sessions "easelect/backend/core_components/sessions"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 
 	"github.com/lib/pq"
 )
@@ -28,11 +34,15 @@ func DeleteRowsHandler(w http.ResponseWriter, r *http.Request, table_name string
 		return
 	}
 
+	// ids-listan alkiot ovat pääavaimen arvoja tai yhdistelmäavaimella olioita {"sarake": arvo, ...}
 	var request_data struct {
-		IDs []int `json:"ids"`
+		IDs []interface{} `json:"ids"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&request_data); err != nil {
+	// Luvut json.Number-arvoina, jotta suuret bigint-avaimet eivät pyöristy
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&request_data); err != nil {
 		log.Printf("Virhe datan dekoodauksessa: %v", err)
 		http.Error(w, "Virheellinen data", http.StatusBadRequest)
 		return
@@ -43,6 +53,21 @@ func DeleteRowsHandler(w http.ResponseWriter, r *http.Request, table_name string
 		return
 	}
 
+	row_keys := make([]row_key.RowKey, 0, len(request_data.IDs))
+	for _, raw_id := range request_data.IDs {
+		key, err := row_key.Parse(table_name, raw_id)
+		if errors.Is(err, row_key.ErrInvalidKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "Virhe pääavaimen haussa", http.StatusInternalServerError)
+			return
+		}
+		row_keys = append(row_keys, key)
+	}
+
 	// Jos poistetaan rivejä system_db_tables-taulusta, tarkoitetaan, että halutaan
 	// poistaa kokonaiset taulut tietokannasta niiden nimien perusteella.
 	if table_name == "system_db_tables" {
@@ -61,11 +86,15 @@ func DeleteRowsHandler(w http.ResponseWriter, r *http.Request, table_name string
 			}
 		}()
 
-		for _, one_id := range request_data.IDs {
+		user_id, _ := e_sessions.GetUserIDFromSession(r)
+		var trash_ids []int64
+		var trashed_tables []string
+		for _, key := range row_keys {
 			var found_table_name string
 
 			// Haetaan poistettavan taulun nimi
-			err = tx.QueryRow("SELECT table_name FROM system_db_tables WHERE id = $1", one_id).Scan(&found_table_name)
+			key_cond, key_args := key.Condition("", 1)
+			err = tx.QueryRow("SELECT table_name FROM system_db_tables WHERE "+key_cond, key_args...).Scan(&found_table_name)
 			if err != nil {
 				_ = tx.Rollback()
 				log.Printf("virhe taulun nimen hakemisessa: %v", err)
@@ -73,17 +102,19 @@ func DeleteRowsHandler(w http.ResponseWriter, r *http.Request, table_name string
 				return
 			}
 
-			drop_query := fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", pq.QuoteIdentifier(found_table_name))
-			_, err = tx.Exec(drop_query)
+			// Taulu siirretään roskakoriin pudottamisen sijaan, jotta sen voi palauttaa
+			trash_id, err := trash.MoveTableToTrash(tx, found_table_name, user_id)
 			if err != nil {
 				_ = tx.Rollback()
 				log.Printf("virhe taulun poistossa (%s): %v", found_table_name, err)
 				http.Error(w, "Virhe taulun poistossa", http.StatusInternalServerError)
 				return
 			}
+			trash_ids = append(trash_ids, trash_id)
+			trashed_tables = append(trashed_tables, found_table_name)
 
 			// Poistetaan rivi system_db_tables-taulusta
-			_, err = tx.Exec("DELETE FROM system_db_tables WHERE id = $1", one_id)
+			_, err = tx.Exec("DELETE FROM system_db_tables WHERE "+key_cond, key_args...)
 			if err != nil {
 				_ = tx.Rollback()
 				log.Printf("virhe rivin poistossa system_db_tables-taulusta: %v", err)
@@ -98,37 +129,128 @@ func DeleteRowsHandler(w http.ResponseWriter, r *http.Request, table_name string
 			http.Error(w, "Virhe transaktion commitissa", http.StatusInternalServerError)
 			return
 		}
+		// Vain siirrettyjen taulujen ja taulurekisterin metatiedot vanhenevat
+		metadata_cache.InvalidateForTableChange("system_db_tables")
+		for _, trashed_table := range trashed_tables {
+			metadata_cache.InvalidateTable(trashed_table)
+		}
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Valitut taulut poistettiin onnistuneesti",
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":   "Valitut taulut siirrettiin roskakoriin",
+			"trash_ids": trash_ids,
 		})
 		return
 	}
 
-	// Jos taulu ei ole system_db_tables, toimitaan kuten aiemmin.
-	id_placeholders := []string{}
-	args := []interface{}{}
-	for i, one_id := range request_data.IDs {
-		id_placeholders = append(id_placeholders, fmt.Sprintf("$%d", i+1))
-		args = append(args, one_id)
+	// Jos taulu ei ole system_db_tables, rivit rajataan pääavaimella.
+	safe_table := pq.QuoteIdentifier(table_name)
+	keys_cond, args := row_key.ConditionForKeys(safe_table, row_keys, 1)
+
+	// Rivitason säännöt: poistetaan vain rivit, jotka käyttäjä saa nähdä
+	user_id, _ := e_sessions.GetUserIDFromSession(r)
+	where_clause, args, err := row_policies.AppendCondition(
+		" WHERE "+keys_cond,
+		args,
+		user_id,
+		table_name,
+	)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "Virhe rivisääntöjen haussa", http.StatusInternalServerError)
+		return
 	}
 
+	// RETURNING palauttaa poistettujen rivien tilannekuvat muutoshistoriaa varten
 	query := fmt.Sprintf(
-		"DELETE FROM %s WHERE id IN (%s)",
-		pq.QuoteIdentifier(table_name),
-		strings.Join(id_placeholders, ", "),
+		"DELETE FROM %s%s RETURNING %s",
+		safe_table,
+		where_clause,
+		row_audit.SnapshotExpression(safe_table),
 	)
 
-	_, err := backend.Db.Exec(query, args...)
+	tx, err := backend.Db.Begin()
+	if err != nil {
+		log.Printf("virhe transaktion avaamisessa: %v", err)
+		http.Error(w, "Virhe transaktion avaamisessa", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Rivit riippuvuuksineen roskakoriin ennen poistoa, samassa transaktiossa
+	trash_ids, err := trash.MoveRowsToTrash(tx, table_name, where_clause, args, user_id)
+	var pq_err *pq.Error
+	if errors.As(err, &pq_err) && pq_err.Code == "22P02" {
+		http.Error(w, "Virheellinen pääavaimen arvo", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "Virhe rivien siirrossa roskakoriin", http.StatusInternalServerError)
+		return
+	}
+
+	deleted_rows, err := tx.Query(query, args...)
 	if err != nil {
 		log.Printf("Virhe rivien poistossa taulusta %s: %v", table_name, err)
 		http.Error(w, "Virhe rivien poistossa", http.StatusInternalServerError)
 		return
 	}
+	var audit_entries []row_audit.Entry
+	for deleted_rows.Next() {
+		var snapshot []byte
+		if err := deleted_rows.Scan(&snapshot); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			continue
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(snapshot, &values); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			continue
+		}
+		deleted_key, err := row_key.FromSnapshot(table_name, values)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			continue
+		}
+		audit_entries = append(audit_entries, row_audit.Entry{
+			TableName: table_name,
+			RowID:     deleted_key.String(),
+			Operation: row_audit.OperationDelete,
+			Before:    snapshot,
+			UserID:    user_id,
+			Endpoint:  r.URL.Path,
+		})
+	}
+	if err := deleted_rows.Err(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+	deleted_rows.Close()
+
+	// Muutoshistoria kirjataan samassa transaktiossa kuin poisto
+	for _, entry := range audit_entries {
+		if err := row_audit.Record(tx, entry); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "Virhe muutoshistorian kirjauksessa", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("virhe transaktion commitissa: %v", err)
+		http.Error(w, "Virhe transaktion commitissa", http.StatusInternalServerError)
+		return
+	}
+
+	// Metatietotaulun (esim. system_config) rivien poisto vanhentaa niistä ladatut arvot
+	metadata_cache.InvalidateForTableChange(table_name)
+
+	deleted_count := len(audit_entries)
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Rivit poistettu onnistuneesti",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Rivit poistettu onnistuneesti",
+		"deleted":   deleted_count,
+		"trash_ids": trash_ids,
 	})
 }
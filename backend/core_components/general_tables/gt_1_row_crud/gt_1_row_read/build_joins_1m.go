@@ -11,6 +11,7 @@ import (
 
 	"easelect/backend/core_components/general_tables/models"
 	"easelect/backend/core_components/general_tables/utils"
+	"easelect/backend/core_components/metadata_cache"
 )
 
 // OneMRelation edustaa riviä foreign_key_relations_1_m -taulussa.
@@ -87,12 +88,7 @@ func buildJoinsWith1MRelations(
 				aliasCount[colName]++
 				alias := fmt.Sprintf("%s_alias%d", colName, aliasCount[colName])
 
-				generatedColumnName := colName + "_name"
-				if strings.HasSuffix(colName, "_id") {
-					generatedColumnName = strings.TrimSuffix(colName, "_id") + "_name (ln)"
-				} else if strings.HasSuffix(colName, "_uid") {
-					generatedColumnName = strings.TrimSuffix(colName, "_uid") + "_name (ln)"
-				}
+				generatedColumnName := generatedNameColumnFor(colName)
 
 				fullyQualifiedColumnName := fmt.Sprintf(
 					"%s.%s",
@@ -136,9 +132,37 @@ func buildJoinsWith1MRelations(
 	return selectColumns, joinClauses, columnExpressions, nil
 }
 
+// generatedNameColumnFor palauttaa vierasavainsarakkeen JOINilla haetun
+// nimisarakkeen nimen, esim. customer_id -> "customer_name (ln)".
+func generatedNameColumnFor(colName string) string {
+	if strings.HasSuffix(colName, "_id") {
+		return strings.TrimSuffix(colName, "_id") + "_name (ln)"
+	} else if strings.HasSuffix(colName, "_uid") {
+		return strings.TrimSuffix(colName, "_uid") + "_name (ln)"
+	}
+	return colName + "_name"
+}
+
 // fetchForeignKeyRelations hakee foreign_key_relations_1_m -taulusta rivit,
-// jotka koskevat annettua lähdetaulua (source_table_name).
+// jotka koskevat annettua lähdetaulua (source_table_name). Tulos tulee
+// metatietovälimuistista; palautettu kartta on kopio.
 func fetchForeignKeyRelations(db *sql.DB, sourceTable string) (map[string]OneMRelation, error) {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindForeignKeys, fmt.Sprintf("%p", db), sourceTable),
+		func() (interface{}, error) { return loadForeignKeyRelations(db, sourceTable) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	source := cached.(map[string]OneMRelation)
+	result := make(map[string]OneMRelation, len(source))
+	for col, rel := range source {
+		result[col] = rel
+	}
+	return result, nil
+}
+
+func loadForeignKeyRelations(db *sql.DB, sourceTable string) (map[string]OneMRelation, error) {
 	query := `
 		SELECT
 			source_table_name,
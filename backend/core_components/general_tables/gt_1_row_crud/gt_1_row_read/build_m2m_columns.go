@@ -0,0 +1,197 @@
+// file: build_m2m_columns.go
+package gt_1_row_read
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/utils"
+)
+
+// m2mVirtualColumn on foreign_key_relations_m_m -riviltä johdettu virtuaalisarake,
+// joka kokoaa siltataulun kautta liittyvien rivien näyttöarvot yhdeksi tekstiksi.
+type m2mVirtualColumn struct {
+	Name           string // esim. "service_catalog (m2m)"
+	BridgingTable  string
+	BridgeOwnCol   string // siltataulun sarake, joka viittaa päätauluun
+	OwnColumn      string // päätaulun sarake, johon silta viittaa (yleensä id)
+	BridgeOtherCol string // siltataulun sarake, joka viittaa toiseen tauluun
+	OtherTable     string
+	OtherColumn    string
+	DisplayColumn  string
+}
+
+// m2mColumnSuffix erottaa virtuaalisarakkeet tavallisista ja 1-M "(ln)"-sarakkeista.
+const m2mColumnSuffix = " (m2m)"
+
+// EnsureM2MColumnSettingsTable luo taulun, johon M2M-virtuaalisarakkeiden nimet ja
+// näyttösarakkeet tallennetaan. Asetus luodaan, kun suhde nähdään ensimmäisen kerran,
+// joten sarakkeen nimi pysyy samana uudelleenkäynnistysten yli ja sitä voi muokata.
+func EnsureM2MColumnSettingsTable() error {
+	_, err := backend.Db.Exec(`
+		CREATE TABLE IF NOT EXISTS m2m_column_settings (
+			table_name TEXT NOT NULL,
+			bridging_table TEXT NOT NULL,
+			bridge_own_column TEXT NOT NULL,
+			other_table TEXT NOT NULL,
+			column_name TEXT NOT NULL,
+			display_column TEXT NOT NULL,
+			is_enabled BOOLEAN NOT NULL DEFAULT true,
+			created TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (table_name, bridging_table, bridge_own_column),
+			UNIQUE (table_name, column_name)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("m2m_column_settings-taulun luonti epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// fetchManyToManyVirtualColumns hakee taulun M2M-suhteet tallennettuine asetuksineen ja
+// muodostaa niistä virtuaalisarakkeet. Mukaan otetaan vain käytössä olevat suhteet,
+// joiden silta- ja kohdetauluun roolilla on SELECT-oikeus.
+func fetchManyToManyVirtualColumns(db *sql.DB, tableName string) ([]m2mVirtualColumn, error) {
+	query := `
+		SELECT
+			r.bridging_table_name,
+			r.bridging_col_a, r.table_a_name, r.table_a_column,
+			r.bridging_col_b, r.table_b_name, r.table_b_column,
+			s.column_name, s.display_column, COALESCE(s.is_enabled, true)
+		FROM foreign_key_relations_m_m r
+		LEFT JOIN m2m_column_settings s
+		       ON s.table_name = $1
+		      AND s.bridging_table = r.bridging_table_name
+		      AND s.bridge_own_column = CASE WHEN r.table_a_name = $1 THEN r.bridging_col_a ELSE r.bridging_col_b END
+		WHERE r.table_a_name = $1 OR r.table_b_name = $1
+		ORDER BY r.id
+	`
+	rows, err := backend.Db.Query(query, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("fetchManyToManyVirtualColumns: %v", err)
+	}
+	defer rows.Close()
+
+	var candidates []m2mVirtualColumn
+	var unsaved []int // candidates-indeksit, joilla ei vielä ole tallennettua asetusta
+	usedNames := make(map[string]bool)
+	for rows.Next() {
+		var bridge, colA, tableA, refA, colB, tableB, refB string
+		var name, displayCol sql.NullString
+		var enabled bool
+		if err := rows.Scan(&bridge, &colA, &tableA, &refA, &colB, &tableB, &refB, &name, &displayCol, &enabled); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			continue
+		}
+		if !enabled {
+			usedNames[name.String] = true
+			continue
+		}
+		vc := m2mVirtualColumn{BridgingTable: bridge, Name: name.String, DisplayColumn: displayCol.String}
+		if tableA == tableName {
+			vc.BridgeOwnCol, vc.OwnColumn = colA, refA
+			vc.BridgeOtherCol, vc.OtherTable, vc.OtherColumn = colB, tableB, refB
+		} else {
+			vc.BridgeOwnCol, vc.OwnColumn = colB, refB
+			vc.BridgeOtherCol, vc.OtherTable, vc.OtherColumn = colA, tableA, refA
+		}
+		if name.Valid {
+			usedNames[vc.Name] = true
+		} else {
+			unsaved = append(unsaved, len(candidates))
+		}
+		candidates = append(candidates, vc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("fetchManyToManyVirtualColumns: %v", err)
+	}
+
+	// Uusille suhteille päätellään nimi ja näyttösarake ja tallennetaan ne
+	for _, i := range unsaved {
+		vc := &candidates[i]
+		displayCol, err := utils.GetNameColumnForTable(vc.OtherTable)
+		if err != nil || displayCol == "" {
+			displayCol = vc.OtherColumn
+		}
+		vc.DisplayColumn = displayCol
+		vc.Name = vc.OtherTable + m2mColumnSuffix
+		if usedNames[vc.Name] {
+			vc.Name = vc.BridgingTable + "." + vc.OtherTable + m2mColumnSuffix
+		}
+		usedNames[vc.Name] = true
+
+		_, err = backend.Db.Exec(`
+			INSERT INTO m2m_column_settings
+				(table_name, bridging_table, bridge_own_column, other_table, column_name, display_column)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT DO NOTHING
+		`, tableName, vc.BridgingTable, vc.BridgeOwnCol, vc.OtherTable, vc.Name, vc.DisplayColumn)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		}
+	}
+
+	readable, err := fetchReadableTables(db, candidates)
+	if err != nil {
+		return nil, fmt.Errorf("fetchManyToManyVirtualColumns: %v", err)
+	}
+	var result []m2mVirtualColumn
+	for _, vc := range candidates {
+		if readable[vc.BridgingTable] && readable[vc.OtherTable] {
+			result = append(result, vc)
+		}
+	}
+	return result, nil
+}
+
+// fetchReadableTables tarkistaa yhdellä kyselyllä, mihin suhteiden silta- ja
+// kohdetauluista roolilla on SELECT-oikeus.
+func fetchReadableTables(db *sql.DB, columns []m2mVirtualColumn) (map[string]bool, error) {
+	readable := make(map[string]bool)
+	if len(columns) == 0 {
+		return readable, nil
+	}
+	tables := make([]string, 0, len(columns)*2)
+	for _, vc := range columns {
+		tables = append(tables, vc.BridgingTable, vc.OtherTable)
+	}
+	rows, err := db.Query(`
+		SELECT t
+		FROM unnest($1::text[]) AS t
+		WHERE has_table_privilege(quote_ident(t), 'SELECT')
+	`, pq.Array(tables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		readable[table] = true
+	}
+	return readable, rows.Err()
+}
+
+// expression palauttaa korreloidun alikyselyn, joka kokoaa liittyvien rivien
+// näyttöarvot pilkuin eroteltuna. Lauseketta voi käyttää sekä SELECTissä että WHEREssä.
+func (vc m2mVirtualColumn) expression(tableName string) string {
+	display := fmt.Sprintf("m2m_o.%s::text", pq.QuoteIdentifier(vc.DisplayColumn))
+	return fmt.Sprintf(
+		"(SELECT string_agg(DISTINCT %[1]s, ', ' ORDER BY %[1]s) FROM %[2]s AS m2m_b JOIN %[3]s AS m2m_o ON m2m_o.%[4]s = m2m_b.%[5]s WHERE m2m_b.%[6]s = %[7]s.%[8]s)",
+		display,
+		pq.QuoteIdentifier(vc.BridgingTable),
+		pq.QuoteIdentifier(vc.OtherTable),
+		pq.QuoteIdentifier(vc.OtherColumn),
+		pq.QuoteIdentifier(vc.BridgeOtherCol),
+		pq.QuoteIdentifier(vc.BridgeOwnCol),
+		pq.QuoteIdentifier(tableName),
+		pq.QuoteIdentifier(vc.OwnColumn),
+	)
+}
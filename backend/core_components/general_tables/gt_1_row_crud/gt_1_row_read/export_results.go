@@ -0,0 +1,273 @@
+// file: export_results.go
+package gt_1_row_read
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// exportFlushInterval kertoo, kuinka monen rivin välein vastaus huuhdellaan asiakkaalle.
+const exportFlushInterval = 500
+
+// exportRowWriter kirjoittaa vientitiedoston rivi kerrallaan.
+type exportRowWriter interface {
+	WriteHeader(columns []string) error
+	WriteRow(columns []string, values []interface{}) error
+	Flush() error
+	Close() error
+}
+
+// ExportResultsHandler virtaa koko nykyisen hakunäkymän (sama putki kuin GetResults:
+// sarakeasetukset, sarakeoikeudet, 1-M JOINit, suodattimet ja lajittelu) tiedostoksi.
+// Parametrit: ?list=<taulu>&format=csv|xlsx|json|ndjson + samat suodattimet kuin get-results.
+func ExportResultsHandler(w http.ResponseWriter, r *http.Request) {
+	tableName := r.URL.Query().Get("list")
+	if tableName == "" {
+		http.Error(w, "missing 'list' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" && format != "json" && format != "ndjson" {
+		http.Error(w, "tuntematon vientimuoto: "+format, http.StatusBadRequest)
+		return
+	}
+
+	rq, ok := prepareResultsQuery(w, r, tableName, r.URL.Query(), resultsQueryOptions{})
+	if !ok {
+		return
+	}
+
+	orderByClause, err := buildOrderByClause(
+		r.URL.Query(),
+		tableName,
+		rq.ColumnsByName,
+		rq.ColumnExpressions,
+	)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe ORDER BY -ehdon rakentamisessa", http.StatusBadRequest)
+		return
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s %s%s%s",
+		rq.SelectColumns,
+		pq.QuoteIdentifier(tableName),
+		rq.JoinClauses,
+		rq.WhereClause,
+		orderByClause,
+	)
+
+	rows, err := rq.Db.Query(query, rq.Args...)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe tietoja haettaessa", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resultColumns, err := rows.Columns()
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe sarakkeiden haussa", http.StatusInternalServerError)
+		return
+	}
+
+	// Viedään näyttöarvot: jos vierasavaimelle on JOINilla haettu nimisarake,
+	// raaka id jätetään pois. Embedding-vektoreita ei viedä.
+	exportIdx := make([]int, 0, len(resultColumns))
+	exportColumns := make([]string, 0, len(resultColumns))
+	for i, col := range resultColumns {
+		if col == "openai_embedding" {
+			continue
+		}
+		if generated := generatedNameColumnFor(col); generated != col {
+			if _, hasName := rq.ColumnExpressions[generated]; hasName {
+				continue
+			}
+		}
+		exportIdx = append(exportIdx, i)
+		exportColumns = append(exportColumns, col)
+	}
+
+	fileName := fmt.Sprintf("%s_%s.%s", tableName, time.Now().Format("20060102_150405"), format)
+	var writer exportRowWriter
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer = newCSVExportWriter(w)
+	case "xlsx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		writer = newXLSXExportWriter(w)
+	case "json":
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		writer = &jsonExportWriter{w: w}
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		writer = &jsonExportWriter{w: w, ndjson: true}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	flusher, _ := w.(http.Flusher)
+
+	if err := writer.WriteHeader(exportColumns); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return
+	}
+
+	rowValues := make([]interface{}, len(resultColumns))
+	rowPointers := make([]interface{}, len(resultColumns))
+	for i := range rowValues {
+		rowPointers[i] = &rowValues[i]
+	}
+	exportValues := make([]interface{}, len(exportIdx))
+
+	rowCount := 0
+	for rows.Next() {
+		if err := rows.Scan(rowPointers...); err != nil {
+			// Otsakkeet on jo lähetetty, joten virhe voidaan vain lokittaa
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			return
+		}
+		for j, idx := range exportIdx {
+			exportValues[j] = exportValue(rowValues[idx])
+		}
+		if err := writer.WriteRow(exportColumns, exportValues); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			return
+		}
+		rowCount++
+		if flusher != nil && rowCount%exportFlushInterval == 0 {
+			if err := writer.Flush(); err != nil {
+				log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+				return
+			}
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return
+	}
+	fmt.Printf("\033[36m[ExportResultsHandler] taulusta '%s' vietiin %d riviä (%s).\033[0m\n", tableName, rowCount, format)
+}
+
+// exportValue muuntaa skannatun arvon vientimuotoon samoin kuin GetResults.
+func exportValue(val interface{}) interface{} {
+	switch typed := val.(type) {
+	case time.Time:
+		return typed.Format("2006-01-02 15:04:05")
+	case []byte:
+		return string(typed)
+	default:
+		return typed
+	}
+}
+
+// --------------------------------------------------------------------
+// CSV
+// --------------------------------------------------------------------
+
+type csvExportWriter struct {
+	w      http.ResponseWriter
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVExportWriter(w http.ResponseWriter) *csvExportWriter {
+	return &csvExportWriter{w: w, writer: csv.NewWriter(w)}
+}
+
+func (c *csvExportWriter) WriteHeader(columns []string) error {
+	// UTF-8 BOM, jotta Excel tunnistaa ääkköset oikein
+	if _, err := c.w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return err
+	}
+	c.record = make([]string, len(columns))
+	return c.writer.Write(columns)
+}
+
+func (c *csvExportWriter) WriteRow(_ []string, values []interface{}) error {
+	for i, v := range values {
+		if v == nil {
+			c.record[i] = ""
+		} else {
+			c.record[i] = fmt.Sprintf("%v", v)
+		}
+	}
+	return c.writer.Write(c.record)
+}
+
+func (c *csvExportWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvExportWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// --------------------------------------------------------------------
+// JSON / NDJSON
+// --------------------------------------------------------------------
+
+type jsonExportWriter struct {
+	w       http.ResponseWriter
+	ndjson  bool
+	rowsOut int
+}
+
+func (j *jsonExportWriter) WriteHeader(_ []string) error {
+	if j.ndjson {
+		return nil
+	}
+	_, err := j.w.Write([]byte("["))
+	return err
+}
+
+func (j *jsonExportWriter) WriteRow(columns []string, values []interface{}) error {
+	row := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		row[col] = values[i]
+	}
+	encoded, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if j.ndjson {
+		encoded = append(encoded, '\n')
+	} else if j.rowsOut > 0 {
+		encoded = append([]byte(",\n"), encoded...)
+	}
+	j.rowsOut++
+	_, err = j.w.Write(encoded)
+	return err
+}
+
+func (j *jsonExportWriter) Flush() error {
+	return nil
+}
+
+func (j *jsonExportWriter) Close() error {
+	if j.ndjson {
+		return nil
+	}
+	_, err := j.w.Write([]byte("]\n"))
+	return err
+}
@@ -0,0 +1,147 @@
+// file: export_xlsx.go
+package gt_1_row_read
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xlsxExportWriter kirjoittaa minimaalisen XLSX-tiedoston suoraan vastaukseen.
+// Zip-arkisto kirjoitetaan peräkkäin, joten rivejä ei tarvitse pitää muistissa:
+// taulukon rivit virtaavat sheet1.xml-tiedostoon inline-merkkijonoina.
+type xlsxExportWriter struct {
+	zipWriter *zip.Writer
+	sheet     io.Writer
+	rowNumber int
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="export" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+func newXLSXExportWriter(w io.Writer) *xlsxExportWriter {
+	return &xlsxExportWriter{zipWriter: zip.NewWriter(w)}
+}
+
+func (x *xlsxExportWriter) WriteHeader(columns []string) error {
+	staticParts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range staticParts {
+		f, err := x.zipWriter.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := x.zipWriter.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.sheet = sheet
+	if _, err := io.WriteString(x.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	for i, c := range columns {
+		values[i] = c
+	}
+	return x.WriteRow(columns, values)
+}
+
+func (x *xlsxExportWriter) WriteRow(_ []string, values []interface{}) error {
+	x.rowNumber++
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<row r="%d">`, x.rowNumber)
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		ref := xlsxColumnName(i) + strconv.Itoa(x.rowNumber)
+		switch typed := v.(type) {
+		case int64:
+			fmt.Fprintf(&sb, `<c r="%s"><v>%d</v></c>`, ref, typed)
+		case float64:
+			fmt.Fprintf(&sb, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(typed, 'g', -1, 64))
+		case bool:
+			b := 0
+			if typed {
+				b = 1
+			}
+			fmt.Fprintf(&sb, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		default:
+			fmt.Fprintf(&sb, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			if err := xml.EscapeText(&sb, []byte(xlsxCleanText(fmt.Sprintf("%v", typed)))); err != nil {
+				return err
+			}
+			sb.WriteString(`</t></is></c>`)
+		}
+	}
+	sb.WriteString(`</row>`)
+	_, err := io.WriteString(x.sheet, sb.String())
+	return err
+}
+
+func (x *xlsxExportWriter) Flush() error {
+	return x.zipWriter.Flush()
+}
+
+func (x *xlsxExportWriter) Close() error {
+	if x.sheet != nil {
+		if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+			return err
+		}
+	}
+	return x.zipWriter.Close()
+}
+
+// xlsxColumnName muuntaa 0-pohjaisen sarakeindeksin Excelin kirjaimiksi (0 -> A, 26 -> AA).
+func xlsxColumnName(idx int) string {
+	name := ""
+	for idx >= 0 {
+		name = string(rune('A'+idx%26)) + name
+		idx = idx/26 - 1
+	}
+	return name
+}
+
+// xlsxCleanText poistaa merkit, jotka eivät ole sallittuja XML 1.0 -dokumentissa.
+func xlsxCleanText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r != 0xFFFE && r != 0xFFFF) {
+			return r
+		}
+		return -1
+	}, s)
+}
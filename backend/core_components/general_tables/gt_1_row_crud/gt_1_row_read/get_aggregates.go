@@ -0,0 +1,234 @@
+// file: get_aggregates.go
+package gt_1_row_read
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// maxAggregateGroups rajaa palautettavien ryhmien määrän, jos limit-parametria ei anneta.
+const maxAggregateGroups = 1000
+
+// allowedAggregateFuncs kertoo sallitut koostefunktiot ja vaativatko ne numeerisen sarakkeen.
+var allowedAggregateFuncs = map[string]bool{
+	"count": false,
+	"sum":   true,
+	"avg":   true,
+	"min":   false,
+	"max":   false,
+}
+
+// GetAggregatesHandler palauttaa ryhmitellyt koosteet taulun datasta.
+// Parametrit:
+//
+//	?list=<taulu>
+//	&group_by=status,service_name (ln)     (pilkkuerotettu, voi olla tyhjä)
+//	&aggregates=count:*,sum:price,avg:price (funktio:sarake)
+//	&limit=100
+//
+// Suodattimet, sarakeoikeudet ja must_be_true -rajaukset toimivat kuten GetResultsissa.
+func GetAggregatesHandler(w http.ResponseWriter, r *http.Request) {
+	tableName := r.URL.Query().Get("list")
+	if tableName == "" {
+		http.Error(w, "missing 'list' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	aggregatesParam := strings.TrimSpace(r.URL.Query().Get("aggregates"))
+	if aggregatesParam == "" {
+		aggregatesParam = "count:*"
+	}
+
+	limit := maxAggregateGroups
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "virhe limit-parametrissa", http.StatusBadRequest)
+			return
+		}
+		if parsed < limit {
+			limit = parsed
+		}
+	}
+
+	rq, ok := prepareResultsQuery(w, r, tableName, r.URL.Query(), resultsQueryOptions{AllAllowedColumns: true})
+	if !ok {
+		return
+	}
+
+	// 1. Ryhmittelysarakkeet: sallittuja ovat kaikki näkyvät sarakkeet ja 1-M nimisarakkeet
+	var selectParts []string
+	var groupByExprs []string
+	var outputColumns []string
+	for _, rawCol := range strings.Split(r.URL.Query().Get("group_by"), ",") {
+		col := strings.TrimSpace(rawCol)
+		if col == "" {
+			continue
+		}
+		expr, ok := rq.ColumnExpressions[col]
+		if !ok {
+			http.Error(w, fmt.Sprintf("tuntematon ryhmittelysarake: %s", col), http.StatusBadRequest)
+			return
+		}
+		if rq.VirtualColumns[col] {
+			http.Error(w, fmt.Sprintf("M2M-sarakkeella ei voi ryhmitellä: %s", col), http.StatusBadRequest)
+			return
+		}
+		selectParts = append(selectParts, fmt.Sprintf("%s AS %s", expr, pq.QuoteIdentifier(col)))
+		groupByExprs = append(groupByExprs, expr)
+		outputColumns = append(outputColumns, col)
+	}
+
+	// 2. Koostefunktiot
+	for _, rawAgg := range strings.Split(aggregatesParam, ",") {
+		rawAgg = strings.TrimSpace(rawAgg)
+		if rawAgg == "" {
+			continue
+		}
+		parts := strings.SplitN(rawAgg, ":", 2)
+		funcName := strings.ToLower(strings.TrimSpace(parts[0]))
+		targetCol := "*"
+		if len(parts) == 2 {
+			targetCol = strings.TrimSpace(parts[1])
+		}
+
+		needsNumeric, known := allowedAggregateFuncs[funcName]
+		if !known {
+			http.Error(w, fmt.Sprintf("tuntematon koostefunktio: %s", funcName), http.StatusBadRequest)
+			return
+		}
+
+		var aggExpr, alias string
+		if targetCol == "*" {
+			if funcName != "count" {
+				http.Error(w, fmt.Sprintf("funktio %s vaatii sarakkeen", funcName), http.StatusBadRequest)
+				return
+			}
+			aggExpr = "COUNT(*)"
+			alias = "count"
+		} else {
+			expr, ok := rq.ColumnExpressions[targetCol]
+			if !ok {
+				http.Error(w, fmt.Sprintf("tuntematon koostesarake: %s", targetCol), http.StatusBadRequest)
+				return
+			}
+			if needsNumeric {
+				colInfo, isBase := rq.ColumnsByName[targetCol]
+				if !isBase || !isNumericDataType(colInfo.DataType) {
+					http.Error(w, fmt.Sprintf("funktio %s vaatii numeerisen sarakkeen: %s", funcName, targetCol), http.StatusBadRequest)
+					return
+				}
+			}
+			aggExpr = fmt.Sprintf("%s(%s)", strings.ToUpper(funcName), expr)
+			alias = funcName + "_" + targetCol
+		}
+		selectParts = append(selectParts, fmt.Sprintf("%s AS %s", aggExpr, pq.QuoteIdentifier(alias)))
+		outputColumns = append(outputColumns, alias)
+	}
+
+	groupByClause := ""
+	orderByClause := ""
+	if len(groupByExprs) > 0 {
+		groupByClause = " GROUP BY " + strings.Join(groupByExprs, ", ")
+		orderByClause = " ORDER BY " + strings.Join(groupByExprs, ", ")
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s %s%s%s%s LIMIT %d",
+		strings.Join(selectParts, ", "),
+		pq.QuoteIdentifier(tableName),
+		rq.JoinClauses,
+		rq.WhereClause,
+		groupByClause,
+		orderByClause,
+		limit,
+	)
+
+	rows, err := rq.Db.Query(query, rq.Args...)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe koosteiden haussa", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	results := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(outputColumns))
+		pointers := make([]interface{}, len(outputColumns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe rivien käsittelyssä", http.StatusInternalServerError)
+			return
+		}
+		results = append(results, aggregateRow(outputColumns, len(groupByExprs), values))
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe rivien käsittelyssä (err)", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"columns": outputColumns,
+		"data":    results,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// aggregateRow muodostaa vastausrivin. Ensimmäiset groupCount saraketta ovat
+// ryhmittelyarvoja, jotka palautetaan kuten viennissä; loput ovat koosteita.
+func aggregateRow(columns []string, groupCount int, values []interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		if i < groupCount {
+			row[col] = exportValue(values[i])
+		} else {
+			row[col] = aggregateValue(values[i])
+		}
+	}
+	return row
+}
+
+// aggregateValue palauttaa numeric-tyyppisen ([]byte) koosteen json.Numberina, jotta
+// esim. suurten summien tai tarkkojen desimaalien arvo säilyy sellaisenaan JSONissa.
+// Muut kuin lukumuotoiset arvot (esim. NaN) palautetaan merkkijonoina, muut kuten exportValue.
+func aggregateValue(val interface{}) interface{} {
+	if b, ok := val.([]byte); ok {
+		if isJSONNumber(b) {
+			return json.Number(b)
+		}
+		return string(b)
+	}
+	return exportValue(val)
+}
+
+// isJSONNumber kertoo, kelpaako arvo sellaisenaan JSON-luvuksi.
+func isJSONNumber(b []byte) bool {
+	if len(b) == 0 || (b[0] != '-' && (b[0] < '0' || b[0] > '9')) {
+		return false
+	}
+	return json.Valid(b)
+}
+
+// isNumericDataType kertoo, onko PostgreSQL:n tietotyyppi numeerinen.
+func isNumericDataType(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "smallint", "integer", "bigint", "int", "int2", "int4", "int8",
+		"numeric", "decimal", "real", "double precision", "float4", "float8",
+		"serial", "bigserial", "smallserial":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package gt_1_row_read
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAggregateRowJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		columns    []string
+		groupCount int
+		values     []interface{}
+		want       string
+	}{
+		{
+			"numeric sum keeps every digit",
+			[]string{"sum_price"}, 0,
+			[]interface{}{[]byte("12345678901234567890.12")},
+			`{"sum_price":12345678901234567890.12}`,
+		},
+		{
+			"count and avg",
+			[]string{"count", "avg_price"}, 0,
+			[]interface{}{int64(3), []byte("0.33333333333333333333")},
+			`{"avg_price":0.33333333333333333333,"count":3}`,
+		},
+		{
+			"numeric group value stays text",
+			[]string{"zip", "count"}, 1,
+			[]interface{}{[]byte("00100"), int64(2)},
+			`{"count":2,"zip":"00100"}`,
+		},
+		{
+			"NaN aggregate as text",
+			[]string{"avg_price"}, 0,
+			[]interface{}{[]byte("NaN")},
+			`{"avg_price":"NaN"}`,
+		},
+		{
+			"null aggregate",
+			[]string{"max_price"}, 0,
+			[]interface{}{nil},
+			`{"max_price":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(aggregateRow(tt.columns, tt.groupCount, tt.values))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("aggregateRow() JSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,18 +4,23 @@ package gt_1_row_read
 
 import (
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/general_tables/row_policies"
+	e_sessions "easelect/backend/core_components/sessions"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/lib/pq"
 )
 
-// GetDynamicChildItemsHandler etsii referencing_table/column -parit,
-// joilla referenced_table = parent_table, ja hakee lapsirivit,
-// joissa referencing_column = parent_pk_value.
+// GetDynamicChildItemsHandler etsii vierasavaimet, jotka viittaavat parent_tableen,
+// ja hakee lapsirivit, joiden viittaussarakkeet vastaavat parent_pk_value-avaimella
+// löytyvän rivin viitattuja sarakkeita. parent_pk_value on pääavaimen arvo tai
+// yhdistelmäavaimella olio {"sarake": arvo, ...}; moniosaiset vierasavaimet tuetaan.
 func GetDynamicChildItemsHandler(response_writer http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodPost {
 		http.Error(response_writer, "method not allowed", http.StatusMethodNotAllowed)
@@ -24,10 +29,12 @@ func GetDynamicChildItemsHandler(response_writer http.ResponseWriter, request *h
 
 	// Luetaan body
 	var body_data struct {
-		Parent_table    string `json:"parent_table"`
-		Parent_pk_value string `json:"parent_pk_value"`
+		Parent_table    string      `json:"parent_table"`
+		Parent_pk_value interface{} `json:"parent_pk_value"`
 	}
-	if err := json.NewDecoder(request.Body).Decode(&body_data); err != nil {
+	decoder := json.NewDecoder(request.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body_data); err != nil {
 		log.Printf("\033[31mvirhe: dynaaminen lapsihaku, dekoodaus epäonnistui: %s\033[0m\n", err.Error())
 		http.Error(response_writer, "virhe dekoodattaessa dataa", http.StatusBadRequest)
 		return
@@ -37,35 +44,45 @@ func GetDynamicChildItemsHandler(response_writer http.ResponseWriter, request *h
 		http.Error(response_writer, "parent_table puuttuu", http.StatusBadRequest)
 		return
 	}
-	if body_data.Parent_pk_value == "" {
+	if body_data.Parent_pk_value == nil || body_data.Parent_pk_value == "" {
 		http.Error(response_writer, "parent_pk_value puuttuu", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Dynaaminen lapsihaku: table=%s, pk_value=%s", body_data.Parent_table, body_data.Parent_pk_value)
+	parent_key, err := row_key.Parse(body_data.Parent_table, body_data.Parent_pk_value)
+	if errors.Is(err, row_key.ErrInvalidKey) {
+		http.Error(response_writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "virhe pääavaimen haussa", http.StatusInternalServerError)
+		return
+	}
 
-	// Kysely, jolla haetaan ne foreign key -rivit, joissa ccu.table_name = haluttu taulu
+	log.Printf("Dynaaminen lapsihaku: table=%s, pk_value=%s", body_data.Parent_table, parent_key.String())
+
+	// Vierasavaimet, jotka viittaavat tauluun; sarakeparit vierasavaimen järjestyksessä
 	query_fk := `
         SELECT
-            tc.constraint_name,
-            tc.table_name AS referencing_table,
-            kcu.column_name AS referencing_column,
-            ccu.table_name AS referenced_table,
-            ccu.column_name AS referenced_column
-        FROM
-            information_schema.table_constraints AS tc
-            JOIN information_schema.key_column_usage AS kcu
-                ON tc.constraint_name = kcu.constraint_name
-                AND tc.constraint_schema = kcu.constraint_schema
-            JOIN information_schema.constraint_column_usage AS ccu
-                ON ccu.constraint_name = tc.constraint_name
-                AND ccu.constraint_schema = tc.constraint_schema
-        WHERE
-            tc.constraint_type = 'FOREIGN KEY'
-            AND ccu.table_name = $1
+            c.conname,
+            cl.relname AS referencing_table,
+            array_agg(a.attname ORDER BY k.ord) AS referencing_columns,
+            array_agg(pa.attname ORDER BY k.ord) AS referenced_columns
+        FROM pg_constraint c
+        JOIN pg_class cl ON cl.oid = c.conrelid
+        JOIN pg_namespace n ON n.oid = cl.relnamespace
+        CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(attnum, fattnum, ord)
+        JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+        JOIN pg_attribute pa ON pa.attrelid = c.confrelid AND pa.attnum = k.fattnum
+        WHERE c.contype = 'f'
+          AND c.confrelid = to_regclass($1)
+          AND n.nspname = 'public'
+        GROUP BY c.conname, cl.relname
+        ORDER BY cl.relname, c.conname
     `
 
-	rows_fk, err := backend.Db.Query(query_fk, body_data.Parent_table)
+	rows_fk, err := backend.Db.Query(query_fk, "public."+pq.QuoteIdentifier(body_data.Parent_table))
 	if err != nil {
 		log.Printf("\033[31mvirhe: foreign key -haku epäonnistui: %s\033[0m\n", err.Error())
 		http.Error(response_writer, "virhe foreign key -haussa", http.StatusInternalServerError)
@@ -74,18 +91,17 @@ func GetDynamicChildItemsHandler(response_writer http.ResponseWriter, request *h
 	defer rows_fk.Close()
 
 	type FKInfo struct {
-		Constraint_name    string
-		Referencing_table  string
-		Referencing_column string
-		Referenced_table   string
-		Referenced_column  string
+		Constraint_name     string
+		Referencing_table   string
+		Referencing_columns []string
+		Referenced_columns  []string
 	}
 
 	var fk_infos []FKInfo
 	for rows_fk.Next() {
 		var f FKInfo
-		if err := rows_fk.Scan(&f.Constraint_name, &f.Referencing_table, &f.Referencing_column,
-			&f.Referenced_table, &f.Referenced_column); err != nil {
+		if err := rows_fk.Scan(&f.Constraint_name, &f.Referencing_table,
+			pq.Array(&f.Referencing_columns), pq.Array(&f.Referenced_columns)); err != nil {
 			log.Printf("\033[31mvirhe: foreign key -scan: %s\033[0m\n", err.Error())
 			http.Error(response_writer, "virhe foreign key -datassa", http.StatusInternalServerError)
 			return
@@ -93,14 +109,6 @@ func GetDynamicChildItemsHandler(response_writer http.ResponseWriter, request *h
 		fk_infos = append(fk_infos, f)
 	}
 
-	// Muunnetaan parent_pk_value intiksi (jos pk on numeerinen)
-	parent_id, err := strconv.Atoi(body_data.Parent_pk_value)
-	if err != nil {
-		log.Printf("\033[31mvirhe: parent_pk_value ei ole int: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "parent_pk_value ei ollut int", http.StatusBadRequest)
-		return
-	}
-
 	// Rakenne palautusta varten:
 	// child_tables_list = [
 	//   { table_name: "children", column_name: "parent_id", rows: [...] },
@@ -114,12 +122,36 @@ func GetDynamicChildItemsHandler(response_writer http.ResponseWriter, request *h
 
 	var child_tables_list []ChildTableResult
 
+	user_id, _ := e_sessions.GetUserIDFromSession(request)
+
+	safe_parent := pq.QuoteIdentifier(body_data.Parent_table)
 	for _, fk_row := range fk_infos {
-		query_child := fmt.Sprintf("SELECT * FROM %s WHERE %s = $1",
+		// Lapsirivit sidotaan vanhemman rivin viitattuihin sarakkeisiin, jotka haetaan
+		// pääavaimella (vierasavain ei välttämättä viittaa pääavaimeen)
+		key_cond, key_args := parent_key.Condition(safe_parent, 1)
+		fk_cond := fmt.Sprintf("(%s) IN (SELECT %s FROM %s WHERE %s)",
+			row_key.ColumnList(pq.QuoteIdentifier(fk_row.Referencing_table), fk_row.Referencing_columns),
+			row_key.ColumnList(safe_parent, fk_row.Referenced_columns),
+			safe_parent,
+			key_cond,
+		)
+
+		// Lapsitaulun rivitason säännöt rajaavat palautettavat rivit
+		where_clause, query_args, err := row_policies.AppendCondition(
+			" WHERE "+fk_cond,
+			key_args,
+			user_id,
+			fk_row.Referencing_table,
+		)
+		if err != nil {
+			log.Printf("\033[31mvirhe: rivisäännöt taululle %s: %s\033[0m\n", fk_row.Referencing_table, err.Error())
+			continue
+		}
+		query_child := fmt.Sprintf("SELECT * FROM %s%s",
 			pq.QuoteIdentifier(fk_row.Referencing_table),
-			pq.QuoteIdentifier(fk_row.Referencing_column),
+			where_clause,
 		)
-		child_rows, err := backend.Db.Query(query_child, parent_id)
+		child_rows, err := backend.Db.Query(query_child, query_args...)
 		if err != nil {
 			log.Printf("\033[31mvirhe: lapsirivien haku taulusta %s: %s\033[0m\n", fk_row.Referencing_table, err.Error())
 			continue
@@ -160,7 +192,7 @@ func GetDynamicChildItemsHandler(response_writer http.ResponseWriter, request *h
 
 		child_tables_list = append(child_tables_list, ChildTableResult{
 			Table_name:  fk_row.Referencing_table,
-			Column_name: fk_row.Referencing_column,
+			Column_name: strings.Join(fk_row.Referencing_columns, ","),
 			Rows:        table_rows,
 		})
 	}
@@ -0,0 +1,247 @@
+// file: get_facets.go
+package gt_1_row_read
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultFacetLimit = 20
+	maxFacetLimit     = 200
+)
+
+// allowedFacetBuckets ovat päivämääräsarakkeiden sallitut ryhmittelyvälit.
+var allowedFacetBuckets = map[string]bool{
+	"day":     true,
+	"week":    true,
+	"month":   true,
+	"quarter": true,
+	"year":    true,
+}
+
+// facetSpec on yksi pyydetty fasetti.
+type facetSpec struct {
+	Column string
+	Kind   string // value, boolean, date
+	Bucket string
+	Expr   string
+}
+
+// facetValue on fasetin yksi arvo ja sitä vastaavien rivien määrä.
+type facetValue struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
+
+// facetResult on yhden fasetin vastaus.
+type facetResult struct {
+	Column  string       `json:"column"`
+	Kind    string       `json:"kind"`
+	Bucket  string       `json:"bucket,omitempty"`
+	Values  []facetValue `json:"values"`
+	HasMore bool         `json:"has_more"`
+}
+
+// GetFacetsHandler palauttaa valituille sarakkeille arvojen lukumäärät suodatinpalkkia varten.
+// Parametrit:
+//
+//	?list=<taulu>
+//	&facets=status,service_name (ln),created:month   (pilkkuerotettu; päivämäärille väli)
+//	&facet_limit=20                                 (arvoja per fasetti)
+//
+// Suodattimet, sarakeoikeudet, must_be_true ja rivitason säännöt toimivat kuten
+// GetResultsissa, mutta kunkin fasetin määrissä ei huomioida fasetin oman sarakkeen
+// suodatinta (sarake, sarake_from, sarake_to), jotta muut vaihtoehdot näkyvät.
+// 1-M-nimisarakkeet ryhmitellään näyttöarvon mukaan, boolean-sarakkeet true/false-arvoihin
+// ja päivämäärät väleihin (day, week, month, quarter, year; oletus month).
+func GetFacetsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	tableName := queryParams.Get("list")
+	if tableName == "" {
+		http.Error(w, "missing 'list' query parameter", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(queryParams.Get("facets")) == "" {
+		http.Error(w, "missing 'facets' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultFacetLimit
+	if limitStr := queryParams.Get("facet_limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "virhe facet_limit-parametrissa", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	if limit > maxFacetLimit {
+		limit = maxFacetLimit
+	}
+
+	rq, ok := prepareResultsQuery(w, r, tableName, queryParams, resultsQueryOptions{AllAllowedColumns: true})
+	if !ok {
+		return
+	}
+
+	specs, err := parseFacetSpecs(rq, queryParams.Get("facets"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]facetResult, 0, len(specs))
+	for _, spec := range specs {
+		// Fasetin määrät lasketaan ilman sen omaa suodatinta
+		facetQuery := *rq
+		if rqErr := facetQuery.applyFilters(withoutColumnFilters(queryParams, tableName, spec.Column)); rqErr != nil {
+			http.Error(w, rqErr.Message, rqErr.Status)
+			return
+		}
+		result, err := facetQuery.queryFacet(spec, limit)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe fasettien haussa", http.StatusInternalServerError)
+			return
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"facets": results,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// parseFacetSpecs tulkitsee facets-parametrin ja tarkistaa sarakkeet.
+func parseFacetSpecs(rq *resultsQuery, raw string) ([]facetSpec, error) {
+	var specs []facetSpec
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		column, bucket := part, ""
+		if idx := strings.LastIndex(part, ":"); idx >= 0 {
+			column, bucket = strings.TrimSpace(part[:idx]), strings.ToLower(strings.TrimSpace(part[idx+1:]))
+		}
+		if seen[column] {
+			continue
+		}
+		seen[column] = true
+
+		expr, ok := rq.ColumnExpressions[column]
+		if !ok {
+			return nil, fmt.Errorf("tuntematon fasettisarake: %s", column)
+		}
+		if rq.VirtualColumns[column] {
+			return nil, fmt.Errorf("M2M-sarakkeesta ei voi muodostaa fasettia: %s", column)
+		}
+
+		spec := facetSpec{Column: column, Kind: "value", Expr: expr}
+		dataType := ""
+		if colInfo, isBase := rq.ColumnsByName[column]; isBase {
+			dataType = strings.ToLower(colInfo.DataType)
+		}
+		switch {
+		case dataType == "boolean":
+			spec.Kind = "boolean"
+		case dataType == "date" || strings.HasPrefix(dataType, "timestamp"):
+			spec.Kind = "date"
+			if bucket == "" {
+				bucket = "month"
+			}
+			if !allowedFacetBuckets[bucket] {
+				return nil, fmt.Errorf("tuntematon päivämääräväli: %s", bucket)
+			}
+			spec.Bucket = bucket
+			spec.Expr = fmt.Sprintf("date_trunc(%s, %s)::date", pq.QuoteLiteral(bucket), expr)
+		case dataType == "bytea" || dataType == "json" || dataType == "jsonb" ||
+			strings.HasPrefix(dataType, "vector") || strings.HasPrefix(dataType, "geometry") ||
+			strings.HasPrefix(dataType, "geography"):
+			return nil, fmt.Errorf("sarakkeen tyypistä %s ei voi muodostaa fasettia: %s", dataType, column)
+		}
+		if bucket != "" && spec.Kind != "date" {
+			return nil, fmt.Errorf("väli on sallittu vain päivämääräsarakkeille: %s", column)
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("facets-parametrissa ei ole sarakkeita")
+	}
+	return specs, nil
+}
+
+// withoutColumnFilters palauttaa kyselyparametrit ilman sarakkeen omia suodattimia.
+// JSON-suodatinpuu (?filter=) ja globaali haku säilyvät sellaisinaan.
+func withoutColumnFilters(queryParams url.Values, tableName, column string) url.Values {
+	filtered := make(url.Values, len(queryParams))
+	for param, values := range queryParams {
+		switch stripTablePrefix(param, tableName) {
+		case column, column + "_from", column + "_to":
+			continue
+		}
+		filtered[param] = values
+	}
+	return filtered
+}
+
+// queryFacet laskee fasetin arvojen määrät. Arvot ovat määrän mukaan laskevassa
+// järjestyksessä; boolean- ja päivämääräfasetit arvon mukaan.
+func (rq *resultsQuery) queryFacet(spec facetSpec, limit int) (facetResult, error) {
+	orderBy := "2 DESC, 1"
+	if spec.Kind != "value" {
+		orderBy = "1"
+	}
+	query := fmt.Sprintf(
+		"SELECT %s AS facet_value, COUNT(*) AS facet_count FROM %s %s%s GROUP BY 1 ORDER BY %s NULLS LAST LIMIT %d",
+		spec.Expr,
+		pq.QuoteIdentifier(rq.TableName),
+		rq.JoinClauses,
+		rq.WhereClause,
+		orderBy,
+		limit+1,
+	)
+	rows, err := rq.Db.Query(query, rq.Args...)
+	if err != nil {
+		return facetResult{}, fmt.Errorf("fasetin %s haku epäonnistui: %w", spec.Column, err)
+	}
+	defer rows.Close()
+
+	result := facetResult{
+		Column: spec.Column,
+		Kind:   spec.Kind,
+		Bucket: spec.Bucket,
+		Values: make([]facetValue, 0),
+	}
+	for rows.Next() {
+		if len(result.Values) == limit {
+			result.HasMore = true
+			break
+		}
+		var value interface{}
+		var count int64
+		if err := rows.Scan(&value, &count); err != nil {
+			return facetResult{}, err
+		}
+		if t, ok := value.(time.Time); ok {
+			value = t.Format("2006-01-02")
+		} else {
+			value = exportValue(value)
+		}
+		result.Values = append(result.Values, facetValue{Value: value, Count: count})
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,502 @@
+// file: get_geo.go
+package gt_1_row_read
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"easelect/backend/core_components/general_tables/column_grants"
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/general_tables/row_policies"
+	"easelect/backend/core_components/middlewares"
+)
+
+// Paikkatietohaku rajaa taulun rivit kartan näkymäalueella (bbox) tai säteellä pisteestä
+// (near + radius_m). Geometria otetaan taulun omasta geo-sarakkeesta tai taulua
+// vierasavaimella viittaavasta geo-taulusta (esim. service_locations.position ->
+// services), kuten getGeometryColumns / getGeometrySourceTables ne tunnistavat.
+// Geometrioiden oletetaan olevan WGS84-koordinaatistossa (SRID 4326).
+
+const (
+	defaultGeoLimit = 1000
+	maxGeoLimit     = 10000
+
+	// geoSourceAlias on liitetyn geo-taulun alias kyselyssä
+	geoSourceAlias = "geo_src"
+
+	// Sisäiset tulossarakkeet, joita ei palauteta ominaisuuksina
+	geoGeometryColumn = "_geo_geometry"
+	geoDistanceColumn = "_geo_distance_m"
+	geoKeyColumn      = "_geo_key_"
+)
+
+// geoSource on paikkatiedon lähde: taulun oma geo-sarake tai viittaavan taulun geo-sarake.
+type geoSource struct {
+	Table            string
+	GeomColumn       string
+	FKColumn         string // lähdetaulun viittaussarake; tyhjä, jos sarake on taulun oma
+	ReferencedColumn string // päätaulun sarake, johon FKColumn viittaa
+}
+
+// Name on lähteen tunniste geom-parametrissa: "sarake" tai "taulu.sarake".
+func (gs geoSource) Name() string {
+	if gs.isOwn() {
+		return gs.GeomColumn
+	}
+	return gs.Table + "." + gs.GeomColumn
+}
+
+func (gs geoSource) isOwn() bool {
+	return gs.FKColumn == ""
+}
+
+// geometryExpr palauttaa lähteen geometrian geometry-tyyppisenä lausekkeena.
+func (gs geoSource) geometryExpr(mainTable string) string {
+	ref := pq.QuoteIdentifier(mainTable)
+	if !gs.isOwn() {
+		ref = geoSourceAlias
+	}
+	return fmt.Sprintf("%s.%s::geometry", ref, pq.QuoteIdentifier(gs.GeomColumn))
+}
+
+// geoFilter on bbox- tai säde-ehto.
+type geoFilter struct {
+	HasBBox                        bool
+	MinLon, MinLat, MaxLon, MaxLat float64
+	HasRadius                      bool
+	Lat, Lon, RadiusM              float64
+}
+
+// parseGeoFilter lukee parametrit bbox=minLon,minLat,maxLon,maxLat sekä
+// near=lat,lon ja radius_m=metrit. Kumpikin ehto on valinnainen.
+func parseGeoFilter(q url.Values) (geoFilter, error) {
+	var f geoFilter
+	if raw := q.Get("bbox"); raw != "" {
+		values, err := parseFloatList(raw, 4)
+		if err != nil {
+			return f, fmt.Errorf("bbox: %w", err)
+		}
+		f.HasBBox = true
+		f.MinLon, f.MinLat, f.MaxLon, f.MaxLat = values[0], values[1], values[2], values[3]
+		if f.MinLat > f.MaxLat || f.MinLon > f.MaxLon {
+			return f, fmt.Errorf("bbox: minimin on oltava maksimia pienempi")
+		}
+	}
+	near, radius := q.Get("near"), q.Get("radius_m")
+	if near != "" || radius != "" {
+		if near == "" || radius == "" {
+			return f, fmt.Errorf("near ja radius_m annetaan yhdessä")
+		}
+		values, err := parseFloatList(near, 2)
+		if err != nil {
+			return f, fmt.Errorf("near: %w", err)
+		}
+		f.Lat, f.Lon = values[0], values[1]
+		f.RadiusM, err = strconv.ParseFloat(radius, 64)
+		if err != nil || f.RadiusM <= 0 {
+			return f, fmt.Errorf("radius_m: positiivinen luku vaaditaan")
+		}
+		f.HasRadius = true
+	}
+	return f, nil
+}
+
+func parseFloatList(raw string, count int) ([]float64, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != count {
+		return nil, fmt.Errorf("odotettiin %d lukua", count)
+	}
+	values := make([]float64, count)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("virheellinen luku %q", part)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// availableGeoSources listaa taulun geo-lähteet, joihin käyttäjällä on oikeus:
+// omat geo-sarakkeet SELECT-oikeudella sekä viittaavat geo-taulut, joihin käyttäjällä
+// on GetResults-oikeus ja joiden geo- ja viittaussarakkeisiin roolilla on SELECT-oikeus.
+func availableGeoSources(rq *resultsQuery) ([]geoSource, error) {
+	var sources []geoSource
+
+	ownColumns, err := getGeometryColumns(rq.Db, rq.TableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range ownColumns {
+		if rq.AllowedColumns[col] {
+			sources = append(sources, geoSource{Table: rq.TableName, GeomColumn: col})
+		}
+	}
+
+	referencing, err := fetchReferencingForeignKeys(rq.TableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range referencing {
+		if fk.ChildTable == rq.TableName || !rq.AllowedColumns[fk.ReferencedColumn] {
+			continue
+		}
+		geomColumns, err := getGeometryColumns(rq.Db, fk.ChildTable)
+		if err != nil {
+			return nil, err
+		}
+		if len(geomColumns) == 0 {
+			continue
+		}
+		if !middlewares.UserHasFunctionPermission(rq.UserID, getResultsFunctionName, fk.ChildTable) {
+			continue
+		}
+		allowed, err := column_grants.SelectableSet(rq.Db, fk.ChildTable)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed[fk.ChildColumn] {
+			continue
+		}
+		for _, col := range geomColumns {
+			if allowed[col] {
+				sources = append(sources, geoSource{
+					Table:            fk.ChildTable,
+					GeomColumn:       col,
+					FKColumn:         fk.ChildColumn,
+					ReferencedColumn: fk.ReferencedColumn,
+				})
+			}
+		}
+	}
+	return sources, nil
+}
+
+// pickGeoSource valitsee geom-parametrin mukaisen lähteen tai oletuksena ensimmäisen
+// (taulun omat sarakkeet ensin).
+func pickGeoSource(sources []geoSource, requested string) (geoSource, *resultsQueryError) {
+	if len(sources) == 0 {
+		return geoSource{}, &resultsQueryError{http.StatusBadRequest, "taulussa ei ole paikkatietoa, johon sinulla on oikeus"}
+	}
+	if requested == "" {
+		return sources[0], nil
+	}
+	for _, gs := range sources {
+		if gs.Name() == requested {
+			return gs, nil
+		}
+	}
+	return geoSource{}, &resultsQueryError{http.StatusBadRequest, "tuntematon geom-lähde: " + requested}
+}
+
+// applyGeoSource liittää lähteen kyselyyn. Liitetyn geo-taulun must_be_true-sarakkeet
+// ja rivitason säännöt rajaavat sijainnit kuten taulun omassa GetResults-haussa.
+// Rivit, joilla ei ole geometriaa, jätetään pois.
+func (rq *resultsQuery) applyGeoSource(gs geoSource) error {
+	if !gs.isOwn() {
+		rq.JoinClauses += fmt.Sprintf(" JOIN %s AS %s ON %s.%s = %s.%s",
+			pq.QuoteIdentifier(gs.Table),
+			geoSourceAlias,
+			geoSourceAlias,
+			pq.QuoteIdentifier(gs.FKColumn),
+			pq.QuoteIdentifier(rq.TableName),
+			pq.QuoteIdentifier(gs.ReferencedColumn),
+		)
+		if rq.UserRole != "admin" {
+			mustTrueCols, err := getMustBeTrueColumns(rq.Db, gs.Table)
+			if err != nil {
+				return err
+			}
+			for _, c := range mustTrueCols {
+				rq.addCondition(fmt.Sprintf("%s.%s = TRUE", geoSourceAlias, pq.QuoteIdentifier(c)))
+			}
+		}
+		policyCond, policyArgs, err := row_policies.BuildCondition(rq.UserID, gs.Table, geoSourceAlias, rq.nextArgIdx())
+		if err != nil {
+			return err
+		}
+		if policyCond != "" {
+			rq.addCondition(policyCond, policyArgs...)
+		}
+	}
+	rq.addCondition(gs.geometryExpr(rq.TableName) + " IS NOT NULL")
+	return nil
+}
+
+// apply lisää suodattimen ehdot kyselyyn. Sädehaussa palautetaan etäisyyslauseke
+// (metreinä) lajittelua ja vastausta varten, muuten tyhjä.
+func (f geoFilter) apply(rq *resultsQuery, geomExpr string) string {
+	if f.HasBBox {
+		idx := rq.nextArgIdx()
+		rq.addCondition(
+			fmt.Sprintf("ST_Intersects(%s, ST_MakeEnvelope($%d, $%d, $%d, $%d, 4326))", geomExpr, idx, idx+1, idx+2, idx+3),
+			f.MinLon, f.MinLat, f.MaxLon, f.MaxLat,
+		)
+	}
+	if !f.HasRadius {
+		return ""
+	}
+	idx := rq.nextArgIdx()
+	point := fmt.Sprintf("ST_SetSRID(ST_MakePoint($%d, $%d), 4326)::geography", idx, idx+1) // huom! lon, lat
+	rq.addCondition(
+		fmt.Sprintf("ST_DWithin(%s::geography, %s, $%d)", geomExpr, point, idx+2),
+		f.Lon, f.Lat, f.RadiusM,
+	)
+	return fmt.Sprintf("ST_Distance(%s::geography, %s)", geomExpr, point)
+}
+
+// geoQuery on paikkatietohaun valmiiksi rakennettu putki: GetResults-putki, valittu
+// lähde ehtoineen sekä pääavain feature-id:tä varten.
+type geoQuery struct {
+	rq           *resultsQuery
+	source       geoSource
+	sourceNames  []string
+	filter       geoFilter
+	geomExpr     string
+	distanceExpr string
+	pkColumns    []string
+	limit        int
+}
+
+// prepareGeoQuery lukee paikkatietohaun parametrit ja rakentaa putken.
+// Virhetilanteessa vastaus on jo kirjoitettu ja palautetaan false.
+func prepareGeoQuery(w http.ResponseWriter, r *http.Request) (*geoQuery, bool) {
+	queryParams := r.URL.Query()
+	tableName := queryParams.Get("table")
+	if tableName == "" {
+		http.Error(w, "table-parametri puuttuu", http.StatusBadRequest)
+		return nil, false
+	}
+	filter, err := parseGeoFilter(queryParams)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	limit := defaultGeoLimit
+	if raw := queryParams.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "virheellinen limit", http.StatusBadRequest)
+			return nil, false
+		}
+		limit = parsed
+	}
+	if limit > maxGeoLimit {
+		limit = maxGeoLimit
+	}
+
+	userID, userRole, currentDb, ok := getSessionUserRoleAndDb(w, r)
+	if !ok {
+		return nil, false
+	}
+	rq, rqErr := buildResultsQuery(userID, userRole, currentDb, tableName, queryParams, resultsQueryOptions{})
+	if rqErr != nil {
+		http.Error(w, rqErr.Message, rqErr.Status)
+		return nil, false
+	}
+
+	sources, err := availableGeoSources(rq)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe paikkatietolähteiden haussa", http.StatusInternalServerError)
+		return nil, false
+	}
+	source, srcErr := pickGeoSource(sources, queryParams.Get("geom"))
+	if srcErr != nil {
+		http.Error(w, srcErr.Message, srcErr.Status)
+		return nil, false
+	}
+	if err := rq.applyGeoSource(source); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe paikkatietolähteen liittämisessä", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	pkColumns, err := row_key.PrimaryKeyColumns(tableName)
+	if err != nil && !errors.Is(err, row_key.ErrNoPrimaryKey) {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe pääavaimen haussa", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	gq := &geoQuery{
+		rq:          rq,
+		source:      source,
+		sourceNames: make([]string, len(sources)),
+		filter:      filter,
+		geomExpr:    source.geometryExpr(tableName),
+		pkColumns:   pkColumns,
+		limit:       limit,
+	}
+	for i, gs := range sources {
+		gq.sourceNames[i] = gs.Name()
+	}
+	gq.distanceExpr = filter.apply(rq, gq.geomExpr)
+	return gq, true
+}
+
+// selectList palauttaa näkyvät sarakkeet sekä sisäiset geometria-, etäisyys- ja
+// avainsarakkeet. geometryExpr korvaa GeoJSON-muunnoksen lähteen (esim. klusterin piste).
+func (gq *geoQuery) selectList(geometryExpr string) string {
+	safeTable := pq.QuoteIdentifier(gq.rq.TableName)
+	parts := make([]string, 0, len(gq.pkColumns)+3)
+	if gq.rq.SelectColumns != "" {
+		parts = append(parts, gq.rq.SelectColumns)
+	}
+	parts = append(parts, fmt.Sprintf("%s AS %s", geometryExpr, geoGeometryColumn))
+	if gq.distanceExpr != "" {
+		parts = append(parts, fmt.Sprintf("%s AS %s", gq.distanceExpr, geoDistanceColumn))
+	}
+	for i, col := range gq.pkColumns {
+		parts = append(parts, fmt.Sprintf("%s.%s::text AS %s%d", safeTable, pq.QuoteIdentifier(col), geoKeyColumn, i))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// orderBy lajittelee sädehaun etäisyyden, muuten pääavaimen mukaan.
+func (gq *geoQuery) orderBy() string {
+	switch {
+	case gq.distanceExpr != "":
+		return " ORDER BY " + geoDistanceColumn
+	case len(gq.pkColumns) > 0:
+		return " ORDER BY " + row_key.ColumnList(pq.QuoteIdentifier(gq.rq.TableName), gq.pkColumns)
+	}
+	return ""
+}
+
+// queryFeatures hakee enintään limit riviä featureina sekä tiedon, jäikö rivejä yli.
+func (gq *geoQuery) queryFeatures() ([]map[string]interface{}, bool, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s %s%s%s LIMIT %d",
+		gq.selectList(fmt.Sprintf("ST_AsGeoJSON(%s, 6)", gq.geomExpr)),
+		pq.QuoteIdentifier(gq.rq.TableName),
+		gq.rq.JoinClauses,
+		gq.rq.WhereClause,
+		gq.orderBy(),
+		gq.limit+1,
+	)
+	rows, err := gq.rq.Db.Query(query, gq.rq.Args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, false, err
+	}
+
+	features := make([]map[string]interface{}, 0)
+	hasMore := false
+	for rows.Next() {
+		if len(features) == gq.limit {
+			hasMore = true
+			break
+		}
+		values, err := scanRowValues(rows, len(columns))
+		if err != nil {
+			return nil, false, err
+		}
+		features = append(features, buildGeoFeature(columns, values, gq.source, gq.pkColumns))
+	}
+	return features, hasMore, rows.Err()
+}
+
+// scanRowValues skannaa rivin kaikki sarakkeet.
+func scanRowValues(rows *sql.Rows, count int) ([]interface{}, error) {
+	values := make([]interface{}, count)
+	pointers := make([]interface{}, count)
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// GetGeoHandler palauttaa taulun rivit GeoJSON FeatureCollectionina.
+//
+//	GET /api/get-geo?table=services&bbox=24.8,60.1,25.1,60.3
+//	GET /api/get-geo?table=services&near=60.17,24.94&radius_m=2000&geom=service_locations.position
+//
+// Sarakeoikeudet, käyttäjän sarakeasetukset, suodattimet, must_be_true ja rivitason
+// säännöt ovat samat kuin GetResultsissa; ominaisuuksina palautetaan näkyvät sarakkeet.
+// Feature-id on rivin pääavain. Jos rivillä on useita sijainteja lähdetaulussa, jokainen
+// sijainti on oma featurensa. Sädehaussa tulokset ovat etäisyysjärjestyksessä ja
+// ominaisuuksissa on distance_m.
+func GetGeoHandler(w http.ResponseWriter, r *http.Request) {
+	gq, ok := prepareGeoQuery(w, r)
+	if !ok {
+		return
+	}
+
+	features, hasMore, err := gq.queryFeatures()
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe paikkatietohaussa", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("\033[36m[GetGeoHandler] %s (%s): %d featurea (user %d)\033[0m\n", gq.rq.TableName, gq.source.Name(), len(features), gq.rq.UserID)
+	writeFeatureCollection(w, map[string]interface{}{
+		"features":         features,
+		"geometry_source":  gq.source.Name(),
+		"geometry_sources": gq.sourceNames,
+		"has_more":         hasMore,
+		"limit":            gq.limit,
+	})
+}
+
+// writeFeatureCollection kirjoittaa GeoJSON FeatureCollectionin; members sisältää
+// features-listan sekä muut vastauksen kentät.
+func writeFeatureCollection(w http.ResponseWriter, members map[string]interface{}) {
+	members["type"] = "FeatureCollection"
+	w.Header().Set("Content-Type", "application/geo+json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(members); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// buildGeoFeature muodostaa yhden GeoJSON-featuren skannatusta rivistä.
+func buildGeoFeature(columns []string, values []interface{}, source geoSource, pkColumns []string) map[string]interface{} {
+	properties := make(map[string]interface{}, len(columns))
+	var geometry interface{}
+	key := row_key.RowKey{Columns: pkColumns, Values: make([]string, len(pkColumns))}
+	for i, col := range columns {
+		value := formatResultValue(col, values[i])
+		switch {
+		case col == geoGeometryColumn:
+			if s, ok := value.(string); ok {
+				geometry = json.RawMessage(s)
+			}
+		case col == geoDistanceColumn:
+			properties["distance_m"] = value
+		case strings.HasPrefix(col, geoKeyColumn):
+			if n, err := strconv.Atoi(strings.TrimPrefix(col, geoKeyColumn)); err == nil && n < len(pkColumns) {
+				key.Values[n], _ = value.(string)
+			}
+		case source.isOwn() && col == source.GeomColumn:
+			// geometria on jo featuren geometry-kentässä
+		default:
+			properties[col] = value
+		}
+	}
+	feature := map[string]interface{}{
+		"type":       "Feature",
+		"geometry":   geometry,
+		"properties": properties,
+	}
+	if len(pkColumns) > 0 {
+		feature["id"] = key.String()
+	}
+	return feature
+}
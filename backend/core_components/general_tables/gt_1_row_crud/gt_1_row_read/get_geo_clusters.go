@@ -0,0 +1,289 @@
+// file: get_geo_clusters.go
+package gt_1_row_read
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+
+	"easelect/backend/core_components/general_tables/row_key"
+)
+
+// Klusterointi kokoaa näkymäalueen (bbox) rivit ruudukkoon, jonka solukoko riippuu
+// kartan zoom-tasosta (Web Mercator -tiilet, 256 px): yksi solu on noin
+// geoClusterCellPx pikseliä leveä. Jokaisesta solusta palautetaan yksi feature,
+// jossa on rivien määrä, keskipiste, rajaava laatikko ja edustajarivi (pienin
+// pääavain). Zoom-tasolla geoClusterMaxZoom ja sitä tarkemmin palautetaan yksittäiset
+// rivit kuten GetGeoHandlerissa.
+
+const (
+	geoClusterCellPx  = 64
+	geoClusterMaxZoom = 17
+	maxGeoZoom        = 22
+
+	defaultGeoClusterLimit = 2000
+)
+
+// Klusterikyselyn sisäiset sarakkeet
+const (
+	geoClusterXColumn     = "_geo_x"
+	geoClusterYColumn     = "_geo_y"
+	geoClusterCountColumn = "_geo_count"
+	geoClusterTotalColumn = "_geo_total"
+	geoClusterCXColumn    = "_geo_cx"
+	geoClusterCYColumn    = "_geo_cy"
+	geoClusterMinXColumn  = "_geo_minx"
+	geoClusterMinYColumn  = "_geo_miny"
+	geoClusterMaxXColumn  = "_geo_maxx"
+	geoClusterMaxYColumn  = "_geo_maxy"
+	geoClusterRankColumn  = "_geo_rank"
+)
+
+// geoClusterCellSize palauttaa ruudukon solukoon asteina annetulla zoom-tasolla.
+func geoClusterCellSize(zoom int) float64 {
+	return 360.0 / math.Pow(2, float64(zoom)) * geoClusterCellPx / 256.0
+}
+
+// geoClusterExpansionZoom palauttaa zoom-tason, jolla klusteri hajoaa useaan soluun.
+func geoClusterExpansionZoom(zoom int, minX, minY, maxX, maxY float64) int {
+	extent := math.Max(maxX-minX, maxY-minY)
+	if extent <= 0 {
+		return geoClusterMaxZoom
+	}
+	z := int(math.Floor(math.Log2(360.0*geoClusterCellPx/256.0/extent))) + 1
+	if z <= zoom {
+		z = zoom + 1
+	}
+	if z > geoClusterMaxZoom {
+		z = geoClusterMaxZoom
+	}
+	return z
+}
+
+// GetGeoClustersHandler palauttaa näkymäalueen rivit klusteroituna GeoJSON
+// FeatureCollectionina.
+//
+//	GET /api/get-geo-clusters?table=services&bbox=19.0,59.5,31.6,70.1&zoom=5
+//
+// Parametrit ja oikeudet ovat samat kuin GetGeoHandlerissa; bbox ja zoom ovat pakollisia.
+// Klusterifeaturen ominaisuuksissa on cluster, point_count, bbox, expansion_zoom ja
+// representative (edustajarivin id ja ominaisuudet). Yhden rivin solut palautetaan
+// rivin omana featurena (cluster: false). Vastauksen total on rajattujen rivien määrä.
+func GetGeoClustersHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	if queryParams.Get("bbox") == "" {
+		http.Error(w, "bbox-parametri puuttuu", http.StatusBadRequest)
+		return
+	}
+	zoom, err := strconv.Atoi(queryParams.Get("zoom"))
+	if err != nil || zoom < 0 || zoom > maxGeoZoom {
+		http.Error(w, fmt.Sprintf("zoom on annettava kokonaislukuna 0–%d", maxGeoZoom), http.StatusBadRequest)
+		return
+	}
+
+	gq, ok := prepareGeoQuery(w, r)
+	if !ok {
+		return
+	}
+	if queryParams.Get("limit") == "" {
+		gq.limit = defaultGeoClusterLimit
+	}
+
+	// Tarkalla zoomilla palautetaan yksittäiset rivit
+	if zoom >= geoClusterMaxZoom {
+		features, hasMore, err := gq.queryFeatures()
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe paikkatietohaussa", http.StatusInternalServerError)
+			return
+		}
+		for _, feature := range features {
+			feature["properties"].(map[string]interface{})["cluster"] = false
+		}
+		fmt.Printf("\033[36m[GetGeoClustersHandler] %s (%s) zoom %d: %d riviä (user %d)\033[0m\n", gq.rq.TableName, gq.source.Name(), zoom, len(features), gq.rq.UserID)
+		writeFeatureCollection(w, map[string]interface{}{
+			"features":         features,
+			"geometry_source":  gq.source.Name(),
+			"geometry_sources": gq.sourceNames,
+			"has_more":         hasMore,
+			"limit":            gq.limit,
+			"zoom":             zoom,
+			"clustered":        false,
+		})
+		return
+	}
+
+	features, total, hasMore, err := gq.queryClusters(zoom)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe klusterihaussa", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("\033[36m[GetGeoClustersHandler] %s (%s) zoom %d: %d featurea, %d riviä (user %d)\033[0m\n", gq.rq.TableName, gq.source.Name(), zoom, len(features), total, gq.rq.UserID)
+	writeFeatureCollection(w, map[string]interface{}{
+		"features":         features,
+		"geometry_source":  gq.source.Name(),
+		"geometry_sources": gq.sourceNames,
+		"has_more":         hasMore,
+		"limit":            gq.limit,
+		"zoom":             zoom,
+		"clustered":        true,
+		"total":            total,
+	})
+}
+
+// queryClusters kokoaa rajatut rivit ruudukon soluihin. Jokaisesta solusta haetaan
+// edustajarivi sekä solun rivimäärä, keskipiste ja rajaava laatikko ikkunafunktioilla.
+// Solut palautetaan suurimmasta pienimpään, enintään limit kappaletta.
+func (gq *geoQuery) queryClusters(zoom int) ([]map[string]interface{}, int64, bool, error) {
+	cellIdx := gq.rq.nextArgIdx()
+	args := append(append([]interface{}(nil), gq.rq.Args...), geoClusterCellSize(zoom))
+
+	point := fmt.Sprintf("ST_PointOnSurface(%s)", gq.geomExpr)
+	rankOrder := "1"
+	if len(gq.pkColumns) > 0 {
+		keyColumns := make([]string, len(gq.pkColumns))
+		for i := range gq.pkColumns {
+			keyColumns[i] = fmt.Sprintf("%s%d", geoKeyColumn, i)
+		}
+		rankOrder = row_key.ColumnList("", keyColumns)
+	}
+
+	query := fmt.Sprintf(`
+		WITH filtered AS (
+			SELECT %s, ST_X(%s) AS %s, ST_Y(%s) AS %s
+			FROM %s %s%s
+		), cells AS (
+			SELECT filtered.*,
+				count(*) OVER cell AS %s,
+				count(*) OVER () AS %s,
+				avg(%s) OVER cell AS %s,
+				avg(%s) OVER cell AS %s,
+				min(%s) OVER cell AS %s,
+				min(%s) OVER cell AS %s,
+				max(%s) OVER cell AS %s,
+				max(%s) OVER cell AS %s,
+				row_number() OVER (PARTITION BY floor(%s / $%d), floor(%s / $%d) ORDER BY %s) AS %s
+			FROM filtered
+			WINDOW cell AS (PARTITION BY floor(%s / $%d), floor(%s / $%d))
+		)
+		SELECT * FROM cells WHERE %s = 1 ORDER BY %s DESC LIMIT %d`,
+		gq.selectList(fmt.Sprintf("ST_AsGeoJSON(%s, 6)", gq.geomExpr)),
+		point, geoClusterXColumn, point, geoClusterYColumn,
+		pq.QuoteIdentifier(gq.rq.TableName), gq.rq.JoinClauses, gq.rq.WhereClause,
+		geoClusterCountColumn,
+		geoClusterTotalColumn,
+		geoClusterXColumn, geoClusterCXColumn,
+		geoClusterYColumn, geoClusterCYColumn,
+		geoClusterXColumn, geoClusterMinXColumn,
+		geoClusterYColumn, geoClusterMinYColumn,
+		geoClusterXColumn, geoClusterMaxXColumn,
+		geoClusterYColumn, geoClusterMaxYColumn,
+		geoClusterXColumn, cellIdx, geoClusterYColumn, cellIdx, rankOrder, geoClusterRankColumn,
+		geoClusterXColumn, cellIdx, geoClusterYColumn, cellIdx,
+		geoClusterRankColumn, geoClusterCountColumn, gq.limit+1,
+	)
+
+	rows, err := gq.rq.Db.Query(query, args...)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	features := make([]map[string]interface{}, 0)
+	var total int64
+	hasMore := false
+	for rows.Next() {
+		if len(features) == gq.limit {
+			hasMore = true
+			break
+		}
+		values, err := scanRowValues(rows, len(columns))
+		if err != nil {
+			return nil, 0, false, err
+		}
+		feature, rowTotal := gq.buildClusterFeature(columns, values, zoom)
+		total = rowTotal
+		features = append(features, feature)
+	}
+	return features, total, hasMore, rows.Err()
+}
+
+// buildClusterFeature muodostaa solun featuren. Yhden rivin solu on rivin oma feature;
+// useamman rivin solu on piste keskipisteessä, ja edustajarivi on representative-kentässä.
+func (gq *geoQuery) buildClusterFeature(columns []string, values []interface{}, zoom int) (map[string]interface{}, int64) {
+	stats := make(map[string]float64, 8)
+	rowColumns := make([]string, 0, len(columns))
+	rowValues := make([]interface{}, 0, len(columns))
+	for i, col := range columns {
+		switch col {
+		case geoClusterXColumn, geoClusterYColumn, geoClusterRankColumn:
+		case geoClusterCountColumn, geoClusterTotalColumn,
+			geoClusterCXColumn, geoClusterCYColumn,
+			geoClusterMinXColumn, geoClusterMinYColumn,
+			geoClusterMaxXColumn, geoClusterMaxYColumn:
+			stats[col] = numericValue(values[i])
+		default:
+			rowColumns = append(rowColumns, col)
+			rowValues = append(rowValues, values[i])
+		}
+	}
+
+	feature := buildGeoFeature(rowColumns, rowValues, gq.source, gq.pkColumns)
+	count := int64(stats[geoClusterCountColumn])
+	total := int64(stats[geoClusterTotalColumn])
+	if count <= 1 {
+		feature["properties"].(map[string]interface{})["cluster"] = false
+		return feature, total
+	}
+
+	representative := map[string]interface{}{
+		"properties": feature["properties"],
+	}
+	if id, ok := feature["id"]; ok {
+		representative["id"] = id
+	}
+	minX, minY := stats[geoClusterMinXColumn], stats[geoClusterMinYColumn]
+	maxX, maxY := stats[geoClusterMaxXColumn], stats[geoClusterMaxYColumn]
+	return map[string]interface{}{
+		"type": "Feature",
+		"geometry": map[string]interface{}{
+			"type":        "Point",
+			"coordinates": []float64{stats[geoClusterCXColumn], stats[geoClusterCYColumn]},
+		},
+		"properties": map[string]interface{}{
+			"cluster":        true,
+			"point_count":    count,
+			"bbox":           []float64{minX, minY, maxX, maxY},
+			"expansion_zoom": geoClusterExpansionZoom(zoom, minX, minY, maxX, maxY),
+			"representative": representative,
+		},
+	}, total
+}
+
+// numericValue muuntaa skannatun numeerisen arvon float64:ksi.
+func numericValue(value interface{}) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case []byte:
+		f, _ := strconv.ParseFloat(string(v), 64)
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
@@ -16,7 +16,10 @@ import (
 	"time"
 
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_grants"
 	"easelect/backend/core_components/general_tables/gt_2_column_crud/gt_2_column_read"
+	"easelect/backend/core_components/general_tables/row_policies"
+	"easelect/backend/core_components/general_tables/row_version"
 	e_sessions "easelect/backend/core_components/sessions"
 
 	"github.com/lib/pq"
@@ -99,7 +102,11 @@ func queryIntelligentResultsStream(w http.ResponseWriter, r *http.Request) error
 		return fmt.Errorf("table or query parameter missing")
 	}
 
-	textHits, err := fetchFullTextRows(backend.Db, tableName, userQuery)
+	profile, err := getRankingProfile(tableName)
+	if err != nil {
+		return err
+	}
+	textHits, err := fetchFullTextRows(backend.Db, tableName, userQuery, profile.TextLimit)
 	if err != nil {
 		return fmt.Errorf("full‑text search failed: %w", err)
 	}
@@ -107,7 +114,8 @@ func queryIntelligentResultsStream(w http.ResponseWriter, r *http.Request) error
 	for _, h := range textHits {
 		order = append(order, h.RowName)
 	}
-	textRows, textCols, err := fetchRowsInOrder(backend.Db, tableName, order)
+	userID, _ := e_sessions.GetUserIDFromSession(r)
+	textRows, textCols, err := fetchRowsInOrder(backend.Db, tableName, order, userID)
 	if err != nil {
 		return fmt.Errorf("fetchRowsInOrder(text): %w", err)
 	}
@@ -186,11 +194,12 @@ func buildAiResult(r *http.Request, tableName, userQuery string, columnDataTypes
  *  - debug-tulostus kytkettävissä paikallisella booleanilla
  *  - näyttää semDist, GPS-km, ts_rank (jos saatavilla),
  *    käyttäjän hakutekstin ja AI-palautteen JSON-raakana
+ *  - järjestys taulun järjestysprofiilista (ranking_profiles.go);
+ *    ?explain=1 palauttaa jokaisen rivin osapisteet
  * =========================================================*/
 func queryIntelligentResults(w http.ResponseWriter, r *http.Request) error {
 	/* 🐞  debug-tulostus päälle/pois tästä */
 	const debugLogging = true
-	const semanticThreshold = 0.70
 
 	//------------------------------------------------
 	// 1. Input ja sessiorooli
@@ -213,11 +222,16 @@ func queryIntelligentResults(w http.ResponseWriter, r *http.Request) error {
 	}
 	currentDb := roleDb[userRole]
 
+	profile, err := getRankingProfile(tableName)
+	if err != nil {
+		return err
+	}
+
 	//------------------------------------------------
 	// 2. OpenAI-kutsu hakupromptille
 	//------------------------------------------------
 	var promptTemplate string
-	err := backend.Db.QueryRow(`
+	err = backend.Db.QueryRow(`
 		SELECT instruction_prompt
 		FROM ai_chatbot_instructions
 		WHERE title = 'ai_searchbar_prompt'
@@ -270,15 +284,7 @@ func queryIntelligentResults(w http.ResponseWriter, r *http.Request) error {
 	//------------------------------------------------
 	// 3. Semanttinen ja tarkka sanahaku
 	//------------------------------------------------
-	type candidate struct {
-		RowName  string
-		SemDist  float64
-		HasSem   bool
-		ExactHit bool
-		Rank     float64
-		GpsKm    float64
-	}
-	candidates := make(map[string]*candidate)
+	candidates := make(map[string]*rankingCandidate)
 
 	/* A) Semanttinen ---------------------------------------------------*/
 	desc := strings.TrimSpace(aiResp.TranslationToShortDescription)
@@ -286,18 +292,18 @@ func queryIntelligentResults(w http.ResponseWriter, r *http.Request) error {
 		desc = userQuery
 	}
 	if desc != "" {
-		vectorVal, vErr := generateVectorParam(desc)
+		vectorVal, vErr := generateVectorParam(tableName, desc)
 		if vErr != nil {
 			fmt.Printf("\033[31mvirhe: %s\033[0m\n", vErr.Error())
 		} else {
-			similar, sErr := fetchSimilarRows(backend.Db, tableName, vectorVal)
+			similar, sErr := fetchSimilarRows(backend.Db, tableName, vectorVal, profile.SemanticLimit)
 			if sErr != nil {
 				fmt.Printf("\033[31mvirhe: %s\033[0m\n", sErr.Error())
 			} else {
 				for _, s := range similar {
 					c := candidates[s.RowName]
 					if c == nil {
-						c = &candidate{RowName: s.RowName}
+						c = &rankingCandidate{RowName: s.RowName}
 						candidates[s.RowName] = c
 					}
 					c.SemDist = s.DistanceScore
@@ -313,14 +319,14 @@ func queryIntelligentResults(w http.ResponseWriter, r *http.Request) error {
 		tsQuery = userQuery
 	}
 	if tsQuery != "" {
-		textHits, tErr := fetchFullTextRows(backend.Db, tableName, tsQuery)
+		textHits, tErr := fetchFullTextRows(backend.Db, tableName, tsQuery, profile.TextLimit)
 		if tErr != nil {
 			fmt.Printf("\033[31mvirhe: %s\033[0m\n", tErr.Error())
 		} else {
 			for _, h := range textHits {
 				c := candidates[h.RowName]
 				if c == nil {
-					c = &candidate{RowName: h.RowName}
+					c = &rankingCandidate{RowName: h.RowName}
 					candidates[h.RowName] = c
 				}
 				c.ExactHit = true
@@ -375,76 +381,81 @@ func queryIntelligentResults(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	//------------------------------------------------
-	// 5. Ryhmittely & järjestys  (ei enää ExactHit-priorisointia)
-	//------------------------------------------------
-	var near, far []*candidate
-	for n, c := range candidates {
-		if !c.HasSem || c.SemDist > semanticThreshold {
-			far = append(far, &candidate{
-				RowName:  n,
-				SemDist:  c.SemDist,
-				HasSem:   c.HasSem,
-				ExactHit: c.ExactHit,
-				Rank:     c.Rank,
-				GpsKm:    c.GpsKm,
-			})
-			continue
+	/* Boost-sarakkeiden arvot -----------------------------------------*/
+	if len(profile.BoostColumns) > 0 {
+		// Boost-arvot luetaan roolin yhteydellä. Sarakkeet, joihin roolilla ei ole
+		// SELECT-oikeutta, jätetään pois, jotteivät niiden arvot näy explain-vastauksessa
+		// eivätkä vaikuta järjestykseen. Profiili on kopio, joten välimuistin karttaa ei muuteta.
+		allowed, aerr := column_grants.SelectableSet(currentDb, tableName)
+		if aerr != nil {
+			fmt.Printf("\033[31mvirhe: %s\033[0m\n", aerr.Error())
+		}
+		visibleBoosts := make(map[string]float64, len(profile.BoostColumns))
+		for col, weight := range profile.BoostColumns {
+			if allowed[col] {
+				visibleBoosts[col] = weight
+			}
 		}
-		c.RowName = n
-		near = append(near, c)
+		profile.BoostColumns = visibleBoosts
 	}
-
-	// Järjestetään molemmat listat GPS-etäisyyden perusteella
-	sortCandidates := func(list []*candidate) {
-		sort.Slice(list, func(i, j int) bool {
-			return list[i].GpsKm < list[j].GpsKm
-		})
+	if len(profile.BoostColumns) > 0 {
+		names := make([]string, 0, len(candidates))
+		for n := range candidates {
+			names = append(names, n)
+		}
+		boostColumns := make([]string, 0, len(profile.BoostColumns))
+		for col := range profile.BoostColumns {
+			boostColumns = append(boostColumns, col)
+		}
+		sort.Strings(boostColumns)
+		boostMap, berr := fetchBoostValues(currentDb, tableName, boostColumns, names)
+		if berr != nil {
+			fmt.Printf("\033[31mvirhe: %s\033[0m\n", berr.Error())
+		}
+		for n, values := range boostMap {
+			if c := candidates[n]; c != nil {
+				c.Boosts = values
+			}
+		}
 	}
-	sortCandidates(near)
-	sortCandidates(far)
+
+	//------------------------------------------------
+	// 5. Järjestys profiilin mukaan
+	//------------------------------------------------
+	ranked := profile.rankCandidates(candidates)
 
 	//------------------------------------------------
 	// 5b. Debug-tulostus (rivin nimi ensimmäisenä)
 	//------------------------------------------------
 	if debugLogging {
-		printList := func(title string, list []*candidate) {
-			if len(list) == 0 {
-				return
+		fmt.Printf("📊 Järjestysprofiili %s (oletus: %t)\n", tableName, profile.IsDefault)
+		for _, ex := range ranked {
+			kmStr := "∞ km"
+			if ex.GpsKm != nil {
+				kmStr = fmt.Sprintf("%.2f km", *ex.GpsKm)
 			}
-			fmt.Println(title)
-			for i, c := range list {
-				kmStr := "∞ km"
-				if c.GpsKm != math.MaxFloat64 {
-					kmStr = fmt.Sprintf("%.2f km", c.GpsKm)
-				}
-				parts := []string{
-					kmStr,
-					fmt.Sprintf("sem %.4f", c.SemDist),
-				}
-				if c.ExactHit {
-					parts = append(parts, fmt.Sprintf("rank %.4f", c.Rank))
-				}
-				// 🔎 ensimmäisenä rivin nimi
-				fmt.Printf("  %d) %s | %s\n",
-					i+1,
-					c.RowName,
-					strings.Join(parts, " | "))
+			parts := []string{
+				ex.Tier,
+				fmt.Sprintf("score %.4f", ex.Score),
+				kmStr,
+			}
+			if ex.SemDist != nil {
+				parts = append(parts, fmt.Sprintf("sem %.4f", *ex.SemDist))
 			}
+			if ex.TextRank != nil {
+				parts = append(parts, fmt.Sprintf("rank %.4f", *ex.TextRank))
+			}
+			// 🔎 ensimmäisenä rivin nimi
+			fmt.Printf("  %d) %s | %s\n", ex.Position, ex.RowName, strings.Join(parts, " | "))
 		}
-		printList("📍 Lähellä (≤0.70):", near)
-		printList("🚩 Kauempana (>0.70):", far)
 	}
 
 	//------------------------------------------------
 	// 6. Rivit kannasta oikeaan järjestykseen
 	//------------------------------------------------
-	rowOrder := make([]string, 0, len(near)+len(far))
-	for _, c := range near {
-		rowOrder = append(rowOrder, c.RowName)
-	}
-	for _, c := range far {
-		rowOrder = append(rowOrder, c.RowName)
+	rowOrder := make([]string, 0, len(ranked))
+	for _, ex := range ranked {
+		rowOrder = append(rowOrder, ex.RowName)
 	}
 
 	_, _ = gt_2_column_read.GetColumnsMapForTable(tableName)
@@ -454,7 +465,8 @@ func queryIntelligentResults(w http.ResponseWriter, r *http.Request) error {
 		return fmt.Errorf("getColumnDataTypesWithFK: %w", err)
 	}
 
-	rowsJSON, resultColumns, err := fetchRowsInOrder(currentDb, tableName, rowOrder)
+	userID, _ := e_sessions.GetUserIDFromSession(r)
+	rowsJSON, resultColumns, err := fetchRowsInOrder(currentDb, tableName, rowOrder, userID)
 	if err != nil {
 		return fmt.Errorf("fetchRowsInOrder: %w", err)
 	}
@@ -479,6 +491,23 @@ func queryIntelligentResults(w http.ResponseWriter, r *http.Request) error {
 		"types":          columnDataTypes,
 		"resultsPerLoad": resultsPerLoad,
 	}
+	if r.URL.Query().Get("explain") == "1" {
+		// Näkymättömiksi rajattuja rivejä ei selitetä
+		visible := make(map[string]bool, len(rowsJSON))
+		for _, row := range rowsJSON {
+			if header, ok := row["header"].(string); ok {
+				visible[header] = true
+			}
+		}
+		explanations := make([]rankingExplanation, 0, len(ranked))
+		for _, ex := range ranked {
+			if visible[ex.RowName] {
+				explanations = append(explanations, ex)
+			}
+		}
+		respJSON["ranking"] = explanations
+		respJSON["ranking_profile"] = profile
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(respJSON)
 }
@@ -547,24 +576,31 @@ func writeEmptyResultJSON(w http.ResponseWriter) error {
  *  Rivien haku annetussa järjestyksessä
  * =========================================================*/
 
-func fetchRowsInOrder(db *sql.DB, table string, headers []string) ([]map[string]interface{}, []string, error) {
+func fetchRowsInOrder(db *sql.DB, table string, headers []string, userID int) ([]map[string]interface{}, []string, error) {
 	if len(headers) == 0 {
 		return nil, nil, nil
 	}
+	// Rivitason säännöt rajaavat myös älykkään haun tulokset
+	where, args, err := row_policies.AppendCondition("", []interface{}{pq.Array(headers)}, userID, table)
+	if err != nil {
+		return nil, nil, err
+	}
 	query := fmt.Sprintf(`
 		WITH wanted AS (
 			SELECT unnest($1::text[]) AS header,
 			       generate_series(1, array_length($1::text[],1)) AS pos
 		)
-		SELECT %s.* 
+		SELECT %s.*%s
 		FROM wanted
-		JOIN %s ON %s.header = wanted.header
+		JOIN %s ON %s.header = wanted.header%s
 		ORDER BY wanted.pos`,
 		pq.QuoteIdentifier(table),
+		row_version.SelectColumn(pq.QuoteIdentifier(table)),
 		pq.QuoteIdentifier(table),
 		pq.QuoteIdentifier(table),
+		where,
 	)
-	rows, err := db.Query(query, pq.Array(headers))
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -598,7 +634,8 @@ func fetchRowsInOrder(db *sql.DB, table string, headers []string) ([]map[string]
 		}
 		data = append(data, rowObj)
 	}
-	return data, cols, rows.Err()
+	// Versio jää rivien dataan solumuokkausta varten, mutta ei näy sarakkeena
+	return data, row_version.StripColumn(cols), rows.Err()
 }
 
 /* ===========================================================
@@ -619,11 +656,9 @@ func buildOrPrefixTsQuery(input string) string {
 	return strings.Join(words, " | ")
 }
 
-// fetchFullTextRows hakee 10 parasta täyden tekstin osumaa
+// fetchFullTextRows hakee limitResults parasta täyden tekstin osumaa
 // käyttäen SIMPLE-konfiguraatiota ja search_vector_simple-saraketta.
-func fetchFullTextRows(db *sql.DB, mainTable, searchString string) ([]rowTextRank, error) {
-	const limitResults = 10
-
+func fetchFullTextRows(db *sql.DB, mainTable, searchString string, limitResults int) ([]rowTextRank, error) {
 	tsQuery := buildOrPrefixTsQuery(searchString)
 	if tsQuery == "" {
 		return nil, nil
@@ -680,9 +715,8 @@ func parseLatLonPair(gps string) (lat, lon float64, err error) {
 	return lat, lon, nil
 }
 
-// fetchSimilarRows hakee 10 merkitykseltään lähintä palvelua.
-func fetchSimilarRows(db *sql.DB, mainTable string, queryVector pgvector.Vector) ([]rowSemanticScore, error) {
-	const limitResults = 10
+// fetchSimilarRows hakee limitResults merkitykseltään lähintä riviä.
+func fetchSimilarRows(db *sql.DB, mainTable string, queryVector pgvector.Vector, limitResults int) ([]rowSemanticScore, error) {
 	query := fmt.Sprintf(`
 		SELECT %s.header,
 		       %s.openai_embedding <-> $1 AS distance_score
@@ -8,16 +8,16 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/lib/pq"
 
-	backend "easelect/backend/core_components"
-	"easelect/backend/core_components/general_tables/gt_2_column_crud/gt_2_column_read"
 	"easelect/backend/core_components/general_tables/models"
-	e_sessions "easelect/backend/core_components/sessions"
+	"easelect/backend/core_components/general_tables/row_version"
+	"easelect/backend/core_components/metadata_cache"
 )
 
 // GetResultsHandlerWrapper ...
@@ -37,42 +37,32 @@ func GetResults(response_writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	// 1. Hae user_id sessiosta
-	userID, err := e_sessions.GetUserIDFromSession(request)
-	if err != nil || userID <= 0 {
-		http.Error(response_writer, "Unauthorized: tarvitset kirjautumisen", http.StatusUnauthorized)
+	// 1. Rakennetaan putken osat: sessio, rooli, sarakeoikeudet, JOINit ja WHERE
+	rq, ok := prepareResultsQuery(response_writer, request, table_name, request.URL.Query(), resultsQueryOptions{})
+	if !ok {
 		return
 	}
-
-	// 1b. Hae myös user_role sessiosta, valitse oikea DB.
-	session, sessErr := e_sessions.GetStore().Get(request, "session")
-	if sessErr != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", sessErr.Error())
-		http.Error(response_writer, "virhe session haussa", http.StatusInternalServerError)
-		return
-	}
-
-	userRole, _ := session.Values["user_role"].(string)
-	if userRole == "" {
-		userRole = "guest"
-	}
-
-	// Esimerkkikartta roolin ja DB-yhteyden valintaan
-	roleDbMapping := map[string]*sql.DB{
-		"admin": backend.DbAdmin,
-		"basic": backend.DbBasic,
-		"guest": backend.DbGuest,
-	}
-	currentDb, found := roleDbMapping[userRole]
-	if !found {
-		currentDb = roleDbMapping["guest"]
-	}
+	currentDb := rq.Db
+	userColumnSettings := rq.UserColumnSettings
+	allowedColumnsMap := rq.AllowedColumns
+	columnsMap := rq.ColumnsMap
+	// Rivin versio (xmin) palautetaan muokkauksen samanaikaisuustarkistusta varten
+	selectColumns := rq.SelectColumns + row_version.SelectColumn(pq.QuoteIdentifier(table_name))
+	joinClauses := rq.JoinClauses
+	columnExpressions := rq.ColumnExpressions
 
 	// 2. Haetaan results_per_load ...
-	var results_per_load_str string
-	err = currentDb.QueryRow(
-		"SELECT int_value FROM system_config WHERE key = 'results_load_amount'",
-	).Scan(&results_per_load_str)
+	cachedLoadAmount, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindConfig, "results_load_amount"),
+		func() (interface{}, error) {
+			var value string
+			err := currentDb.QueryRow(
+				"SELECT int_value FROM system_config WHERE key = 'results_load_amount'",
+			).Scan(&value)
+			return value, err
+		},
+	)
+	results_per_load_str, _ := cachedLoadAmount.(string)
 	if err != nil {
 		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
 		http.Error(response_writer, "virhe konfiguraatiota haettaessa", http.StatusInternalServerError)
@@ -96,27 +86,7 @@ func GetResults(response_writer http.ResponseWriter, request *http.Request) {
 		}
 	}
 
-	// 3. Haetaan käyttäjän sarakeasetukset
-	userColumnSettings, err := ensureAndFetchUserColumnSettings(userID, table_name, currentDb)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "virhe sarakeasetuksissa", http.StatusInternalServerError)
-		return
-	}
-
-	// 3b. Haetaan sarakkeet, joihin roolilla on SELECT-oikeus
-	allowedColumns, err := fetchUserSelectableColumns(currentDb, table_name)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "virhe sarakeoikeuksien haussa", http.StatusInternalServerError)
-		return
-	}
-	allowedColumnsMap := make(map[string]bool, len(allowedColumns))
-	for _, ac := range allowedColumns {
-		allowedColumnsMap[ac] = true
-	}
-
-	// 4. Haetaan muun datan osalta saraketietoja
+	// 3. Haetaan muun datan osalta saraketietoja
 	column_data_types, err := getColumnDataTypesWithFK(table_name, currentDb)
 	if err != nil {
 		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
@@ -124,86 +94,79 @@ func GetResults(response_writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	columnsMap, err := gt_2_column_read.GetColumnsMapForTable(table_name)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "virhe sarakkeiden tietojen haussa", http.StatusInternalServerError)
-		return
-	}
+	where_clause := rq.WhereClause
+	query_args := rq.Args
 
-	visibleColUids := make([]int, 0)
-	for _, cs := range userColumnSettings {
-		if cs.IsHidden {
-			continue
-		}
-		if !allowedColumnsMap[cs.ColumnName] {
-			continue
-		}
-		for uid, colInfo := range columnsMap {
-			if colInfo.ColumnName == cs.ColumnName {
-				visibleColUids = append(visibleColUids, uid)
-				break
-			}
+	// 5a. Keyset-sivutus: kun kursori (ensimmäisellä sivulla tyhjä cursor=) tai lajittelu
+	// on pyydetty, lajittelu täydennetään pääavaimella, jos taulussa on yksisarakkeinen
+	// pääavain, johon roolilla on SELECT-oikeus. Muuten järjestys on ennallaan.
+	keysetRequested := request.URL.Query().Has("cursor") || request.URL.Query().Get("sort_column") != ""
+	keysetEnabled := false
+	pkColumn := ""
+	if keysetRequested {
+		pkColumn, err = getSinglePrimaryKeyColumn(table_name)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(response_writer, "virhe pääavaimen haussa", http.StatusInternalServerError)
+			return
 		}
+		keysetEnabled = pkColumn != "" && allowedColumnsMap[pkColumn]
 	}
 
-	// 5. Rakennetaan SELECT- ja JOIN-osat
-	selectColumns, joinClauses, columnExpressions, err := buildJoinsWith1MRelations(
-		currentDb,
-		table_name,
-		columnsMap,
-		visibleColUids,
-	)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "virhe JOIN-liitosten rakentamisessa", http.StatusInternalServerError)
+	cursor_str := request.URL.Query().Get("cursor")
+	if cursor_str != "" && !keysetEnabled {
+		http.Error(response_writer, "kursorisivutus ei ole käytettävissä tälle taululle", http.StatusBadRequest)
 		return
 	}
 
-	// Rakennetaan WHERE- ja ORDER BY -ehdot
-	where_clause, query_args, err := buildWhereClause(
-		request.URL.Query(),
-		table_name,
-		buildColumnsByName(columnsMap),
-		columnExpressions,
-	)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "virhe WHERE-ehdon rakentamisessa", http.StatusInternalServerError)
-		return
-	}
-
-	order_by_clause, err := buildOrderByClause(
-		request.URL.Query(),
-		table_name,
-		buildColumnsByName(columnsMap),
-		columnExpressions,
-	)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "virhe ORDER BY -ehdon rakentamisessa", http.StatusBadRequest)
-		return
+	var order_by_clause string
+	var keyset keysetOrder
+	if keysetEnabled {
+		keyset, err = buildKeysetOrder(request.URL.Query(), table_name, pkColumn, columnExpressions)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(response_writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		order_by_clause = keyset.orderByClause()
+		selectColumns += keyset.hiddenSelectColumns()
+	} else {
+		order_by_clause, err = buildOrderByClause(
+			request.URL.Query(),
+			table_name,
+			buildColumnsByName(columnsMap),
+			columnExpressions,
+		)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(response_writer, "virhe ORDER BY -ehdon rakentamisessa", http.StatusBadRequest)
+			return
+		}
 	}
 
-	// 5b. Haetaan must_be_true -sarakkeet ja lisätään ne suodattimeen, jos ei admin
-	mustTrueCols, err := getMustBeTrueColumns(currentDb, table_name)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "virhe must_be_true -sarakehaussa", http.StatusInternalServerError)
-		return
-	}
-	if userRole != "admin" {
-		for _, c := range mustTrueCols {
-			extraCond := fmt.Sprintf("%s.%s = TRUE", pq.QuoteIdentifier(table_name), pq.QuoteIdentifier(c))
-			if where_clause == "" {
-				where_clause = " WHERE " + extraCond
-			} else {
-				where_clause += " AND " + extraCond
-			}
+	// 5c. Kursoriehto korvaa offsetin
+	if cursor_str != "" {
+		cursor, err := decodeResultsCursor(cursor_str)
+		if err != nil {
+			http.Error(response_writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cursorCond, cursorArgs, _, err := keyset.buildCondition(cursor, len(query_args)+1)
+		if err != nil {
+			http.Error(response_writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if where_clause == "" {
+			where_clause = " WHERE " + cursorCond
+		} else {
+			where_clause += " AND " + cursorCond
 		}
+		query_args = append(query_args, cursorArgs...)
+		offset_value = 0
 	}
 
-	// 6. Kootaan lopullinen SQL-kysely
+	// 6. Kootaan lopullinen SQL-kysely. Haetaan yksi ylimääräinen rivi,
+	// jotta tiedetään, onko seuraavaa sivua olemassa.
 	query := fmt.Sprintf(
 		"SELECT %s FROM %s %s%s%s LIMIT %d OFFSET %d",
 		selectColumns,
@@ -211,7 +174,7 @@ func GetResults(response_writer http.ResponseWriter, request *http.Request) {
 		joinClauses,
 		where_clause,
 		order_by_clause,
-		results_per_load,
+		results_per_load+1,
 		offset_value,
 	)
 
@@ -231,6 +194,7 @@ func GetResults(response_writer http.ResponseWriter, request *http.Request) {
 	}
 
 	var query_results []map[string]interface{}
+	var next_cursor interface{}
 	for rows_result.Next() {
 		row_values := make([]interface{}, len(result_columns))
 		row_pointers := make([]interface{}, len(result_columns))
@@ -243,9 +207,26 @@ func GetResults(response_writer http.ResponseWriter, request *http.Request) {
 			return
 		}
 
+		if len(query_results) == results_per_load {
+			// Ylimääräinen rivi → seuraava sivu on olemassa
+			if keysetEnabled && len(query_results) > 0 {
+				next_cursor, err = buildNextCursor(keyset, query_results[len(query_results)-1])
+				if err != nil {
+					log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+					http.Error(response_writer, "virhe kursorin muodostamisessa", http.StatusInternalServerError)
+					return
+				}
+			}
+			break
+		}
+
 		current_row_result := make(map[string]interface{})
 		for i, column_name := range result_columns {
 			val := row_values[i]
+			if column_name == cursorSortAlias || column_name == cursorPkAlias {
+				current_row_result[column_name] = cursorValueToString(val)
+				continue
+			}
 			switch typed_val := val.(type) {
 			case time.Time:
 				current_row_result[column_name] = typed_val.Format("2006-01-02 15:04:05")
@@ -273,9 +254,20 @@ func GetResults(response_writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// Piilotetut kursorisarakkeet pois vastauksesta
+	if keysetEnabled {
+		result_columns = result_columns[:len(result_columns)-2]
+		for _, row := range query_results {
+			delete(row, cursorSortAlias)
+			delete(row, cursorPkAlias)
+		}
+	}
+	result_columns = row_version.StripColumn(result_columns)
+
 	// Kootaan vastaus
 	response_data := map[string]interface{}{
 		"columns":            result_columns,
+		"next_cursor":        next_cursor,
 		"data":               query_results,
 		"types":              column_data_types,
 		"resultsPerLoad":     results_per_load,
@@ -290,38 +282,6 @@ func GetResults(response_writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
-// fetchUserSelectableColumns hakee sarakkeet, joihin CURRENT_USER:lla on SELECT-oikeus.
-func fetchUserSelectableColumns(db *sql.DB, tableName string) ([]string, error) {
-	query := `
-		SELECT column_name
-		FROM information_schema.column_privileges
-		WHERE table_name = $1
-		  AND privilege_type = 'SELECT'
-		  AND grantee = current_user
-	`
-	rows, err := db.Query(query, tableName)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		return nil, err
-	}
-	defer rows.Close()
-
-	var columns []string
-	for rows.Next() {
-		var colName string
-		if err := rows.Scan(&colName); err != nil {
-			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-			continue
-		}
-		columns = append(columns, colName)
-	}
-	if err := rows.Err(); err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		return nil, err
-	}
-	return columns, nil
-}
-
 // ensureAndFetchUserColumnSettings hakee käyttäjän sarakeasetukset
 func ensureAndFetchUserColumnSettings(userID int, tableName string, db *sql.DB) ([]UserColumnSetting, error) {
 	queryUserSettings := `
@@ -522,8 +482,53 @@ func buildWhereClause(
 	argIdx := 1
 
 	for param, values := range queryParams {
+		// -------- 0) JSON-suodatinpuu (?filter=...) ----------------------
+		if param == "filter" {
+			if len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+				continue
+			}
+			cond, condArgs, nextIdx, err := buildStructuredFilter(values[0], tableName, columnsByName, columnExpressions, argIdx)
+			if err != nil {
+				return "", nil, err
+			}
+			if cond != "" {
+				whereClauses = append(whereClauses, cond)
+				args = append(args, condArgs...)
+				argIdx = nextIdx
+			}
+			continue
+		}
+
+		// -------- 0b) Globaali haku (?search=...) kaikista näkyvistä sarakkeista
+		if param == "search" {
+			if len(values) == 0 {
+				continue
+			}
+			searchAST, err := parseSearchExpression(values[0])
+			if err != nil {
+				return "", nil, err
+			}
+			if searchAST == nil {
+				continue
+			}
+			fb := &filterBuilder{
+				tableName:         tableName,
+				columnsByName:     columnsByName,
+				columnExpressions: columnExpressions,
+				argIdx:            argIdx,
+			}
+			cond, err := buildSearchCondition(searchAST, globalSearchTargets(fb), fb)
+			if err != nil {
+				return "", nil, err
+			}
+			whereClauses = append(whereClauses, cond)
+			args = append(args, fb.args...)
+			argIdx = fb.argIdx
+			continue
+		}
+
 		// ohitetaan metaparametrit
-		if param == "list" || param == "sort_column" || param == "sort_order" || param == "offset" {
+		if resultsMetaParams[param] {
 			continue
 		}
 		if len(values) == 0 {
@@ -580,17 +585,31 @@ func buildWhereClause(
 			continue
 		}
 
-		tokens := parseAdvancedSearch(rawValue)
-		if len(tokens) == 0 {
+		searchAST, err := parseSearchExpression(rawValue)
+		if err != nil {
+			return "", nil, err
+		}
+		if searchAST == nil {
 			continue
 		}
 
-		cond, condArgs, nextIdx := buildConditionForTokens(targetColumn, tokens, argIdx)
-		if cond != "" {
-			whereClauses = append(whereClauses, cond)
-			args = append(args, condArgs...)
-			argIdx = nextIdx
+		fb := &filterBuilder{
+			tableName:         tableName,
+			columnsByName:     columnsByName,
+			columnExpressions: columnExpressions,
+			argIdx:            argIdx,
 		}
+		kind := filterKindText
+		if colInfo, ok := columnsByName[plainParamName]; ok {
+			kind = filterKindForDataType(colInfo.DataType)
+		}
+		cond, err := buildSearchCondition(searchAST, []searchTarget{{Expr: targetColumn, Kind: kind}}, fb)
+		if err != nil {
+			return "", nil, err
+		}
+		whereClauses = append(whereClauses, cond)
+		args = append(args, fb.args...)
+		argIdx = fb.argIdx
 	}
 
 	finalWhere := ""
@@ -600,6 +619,29 @@ func buildWhereClause(
 	return finalWhere, args, nil
 }
 
+// globalSearchTargets palauttaa globaalin haun kohteet: kaikki näkyvät sarakkeet
+// aakkosjärjestyksessä (embedding-saraketta lukuun ottamatta).
+func globalSearchTargets(fb *filterBuilder) []searchTarget {
+	names := make([]string, 0, len(fb.columnExpressions))
+	for name := range fb.columnExpressions {
+		if name == "openai_embedding" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	targets := make([]searchTarget, 0, len(names))
+	for _, name := range names {
+		expr, kind, err := fb.resolveColumn(name)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, searchTarget{Expr: expr, Kind: kind})
+	}
+	return targets
+}
+
 // buildOrderByClause hakee sort_column ja sort_order -parametrit.
 func buildOrderByClause(
 	queryParams url.Values,
@@ -631,58 +673,97 @@ func buildOrderByClause(
 	return orderByClause, nil
 }
 
-// buildConditionForTokens rakentaa ehdon annetuista hakutokeneista (ILIKE, NOT ILIKE, jne.)
-func buildConditionForTokens(
-	columnName string,
-	tokens []Token,
-	argIndex int,
-) (string, []interface{}, int) {
+// searchTarget on hakuehdon kohde: SQL-lauseke ja sen tyyppiluokka.
+type searchTarget struct {
+	Expr string
+	Kind string
+}
 
-	var exprParts []string
-	var args []interface{}
-	currentOp := "AND"
-
-	for _, t := range tokens {
-		switch t.Type {
-		case TokenAnd:
-			currentOp = "AND"
-		case TokenOr:
-			currentOp = "OR"
-		case TokenAll:
-			exprParts = append(exprParts, currentOp, "TRUE")
-		case TokenExclude:
-			if t.Value == "" {
-				expr := fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", columnName, columnName)
-				exprParts = append(exprParts, currentOp, expr)
-			} else {
-				v := strings.ReplaceAll(t.Value, "*", "%")
-				expr := fmt.Sprintf("%s::text NOT ILIKE $%d", columnName, argIndex)
-				exprParts = append(exprParts, currentOp, expr)
-				args = append(args, "%"+v+"%")
-				argIndex++
-			}
-		case TokenInclude:
-			if t.Value == "" {
-				expr := fmt.Sprintf("(%s IS NULL OR %s = '')", columnName, columnName)
-				exprParts = append(exprParts, currentOp, expr)
-			} else {
-				v := strings.ReplaceAll(t.Value, "*", "%")
-				expr := fmt.Sprintf("%s::text ILIKE $%d", columnName, argIndex)
-				exprParts = append(exprParts, currentOp, expr)
-				args = append(args, "%"+v+"%")
-				argIndex++
+// buildSearchCondition muuntaa hakulausekkeen AST:n SQL-ehdoksi. Ilman saraketta
+// annetut termit kohdistuvat defaultTargets-sarakkeisiin (useampi = mikä tahansa osuu).
+func buildSearchCondition(node *searchNode, defaultTargets []searchTarget, fb *filterBuilder) (string, error) {
+	switch node.Kind {
+	case searchNodeAll:
+		return "TRUE", nil
+
+	case searchNodeAnd, searchNodeOr:
+		joiner := " AND "
+		if node.Kind == searchNodeOr {
+			joiner = " OR "
+		}
+		parts := make([]string, 0, len(node.Children))
+		for _, child := range node.Children {
+			part, err := buildSearchCondition(child, defaultTargets, fb)
+			if err != nil {
+				return "", err
 			}
+			parts = append(parts, part)
+		}
+		return "(" + strings.Join(parts, joiner) + ")", nil
+
+	case searchNodeNot:
+		inner, err := buildSearchCondition(node.Children[0], defaultTargets, fb)
+		if err != nil {
+			return "", err
+		}
+		return "(NOT " + inner + ")", nil
+	}
+
+	// Term-solmu
+	targets := defaultTargets
+	operator := node.Operator
+	value := node.Value
+	if node.Field != "" {
+		expr, kind, err := fb.resolveColumn(node.Field)
+		if err == nil {
+			targets = []searchTarget{{Expr: expr, Kind: kind}}
+		} else if node.FieldQuoted {
+			return "", err
+		} else {
+			// "http://..." tms. – kenttä ei ollut sarake, haetaan koko sanaa
+			operator = ""
+			value = node.Raw
 		}
 	}
+	if len(targets) == 0 {
+		return "FALSE", nil
+	}
+	if operator != "" && operator != "!=" && len(targets) > 1 {
+		return "", fmt.Errorf("vertailu '%s%s' vaatii sarakkeen, esim. sarake:%s%s", operator, value, operator, value)
+	}
 
-	if len(exprParts) == 0 {
-		return "", nil, argIndex
+	parts := make([]string, 0, len(targets))
+	for _, t := range targets {
+		parts = append(parts, buildSearchTermCondition(t, operator, value, fb))
 	}
-	if exprParts[0] == "AND" || exprParts[0] == "OR" {
-		exprParts = exprParts[1:]
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return "(" + strings.Join(parts, " OR ") + ")", nil
+}
+
+// buildSearchTermCondition rakentaa yksittäisen termin ehdon yhdelle kohteelle.
+// Ilman operaattoria haetaan ILIKE-osumaa (* = jokerimerkki), tyhjä arvo = tyhjä sarake.
+func buildSearchTermCondition(t searchTarget, operator, value string, fb *filterBuilder) string {
+	switch operator {
+	case "":
+		if value == "" {
+			return fmt.Sprintf("(%s IS NULL OR %s::text = '')", t.Expr, t.Expr)
+		}
+		pattern := "%" + strings.ReplaceAll(value, "*", "%") + "%"
+		return fmt.Sprintf("%s::text ILIKE %s", t.Expr, fb.nextArg(pattern))
+	case "!=":
+		if t.Kind == filterKindText {
+			pattern := "%" + strings.ReplaceAll(value, "*", "%") + "%"
+			return fmt.Sprintf("%s::text NOT ILIKE %s", t.Expr, fb.nextArg(pattern))
+		}
+		return fmt.Sprintf("%s <> %s", t.Expr, fb.nextArg(value))
+	default:
+		if t.Kind == filterKindText {
+			return fmt.Sprintf("%s::text %s %s", t.Expr, operator, fb.nextArg(value))
+		}
+		return fmt.Sprintf("%s %s %s", t.Expr, operator, fb.nextArg(value))
 	}
-	finalExpr := "(" + strings.Join(exprParts, " ") + ")"
-	return finalExpr, args, argIndex
 }
 
 // getMustBeTrueColumns hakee sarakkeet, joilla must_be_true = true.
@@ -0,0 +1,323 @@
+// file: get_row.go
+package gt_1_row_read
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/general_tables/row_version"
+	"easelect/backend/core_components/middlewares"
+)
+
+// getRowRelatedLimit rajaa yhdestä lapsi- tai M2M-taulusta palautettavat rivit.
+const getRowRelatedLimit = 100
+
+// getResultsFunctionName on funktio, jonka taulukohtainen oikeus vaaditaan, jotta
+// lapsi- tai M2M-taulun rivit näytetään.
+const getResultsFunctionName = "gt_1_row_read.GetResultsHandlerWrapper"
+
+// relatedRows on yhden lapsi- tai M2M-taulun rivit get-row-vastauksessa.
+type relatedRows struct {
+	Name             string                   `json:"name,omitempty"`
+	Table            string                   `json:"table"`
+	Column           string                   `json:"column,omitempty"`
+	ReferencedColumn string                   `json:"referenced_column,omitempty"`
+	BridgingTable    string                   `json:"bridging_table,omitempty"`
+	Columns          []string                 `json:"columns"`
+	Rows             []map[string]interface{} `json:"rows"`
+	HasMore          bool                     `json:"has_more"`
+}
+
+// referencingForeignKey on vierasavain, jolla lapsitaulu viittaa tauluun.
+type referencingForeignKey struct {
+	ChildTable       string
+	ChildColumn      string
+	ReferencedColumn string
+}
+
+// GetRowHandler palauttaa yhden rivin yhteyksineen.
+//
+//	GET /api/get-row?table=customers&id=12
+//
+// Vastauksessa on rivi 1-M-näyttöarvoineen, M2M- ja lookup-sarakkeineen (kuten
+// GetResultsissa), lapsitaulujen rivit sekä M2M-suhteiden kautta liitetyt rivit.
+// Jokaisella tasolla pätevät roolin sarakeoikeudet, must_be_true ja rivitason säännöt;
+// lapsi- ja M2M-tauluista näytetään vain ne, joihin käyttäjällä on GetResults-oikeus.
+// version (myös rivin _row_version) annetaan takaisin rivin päivityksessä.
+func GetRowHandler(w http.ResponseWriter, r *http.Request) {
+	tableName := r.URL.Query().Get("table")
+	rowID := r.URL.Query().Get("id")
+	if tableName == "" || rowID == "" {
+		http.Error(w, "table- tai id-parametri puuttuu", http.StatusBadRequest)
+		return
+	}
+
+	userID, userRole, currentDb, ok := getSessionUserRoleAndDb(w, r)
+	if !ok {
+		return
+	}
+
+	// id on pääavaimen arvo tai yhdistelmäavaimella JSON-olio
+	key, err := row_key.ParseString(tableName, rowID)
+	if errors.Is(err, row_key.ErrInvalidKey) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe pääavaimen haussa", http.StatusInternalServerError)
+		return
+	}
+
+	// 1) Päärivi GetResults-putken kautta
+	rq, rqErr := buildResultsQuery(userID, userRole, currentDb, tableName, url.Values{}, resultsQueryOptions{AllAllowedColumns: true})
+	if rqErr != nil {
+		http.Error(w, rqErr.Message, rqErr.Status)
+		return
+	}
+	keyCond, keyArgs := key.Condition(pq.QuoteIdentifier(tableName), rq.nextArgIdx())
+	rq.addCondition(keyCond, keyArgs...)
+	rq.SelectColumns += row_version.SelectColumn(pq.QuoteIdentifier(tableName))
+
+	columns, rows, _, err := rq.queryRows(1)
+	if err != nil {
+		if pqErr, isPq := err.(*pq.Error); isPq && pqErr.Code == "22P02" {
+			http.Error(w, "virheellinen id", http.StatusBadRequest)
+			return
+		}
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe rivin haussa", http.StatusInternalServerError)
+		return
+	}
+	if len(rows) == 0 {
+		http.Error(w, "Row not found", http.StatusNotFound)
+		return
+	}
+	columns = row_version.StripColumn(columns)
+
+	// Pääriviin viittaava alikysely: lapsi- ja M2M-ehdot sidotaan rivin todellisiin arvoihin
+	parentValue := func(column string, argIdx int) (string, []interface{}) {
+		safe := pq.QuoteIdentifier(tableName)
+		cond, args := key.Condition(safe, argIdx)
+		return fmt.Sprintf("(SELECT %s.%s FROM %s WHERE %s)",
+			safe, pq.QuoteIdentifier(column), safe, cond), args
+	}
+
+	// 2) M2M-suhteiden kautta liitetyt rivit
+	m2mColumns, err := fetchManyToManyVirtualColumns(currentDb, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe M2M-suhteiden haussa", http.StatusInternalServerError)
+		return
+	}
+	bridgingTables := make(map[string]bool)
+	m2mResults := make([]relatedRows, 0)
+	for _, vc := range m2mColumns {
+		bridgingTables[vc.BridgingTable] = true
+		if !rq.AllowedColumns[vc.OwnColumn] {
+			continue
+		}
+		if !middlewares.UserHasFunctionPermission(userID, getResultsFunctionName, vc.OtherTable) {
+			continue
+		}
+		related, relErr := buildResultsQuery(userID, userRole, currentDb, vc.OtherTable, url.Values{}, resultsQueryOptions{AllAllowedColumns: true})
+		if relErr != nil {
+			log.Printf("\033[31mvirhe: M2M-taulu %s: %s\033[0m\n", vc.OtherTable, relErr.Message)
+			continue
+		}
+		parentSub, parentArgs := parentValue(vc.OwnColumn, related.nextArgIdx())
+		related.addCondition(fmt.Sprintf(
+			"%s.%s IN (SELECT m2m_b.%s FROM %s AS m2m_b WHERE m2m_b.%s = %s)",
+			pq.QuoteIdentifier(vc.OtherTable),
+			pq.QuoteIdentifier(vc.OtherColumn),
+			pq.QuoteIdentifier(vc.BridgeOtherCol),
+			pq.QuoteIdentifier(vc.BridgingTable),
+			pq.QuoteIdentifier(vc.BridgeOwnCol),
+			parentSub,
+		), parentArgs...)
+		relColumns, relRows, hasMore, err := related.queryRows(getRowRelatedLimit)
+		if err != nil {
+			log.Printf("\033[31mvirhe: M2M-rivit taulusta %s: %s\033[0m\n", vc.OtherTable, err.Error())
+			continue
+		}
+		m2mResults = append(m2mResults, relatedRows{
+			Name:          vc.Name,
+			Table:         vc.OtherTable,
+			BridgingTable: vc.BridgingTable,
+			Columns:       relColumns,
+			Rows:          relRows,
+			HasMore:       hasMore,
+		})
+	}
+
+	// 3) Lapsitaulut (viittaavat vierasavaimet), siltataulut pois
+	referencing, err := fetchReferencingForeignKeys(tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe lapsitaulujen haussa", http.StatusInternalServerError)
+		return
+	}
+	children := make([]relatedRows, 0)
+	for _, fk := range referencing {
+		if bridgingTables[fk.ChildTable] {
+			continue
+		}
+		if !rq.AllowedColumns[fk.ReferencedColumn] {
+			continue
+		}
+		if !middlewares.UserHasFunctionPermission(userID, getResultsFunctionName, fk.ChildTable) {
+			continue
+		}
+		child, childErr := buildResultsQuery(userID, userRole, currentDb, fk.ChildTable, url.Values{}, resultsQueryOptions{AllAllowedColumns: true})
+		if childErr != nil {
+			log.Printf("\033[31mvirhe: lapsitaulu %s: %s\033[0m\n", fk.ChildTable, childErr.Message)
+			continue
+		}
+		if !child.AllowedColumns[fk.ChildColumn] {
+			continue
+		}
+		parentSub, parentArgs := parentValue(fk.ReferencedColumn, child.nextArgIdx())
+		child.addCondition(fmt.Sprintf("%s.%s = %s",
+			pq.QuoteIdentifier(fk.ChildTable),
+			pq.QuoteIdentifier(fk.ChildColumn),
+			parentSub,
+		), parentArgs...)
+		childColumns, childRows, hasMore, err := child.queryRows(getRowRelatedLimit)
+		if err != nil {
+			log.Printf("\033[31mvirhe: lapsirivit taulusta %s: %s\033[0m\n", fk.ChildTable, err.Error())
+			continue
+		}
+		children = append(children, relatedRows{
+			Table:            fk.ChildTable,
+			Column:           fk.ChildColumn,
+			ReferencedColumn: fk.ReferencedColumn,
+			Columns:          childColumns,
+			Rows:             childRows,
+			HasMore:          hasMore,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"table":    tableName,
+		"id":       key.String(),
+		"version":  rows[0][row_version.Column],
+		"columns":  columns,
+		"row":      rows[0],
+		"children": children,
+		"m2m":      m2mResults,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// queryRows suorittaa putken kyselyn pääavaimen järjestyksessä ja palauttaa enintään
+// limit riviä sekä tiedon, jäikö rivejä yli.
+func (rq *resultsQuery) queryRows(limit int) ([]string, []map[string]interface{}, bool, error) {
+	orderBy := ""
+	pkColumns, err := row_key.PrimaryKeyColumns(rq.TableName)
+	if err != nil && !errors.Is(err, row_key.ErrNoPrimaryKey) {
+		return nil, nil, false, err
+	}
+	if len(pkColumns) > 0 {
+		orderBy = " ORDER BY " + row_key.ColumnList(pq.QuoteIdentifier(rq.TableName), pkColumns)
+	}
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s %s%s%s LIMIT %d",
+		rq.SelectColumns,
+		pq.QuoteIdentifier(rq.TableName),
+		rq.JoinClauses,
+		rq.WhereClause,
+		orderBy,
+		limit+1,
+	)
+	rows, err := rq.Db.Query(query, rq.Args...)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	results := make([]map[string]interface{}, 0)
+	hasMore := false
+	for rows.Next() {
+		if len(results) == limit {
+			hasMore = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, false, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			row[col] = formatResultValue(col, values[i])
+		}
+		results = append(results, row)
+	}
+	return columns, results, hasMore, rows.Err()
+}
+
+// formatResultValue muuntaa skannatun arvon JSON-vastaukseen kuten GetResults.
+func formatResultValue(col string, value interface{}) interface{} {
+	switch typed := value.(type) {
+	case time.Time:
+		return typed.Format("2006-01-02 15:04:05")
+	case []byte:
+		s := string(typed)
+		if col == "openai_embedding" && len(s) > 500 {
+			s = s[:500] + "..."
+		}
+		return s
+	default:
+		return typed
+	}
+}
+
+// fetchReferencingForeignKeys hakee yksisarakkeiset vierasavaimet, jotka viittaavat tauluun.
+func fetchReferencingForeignKeys(tableName string) ([]referencingForeignKey, error) {
+	rows, err := backend.Db.Query(`
+		SELECT cl.relname, a.attname, pa.attname
+		FROM pg_constraint c
+		JOIN pg_class cl ON cl.oid = c.conrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+		JOIN pg_attribute pa ON pa.attrelid = c.confrelid AND pa.attnum = c.confkey[1]
+		WHERE c.contype = 'f'
+		  AND c.confrelid = to_regclass($1)
+		  AND n.nspname = 'public'
+		  AND array_length(c.conkey, 1) = 1
+		ORDER BY cl.relname, a.attname
+	`, pq.QuoteIdentifier(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("fetchReferencingForeignKeys: %w", err)
+	}
+	defer rows.Close()
+
+	var result []referencingForeignKey
+	for rows.Next() {
+		var fk referencingForeignKey
+		if err := rows.Scan(&fk.ChildTable, &fk.ChildColumn, &fk.ReferencedColumn); err != nil {
+			return nil, err
+		}
+		result = append(result, fk)
+	}
+	return result, rows.Err()
+}
@@ -4,6 +4,7 @@ package gt_1_row_read
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +13,8 @@ import (
 	"github.com/lib/pq"
 
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/general_tables/row_policies"
 	e_sessions "easelect/backend/core_components/sessions"
 )
 
@@ -25,13 +28,17 @@ var geoUDTNames = []string{"geometry", "geography", "point"}
  * ----------------------------------------------------------------*/
 type tableMetaResponse struct {
 	RowCount    int      `json:"row_count"`
+	Estimated   bool     `json:"estimated"`
+	CountMethod string   `json:"count_method"`
 	HasGeo      bool     `json:"has_geo"`
 	GeomColumns []string `json:"geom_columns"`
 	GeomSources []string `json:"geom_sources"`
+	PrimaryKey  []string `json:"primary_key"`
 }
 
 /* -----------------------------------------------------------------
- *  /api/get-row-count?table=taulun_nimi
+ *  /api/get-row-count?table=taulun_nimi[&exact=1]
+ *  Suurissa tauluissa rivimäärä on arvio (estimated=true), ellei exact=1.
  * ----------------------------------------------------------------*/
 func GetRowCountHandlerWrapper(w http.ResponseWriter, r *http.Request) {
 	tableName := r.URL.Query().Get("table")
@@ -68,7 +75,19 @@ func GetRowCountHandlerWrapper(w http.ResponseWriter, r *http.Request) {
 	}
 
 	/* ---------- 2. Rivimäärä ---------- */
-	rowCount, err := getRowCount(currentDb, tableName, userRole)
+	// Suodattimet (sarakehaut, search, filter) kulkevat samaa putkea kuin GetResults,
+	// jolloin myös lookup- ja M2M-sarakkeilla suodatus vaikuttaa määrään.
+	forceExact := r.URL.Query().Get("exact") == "1" || r.URL.Query().Get("exact") == "true"
+	var rowCount rowCountResult
+	if hasResultFilters(r.URL.Query()) {
+		rq, ok := prepareResultsQuery(w, r, tableName, r.URL.Query(), resultsQueryOptions{AllAllowedColumns: true})
+		if !ok {
+			return
+		}
+		rowCount, err = rq.countResults(forceExact)
+	} else {
+		rowCount, err = getRowCount(currentDb, tableName, userRole, userID, forceExact)
+	}
 	if err != nil {
 		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
 		http.Error(w, "error counting rows", http.StatusInternalServerError)
@@ -90,25 +109,36 @@ func GetRowCountHandlerWrapper(w http.ResponseWriter, r *http.Request) {
 	}
 	hasGeo := len(geomCols) > 0 || len(geomSrcs) > 0
 
+	// Pääavainsarakkeet, joilla käyttöliittymä tunnistaa rivit (muokkaus, poisto)
+	primaryKey, err := row_key.PrimaryKeyColumns(tableName)
+	if err != nil && !errors.Is(err, row_key.ErrNoPrimaryKey) {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "error fetching primary key", http.StatusInternalServerError)
+		return
+	}
+
 	/* ---------- 4. JSON-vastaus ---------- */
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(w).Encode(tableMetaResponse{
-		RowCount:    rowCount,
+		RowCount:    rowCount.Count,
+		Estimated:   rowCount.Estimated,
+		CountMethod: rowCount.Method,
 		HasGeo:      hasGeo,
 		GeomColumns: geomCols,
 		GeomSources: geomSrcs,
+		PrimaryKey:  primaryKey,
 	})
 }
 
 /* -----------------------------------------------------------------
  *  Rivimäärän haku
  * ----------------------------------------------------------------*/
-func getRowCount(db *sql.DB, tableName, userRole string) (int, error) {
+func getRowCount(db *sql.DB, tableName, userRole string, userID int, forceExact bool) (rowCountResult, error) {
 	safe := pq.QuoteIdentifier(tableName)
 
 	mustTrueCols, err := getMustBeTrueColumns(db, tableName)
 	if err != nil {
-		return 0, fmt.Errorf("error fetching must_be_true columns: %w", err)
+		return rowCountResult{}, fmt.Errorf("error fetching must_be_true columns: %w", err)
 	}
 
 	where := ""
@@ -121,13 +151,12 @@ func getRowCount(db *sql.DB, tableName, userRole string) (int, error) {
 		where = " WHERE " + strings.Join(cond, " AND ")
 	}
 
-	query := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", safe, where)
-
-	var cnt int
-	if err := db.QueryRow(query).Scan(&cnt); err != nil {
-		return 0, fmt.Errorf("error counting rows: %w", err)
+	where, args, err := row_policies.AppendCondition(where, nil, userID, tableName)
+	if err != nil {
+		return rowCountResult{}, err
 	}
-	return cnt, nil
+
+	return countRows(db, tableName, safe+where, args, where != "", forceExact)
 }
 
 /* -----------------------------------------------------------------
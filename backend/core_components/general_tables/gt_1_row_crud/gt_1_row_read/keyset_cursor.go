@@ -0,0 +1,221 @@
+// file: keyset_cursor.go
+package gt_1_row_read
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"easelect/backend/core_components/general_tables/row_key"
+)
+
+// Piilotetut apusarakkeet, joilla kursorin arvot luetaan kyselyn tuloksista.
+// Ne poistetaan vastauksesta ennen lähettämistä.
+const (
+	cursorSortAlias = "_cursor_sort"
+	cursorPkAlias   = "_cursor_pk"
+)
+
+// resultsCursor on get-results -kyselyn keyset-sivutuksen kursori.
+// Asiakkaalle se näkyy läpinäkymättömänä base64-merkkijonona.
+type resultsCursor struct {
+	SortColumn string  `json:"c"`
+	SortOrder  string  `json:"o"`
+	SortValue  *string `json:"v"` // nil = viimeisen rivin lajitteluarvo oli NULL
+	PkValue    string  `json:"k"`
+}
+
+// keysetOrder kuvaa aktiivisen lajittelun: lajittelulauseke (tyhjä, jos
+// lajitellaan pelkän pääavaimen mukaan) ja pääavaimen lauseke tasapelien ratkaisuun.
+type keysetOrder struct {
+	SortColumn string
+	SortExpr   string
+	SortOrder  string
+	PkExpr     string
+}
+
+func encodeResultsCursor(c resultsCursor) (string, error) {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeResultsCursor(encoded string) (resultsCursor, error) {
+	var c resultsCursor
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return c, fmt.Errorf("virheellinen kursori")
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, fmt.Errorf("virheellinen kursori")
+	}
+	if c.SortOrder != "ASC" && c.SortOrder != "DESC" {
+		return c, fmt.Errorf("virheellinen kursori")
+	}
+	return c, nil
+}
+
+// getSinglePrimaryKeyColumn palauttaa taulun pääavainsarakkeen nimen, jos
+// pääavain on yksisarakkeinen. Muuten palautetaan tyhjä merkkijono.
+func getSinglePrimaryKeyColumn(tableName string) (string, error) {
+	pkColumns, err := row_key.PrimaryKeyColumns(tableName)
+	if errors.Is(err, row_key.ErrNoPrimaryKey) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getSinglePrimaryKeyColumn: %v", err)
+	}
+	if len(pkColumns) != 1 {
+		return "", nil
+	}
+	return pkColumns[0], nil
+}
+
+// buildKeysetOrder rakentaa lajittelun, jota sekä offset- että kursoritila käyttävät.
+// Lajittelusarakkeen on oltava näkyvissä (columnExpressions), jotta kursoriin
+// ei päädy arvoja sarakkeista, joihin käyttäjällä ei ole oikeutta.
+func buildKeysetOrder(
+	queryParams map[string][]string,
+	tableName string,
+	pkColumn string,
+	columnExpressions map[string]string,
+) (keysetOrder, error) {
+	order := keysetOrder{
+		SortOrder: "ASC",
+		PkExpr:    fmt.Sprintf("%s.%s", pq.QuoteIdentifier(tableName), pq.QuoteIdentifier(pkColumn)),
+	}
+
+	sortColumn := ""
+	if v, ok := queryParams["sort_column"]; ok && len(v) > 0 {
+		sortColumn = v[0]
+	}
+	if v, ok := queryParams["sort_order"]; ok && len(v) > 0 && strings.ToUpper(v[0]) == "DESC" {
+		order.SortOrder = "DESC"
+	}
+	if sortColumn == "" || sortColumn == pkColumn {
+		return order, nil
+	}
+
+	expr, ok := columnExpressions[sortColumn]
+	if !ok {
+		return order, fmt.Errorf("lajittelusarake ei ole näkyvissä: %s", sortColumn)
+	}
+	order.SortColumn = sortColumn
+	order.SortExpr = expr
+	return order, nil
+}
+
+// orderByClause palauttaa ORDER BY -osan. NULL-arvot sijoittuvat PostgreSQL:n oletuksen
+// mukaan: ASC-järjestyksessä loppuun ja DESC-järjestyksessä alkuun (buildCondition
+// noudattaa samaa).
+func (o keysetOrder) orderByClause() string {
+	if o.SortExpr == "" {
+		return fmt.Sprintf(" ORDER BY %s %s", o.PkExpr, o.SortOrder)
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, %s %s", o.SortExpr, o.SortOrder, o.PkExpr, o.SortOrder)
+}
+
+// hiddenSelectColumns palauttaa SELECT-listaan lisättävät kursorin apusarakkeet.
+func (o keysetOrder) hiddenSelectColumns() string {
+	sortExpr := "NULL"
+	if o.SortExpr != "" {
+		sortExpr = o.SortExpr
+	}
+	return fmt.Sprintf(", %s AS %s, %s AS %s",
+		sortExpr, pq.QuoteIdentifier(cursorSortAlias),
+		o.PkExpr, pq.QuoteIdentifier(cursorPkAlias),
+	)
+}
+
+// buildCondition rakentaa kursorin jälkeisiä rivejä rajaavan ehdon.
+// Palauttaa ehdon, argumentit ja seuraavan vapaan argumentti-indeksin.
+func (o keysetOrder) buildCondition(c resultsCursor, argIdx int) (string, []interface{}, int, error) {
+	if c.SortColumn != o.SortColumn || c.SortOrder != o.SortOrder {
+		return "", nil, argIdx, fmt.Errorf("kursori ei vastaa nykyistä lajittelua")
+	}
+
+	if o.SortExpr == "" {
+		cmp := ">"
+		if o.SortOrder == "DESC" {
+			cmp = "<"
+		}
+		cond := fmt.Sprintf("%s %s $%d", o.PkExpr, cmp, argIdx)
+		return cond, []interface{}{c.PkValue}, argIdx + 1, nil
+	}
+
+	if o.SortOrder == "DESC" {
+		// NULL-rivit tulevat ensin, sitten arvot laskevassa järjestyksessä
+		if c.SortValue == nil {
+			cond := fmt.Sprintf("((%[1]s IS NULL AND %[2]s < $%[3]d) OR %[1]s IS NOT NULL)", o.SortExpr, o.PkExpr, argIdx)
+			return cond, []interface{}{c.PkValue}, argIdx + 1, nil
+		}
+		cond := fmt.Sprintf(
+			"(%[1]s < $%[3]d OR (%[1]s = $%[3]d AND %[2]s < $%[4]d))",
+			o.SortExpr, o.PkExpr, argIdx, argIdx+1,
+		)
+		return cond, []interface{}{*c.SortValue, c.PkValue}, argIdx + 2, nil
+	}
+
+	// ASC: arvot nousevassa järjestyksessä, NULL-rivit lopussa
+	if c.SortValue == nil {
+		// Viimeinen rivi oli jo NULL-osuudessa → jatketaan vain NULL-rivejä pääavaimen mukaan
+		cond := fmt.Sprintf("(%s IS NULL AND %s > $%d)", o.SortExpr, o.PkExpr, argIdx)
+		return cond, []interface{}{c.PkValue}, argIdx + 1, nil
+	}
+	cond := fmt.Sprintf(
+		"(%[1]s > $%[3]d OR (%[1]s = $%[3]d AND %[2]s > $%[4]d) OR %[1]s IS NULL)",
+		o.SortExpr, o.PkExpr, argIdx, argIdx+1,
+	)
+	return cond, []interface{}{*c.SortValue, c.PkValue}, argIdx + 2, nil
+}
+
+// cursorValueToString muuntaa skannatun arvon tekstiksi, jonka PostgreSQL
+// osaa tulkita takaisin alkuperäiseen tyyppiin parametrina.
+func cursorValueToString(val interface{}) *string {
+	var s string
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		s = fmt.Sprintf("%v", v)
+	}
+	return &s
+}
+
+// buildNextCursor muodostaa seuraavan sivun kursorin sivun viimeisestä rivistä.
+func buildNextCursor(o keysetOrder, lastRow map[string]interface{}) (string, error) {
+	pkValue, _ := lastRow[cursorPkAlias].(*string)
+	if pkValue == nil {
+		return "", fmt.Errorf("kursorin pääavainarvo puuttuu")
+	}
+	var sortValue *string
+	if o.SortExpr != "" {
+		sortValue, _ = lastRow[cursorSortAlias].(*string)
+	}
+	return encodeResultsCursor(resultsCursor{
+		SortColumn: o.SortColumn,
+		SortOrder:  o.SortOrder,
+		SortValue:  sortValue,
+		PkValue:    *pkValue,
+	})
+}
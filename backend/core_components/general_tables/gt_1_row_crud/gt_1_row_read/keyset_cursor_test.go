@@ -0,0 +1,224 @@
+package gt_1_row_read
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestResultsCursorRoundTrip(t *testing.T) {
+	tests := []resultsCursor{
+		{SortOrder: "ASC", PkValue: "9007199254740993"},
+		{SortColumn: "name", SortOrder: "DESC", SortValue: strPtr("Ääkkönen"), PkValue: "12"},
+		{SortColumn: "due_date", SortOrder: "ASC", SortValue: nil, PkValue: "3"},
+	}
+	for _, c := range tests {
+		encoded, err := encodeResultsCursor(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeResultsCursor(encoded)
+		if err != nil {
+			t.Fatalf("decodeResultsCursor(%q) error: %v", encoded, err)
+		}
+		if !reflect.DeepEqual(decoded, c) {
+			t.Errorf("round trip = %+v, want %+v", decoded, c)
+		}
+	}
+}
+
+func TestDecodeResultsCursorInvalid(t *testing.T) {
+	badOrder, _ := encodeResultsCursor(resultsCursor{SortOrder: "SIDEWAYS", PkValue: "1"})
+	for _, encoded := range []string{"", "%%%", "bm90IGpzb24", badOrder} {
+		if _, err := decodeResultsCursor(encoded); err == nil {
+			t.Errorf("decodeResultsCursor(%q) error = nil, want error", encoded)
+		}
+	}
+}
+
+func TestBuildKeysetOrder(t *testing.T) {
+	expressions := map[string]string{"name": `"customers"."name"`}
+	tests := []struct {
+		name    string
+		params  map[string][]string
+		want    keysetOrder
+		wantErr bool
+	}{
+		{
+			name:   "primary key only",
+			params: map[string][]string{},
+			want:   keysetOrder{SortOrder: "ASC", PkExpr: `"customers"."id"`},
+		},
+		{
+			name:   "sort by primary key desc",
+			params: map[string][]string{"sort_column": {"id"}, "sort_order": {"desc"}},
+			want:   keysetOrder{SortOrder: "DESC", PkExpr: `"customers"."id"`},
+		},
+		{
+			name:   "visible column",
+			params: map[string][]string{"sort_column": {"name"}},
+			want:   keysetOrder{SortColumn: "name", SortExpr: `"customers"."name"`, SortOrder: "ASC", PkExpr: `"customers"."id"`},
+		},
+		{
+			name:    "hidden column",
+			params:  map[string][]string{"sort_column": {"salary"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildKeysetOrder(tt.params, "customers", "id", expressions)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("buildKeysetOrder() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("buildKeysetOrder() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysetOrderByClause(t *testing.T) {
+	tests := []struct {
+		order keysetOrder
+		want  string
+	}{
+		{keysetOrder{SortOrder: "DESC", PkExpr: "t.id"}, " ORDER BY t.id DESC"},
+		{keysetOrder{SortExpr: "t.name", SortOrder: "ASC", PkExpr: "t.id"}, " ORDER BY t.name ASC, t.id ASC"},
+		{keysetOrder{SortExpr: "t.name", SortOrder: "DESC", PkExpr: "t.id"}, " ORDER BY t.name DESC, t.id DESC"},
+	}
+	for _, tt := range tests {
+		if got := tt.order.orderByClause(); got != tt.want {
+			t.Errorf("orderByClause(%+v) = %q, want %q", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestKeysetBuildCondition(t *testing.T) {
+	asc := keysetOrder{SortColumn: "name", SortExpr: "t.name", SortOrder: "ASC", PkExpr: "t.id"}
+	desc := keysetOrder{SortColumn: "name", SortExpr: "t.name", SortOrder: "DESC", PkExpr: "t.id"}
+	pkOnly := keysetOrder{SortOrder: "DESC", PkExpr: "t.id"}
+
+	tests := []struct {
+		name     string
+		order    keysetOrder
+		cursor   resultsCursor
+		want     string
+		wantArgs []interface{}
+		wantNext int
+		wantErr  bool
+	}{
+		{
+			name:     "primary key only",
+			order:    pkOnly,
+			cursor:   resultsCursor{SortOrder: "DESC", PkValue: "10"},
+			want:     "t.id < $3",
+			wantArgs: []interface{}{"10"},
+			wantNext: 4,
+		},
+		{
+			name:     "asc value, nulls follow",
+			order:    asc,
+			cursor:   resultsCursor{SortColumn: "name", SortOrder: "ASC", SortValue: strPtr("b"), PkValue: "5"},
+			want:     "(t.name > $3 OR (t.name = $3 AND t.id > $4) OR t.name IS NULL)",
+			wantArgs: []interface{}{"b", "5"},
+			wantNext: 5,
+		},
+		{
+			name:     "asc inside nulls",
+			order:    asc,
+			cursor:   resultsCursor{SortColumn: "name", SortOrder: "ASC", PkValue: "5"},
+			want:     "(t.name IS NULL AND t.id > $3)",
+			wantArgs: []interface{}{"5"},
+			wantNext: 4,
+		},
+		{
+			name:     "desc value, nulls already passed",
+			order:    desc,
+			cursor:   resultsCursor{SortColumn: "name", SortOrder: "DESC", SortValue: strPtr("b"), PkValue: "5"},
+			want:     "(t.name < $3 OR (t.name = $3 AND t.id < $4))",
+			wantArgs: []interface{}{"b", "5"},
+			wantNext: 5,
+		},
+		{
+			name:     "desc inside leading nulls",
+			order:    desc,
+			cursor:   resultsCursor{SortColumn: "name", SortOrder: "DESC", PkValue: "5"},
+			want:     "((t.name IS NULL AND t.id < $3) OR t.name IS NOT NULL)",
+			wantArgs: []interface{}{"5"},
+			wantNext: 4,
+		},
+		{
+			name:    "sort changed",
+			order:   asc,
+			cursor:  resultsCursor{SortColumn: "name", SortOrder: "DESC", PkValue: "5"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, next, err := tt.order.buildCondition(tt.cursor, 3)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("buildCondition() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || !reflect.DeepEqual(args, tt.wantArgs) || next != tt.wantNext {
+				t.Errorf("buildCondition() = %q %v %d, want %q %v %d", got, args, next, tt.want, tt.wantArgs, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestCursorValueToString(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  *string
+	}{
+		{nil, nil},
+		{"abc", strPtr("abc")},
+		{[]byte("12.50"), strPtr("12.50")},
+		{int64(9007199254740993), strPtr("9007199254740993")},
+		{float64(0.1), strPtr("0.1")},
+		{true, strPtr("true")},
+		{time.Date(2024, 2, 29, 12, 0, 0, 500, time.UTC), strPtr("2024-02-29T12:00:00.0000005Z")},
+	}
+	for _, tt := range tests {
+		if got := cursorValueToString(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("cursorValueToString(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBuildNextCursor(t *testing.T) {
+	order := keysetOrder{SortColumn: "name", SortExpr: "t.name", SortOrder: "DESC", PkExpr: "t.id"}
+	lastRow := map[string]interface{}{
+		cursorSortAlias: strPtr("b"),
+		cursorPkAlias:   strPtr("42"),
+	}
+	encoded, err := buildNextCursor(order, lastRow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeResultsCursor(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := resultsCursor{SortColumn: "name", SortOrder: "DESC", SortValue: strPtr("b"), PkValue: "42"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildNextCursor() = %+v, want %+v", got, want)
+	}
+
+	if _, err := buildNextCursor(order, map[string]interface{}{cursorSortAlias: strPtr("b")}); err == nil {
+		t.Error("buildNextCursor() without primary key error = nil, want error")
+	}
+}
@@ -0,0 +1,313 @@
+// file: lookup_columns.go
+package gt_1_row_read
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+)
+
+// Monihyppyiset lookup-sarakkeet määritellään system_column_details.lookup_paths
+// -sarakkeeseen sen vierasavainsarakkeen riville, josta polku alkaa. Esim.
+// service_locations.service_id -rivillä:
+//
+//	[{"name": "customer_city", "path": ["customer_id"], "target_column": "city"}]
+//
+// => service_locations.service_id -> service_catalog.customer_id -> customers.city
+// Tuloksissa sarake näkyy nimellä "customer_city (lookup)".
+
+// lookupColumnSuffix erottaa lookup-sarakkeet tavallisista sarakkeista.
+const lookupColumnSuffix = " (lookup)"
+
+// lookupPathSpec on yksi lookup_paths-JSONin alkio.
+type lookupPathSpec struct {
+	Name         string   `json:"name"`
+	Path         []string `json:"path"`
+	TargetColumn string   `json:"target_column"`
+}
+
+// lookupHop on yksi ratkaistu liitos: fromTable.fromColumn -> toTable.toColumn.
+type lookupHop struct {
+	FromTable  string
+	FromColumn string
+	ToTable    string
+	ToColumn   string
+}
+
+// lookupColumn on ratkaistu lookup-sarake liitoksineen.
+type lookupColumn struct {
+	Name         string
+	StartColumn  string
+	Hops         []lookupHop
+	TargetColumn string
+}
+
+// EnsureLookupPathsColumn lisää system_column_details-tauluun lookup_paths-sarakkeen,
+// jos sitä ei vielä ole. Kutsutaan käynnistyksen yhteydessä.
+func EnsureLookupPathsColumn() error {
+	_, err := backend.Db.Exec(`
+		ALTER TABLE system_column_details
+		ADD COLUMN IF NOT EXISTS lookup_paths JSONB
+	`)
+	if err != nil {
+		return fmt.Errorf("lookup_paths-sarakkeen lisäys epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// maxLookupHops rajaa lookup-polun pituuden.
+const maxLookupHops = 8
+
+// fetchLookupColumns hakee taulun lookup-määritykset ja ratkaisee niiden liitospolut.
+// Polut ratkaistaan hyppy kerrallaan kaikille määrityksille yhtä aikaa, joten
+// vierasavaimet haetaan yhdellä kyselyllä hyppyä kohden.
+// Virheelliset määritykset ohitetaan lokituksen kera, jotta yksi rikkinäinen polku
+// ei kaada koko näkymää.
+func fetchLookupColumns(tableName string) ([]lookupColumn, error) {
+	query := `
+		SELECT scd.column_name, scd.lookup_paths
+		FROM system_column_details scd
+		JOIN system_db_tables sdt ON sdt.table_uid = scd.table_uid
+		WHERE sdt.table_name = $1
+		  AND scd.lookup_paths IS NOT NULL
+		ORDER BY scd.co_number
+	`
+	rows, err := backend.Db.Query(query, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("fetchLookupColumns: %v", err)
+	}
+	defer rows.Close()
+
+	var pending []*lookupResolution
+	for rows.Next() {
+		var startColumn string
+		var rawPaths []byte
+		if err := rows.Scan(&startColumn, &rawPaths); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			continue
+		}
+		var specs []lookupPathSpec
+		if err := json.Unmarshal(rawPaths, &specs); err != nil {
+			log.Printf("\033[31mvirhe: lookup_paths (%s.%s) ei ole kelvollinen: %s\033[0m\n", tableName, startColumn, err.Error())
+			continue
+		}
+		for _, spec := range specs {
+			res, err := newLookupResolution(tableName, startColumn, spec)
+			if err != nil {
+				log.Printf("\033[31mvirhe: lookup '%s' (%s.%s): %s\033[0m\n", spec.Name, tableName, startColumn, err.Error())
+				continue
+			}
+			pending = append(pending, res)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("fetchLookupColumns: %v", err)
+	}
+
+	if err := resolveLookupPaths(pending, fetchForeignKeyTargets); err != nil {
+		return nil, fmt.Errorf("fetchLookupColumns: %v", err)
+	}
+	var result []lookupColumn
+	for _, res := range pending {
+		if res.err != nil {
+			log.Printf("\033[31mvirhe: lookup '%s' (%s.%s): %s\033[0m\n", res.spec.Name, tableName, res.column.StartColumn, res.err.Error())
+			continue
+		}
+		result = append(result, res.column)
+	}
+	return result, nil
+}
+
+// lookupResolution on ratkaisua odottava lookup-polku.
+type lookupResolution struct {
+	spec      lookupPathSpec
+	column    lookupColumn
+	remaining []string // vielä seuraamattomat vierasavainsarakkeet
+	table     string   // taulu, josta seuraava hyppy lähtee
+	err       error
+}
+
+// newLookupResolution tarkistaa määrityksen ja valmistelee sen ratkaisun.
+func newLookupResolution(tableName, startColumn string, spec lookupPathSpec) (*lookupResolution, error) {
+	if spec.Name == "" || spec.TargetColumn == "" {
+		return nil, fmt.Errorf("name ja target_column ovat pakollisia")
+	}
+	path := append([]string{startColumn}, spec.Path...)
+	if len(path) > maxLookupHops {
+		return nil, fmt.Errorf("polku on liian pitkä (%d hyppyä)", len(path))
+	}
+	return &lookupResolution{
+		spec: spec,
+		column: lookupColumn{
+			Name:         spec.Name + lookupColumnSuffix,
+			StartColumn:  startColumn,
+			TargetColumn: spec.TargetColumn,
+		},
+		remaining: path,
+		table:     tableName,
+	}, nil
+}
+
+// foreignKeyTargets kertoo taulun vierasavainsarakkeiden kohteet: taulu -> sarake -> (kohdetaulu, kohdesarake).
+type foreignKeyTargets map[string]map[string][2]string
+
+// resolveLookupPaths kulkee kaikkia polkuja hyppy kerrallaan. fetch kutsutaan kerran
+// hyppyä kohden niillä tauluilla, joista keskeneräiset polut jatkuvat.
+func resolveLookupPaths(pending []*lookupResolution, fetch func(tables []string) (foreignKeyTargets, error)) error {
+	for {
+		var tables []string
+		seen := make(map[string]bool)
+		for _, res := range pending {
+			if res.err == nil && len(res.remaining) > 0 && !seen[res.table] {
+				seen[res.table] = true
+				tables = append(tables, res.table)
+			}
+		}
+		if len(tables) == 0 {
+			return nil
+		}
+		targets, err := fetch(tables)
+		if err != nil {
+			return err
+		}
+		for _, res := range pending {
+			if res.err != nil || len(res.remaining) == 0 {
+				continue
+			}
+			fkColumn := res.remaining[0]
+			target, ok := targets[res.table][fkColumn]
+			if !ok {
+				res.err = fmt.Errorf("sarake %s.%s ei ole vierasavain", res.table, fkColumn)
+				continue
+			}
+			res.column.Hops = append(res.column.Hops, lookupHop{
+				FromTable:  res.table,
+				FromColumn: fkColumn,
+				ToTable:    target[0],
+				ToColumn:   target[1],
+			})
+			res.table = target[0]
+			res.remaining = res.remaining[1:]
+		}
+	}
+}
+
+// fetchForeignKeyTargets hakee annettujen taulujen vierasavaimet yhdellä kyselyllä.
+func fetchForeignKeyTargets(tables []string) (foreignKeyTargets, error) {
+	rows, err := backend.Db.Query(`
+		SELECT tc.table_name, kcu.column_name, ccu.table_name, ccu.column_name
+		FROM information_schema.table_constraints AS tc
+		JOIN information_schema.key_column_usage AS kcu
+		  ON tc.constraint_name = kcu.constraint_name
+		 AND tc.constraint_schema = kcu.constraint_schema
+		JOIN information_schema.constraint_column_usage AS ccu
+		  ON ccu.constraint_name = tc.constraint_name
+		 AND ccu.constraint_schema = tc.constraint_schema
+		WHERE tc.constraint_type = 'FOREIGN KEY'
+		  AND tc.table_name = ANY($1)
+	`, pq.Array(tables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make(foreignKeyTargets)
+	for rows.Next() {
+		var table, column, refTable, refColumn string
+		if err := rows.Scan(&table, &column, &refTable, &refColumn); err != nil {
+			return nil, err
+		}
+		if targets[table] == nil {
+			targets[table] = make(map[string][2]string)
+		}
+		targets[table][column] = [2]string{refTable, refColumn}
+	}
+	return targets, rows.Err()
+}
+
+// privilegeChecks palauttaa (taulu, sarake)-parit, joihin roolilla on oltava
+// SELECT-oikeus: liitosketjun sarakkeet ja kohdesarake.
+func (lc lookupColumn) privilegeChecks() [][2]string {
+	var checks [][2]string
+	for i, hop := range lc.Hops {
+		if i > 0 {
+			checks = append(checks, [2]string{hop.FromTable, hop.FromColumn})
+		}
+		checks = append(checks, [2]string{hop.ToTable, hop.ToColumn})
+	}
+	last := lc.Hops[len(lc.Hops)-1]
+	return append(checks, [2]string{last.ToTable, lc.TargetColumn})
+}
+
+// readableLookupColumns tarkistaa yhdellä kyselyllä, mitkä lookup-sarakkeet rooli voi lukea.
+// Palautettu slice vastaa indekseiltään annettuja sarakkeita.
+func readableLookupColumns(db *sql.DB, columns []lookupColumn) ([]bool, error) {
+	result := make([]bool, len(columns))
+	if len(columns) == 0 {
+		return result, nil
+	}
+	var tables, names []string
+	for _, lc := range columns {
+		for _, check := range lc.privilegeChecks() {
+			tables = append(tables, check[0])
+			names = append(names, check[1])
+		}
+	}
+	rows, err := db.Query(`
+		SELECT DISTINCT x.t, x.c
+		FROM unnest($1::text[], $2::text[]) AS x(t, c)
+		WHERE has_column_privilege(quote_ident(x.t), x.c, 'SELECT')
+	`, pq.Array(tables), pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	readable := make(map[[2]string]bool)
+	for rows.Next() {
+		var check [2]string
+		if err := rows.Scan(&check[0], &check[1]); err != nil {
+			return nil, err
+		}
+		readable[check] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, lc := range columns {
+		result[i] = true
+		for _, check := range lc.privilegeChecks() {
+			if !readable[check] {
+				result[i] = false
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// buildJoins palauttaa ketjutetut LEFT JOINit uniikein aliaksin sekä kohdesarakkeen lausekkeen.
+// lookupIdx erottaa saman kyselyn eri lookup-sarakkeiden aliakset toisistaan.
+func (lc lookupColumn) buildJoins(tableName string, lookupIdx int) (string, string) {
+	joins := ""
+	prevAlias := pq.QuoteIdentifier(tableName)
+	for i, hop := range lc.Hops {
+		alias := pq.QuoteIdentifier(fmt.Sprintf("lookup%d_%d", lookupIdx, i))
+		joins += fmt.Sprintf("LEFT JOIN %s AS %s ON %s.%s = %s.%s ",
+			pq.QuoteIdentifier(hop.ToTable),
+			alias,
+			prevAlias,
+			pq.QuoteIdentifier(hop.FromColumn),
+			alias,
+			pq.QuoteIdentifier(hop.ToColumn),
+		)
+		prevAlias = alias
+	}
+	return joins, fmt.Sprintf("%s.%s", prevAlias, pq.QuoteIdentifier(lc.TargetColumn))
+}
@@ -0,0 +1,146 @@
+package gt_1_row_read
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveLookupPaths(t *testing.T) {
+	schema := foreignKeyTargets{
+		"service_locations": {"service_id": {"service_catalog", "id"}, "location_id": {"locations", "id"}},
+		"service_catalog":   {"customer_id": {"customers", "id"}},
+		"customers":         {"region_id": {"regions", "id"}},
+	}
+	specs := []struct {
+		start string
+		spec  lookupPathSpec
+	}{
+		{"service_id", lookupPathSpec{Name: "customer_city", Path: []string{"customer_id"}, TargetColumn: "city"}},
+		{"service_id", lookupPathSpec{Name: "region", Path: []string{"customer_id", "region_id"}, TargetColumn: "name"}},
+		{"location_id", lookupPathSpec{Name: "address", TargetColumn: "street"}},
+		{"service_id", lookupPathSpec{Name: "broken", Path: []string{"missing_id"}, TargetColumn: "x"}},
+	}
+	var pending []*lookupResolution
+	for _, s := range specs {
+		res, err := newLookupResolution("service_locations", s.start, s.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(pending, res)
+	}
+
+	var calls [][]string
+	fetch := func(tables []string) (foreignKeyTargets, error) {
+		calls = append(calls, tables)
+		return schema, nil
+	}
+	if err := resolveLookupPaths(pending, fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	wantCalls := [][]string{{"service_locations"}, {"service_catalog"}, {"customers"}}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("fetch calls = %v, want %v (one per hop)", calls, wantCalls)
+	}
+
+	tests := []struct {
+		name    string
+		res     *lookupResolution
+		want    []lookupHop
+		wantErr bool
+	}{
+		{
+			name: "two hops",
+			res:  pending[0],
+			want: []lookupHop{
+				{"service_locations", "service_id", "service_catalog", "id"},
+				{"service_catalog", "customer_id", "customers", "id"},
+			},
+		},
+		{
+			name: "three hops",
+			res:  pending[1],
+			want: []lookupHop{
+				{"service_locations", "service_id", "service_catalog", "id"},
+				{"service_catalog", "customer_id", "customers", "id"},
+				{"customers", "region_id", "regions", "id"},
+			},
+		},
+		{
+			name: "single hop",
+			res:  pending[2],
+			want: []lookupHop{{"service_locations", "location_id", "locations", "id"}},
+		},
+		{name: "not a foreign key", res: pending[3], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr {
+				if tt.res.err == nil {
+					t.Fatalf("hops = %+v, want error", tt.res.column.Hops)
+				}
+				return
+			}
+			if tt.res.err != nil || !reflect.DeepEqual(tt.res.column.Hops, tt.want) {
+				t.Errorf("hops = %+v, %v, want %+v", tt.res.column.Hops, tt.res.err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLookupResolutionInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec lookupPathSpec
+	}{
+		{"missing name", lookupPathSpec{TargetColumn: "city"}},
+		{"missing target", lookupPathSpec{Name: "city"}},
+		{"too long", lookupPathSpec{Name: "deep", Path: strings.Split("a b c d e f g h", " "), TargetColumn: "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newLookupResolution("t", "start_id", tt.spec); err == nil {
+				t.Error("newLookupResolution() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestLookupPrivilegeChecks(t *testing.T) {
+	lc := lookupColumn{
+		Hops: []lookupHop{
+			{"service_locations", "service_id", "service_catalog", "id"},
+			{"service_catalog", "customer_id", "customers", "id"},
+		},
+		TargetColumn: "city",
+	}
+	want := [][2]string{
+		{"service_catalog", "id"},
+		{"service_catalog", "customer_id"},
+		{"customers", "id"},
+		{"customers", "city"},
+	}
+	if got := lc.privilegeChecks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("privilegeChecks() = %v, want %v", got, want)
+	}
+}
+
+func TestLookupBuildJoins(t *testing.T) {
+	lc := lookupColumn{
+		Hops: []lookupHop{
+			{"service_locations", "service_id", "service_catalog", "id"},
+			{"service_catalog", "customer_id", "customers", "id"},
+		},
+		TargetColumn: "city",
+	}
+	joins, expr := lc.buildJoins("service_locations", 2)
+	wantJoins := `LEFT JOIN "service_catalog" AS "lookup2_0" ON "service_locations"."service_id" = "lookup2_0"."id" ` +
+		`LEFT JOIN "customers" AS "lookup2_1" ON "lookup2_0"."customer_id" = "lookup2_1"."id" `
+	if joins != wantJoins {
+		t.Errorf("buildJoins() joins = %q, want %q", joins, wantJoins)
+	}
+	if want := `"lookup2_1"."city"`; expr != want {
+		t.Errorf("buildJoins() expression = %q, want %q", expr, want)
+	}
+}
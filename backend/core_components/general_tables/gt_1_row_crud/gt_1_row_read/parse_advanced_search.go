@@ -1,130 +1,391 @@
 package gt_1_row_read
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"unicode"
 )
 
-// TokenType kuvaa, mitä lajia hakusana on.
-type TokenType int
+// Hakulausekkeiden kielioppi (rekursiivisesti laskeutuva jäsennin):
+//
+//	expr    := orExpr
+//	orExpr  := andExpr ( OR andExpr )*
+//	andExpr := unary ( [AND] unary )*          – pelkkä välilyönti = AND
+//	unary   := ( NOT | "-" | "!=" ) unary | primary
+//	primary := "(" expr ")" | term
+//	term    := [ field ":" ] [ op ] value
+//	field   := tunniste | "lainattu nimi"        – esim. status: tai "customer_name (ln)":
+//	op      := ">" | ">=" | "<" | "<=" | "=" | "!="
+//	value   := sana | "lainattu" | 'lainattu'
+//
+// AND sitoo tiukemmin kuin OR, joten "foo OR bar AND baz" = foo OR (bar AND baz).
+// Lainattu fraasi pilkotaan sanoiksi kuten ennenkin: "bar baz" = bar AND baz, ja
+// negaatio koskee jokaista sanaa: -"bar baz" = NOT bar AND NOT baz. Numeron edessä
+// oleva "-" kuuluu arvoon (-5 hakee arvoa -5). Sulkeiden ja negaatioiden
+// sisäkkäisyys on rajattu maxSearchDepth-tasoon.
+// Sarakekohtainen hakukenttä ja globaali haku (?search=) käyttävät samaa AST:tä.
+//
+// Esimerkkejä:
+//
+//	k*rhu                    -> ILIKE '%k%rhu%'
+//	(foo OR bar) -baz        -> (foo OR bar) AND NOT baz
+//	status:open price:>10    -> status ILIKE '%open%' AND price > 10
+//	!=""                     -> arvo ei ole tyhjä
+//	*                        -> kaikki rivit
+
+// maxSearchDepth rajaa sulkeiden ja negaatioiden sisäkkäisyyden, jottei pitkä
+// syöte kasvata rekursiota rajatta.
+const maxSearchDepth = 32
+
+// searchNodeKind kuvaa AST-solmun lajia.
+type searchNodeKind int
 
 const (
-	TokenAll     TokenType = iota // "*", "%" => Hae kaikki
-	TokenAnd                      // AND
-	TokenOr                       // OR
-	TokenInclude                  // Normaali hakusana (ILIKE)
-	TokenExclude                  // != => Kielletty hakusana (NOT ILIKE)
+	searchNodeAll  searchNodeKind = iota // "*" / "%" => hae kaikki
+	searchNodeAnd                        // lapset AND-ehdolla
+	searchNodeOr                         // lapset OR-ehdolla
+	searchNodeNot                        // yksi lapsi, negaatio
+	searchNodeTerm                       // yksittäinen hakuehto
 )
 
-// Token kuvaa yksittäistä hakutermiä.
-type Token struct {
-	Type  TokenType
-	Value string
+// searchNode on hakulausekkeen AST-solmu.
+type searchNode struct {
+	Kind     searchNodeKind
+	Children []*searchNode
+	// Term-solmun kentät
+	Field       string // kohdesarake, tyhjä = oletussarake(et)
+	FieldQuoted bool   // kenttä annettiin lainausmerkeissä → sen on oltava sarake
+	Operator    string // "", ">", ">=", "<", "<=", "=", "!="
+	Value       string
+	Raw         string // alkuperäinen sana, jos kenttä ei osoittautukaan sarakkeeksi
+	// And-solmun kentät
+	Phrase bool // lainattu fraasi pilkottu sanoiksi; negaatio kohdistuu jokaiseen sanaan
 }
 
-// parseAdvancedSearch pilkkoo syötemerkkijonon token-listaksi.
-// Se tunnistaa:
-//   - AND / OR (case-insensitive)
-//   - !=
-//   - "([^"]*)" ja '([^']*)' (myös tyhjät, koska * sallii 0 merkkiä)
-//   - (\S+) => muut sanat
-//
-// Esimerkkejä:
-//
-//	foo AND "bar baz" != qux
-//	-> [Include("foo"), And, Include("bar baz"), Exclude("qux")]
-//
-//	k*rhu -> Include("k*rhu") -> myöhemmin buildConditionForTokens vaiheessa
-//	  korvataan * => % => "k%rhu"
-func parseAdvancedSearch(input string) []Token {
-	input = strings.TrimSpace(input)
-
-	// Jos syöte on pelkkä "*" tai "%", tulkitaan = "hae kaikki"
-	if input == "*" || input == "%" {
-		return []Token{{Type: TokenAll, Value: input}}
-	}
-
-	// Regex etsii järjestyksessä:
-	//  1) (AND|OR)  (case-insensitive)
-	//  2) !=
-	//  3) "([^"]*)"   => kaksoshinnat, sallitaan myös tyhjä
-	//  4) '([^']*)'   => yksöishinnat, sallitaan myös tyhjä
-	//  5) (\S+)       => muut sanat
-	re := regexp.MustCompile(`(?i)(AND|OR)|(!=)|"([^"]*)"|'([^']*)'|(\S+)`)
-	matches := re.FindAllStringSubmatch(input, -1)
-
-	var tokens []Token
-	var nextExclude bool
-
-	for _, m := range matches {
-		andOrPart := m[1] // AND/OR
-		excludeOp := m[2] // !=
-		doubleQ := m[3]   // "foo" (voi olla "")
-		singleQ := m[4]   // 'foo' (voi olla "")
-		plainText := m[5] // muu sana
-
-		// 1) AND / OR
-		if strings.EqualFold(andOrPart, "AND") {
-			tokens = append(tokens, Token{Type: TokenAnd, Value: "AND"})
-			continue
+// searchParseError on asiakkaalle palautettava jäsennysvirhe.
+type searchParseError struct {
+	Pos     int
+	Message string
+}
+
+func (e *searchParseError) Error() string {
+	return fmt.Sprintf("hakulausekkeen virhe kohdassa %d: %s", e.Pos+1, e.Message)
+}
+
+type searchTokenType int
+
+const (
+	stWord searchTokenType = iota
+	stQuoted
+	stLParen
+	stRParen
+	stAnd
+	stOr
+	stNot
+	stField
+	stEOF
+)
+
+type searchToken struct {
+	Type   searchTokenType
+	Value  string
+	Pos    int
+	Quoted bool // stField: kenttä oli lainausmerkeissä
+}
+
+var searchFieldRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// tokenizeSearch pilkkoo syötteen tokeneiksi.
+func tokenizeSearch(input string) ([]searchToken, error) {
+	runes := []rune(input)
+	var tokens []searchToken
+	i := 0
+
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, searchToken{Type: stLParen, Pos: i})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, searchToken{Type: stRParen, Pos: i})
+			i++
+
+		case r == '"' || r == '\'':
+			start := i
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, &searchParseError{Pos: start, Message: "sulkeva lainausmerkki puuttuu"}
+			}
+			content := string(runes[i+1 : end])
+			i = end + 1
+			// "lainattu nimi": => kenttä
+			if i < len(runes) && runes[i] == ':' {
+				tokens = append(tokens, searchToken{Type: stField, Value: content, Pos: start, Quoted: true})
+				i++
+				continue
+			}
+			tokens = append(tokens, searchToken{Type: stQuoted, Value: content, Pos: start})
+
+		case r == '!' && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, searchToken{Type: stNot, Value: "!=", Pos: i})
+			i += 2
+
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && !unicode.IsDigit(runes[i+1]):
+			// "-" sanan alussa = negaatio (sanan keskellä ja numeron edessä oleva "-" kuuluu sanaan)
+			tokens = append(tokens, searchToken{Type: stNot, Value: "-", Pos: i})
+			i++
+
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' && runes[i] != '\'' {
+				i++
+			}
+			word := string(runes[start:i])
+
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, searchToken{Type: stAnd, Value: word, Pos: start})
+				continue
+			case "OR":
+				tokens = append(tokens, searchToken{Type: stOr, Value: word, Pos: start})
+				continue
+			case "NOT":
+				tokens = append(tokens, searchToken{Type: stNot, Value: word, Pos: start})
+				continue
+			}
+
+			// sarake:arvo
+			if colon := strings.Index(word, ":"); colon > 0 && searchFieldRe.MatchString(word[:colon]) {
+				tokens = append(tokens, searchToken{Type: stField, Value: word[:colon], Pos: start})
+				rest := word[colon+1:]
+				if rest != "" {
+					tokens = append(tokens, searchToken{Type: stWord, Value: rest, Pos: start + colon + 1})
+				}
+				continue
+			}
+			tokens = append(tokens, searchToken{Type: stWord, Value: word, Pos: start})
+		}
+	}
+	tokens = append(tokens, searchToken{Type: stEOF, Pos: len(runes)})
+	return tokens, nil
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+	depth  int // sulkeiden ja negaatioiden sisäkkäisyys
+}
+
+// enter kasvattaa sisäkkäisyyttä; liian syvä lauseke on virhe.
+func (p *searchParser) enter(pos int) error {
+	p.depth++
+	if p.depth > maxSearchDepth {
+		return &searchParseError{Pos: pos, Message: fmt.Sprintf("lauseke on liian syvä (enintään %d sisäkkäistä tasoa)", maxSearchDepth)}
+	}
+	return nil
+}
+
+func (p *searchParser) peek() searchToken {
+	return p.tokens[p.pos]
+}
+
+func (p *searchParser) next() searchToken {
+	t := p.tokens[p.pos]
+	if t.Type != stEOF {
+		p.pos++
+	}
+	return t
+}
+
+// parseSearchExpression jäsentää hakulausekkeen AST:ksi. Tyhjä syöte palauttaa nil.
+func parseSearchExpression(input string) (*searchNode, error) {
+	trimmed := strings.TrimSpace(input)
+	if trimmed == "" {
+		return nil, nil
+	}
+	if trimmed == "*" || trimmed == "%" {
+		return &searchNode{Kind: searchNodeAll}, nil
+	}
+
+	tokens, err := tokenizeSearch(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &searchParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.Type != stEOF {
+		if t.Type == stRParen {
+			return nil, &searchParseError{Pos: t.Pos, Message: "ylimääräinen ')'"}
 		}
-		if strings.EqualFold(andOrPart, "OR") {
-			tokens = append(tokens, Token{Type: TokenOr, Value: "OR"})
-			continue
+		return nil, &searchParseError{Pos: t.Pos, Message: "odottamaton merkki"}
+	}
+	return node, nil
+}
+
+func (p *searchParser) parseOr() (*searchNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []*searchNode{left}
+	for p.peek().Type == stOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
 		}
+		children = append(children, right)
+	}
+	if len(children) == 1 {
+		return left, nil
+	}
+	return &searchNode{Kind: searchNodeOr, Children: children}, nil
+}
 
-		// 2) != => seuraava sana menee TokenExclude-tilaan
-		if excludeOp == "!=" {
-			nextExclude = true
-			continue
+func (p *searchParser) parseAnd() (*searchNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	children := []*searchNode{left}
+	for {
+		t := p.peek()
+		if t.Type == stAnd {
+			p.next()
+		} else if t.Type == stEOF || t.Type == stOr || t.Type == stRParen {
+			break
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
 		}
+		children = append(children, right)
+	}
+	if len(children) == 1 {
+		return left, nil
+	}
+	return &searchNode{Kind: searchNodeAnd, Children: children}, nil
+}
 
-		// 3) Hae sisältö quotes
-		var content string
-		if doubleQ != "" || doubleQ == "" {
-			content = doubleQ
+func (p *searchParser) parseUnary() (*searchNode, error) {
+	if t := p.peek(); t.Type == stNot {
+		p.next()
+		if err := p.enter(t.Pos); err != nil {
+			return nil, err
 		}
-		if singleQ != "" || singleQ == "" {
-			// Käytännössä vain toinen näistä on kerrallaan täynnä
-			if singleQ != "" {
-				content = singleQ
-			}
+		inner, err := p.parseUnary()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+		return negate(inner), nil
+	}
+	return p.parsePrimary()
+}
+
+// negate palauttaa solmun negaation. Pilkotun fraasin negaatio kohdistuu jokaiseen
+// sanaan erikseen (NOT a AND NOT b), kuten vanhassa !=-käsittelyssä.
+func negate(node *searchNode) *searchNode {
+	if node.Kind == searchNodeAnd && node.Phrase {
+		negated := make([]*searchNode, len(node.Children))
+		for i, child := range node.Children {
+			negated[i] = &searchNode{Kind: searchNodeNot, Children: []*searchNode{child}}
+		}
+		return &searchNode{Kind: searchNodeAnd, Children: negated}
+	}
+	return &searchNode{Kind: searchNodeNot, Children: []*searchNode{node}}
+}
+
+func (p *searchParser) parsePrimary() (*searchNode, error) {
+	t := p.peek()
+	switch t.Type {
+	case stLParen:
+		p.next()
+		if err := p.enter(t.Pos); err != nil {
+			return nil, err
 		}
-		// Jos kumpikaan edellä ei osunut, otetaan plainText
-		if content == "" && doubleQ == "" && singleQ == "" {
-			content = plainText
+		inner, err := p.parseOr()
+		p.depth--
+		if err != nil {
+			return nil, err
 		}
+		if closing := p.next(); closing.Type != stRParen {
+			return nil, &searchParseError{Pos: t.Pos, Message: "sulkeva ')' puuttuu"}
+		}
+		return inner, nil
 
-		// 4) Jos haluat käsitellä "*"-merkkiä sisäisenä wildcardina,
-		//    mutta VAIN silloin, kun content ei ole täsmälleen "*" => TokenAll.
-		//    Emme tee tätä heti, koska TokenAll-tapaus hoidettiin jo alussa,
-		//    kun input == "*" / "%".
-		//    Myös jos user kirjoitti "k*rhu", niin nyt content on "k*rhu".
-
-		// Käsitellään Exclude vs Include
-		if nextExclude {
-			// Jos haluat jakaa "k*rhu s*b" useammaksi exclude-tokeniksi, laita strings.Fields
-			parts := strings.Fields(content)
-			if len(parts) == 0 {
-				// !="": ei ole merkkejä
-				tokens = append(tokens, Token{Type: TokenExclude, Value: ""})
-			} else {
-				for _, p := range parts {
-					tokens = append(tokens, Token{Type: TokenExclude, Value: p})
-				}
-			}
-			nextExclude = false
-		} else {
-			parts := strings.Fields(content)
-			if len(parts) == 0 {
-				tokens = append(tokens, Token{Type: TokenInclude, Value: ""})
-			} else {
-				for _, p := range parts {
-					tokens = append(tokens, Token{Type: TokenInclude, Value: p})
-				}
-			}
+	case stField:
+		p.next()
+		valueTok := p.peek()
+		if valueTok.Type != stWord && valueTok.Type != stQuoted {
+			return nil, &searchParseError{Pos: valueTok.Pos, Message: fmt.Sprintf("sarakkeelle '%s' puuttuu hakuarvo", t.Value)}
+		}
+		p.next()
+		return termsFromToken(valueTok, func(node *searchNode) {
+			node.Field = t.Value
+			node.FieldQuoted = t.Quoted
+			node.Raw = t.Value + ":" + node.Value
+		}), nil
+
+	case stWord, stQuoted:
+		p.next()
+		return termsFromToken(t, nil), nil
+
+	case stEOF:
+		return nil, &searchParseError{Pos: t.Pos, Message: "lauseke päättyi kesken"}
+	case stRParen:
+		return nil, &searchParseError{Pos: t.Pos, Message: "odottamaton ')'"}
+	default:
+		return nil, &searchParseError{Pos: t.Pos, Message: fmt.Sprintf("odottamaton '%s'", t.Value)}
+	}
+}
+
+// termsFromToken muodostaa tokenista term-solmun. Useamman sanan lainattu fraasi
+// pilkotaan sanoiksi, jotka yhdistetään AND-ehdolla. setField täydentää kenttähaun tiedot.
+func termsFromToken(t searchToken, setField func(*searchNode)) *searchNode {
+	words := []string{t.Value}
+	if t.Type == stQuoted {
+		if fields := strings.Fields(t.Value); len(fields) > 0 {
+			words = fields
+		}
+	}
+	terms := make([]*searchNode, len(words))
+	for i, word := range words {
+		terms[i] = termFromToken(searchToken{Type: t.Type, Value: word, Pos: t.Pos})
+		if setField != nil {
+			setField(terms[i])
 		}
 	}
+	if len(terms) == 1 {
+		return terms[0]
+	}
+	return &searchNode{Kind: searchNodeAnd, Children: terms, Phrase: true}
+}
 
-	return tokens
+// termFromToken muodostaa term-solmun; lainaamattoman sanan alussa voi olla vertailuoperaattori.
+func termFromToken(t searchToken) *searchNode {
+	node := &searchNode{Kind: searchNodeTerm, Value: t.Value, Raw: t.Value}
+	if t.Type == stQuoted {
+		return node
+	}
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if strings.HasPrefix(t.Value, op) && len(t.Value) > len(op) {
+			node.Operator = op
+			node.Value = t.Value[len(op):]
+			break
+		}
+	}
+	return node
 }
@@ -0,0 +1,101 @@
+package gt_1_row_read
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// formatSearchNode kirjoittaa AST:n tiiviiksi merkkijonoksi vertailua varten.
+func formatSearchNode(node *searchNode) string {
+	if node == nil {
+		return "<nil>"
+	}
+	switch node.Kind {
+	case searchNodeAll:
+		return "*"
+	case searchNodeNot:
+		return "NOT(" + formatSearchNode(node.Children[0]) + ")"
+	case searchNodeAnd, searchNodeOr:
+		name := "AND"
+		if node.Kind == searchNodeOr {
+			name = "OR"
+		}
+		parts := make([]string, len(node.Children))
+		for i, child := range node.Children {
+			parts[i] = formatSearchNode(child)
+		}
+		return name + "(" + strings.Join(parts, " ") + ")"
+	default:
+		term := node.Operator + "[" + node.Value + "]"
+		if node.Field != "" {
+			term = node.Field + ":" + term
+		}
+		return term
+	}
+}
+
+func TestParseSearchExpression(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "  ", "<nil>"},
+		{"all", "*", "*"},
+		{"single word", "karhu", "[karhu]"},
+		{"implicit and", "foo bar", "AND([foo] [bar])"},
+		{"and binds tighter", "foo OR bar AND baz", "OR([foo] AND([bar] [baz]))"},
+		{"parentheses", "(foo OR bar) -baz", "AND(OR([foo] [bar]) NOT([baz]))"},
+		{"quoted phrase split", `"bar baz"`, "AND([bar] [baz])"},
+		{"negated phrase excludes each word", `-"bar baz"`, "AND(NOT([bar]) NOT([baz]))"},
+		{"not equal phrase", `!="bar baz"`, "AND(NOT([bar]) NOT([baz]))"},
+		{"field phrase", `status:"in progress"`, "AND(status:[in] status:[progress])"},
+		{"quoted single word", `'foo'`, "[foo]"},
+		{"not empty", `!=""`, "NOT([])"},
+		{"negative number", "-5", "[-5]"},
+		{"negative decimal in field", "price:-5.5", "price:[-5.5]"},
+		{"negation still works", "-foo", "NOT([foo])"},
+		{"operator", "price:>=10", "price:>=[10]"},
+		{"hyphen inside word", "e-mail", "[e-mail]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseSearchExpression(tt.input)
+			if err != nil {
+				t.Fatalf("parseSearchExpression(%q) error: %v", tt.input, err)
+			}
+			if got := formatSearchNode(node); got != tt.want {
+				t.Errorf("parseSearchExpression(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unclosed paren", "(foo"},
+		{"extra paren", "foo)"},
+		{"unclosed quote", `"foo`},
+		{"too deep parens", strings.Repeat("(", maxSearchDepth+1) + "a" + strings.Repeat(")", maxSearchDepth+1)},
+		{"too many negations", strings.Repeat("NOT ", maxSearchDepth+1) + "a"},
+		{"huge input", strings.Repeat("(", 100000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseSearchExpression(tt.input)
+			var parseErr *searchParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("parseSearchExpression() = %s, %v, want searchParseError", formatSearchNode(node), err)
+			}
+		})
+	}
+
+	deepest := strings.Repeat("(", maxSearchDepth) + "a" + strings.Repeat(")", maxSearchDepth)
+	if _, err := parseSearchExpression(deepest); err != nil {
+		t.Errorf("parseSearchExpression(depth %d) error: %v", maxSearchDepth, err)
+	}
+}
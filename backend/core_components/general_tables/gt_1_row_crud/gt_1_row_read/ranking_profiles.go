@@ -0,0 +1,278 @@
+// file: ranking_profiles.go
+package gt_1_row_read
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// Älykkään haun järjestys määritellään taulukohtaisella profiililla
+// (search_ranking_profiles). Jokaiselle ehdokasriville lasketaan osapisteet:
+//
+//	semantic = 1 / (1 + vektorietäisyys)      (0, jos ei semanttista osumaa)
+//	text     = ts_rank                        (0, jos ei tekstiosumaa)
+//	gps      = 1 / (1 + etäisyys km)          (0, jos sijaintia ei tiedetä)
+//	boost    = sarakkeen numeroarvo           (boolean 1/0) jokaiselle boost-sarakkeelle
+//
+// Kokonaispiste on osapisteiden painotettu summa. Jos semantic_threshold on asetettu,
+// rivit, joiden vektorietäisyys on enintään kynnys, tulevat ensin ("near") ja muut
+// niiden jälkeen ("far"); kummankin ryhmän sisällä järjestys on kokonaispisteen mukaan.
+// Oletusprofiili (kynnys 0.70, vain gps-paino) tuottaa aiemman kiinteän järjestyksen:
+// lähellä merkitykseltään olevat ensin, kumpikin ryhmä GPS-etäisyyden mukaan.
+
+// rankingProfile on taulun järjestysprofiili.
+type rankingProfile struct {
+	TableName         string             `json:"table_name"`
+	SemanticThreshold *float64           `json:"semantic_threshold"`
+	SemanticWeight    float64            `json:"semantic_weight"`
+	TextWeight        float64            `json:"text_weight"`
+	GpsWeight         float64            `json:"gps_weight"`
+	BoostColumns      map[string]float64 `json:"boost_columns"`
+	SemanticLimit     int                `json:"semantic_limit"`
+	TextLimit         int                `json:"text_limit"`
+	IsDefault         bool               `json:"is_default"`
+}
+
+const (
+	defaultSemanticThreshold = 0.70
+	defaultRankingLimit      = 10
+)
+
+// defaultRankingProfile vastaa aiempaa kiinteää järjestystä.
+func defaultRankingProfile(tableName string) rankingProfile {
+	threshold := defaultSemanticThreshold
+	return rankingProfile{
+		TableName:         tableName,
+		SemanticThreshold: &threshold,
+		GpsWeight:         1,
+		BoostColumns:      map[string]float64{},
+		SemanticLimit:     defaultRankingLimit,
+		TextLimit:         defaultRankingLimit,
+		IsDefault:         true,
+	}
+}
+
+// EnsureRankingProfilesTable luo search_ranking_profiles-taulun, jos sitä ei vielä ole.
+// Sarakkeiden oletusarvot vastaavat oletusprofiilia.
+func EnsureRankingProfilesTable() error {
+	_, err := backend.Db.Exec(`
+		CREATE TABLE IF NOT EXISTS search_ranking_profiles (
+			table_name TEXT PRIMARY KEY,
+			semantic_threshold DOUBLE PRECISION DEFAULT 0.70,
+			semantic_weight DOUBLE PRECISION NOT NULL DEFAULT 0,
+			text_weight DOUBLE PRECISION NOT NULL DEFAULT 0,
+			gps_weight DOUBLE PRECISION NOT NULL DEFAULT 1,
+			boost_columns JSONB NOT NULL DEFAULT '{}'::jsonb,
+			semantic_limit INT NOT NULL DEFAULT 10 CHECK (semantic_limit > 0),
+			text_limit INT NOT NULL DEFAULT 10 CHECK (text_limit > 0),
+			created TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("search_ranking_profiles-taulun luonti epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// getRankingProfile palauttaa taulun profiilin tai oletusprofiilin, jos taululle ei ole riviä.
+func getRankingProfile(tableName string) (rankingProfile, error) {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindRanking, tableName),
+		func() (interface{}, error) { return loadRankingProfile(tableName) },
+	)
+	if err != nil {
+		return rankingProfile{}, err
+	}
+	return cached.(rankingProfile), nil
+}
+
+func loadRankingProfile(tableName string) (rankingProfile, error) {
+	profile := rankingProfile{TableName: tableName}
+	var threshold sql.NullFloat64
+	var boostRaw []byte
+	err := backend.Db.QueryRow(`
+		SELECT semantic_threshold, semantic_weight, text_weight, gps_weight,
+		       boost_columns, semantic_limit, text_limit
+		FROM search_ranking_profiles
+		WHERE table_name = $1
+	`, tableName).Scan(
+		&threshold, &profile.SemanticWeight, &profile.TextWeight, &profile.GpsWeight,
+		&boostRaw, &profile.SemanticLimit, &profile.TextLimit,
+	)
+	if err == sql.ErrNoRows {
+		return defaultRankingProfile(tableName), nil
+	}
+	if err != nil {
+		return rankingProfile{}, fmt.Errorf("järjestysprofiilin haku taululle %s epäonnistui: %w", tableName, err)
+	}
+	if threshold.Valid {
+		profile.SemanticThreshold = &threshold.Float64
+	}
+	profile.BoostColumns = map[string]float64{}
+	if len(boostRaw) > 0 {
+		if err := json.Unmarshal(boostRaw, &profile.BoostColumns); err != nil {
+			return rankingProfile{}, fmt.Errorf("virheellinen boost_columns taululle %s: %w", tableName, err)
+		}
+	}
+	return profile, nil
+}
+
+// rankingCandidate on älykkään haun ehdokasrivi osumatietoineen.
+type rankingCandidate struct {
+	RowName  string
+	SemDist  float64
+	HasSem   bool
+	ExactHit bool
+	Rank     float64
+	GpsKm    float64 // math.MaxFloat64, jos sijaintia ei tiedetä
+	Boosts   map[string]float64
+}
+
+// rankingComponent on yhden osapisteen selitys.
+type rankingComponent struct {
+	Value        float64 `json:"value"`
+	Weight       float64 `json:"weight"`
+	Contribution float64 `json:"contribution"`
+}
+
+// rankingExplanation kertoo, miksi rivi on sijallaan (?explain=1).
+type rankingExplanation struct {
+	RowName    string                      `json:"row"`
+	Position   int                         `json:"position"`
+	Tier       string                      `json:"tier"`
+	Score      float64                     `json:"score"`
+	SemDist    *float64                    `json:"semantic_distance"`
+	TextRank   *float64                    `json:"text_rank"`
+	GpsKm      *float64                    `json:"gps_km"`
+	Components map[string]rankingComponent `json:"components"`
+}
+
+// explain laskee ehdokkaan osapisteet ja ryhmän profiilin mukaan.
+func (p rankingProfile) explain(c *rankingCandidate) rankingExplanation {
+	ex := rankingExplanation{
+		RowName:    c.RowName,
+		Tier:       "all",
+		Components: make(map[string]rankingComponent, 3+len(p.BoostColumns)),
+	}
+	add := func(name string, value, weight float64) {
+		contribution := value * weight
+		ex.Components[name] = rankingComponent{Value: value, Weight: weight, Contribution: contribution}
+		ex.Score += contribution
+	}
+
+	semantic := 0.0
+	if c.HasSem {
+		semantic = 1 / (1 + c.SemDist)
+		ex.SemDist = &c.SemDist
+	}
+	add("semantic", semantic, p.SemanticWeight)
+
+	text := 0.0
+	if c.ExactHit {
+		text = c.Rank
+		ex.TextRank = &c.Rank
+	}
+	add("text", text, p.TextWeight)
+
+	gps := 0.0
+	if c.GpsKm != math.MaxFloat64 {
+		gps = 1 / (1 + c.GpsKm)
+		ex.GpsKm = &c.GpsKm
+	}
+	add("gps", gps, p.GpsWeight)
+
+	for column, weight := range p.BoostColumns {
+		add("boost:"+column, c.Boosts[column], weight)
+	}
+
+	if p.SemanticThreshold != nil {
+		if c.HasSem && c.SemDist <= *p.SemanticThreshold {
+			ex.Tier = "near"
+		} else {
+			ex.Tier = "far"
+		}
+	}
+	return ex
+}
+
+// rankCandidates järjestää ehdokkaat profiilin mukaan: ensin ryhmä (near ennen far),
+// sitten kokonaispiste laskevasti ja lopuksi rivin nimi.
+func (p rankingProfile) rankCandidates(candidates map[string]*rankingCandidate) []rankingExplanation {
+	ranked := make([]rankingExplanation, 0, len(candidates))
+	for _, c := range candidates {
+		ranked = append(ranked, p.explain(c))
+	}
+	tierOrder := map[string]int{"near": 0, "all": 0, "far": 1}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if tierOrder[ranked[i].Tier] != tierOrder[ranked[j].Tier] {
+			return tierOrder[ranked[i].Tier] < tierOrder[ranked[j].Tier]
+		}
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].RowName < ranked[j].RowName
+	})
+	for i := range ranked {
+		ranked[i].Position = i + 1
+	}
+	return ranked
+}
+
+// fetchBoostValues hakee ehdokasrivien boost-sarakkeiden arvot. Numeroarvot otetaan
+// sellaisenaan, boolean on 1/0 ja muut arvot 0.
+func fetchBoostValues(db *sql.DB, tableName string, columns []string, headers []string) (map[string]map[string]float64, error) {
+	if len(columns) == 0 || len(headers) == 0 {
+		return nil, nil
+	}
+	safeTable := pq.QuoteIdentifier(tableName)
+	selectParts := ""
+	for _, col := range columns {
+		selectParts += fmt.Sprintf(", to_jsonb(%s.%s)", safeTable, pq.QuoteIdentifier(col))
+	}
+	query := fmt.Sprintf(`SELECT %s.header%s FROM %s WHERE %s.header = ANY($1::text[])`,
+		safeTable, selectParts, safeTable, safeTable)
+	rows, err := db.Query(query, pq.Array(headers))
+	if err != nil {
+		return nil, fmt.Errorf("boost-sarakkeiden haku taulusta %s epäonnistui: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	values := make(map[string]map[string]float64)
+	for rows.Next() {
+		var header string
+		raw := make([][]byte, len(columns))
+		ptrs := make([]interface{}, len(columns)+1)
+		ptrs[0] = &header
+		for i := range raw {
+			ptrs[i+1] = &raw[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		rowValues := make(map[string]float64, len(columns))
+		for i, col := range columns {
+			var v interface{}
+			if len(raw[i]) > 0 && json.Unmarshal(raw[i], &v) == nil {
+				switch typed := v.(type) {
+				case float64:
+					rowValues[col] = typed
+				case bool:
+					if typed {
+						rowValues[col] = 1
+					}
+				}
+			}
+		}
+		values[header] = rowValues
+	}
+	return values, rows.Err()
+}
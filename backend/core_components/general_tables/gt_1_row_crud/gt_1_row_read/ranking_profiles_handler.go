@@ -0,0 +1,151 @@
+// file: ranking_profiles_handler.go
+package gt_1_row_read
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// RankingProfileHandler näyttää ja muokkaa taulun älykkään haun järjestysprofiilia.
+//
+//	GET    /api/ranking-profile?table=service_catalog   -> voimassa oleva profiili (tai oletus)
+//	PUT    /api/ranking-profile?table=service_catalog   -> tallentaa profiilin (runko kuten GET-vastaus)
+//	DELETE /api/ranking-profile?table=service_catalog   -> palauttaa oletusprofiilin
+//
+// Oikeus myönnetään taulukohtaisena funktio-oikeutena kuten muillekin ylläpidon
+// endpointeille. Tallennus ja poisto tyhjentävät taulun profiilin välimuistista.
+func RankingProfileHandler(w http.ResponseWriter, r *http.Request) {
+	tableName := r.URL.Query().Get("table")
+	if tableName == "" {
+		http.Error(w, "table-parametri puuttuu", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var profile rankingProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			http.Error(w, "Virheellinen data", http.StatusBadRequest)
+			return
+		}
+		profile.TableName = tableName
+		if err := validateRankingProfile(profile); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkBoostColumns(tableName, profile.BoostColumns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := saveRankingProfile(profile); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe järjestysprofiilin tallennuksessa", http.StatusInternalServerError)
+			return
+		}
+		metadata_cache.Invalidate(metadata_cache.KindRanking, tableName)
+	case http.MethodDelete:
+		if _, err := backend.Db.Exec(`DELETE FROM search_ranking_profiles WHERE table_name = $1`, tableName); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe järjestysprofiilin poistossa", http.StatusInternalServerError)
+			return
+		}
+		metadata_cache.Invalidate(metadata_cache.KindRanking, tableName)
+	default:
+		http.Error(w, "Only GET, PUT and DELETE requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	profile, err := getRankingProfile(tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe järjestysprofiilin haussa", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// validateRankingProfile tarkistaa profiilin arvot ennen tallennusta.
+func validateRankingProfile(p rankingProfile) error {
+	if p.SemanticThreshold != nil && *p.SemanticThreshold < 0 {
+		return fmt.Errorf("semantic_threshold ei voi olla negatiivinen")
+	}
+	if p.SemanticLimit <= 0 || p.TextLimit <= 0 {
+		return fmt.Errorf("semantic_limit ja text_limit on oltava positiivisia")
+	}
+	return nil
+}
+
+// checkBoostColumns varmistaa, että boost-sarakkeet ovat taulun numero- tai boolean-sarakkeita.
+func checkBoostColumns(tableName string, boostColumns map[string]float64) error {
+	if len(boostColumns) == 0 {
+		return nil
+	}
+	rows, err := backend.Db.Query(`
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = 'public'
+		  AND table_name = $1
+		  AND data_type IN ('smallint', 'integer', 'bigint', 'numeric', 'real', 'double precision', 'boolean')
+	`, tableName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	numeric := make(map[string]bool)
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return err
+		}
+		numeric[col] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for column := range boostColumns {
+		if !numeric[column] {
+			return fmt.Errorf("boost-sarake %s ei ole taulun %s numero- tai boolean-sarake", column, tableName)
+		}
+	}
+	return nil
+}
+
+// saveRankingProfile lisää tai päivittää taulun profiilin.
+func saveRankingProfile(p rankingProfile) error {
+	boostJSON, err := json.Marshal(p.BoostColumns)
+	if err != nil {
+		return err
+	}
+	if p.BoostColumns == nil {
+		boostJSON = []byte("{}")
+	}
+	_, err = backend.Db.Exec(`
+		INSERT INTO search_ranking_profiles
+			(table_name, semantic_threshold, semantic_weight, text_weight, gps_weight,
+			 boost_columns, semantic_limit, text_limit)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (table_name) DO UPDATE SET
+			semantic_threshold = EXCLUDED.semantic_threshold,
+			semantic_weight = EXCLUDED.semantic_weight,
+			text_weight = EXCLUDED.text_weight,
+			gps_weight = EXCLUDED.gps_weight,
+			boost_columns = EXCLUDED.boost_columns,
+			semantic_limit = EXCLUDED.semantic_limit,
+			text_limit = EXCLUDED.text_limit,
+			updated = now()
+	`, p.TableName, p.SemanticThreshold, p.SemanticWeight, p.TextWeight, p.GpsWeight,
+		boostJSON, p.SemanticLimit, p.TextLimit)
+	if err != nil {
+		return fmt.Errorf("järjestysprofiilin tallennus taululle %s epäonnistui: %w", p.TableName, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package gt_1_row_read
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Tapaukset hylätään ennen tietokantaa, joten testi ei tarvitse yhteyttä.
+func TestRankingProfileHandlerRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		detail string
+	}{
+		{"missing table", http.MethodGet, "/api/ranking-profile", "", http.StatusBadRequest, "table-parametri puuttuu"},
+		{"unsupported method", http.MethodPatch, "/api/ranking-profile?table=services", "", http.StatusMethodNotAllowed, "Only GET, PUT and DELETE"},
+		{"invalid json", http.MethodPut, "/api/ranking-profile?table=services", "{", http.StatusBadRequest, "Virheellinen data"},
+		{"negative threshold", http.MethodPut, "/api/ranking-profile?table=services",
+			`{"semantic_threshold": -0.1, "semantic_limit": 10, "text_limit": 10}`, http.StatusBadRequest, "semantic_threshold"},
+		{"zero limit", http.MethodPut, "/api/ranking-profile?table=services",
+			`{"semantic_threshold": null, "semantic_limit": 0, "text_limit": 10}`, http.StatusBadRequest, "semantic_limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			RankingProfileHandler(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.detail) {
+				t.Errorf("body = %q, want it to mention %q", rec.Body.String(), tt.detail)
+			}
+		})
+	}
+}
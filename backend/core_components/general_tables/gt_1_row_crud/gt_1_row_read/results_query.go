@@ -0,0 +1,375 @@
+// file: results_query.go
+package gt_1_row_read
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_grants"
+	"easelect/backend/core_components/general_tables/gt_2_column_crud/gt_2_column_read"
+	"easelect/backend/core_components/general_tables/models"
+	"easelect/backend/core_components/general_tables/row_policies"
+	e_sessions "easelect/backend/core_components/sessions"
+)
+
+// resultsQuery sisältää GetResults-putken valmiiksi rakennetut osat:
+// käyttäjän ja roolin, sarakeoikeudet, SELECT/JOIN-osat sekä WHERE-ehdon
+// must_be_true- ja rivisääntörajauksineen. Samaa putkea käyttävät vienti, ryhmittely ym.
+type resultsQuery struct {
+	TableName          string
+	UserID             int
+	UserRole           string
+	Db                 *sql.DB
+	UserColumnSettings []UserColumnSetting
+	AllowedColumns     map[string]bool
+	ColumnsMap         map[int]models.ColumnInfo
+	ColumnsByName      map[string]models.ColumnInfo
+	SelectColumns      string
+	JoinClauses        string
+	ColumnExpressions  map[string]string
+	VirtualColumns     map[string]bool // M2M-sarakkeet, joita ei voi ryhmitellä
+	WhereClause        string
+	Args               []interface{}
+}
+
+// resultsMetaParams ovat kyselyparametreja, jotka eivät ole sarakesuodattimia.
+var resultsMetaParams = map[string]bool{
+	"list":        true,
+	"table":       true,
+	"sort_column": true,
+	"sort_order":  true,
+	"offset":      true,
+	"cursor":      true,
+	"format":      true,
+	"group_by":    true,
+	"aggregates":  true,
+	"limit":       true,
+	"exact":       true,
+	"bbox":        true,
+	"near":        true,
+	"radius_m":    true,
+	"geom":        true,
+	"zoom":        true,
+	"facets":      true,
+	"facet_limit": true,
+}
+
+// resultsQueryOptions ohjaa, mitkä sarakkeet putkeen otetaan mukaan.
+type resultsQueryOptions struct {
+	// AllAllowedColumns: true => kaikki sarakkeet, joihin roolilla on SELECT-oikeus,
+	// false => vain käyttäjän sarakeasetuksissa näkyviksi merkityt.
+	AllAllowedColumns bool
+}
+
+// getSessionUserRoleAndDb hakee käyttäjän id:n ja roolin sessiosta sekä
+// roolia vastaavan tietokantayhteyden. Virhetilanteessa vastaus on jo kirjoitettu.
+func getSessionUserRoleAndDb(w http.ResponseWriter, r *http.Request) (int, string, *sql.DB, bool) {
+	userID, err := e_sessions.GetUserIDFromSession(r)
+	if err != nil || userID <= 0 {
+		http.Error(w, "Unauthorized: tarvitset kirjautumisen", http.StatusUnauthorized)
+		return 0, "", nil, false
+	}
+
+	session, sessErr := e_sessions.GetStore().Get(r, "session")
+	if sessErr != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", sessErr.Error())
+		http.Error(w, "virhe session haussa", http.StatusInternalServerError)
+		return 0, "", nil, false
+	}
+
+	userRole, _ := session.Values["user_role"].(string)
+	if userRole == "" {
+		userRole = "guest"
+	}
+
+	roleDbMapping := map[string]*sql.DB{
+		"admin": backend.DbAdmin,
+		"basic": backend.DbBasic,
+		"guest": backend.DbGuest,
+	}
+	currentDb, found := roleDbMapping[userRole]
+	if !found {
+		currentDb = roleDbMapping["guest"]
+	}
+	return userID, userRole, currentDb, true
+}
+
+// resultsQueryError kertoo, miksi putken rakentaminen epäonnistui ja millä statuksella.
+type resultsQueryError struct {
+	Status  int
+	Message string
+}
+
+// prepareResultsQuery rakentaa GetResults-putken osat annetulle taululle.
+// Virhetilanteessa vastaus on jo kirjoitettu ja palautetaan false.
+func prepareResultsQuery(
+	w http.ResponseWriter,
+	r *http.Request,
+	tableName string,
+	queryParams url.Values,
+	opts resultsQueryOptions,
+) (*resultsQuery, bool) {
+
+	userID, userRole, currentDb, ok := getSessionUserRoleAndDb(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	rq, rqErr := buildResultsQuery(userID, userRole, currentDb, tableName, queryParams, opts)
+	if rqErr != nil {
+		http.Error(w, rqErr.Message, rqErr.Status)
+		return nil, false
+	}
+	return rq, true
+}
+
+// buildResultsQuery rakentaa putken osat jo selvitetylle käyttäjälle ja roolille.
+// Käytetään suoraan, kun samassa pyynnössä tarvitaan useamman taulun putki (esim. get-row).
+func buildResultsQuery(
+	userID int,
+	userRole string,
+	currentDb *sql.DB,
+	tableName string,
+	queryParams url.Values,
+	opts resultsQueryOptions,
+) (*resultsQuery, *resultsQueryError) {
+
+	rq := &resultsQuery{
+		TableName: tableName,
+		UserID:    userID,
+		UserRole:  userRole,
+		Db:        currentDb,
+	}
+
+	// Käyttäjän sarakeasetukset
+	userColumnSettings, err := ensureAndFetchUserColumnSettings(userID, tableName, currentDb)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &resultsQueryError{http.StatusInternalServerError, "virhe sarakeasetuksissa"}
+	}
+	rq.UserColumnSettings = userColumnSettings
+
+	// Sarakkeet, joihin roolilla on SELECT-oikeus
+	allowedColumns, err := column_grants.SelectableColumns(currentDb, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &resultsQueryError{http.StatusInternalServerError, "virhe sarakeoikeuksien haussa"}
+	}
+	rq.AllowedColumns = make(map[string]bool, len(allowedColumns))
+	for _, ac := range allowedColumns {
+		rq.AllowedColumns[ac] = true
+	}
+
+	columnsMap, err := gt_2_column_read.GetColumnsMapForTable(tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &resultsQueryError{http.StatusInternalServerError, "virhe sarakkeiden tietojen haussa"}
+	}
+	rq.ColumnsMap = columnsMap
+	rq.ColumnsByName = buildColumnsByName(columnsMap)
+
+	visibleColUids := make([]int, 0)
+	if opts.AllAllowedColumns {
+		for uid, colInfo := range columnsMap {
+			if rq.AllowedColumns[colInfo.ColumnName] {
+				visibleColUids = append(visibleColUids, uid)
+			}
+		}
+		sort.Slice(visibleColUids, func(i, j int) bool {
+			return columnsMap[visibleColUids[i]].CoNumber < columnsMap[visibleColUids[j]].CoNumber
+		})
+	} else {
+		for _, cs := range userColumnSettings {
+			if cs.IsHidden {
+				continue
+			}
+			if !rq.AllowedColumns[cs.ColumnName] {
+				continue
+			}
+			for uid, colInfo := range columnsMap {
+				if colInfo.ColumnName == cs.ColumnName {
+					visibleColUids = append(visibleColUids, uid)
+					break
+				}
+			}
+		}
+	}
+
+	// SELECT- ja JOIN-osat
+	rq.SelectColumns, rq.JoinClauses, rq.ColumnExpressions, err = buildJoinsWith1MRelations(
+		currentDb,
+		tableName,
+		columnsMap,
+		visibleColUids,
+	)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &resultsQueryError{http.StatusInternalServerError, "virhe JOIN-liitosten rakentamisessa"}
+	}
+
+	// M2M-virtuaalisarakkeet: näkyvät oletuksena, ellei käyttäjä ole piilottanut niitä
+	m2mColumns, err := fetchManyToManyVirtualColumns(currentDb, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &resultsQueryError{http.StatusInternalServerError, "virhe M2M-sarakkeiden haussa"}
+	}
+	rq.VirtualColumns = make(map[string]bool)
+	for _, vc := range m2mColumns {
+		if !rq.AllowedColumns[vc.OwnColumn] {
+			continue
+		}
+		setting, hasSetting := rq.columnSetting(vc.Name)
+		if !hasSetting {
+			setting = UserColumnSetting{ColumnName: vc.Name, SortOrder: len(rq.UserColumnSettings) + 1}
+			rq.UserColumnSettings = append(rq.UserColumnSettings, setting)
+		}
+		if setting.IsHidden && !opts.AllAllowedColumns {
+			continue
+		}
+		expr := vc.expression(tableName)
+		if rq.SelectColumns != "" {
+			rq.SelectColumns += ", "
+		}
+		rq.SelectColumns += fmt.Sprintf("%s AS %s", expr, pq.QuoteIdentifier(vc.Name))
+		rq.ColumnExpressions[vc.Name] = expr
+		rq.VirtualColumns[vc.Name] = true
+	}
+
+	// Monihyppyiset lookup-sarakkeet: liitokset lisätään aina, jotta sarakkeella
+	// voi suodattaa ja lajitella, vaikka se olisi piilotettu näkymästä
+	lookupColumns, err := fetchLookupColumns(tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &resultsQueryError{http.StatusInternalServerError, "virhe lookup-sarakkeiden haussa"}
+	}
+	readableLookups, err := readableLookupColumns(currentDb, lookupColumns)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &resultsQueryError{http.StatusInternalServerError, "virhe lookup-sarakkeiden oikeuksien haussa"}
+	}
+	for i, lc := range lookupColumns {
+		if !rq.AllowedColumns[lc.StartColumn] || !readableLookups[i] {
+			continue
+		}
+		joins, expr := lc.buildJoins(tableName, i)
+		rq.JoinClauses += " " + joins
+		rq.ColumnExpressions[lc.Name] = expr
+
+		setting, hasSetting := rq.columnSetting(lc.Name)
+		if !hasSetting {
+			setting = UserColumnSetting{ColumnName: lc.Name, SortOrder: len(rq.UserColumnSettings) + 1}
+			rq.UserColumnSettings = append(rq.UserColumnSettings, setting)
+		}
+		if setting.IsHidden && !opts.AllAllowedColumns {
+			continue
+		}
+		if rq.SelectColumns != "" {
+			rq.SelectColumns += ", "
+		}
+		rq.SelectColumns += fmt.Sprintf("%s AS %s", expr, pq.QuoteIdentifier(lc.Name))
+	}
+
+	if rqErr := rq.applyFilters(queryParams); rqErr != nil {
+		return nil, rqErr
+	}
+
+	return rq, nil
+}
+
+// applyFilters rakentaa WHERE-ehdon kyselyparametrien suodattimista ja lisää siihen
+// must_be_true- ja rivisääntörajaukset. Korvaa aiemman ehdon.
+func (rq *resultsQuery) applyFilters(queryParams url.Values) *resultsQueryError {
+	var err error
+	rq.WhereClause, rq.Args, err = buildWhereClause(
+		queryParams,
+		rq.TableName,
+		rq.ColumnsByName,
+		rq.ColumnExpressions,
+	)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return &resultsQueryError{http.StatusBadRequest, "virhe WHERE-ehdon rakentamisessa: " + err.Error()}
+	}
+
+	// must_be_true -sarakkeet suodattimeen, jos ei admin
+	mustTrueCols, err := getMustBeTrueColumns(rq.Db, rq.TableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return &resultsQueryError{http.StatusInternalServerError, "virhe must_be_true -sarakehaussa"}
+	}
+	if rq.UserRole != "admin" {
+		for _, c := range mustTrueCols {
+			rq.addCondition(fmt.Sprintf("%s.%s = TRUE", pq.QuoteIdentifier(rq.TableName), pq.QuoteIdentifier(c)))
+		}
+	}
+
+	// Käyttäjäryhmien rivitason säännöt
+	policyCond, policyArgs, err := row_policies.BuildCondition(rq.UserID, rq.TableName, pq.QuoteIdentifier(rq.TableName), rq.nextArgIdx())
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return &resultsQueryError{http.StatusInternalServerError, "virhe rivisääntöjen haussa"}
+	}
+	if policyCond != "" {
+		rq.addCondition(policyCond, policyArgs...)
+	}
+	return nil
+}
+
+// columnSetting palauttaa käyttäjän sarakeasetuksen annetulle sarakkeelle.
+func (rq *resultsQuery) columnSetting(columnName string) (UserColumnSetting, bool) {
+	for _, cs := range rq.UserColumnSettings {
+		if cs.ColumnName == columnName {
+			return cs, true
+		}
+	}
+	return UserColumnSetting{}, false
+}
+
+// addCondition lisää WHERE-ehtoon AND-ehdon.
+func (rq *resultsQuery) addCondition(cond string, args ...interface{}) {
+	if rq.WhereClause == "" {
+		rq.WhereClause = " WHERE " + cond
+	} else {
+		rq.WhereClause += " AND " + cond
+	}
+	rq.Args = append(rq.Args, args...)
+}
+
+// hasResultFilters kertoo, sisältävätkö kyselyparametrit sarakesuodattimia tai hakuja.
+func hasResultFilters(queryParams url.Values) bool {
+	for param, values := range queryParams {
+		if resultsMetaParams[param] {
+			continue
+		}
+		for _, v := range values {
+			if v != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// countResults laskee putken rajaamien rivien määrän liitoksineen. Suurissa tauluissa
+// määrä arvioidaan (ks. countRows), ellei forceExact.
+func (rq *resultsQuery) countResults(forceExact bool) (rowCountResult, error) {
+	fromClause := fmt.Sprintf(
+		"%s %s%s",
+		pq.QuoteIdentifier(rq.TableName),
+		rq.JoinClauses,
+		rq.WhereClause,
+	)
+	return countRows(rq.Db, rq.TableName, fromClause, rq.Args, rq.WhereClause != "", forceExact)
+}
+
+// nextArgIdx palauttaa seuraavan vapaan $n-parametrin indeksin.
+func (rq *resultsQuery) nextArgIdx() int {
+	return len(rq.Args) + 1
+}
@@ -0,0 +1,136 @@
+// file: row_count_estimate.go
+package gt_1_row_read
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// Suurten taulujen tarkka COUNT(*) vie sekunteja, joten rivimäärä arvioidaan, kun
+// taulussa on arviolta vähintään system_config.row_count_estimate_threshold riviä:
+//
+//	reltuples – ei ehtoja: pg_class.reltuples (ANALYZE/autovacuum ylläpitää)
+//	explain   – ehtoja (suodattimet, must_be_true, rivisäännöt): suunnittelijan
+//	            "Plan Rows" -arvio samasta kyselystä
+//
+// Kynnyksen alittavat taulut lasketaan aina tarkasti. Arvo 0 kytkee arvioinnin pois.
+
+// defaultEstimateThreshold on kynnys, jos system_config ei sitä määrää.
+const defaultEstimateThreshold = 100000
+
+// Arviointitavat rowCountResult.Method -kentässä.
+const (
+	countMethodExact     = "exact"
+	countMethodRelTuples = "reltuples"
+	countMethodExplain   = "explain"
+)
+
+// rowCountResult on laskettu tai arvioitu rivimäärä.
+type rowCountResult struct {
+	Count     int
+	Estimated bool
+	Method    string
+}
+
+// estimateThreshold lukee kynnyksen system_configista (row_count_estimate_threshold).
+func estimateThreshold(db *sql.DB) int {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindConfig, "row_count_estimate_threshold"),
+		func() (interface{}, error) {
+			var threshold sql.NullInt64
+			err := db.QueryRow(`SELECT int_value FROM system_config WHERE key = 'row_count_estimate_threshold'`).Scan(&threshold)
+			if err == sql.ErrNoRows || (err == nil && !threshold.Valid) {
+				return defaultEstimateThreshold, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return int(threshold.Int64), nil
+		},
+	)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return defaultEstimateThreshold
+	}
+	return cached.(int)
+}
+
+// countRows laskee "SELECT COUNT(*) FROM <fromClause>" -kyselyn rivimäärän tai
+// arvioi sen, jos taulu on kynnystä suurempi. hasConditions kertoo, rajaako
+// fromClause rivejä (WHERE), jolloin reltuples ei kelpaa arvioksi.
+func countRows(db *sql.DB, tableName, fromClause string, args []interface{}, hasConditions, forceExact bool) (rowCountResult, error) {
+	threshold := estimateThreshold(db)
+	if !forceExact && threshold > 0 {
+		tableEstimate, err := relTuplesEstimate(db, tableName)
+		if err != nil {
+			return rowCountResult{}, err
+		}
+		if tableEstimate >= int64(threshold) {
+			if !hasConditions {
+				return rowCountResult{Count: int(tableEstimate), Estimated: true, Method: countMethodRelTuples}, nil
+			}
+			planRows, err := explainRowEstimate(db, "SELECT 1 FROM "+fromClause, args)
+			if err != nil {
+				return rowCountResult{}, err
+			}
+			return rowCountResult{Count: planRows, Estimated: true, Method: countMethodExplain}, nil
+		}
+	}
+
+	var cnt int
+	if err := db.QueryRow("SELECT COUNT(*) FROM "+fromClause, args...).Scan(&cnt); err != nil {
+		return rowCountResult{}, fmt.Errorf("error counting rows: %w", err)
+	}
+	return rowCountResult{Count: cnt, Method: countMethodExact}, nil
+}
+
+// relTuplesEstimate palauttaa taulun arvioidun rivimäärän. Jos taulua ei ole vielä
+// analysoitu (reltuples = -1), arvio otetaan suunnittelijalta, joka laskee sen
+// taulun koosta.
+func relTuplesEstimate(db *sql.DB, tableName string) (int64, error) {
+	var reltuples float64
+	err := db.QueryRow(
+		`SELECT reltuples FROM pg_class WHERE oid = to_regclass($1)`,
+		pq.QuoteIdentifier(tableName),
+	).Scan(&reltuples)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("table %s not found", tableName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error fetching reltuples: %w", err)
+	}
+	if reltuples >= 0 {
+		return int64(reltuples), nil
+	}
+	planRows, err := explainRowEstimate(db, "SELECT 1 FROM "+pq.QuoteIdentifier(tableName), nil)
+	if err != nil {
+		return 0, err
+	}
+	return int64(planRows), nil
+}
+
+// explainRowEstimate palauttaa suunnittelijan rivimääräarvion kyselylle.
+func explainRowEstimate(db *sql.DB, query string, args []interface{}) (int, error) {
+	var raw []byte
+	if err := db.QueryRow("EXPLAIN (FORMAT JSON) "+query, args...).Scan(&raw); err != nil {
+		return 0, fmt.Errorf("error explaining count query: %w", err)
+	}
+	var plans []struct {
+		Plan struct {
+			PlanRows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return 0, fmt.Errorf("error parsing explain output: %w", err)
+	}
+	if len(plans) == 0 {
+		return 0, fmt.Errorf("empty explain output")
+	}
+	return int(plans[0].Plan.PlanRows), nil
+}
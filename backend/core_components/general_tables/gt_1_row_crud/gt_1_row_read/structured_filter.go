@@ -0,0 +1,336 @@
+// file: structured_filter.go
+package gt_1_row_read
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"easelect/backend/core_components/general_tables/models"
+)
+
+// filterNode on JSON-muotoisen suodatinpuun solmu. Solmu on joko looginen
+// (and / or / not) tai yksittäinen sarake-ehto (column + op + value).
+//
+// Esimerkki (?filter=...):
+//
+//	{"and": [
+//	  {"column": "price",   "op": "between", "value": [10, 50]},
+//	  {"column": "created", "op": "last",    "value": "30 days"},
+//	  {"or": [
+//	    {"column": "status", "op": "in",      "value": ["open", "waiting"]},
+//	    {"column": "closed", "op": "is_null"}
+//	  ]}
+//	]}
+type filterNode struct {
+	And    []filterNode    `json:"and,omitempty"`
+	Or     []filterNode    `json:"or,omitempty"`
+	Not    *filterNode     `json:"not,omitempty"`
+	Column string          `json:"column,omitempty"`
+	Op     string          `json:"op,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// Sarakkeiden tyyppiluokat, joiden mukaan sallitut operaattorit valitaan.
+const (
+	filterKindText    = "text"
+	filterKindNumeric = "numeric"
+	filterKindDate    = "date"
+	filterKindBoolean = "boolean"
+)
+
+// filterOperatorsByKind kertoo, mitkä operaattorit on sallittu kullekin tyyppiluokalle.
+// Kaikille tyypeille sallitut operaattorit ovat commonFilterOperators-listassa.
+var filterOperatorsByKind = map[string]map[string]bool{
+	filterKindText: {
+		"contains": true, "not_contains": true, "starts_with": true, "ends_with": true,
+	},
+	filterKindNumeric: {
+		"gt": true, "gte": true, "lt": true, "lte": true, "between": true,
+	},
+	filterKindDate: {
+		"gt": true, "gte": true, "lt": true, "lte": true, "between": true,
+		"last": true, "next": true, "today": true,
+	},
+	filterKindBoolean: {
+		"is_true": true, "is_false": true,
+	},
+}
+
+var commonFilterOperators = map[string]bool{
+	"eq": true, "neq": true, "in": true, "not_in": true, "is_null": true, "is_not_null": true,
+}
+
+var relativeIntervalRe = regexp.MustCompile(`^(\d{1,5})\s*(day|days|week|weeks|month|months|year|years)$`)
+
+// filterKindForDataType luokittelee PostgreSQL:n tietotyypin suodatinluokkaan.
+func filterKindForDataType(dataType string) string {
+	dt := strings.ToLower(dataType)
+	switch {
+	case isNumericDataType(dt):
+		return filterKindNumeric
+	case dt == "boolean" || dt == "bool":
+		return filterKindBoolean
+	case dt == "date" || strings.HasPrefix(dt, "timestamp"):
+		return filterKindDate
+	default:
+		return filterKindText
+	}
+}
+
+// filterBuilder kokoaa suodatinpuusta SQL-ehdon ja sen argumentit.
+type filterBuilder struct {
+	tableName         string
+	columnsByName     map[string]models.ColumnInfo
+	columnExpressions map[string]string
+	args              []interface{}
+	argIdx            int
+}
+
+// buildStructuredFilter jäsentää ?filter=-parametrin JSON-puun ja rakentaa siitä ehdon.
+// Palauttaa ehdon (tyhjä, jos puu on tyhjä), argumentit ja seuraavan vapaan indeksin.
+func buildStructuredFilter(
+	rawFilter string,
+	tableName string,
+	columnsByName map[string]models.ColumnInfo,
+	columnExpressions map[string]string,
+	argIdx int,
+) (string, []interface{}, int, error) {
+	var root filterNode
+	if err := json.Unmarshal([]byte(rawFilter), &root); err != nil {
+		return "", nil, argIdx, fmt.Errorf("virheellinen filter-JSON: %v", err)
+	}
+
+	fb := &filterBuilder{
+		tableName:         tableName,
+		columnsByName:     columnsByName,
+		columnExpressions: columnExpressions,
+		argIdx:            argIdx,
+	}
+	cond, err := fb.build(root, 0)
+	if err != nil {
+		return "", nil, argIdx, err
+	}
+	return cond, fb.args, fb.argIdx, nil
+}
+
+func (fb *filterBuilder) nextArg(v interface{}) string {
+	fb.args = append(fb.args, v)
+	placeholder := fmt.Sprintf("$%d", fb.argIdx)
+	fb.argIdx++
+	return placeholder
+}
+
+func (fb *filterBuilder) build(node filterNode, depth int) (string, error) {
+	if depth > 20 {
+		return "", fmt.Errorf("suodatinpuu on liian syvä")
+	}
+
+	switch {
+	case len(node.And) > 0 || len(node.Or) > 0:
+		children := node.And
+		joiner := " AND "
+		if len(node.Or) > 0 {
+			if len(node.And) > 0 {
+				return "", fmt.Errorf("solmussa ei voi olla sekä and- että or-listaa")
+			}
+			children = node.Or
+			joiner = " OR "
+		}
+		var parts []string
+		for _, child := range children {
+			part, err := fb.build(child, depth+1)
+			if err != nil {
+				return "", err
+			}
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 {
+			return "", nil
+		}
+		return "(" + strings.Join(parts, joiner) + ")", nil
+
+	case node.Not != nil:
+		inner, err := fb.build(*node.Not, depth+1)
+		if err != nil || inner == "" {
+			return "", err
+		}
+		return "(NOT " + inner + ")", nil
+
+	case node.Column != "":
+		return fb.buildLeaf(node)
+	}
+	return "", nil
+}
+
+// resolveColumn palauttaa sarakkeen SQL-lausekkeen ja tyyppiluokan.
+// JOINilla haetut nimisarakkeet ovat aina tekstiä.
+func (fb *filterBuilder) resolveColumn(column string) (string, string, error) {
+	if colInfo, ok := fb.columnsByName[column]; ok {
+		expr := fmt.Sprintf("%s.%s", pq.QuoteIdentifier(fb.tableName), pq.QuoteIdentifier(column))
+		if e, ok := fb.columnExpressions[column]; ok {
+			expr = e
+		}
+		return expr, filterKindForDataType(colInfo.DataType), nil
+	}
+	if expr, ok := fb.columnExpressions[column]; ok {
+		return expr, filterKindText, nil
+	}
+	return "", "", fmt.Errorf("tuntematon suodatinsarake: %s", column)
+}
+
+func (fb *filterBuilder) buildLeaf(node filterNode) (string, error) {
+	expr, kind, err := fb.resolveColumn(node.Column)
+	if err != nil {
+		return "", err
+	}
+	op := strings.ToLower(node.Op)
+	if !commonFilterOperators[op] && !filterOperatorsByKind[kind][op] {
+		return "", fmt.Errorf("operaattori '%s' ei ole sallittu sarakkeelle %s (%s)", node.Op, node.Column, kind)
+	}
+
+	switch op {
+	case "is_null":
+		return fmt.Sprintf("%s IS NULL", expr), nil
+	case "is_not_null":
+		return fmt.Sprintf("%s IS NOT NULL", expr), nil
+	case "is_true":
+		return fmt.Sprintf("%s IS TRUE", expr), nil
+	case "is_false":
+		return fmt.Sprintf("%s IS FALSE", expr), nil
+	case "today":
+		return fmt.Sprintf("(%s >= CURRENT_DATE AND %s < CURRENT_DATE + 1)", expr, expr), nil
+	}
+
+	var value interface{}
+	if len(node.Value) == 0 {
+		return "", fmt.Errorf("operaattori '%s' vaatii arvon (sarake %s)", node.Op, node.Column)
+	}
+	if err := json.Unmarshal(node.Value, &value); err != nil {
+		return "", fmt.Errorf("virheellinen arvo sarakkeelle %s: %v", node.Column, err)
+	}
+
+	switch op {
+	case "eq", "neq", "gt", "gte", "lt", "lte":
+		scalar, err := filterScalar(value, node.Column)
+		if err != nil {
+			return "", err
+		}
+		sqlOp := map[string]string{"eq": "=", "neq": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}[op]
+		if kind == filterKindText && (op == "eq" || op == "neq") {
+			// Tekstivertailu tehdään tekstinä, jotta myös JOIN-nimisarakkeet toimivat
+			return fmt.Sprintf("%s::text %s %s", expr, sqlOp, fb.nextArg(scalar)), nil
+		}
+		return fmt.Sprintf("%s %s %s", expr, sqlOp, fb.nextArg(scalar)), nil
+
+	case "between":
+		list, ok := value.([]interface{})
+		if !ok || len(list) != 2 {
+			return "", fmt.Errorf("between vaatii kaksialkioisen listan (sarake %s)", node.Column)
+		}
+		var parts []string
+		if list[0] != nil {
+			low, err := filterScalar(list[0], node.Column)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("%s >= %s", expr, fb.nextArg(low)))
+		}
+		if list[1] != nil {
+			high, err := filterScalar(list[1], node.Column)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("%s <= %s", expr, fb.nextArg(high)))
+		}
+		if len(parts) == 0 {
+			return "", nil
+		}
+		return "(" + strings.Join(parts, " AND ") + ")", nil
+
+	case "in", "not_in":
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return "", fmt.Errorf("%s vaatii ei-tyhjän listan (sarake %s)", op, node.Column)
+		}
+		strValues := make([]string, 0, len(list))
+		for _, item := range list {
+			scalar, err := filterScalar(item, node.Column)
+			if err != nil {
+				return "", err
+			}
+			strValues = append(strValues, scalar)
+		}
+		target := expr
+		if kind == filterKindText {
+			target = expr + "::text"
+		}
+		if op == "in" {
+			return fmt.Sprintf("%s = ANY(%s)", target, fb.nextArg(pq.Array(strValues))), nil
+		}
+		return fmt.Sprintf("NOT (%s = ANY(%s))", target, fb.nextArg(pq.Array(strValues))), nil
+
+	case "contains", "not_contains", "starts_with", "ends_with":
+		scalar, err := filterScalar(value, node.Column)
+		if err != nil {
+			return "", err
+		}
+		escaped := escapeLikePattern(scalar)
+		pattern := map[string]string{
+			"contains":     "%" + escaped + "%",
+			"not_contains": "%" + escaped + "%",
+			"starts_with":  escaped + "%",
+			"ends_with":    "%" + escaped,
+		}[op]
+		if op == "not_contains" {
+			return fmt.Sprintf("(%s IS NULL OR %s::text NOT ILIKE %s)", expr, expr, fb.nextArg(pattern)), nil
+		}
+		return fmt.Sprintf("%s::text ILIKE %s", expr, fb.nextArg(pattern)), nil
+
+	case "last", "next":
+		interval, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("%s vaatii aikavälin, esim. \"30 days\" (sarake %s)", op, node.Column)
+		}
+		interval = strings.ToLower(strings.TrimSpace(interval))
+		if !relativeIntervalRe.MatchString(interval) {
+			return "", fmt.Errorf("virheellinen aikaväli '%s' (sarake %s)", interval, node.Column)
+		}
+		if op == "last" {
+			return fmt.Sprintf("(%s >= now() - %s::interval AND %s <= now())", expr, fb.nextArg(interval), expr), nil
+		}
+		return fmt.Sprintf("(%s >= now() AND %s <= now() + %s::interval)", expr, expr, fb.nextArg(interval)), nil
+	}
+
+	return "", fmt.Errorf("tuntematon operaattori: %s", node.Op)
+}
+
+// filterScalar muuntaa JSON-skalaarin merkkijonoksi, jonka PostgreSQL tulkitsee
+// sarakkeen tyypin mukaan. Listat ja oliot hylätään.
+func filterScalar(v interface{}, column string) (string, error) {
+	switch typed := v.(type) {
+	case string:
+		return typed, nil
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64), nil
+	case bool:
+		if typed {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	return "", fmt.Errorf("virheellinen arvo sarakkeelle %s", column)
+}
+
+// escapeLikePattern suojaa LIKE-erikoismerkit, jotta haku on kirjaimellinen.
+func escapeLikePattern(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `%`, `\%`)
+	return strings.ReplaceAll(s, `_`, `\_`)
+}
@@ -0,0 +1,144 @@
+package gt_1_row_read
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+
+	"easelect/backend/core_components/general_tables/models"
+)
+
+func TestFilterKindForDataType(t *testing.T) {
+	tests := []struct {
+		dataType string
+		want     string
+	}{
+		{"integer", filterKindNumeric},
+		{"numeric", filterKindNumeric},
+		{"boolean", filterKindBoolean},
+		{"date", filterKindDate},
+		{"timestamp with time zone", filterKindDate},
+		{"character varying", filterKindText},
+		{"jsonb", filterKindText},
+	}
+	for _, tt := range tests {
+		if got := filterKindForDataType(tt.dataType); got != tt.want {
+			t.Errorf("filterKindForDataType(%q) = %s, want %s", tt.dataType, got, tt.want)
+		}
+	}
+}
+
+func TestBuildStructuredFilter(t *testing.T) {
+	columns := map[string]models.ColumnInfo{
+		"name":        {ColumnName: "name", DataType: "character varying"},
+		"price":       {ColumnName: "price", DataType: "numeric"},
+		"created":     {ColumnName: "created", DataType: "timestamp with time zone"},
+		"active":      {ColumnName: "active", DataType: "boolean"},
+		"customer_id": {ColumnName: "customer_id", DataType: "integer"},
+	}
+	expressions := map[string]string{
+		"customer_id":     `"customers_1"."name"`,
+		"region (lookup)": `"lookup0_1"."name"`,
+	}
+
+	tests := []struct {
+		name     string
+		filter   string
+		want     string
+		wantArgs []interface{}
+		wantErr  string
+	}{
+		{
+			name:     "between and relative date",
+			filter:   `{"and": [{"column": "price", "op": "between", "value": [10, 50.5]}, {"column": "created", "op": "last", "value": "30 Days"}]}`,
+			want:     `(("t"."price" >= $2 AND "t"."price" <= $3) AND ("t"."created" >= now() - $4::interval AND "t"."created" <= now()))`,
+			wantArgs: []interface{}{"10", "50.5", "30 days"},
+		},
+		{
+			name:     "open-ended between",
+			filter:   `{"column": "price", "op": "between", "value": [null, 5]}`,
+			want:     `("t"."price" <= $2)`,
+			wantArgs: []interface{}{"5"},
+		},
+		{
+			name:     "or with in and is_null",
+			filter:   `{"or": [{"column": "name", "op": "in", "value": ["a", "b"]}, {"column": "created", "op": "is_null"}]}`,
+			want:     `("t"."name"::text = ANY($2) OR "t"."created" IS NULL)`,
+			wantArgs: []interface{}{pq.Array([]string{"a", "b"})},
+		},
+		{
+			name:     "not contains escapes like",
+			filter:   `{"not": {"column": "name", "op": "contains", "value": "50%_off"}}`,
+			want:     `(NOT "t"."name"::text ILIKE $2)`,
+			wantArgs: []interface{}{`%50\%\_off%`},
+		},
+		{
+			name:     "join expression compared as text",
+			filter:   `{"column": "customer_id", "op": "eq", "value": 7}`,
+			want:     `"customers_1"."name" = $2`,
+			wantArgs: []interface{}{"7"},
+		},
+		{
+			name:     "virtual column is text",
+			filter:   `{"column": "region (lookup)", "op": "starts_with", "value": "Uusi"}`,
+			want:     `"lookup0_1"."name"::text ILIKE $2`,
+			wantArgs: []interface{}{"Uusi%"},
+		},
+		{
+			name:   "boolean",
+			filter: `{"column": "active", "op": "is_true"}`,
+			want:   `"t"."active" IS TRUE`,
+		},
+		{
+			name:   "empty tree",
+			filter: `{}`,
+			want:   "",
+		},
+		{name: "invalid json", filter: `{"and": [`, wantErr: "virheellinen filter-JSON"},
+		{name: "unknown column", filter: `{"column": "salary", "op": "eq", "value": 1}`, wantErr: "tuntematon suodatinsarake"},
+		{name: "operator not allowed for kind", filter: `{"column": "name", "op": "gt", "value": "a"}`, wantErr: "ei ole sallittu"},
+		{name: "and with or", filter: `{"and": [{"column": "active", "op": "is_true"}], "or": [{"column": "active", "op": "is_false"}]}`, wantErr: "sekä and- että or"},
+		{name: "missing value", filter: `{"column": "price", "op": "gt"}`, wantErr: "vaatii arvon"},
+		{name: "between needs two", filter: `{"column": "price", "op": "between", "value": [1]}`, wantErr: "kaksialkioisen"},
+		{name: "empty in", filter: `{"column": "name", "op": "in", "value": []}`, wantErr: "ei-tyhjän listan"},
+		{name: "object value", filter: `{"column": "price", "op": "eq", "value": {"x": 1}}`, wantErr: "virheellinen arvo"},
+		{name: "bad interval", filter: `{"column": "created", "op": "next", "value": "3 fortnights"}`, wantErr: "virheellinen aikaväli"},
+		{name: "too deep", filter: strings.Repeat(`{"not": `, 22) + `{"column": "active", "op": "is_true"}` + strings.Repeat("}", 22), wantErr: "liian syvä"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, next, err := buildStructuredFilter(tt.filter, "t", columns, expressions, 2)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("buildStructuredFilter() = %q, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("buildStructuredFilter() = %q %#v, want %q %#v", got, args, tt.want, tt.wantArgs)
+			}
+			if want := 2 + len(tt.wantArgs); next != want {
+				t.Errorf("next arg index = %d, want %d", next, want)
+			}
+		})
+	}
+}
+
+func TestEscapeLikePattern(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", "plain"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`c:\dir`, `c:\\dir`},
+	}
+	for _, tt := range tests {
+		if got := escapeLikePattern(tt.in); got != tt.want {
+			t.Errorf("escapeLikePattern(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
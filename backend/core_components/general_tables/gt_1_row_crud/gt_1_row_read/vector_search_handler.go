@@ -5,20 +5,22 @@ import (
 	"context"
 	"database/sql" // tarvitaan *sql.DB
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
 	"github.com/lib/pq"
 	pgvector "github.com/pgvector/pgvector-go"
-	"github.com/sashabaranov/go-openai"
 
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/embeddings"
 	"easelect/backend/core_components/general_tables/gt_2_column_crud/gt_2_column_read"
 	"easelect/backend/core_components/general_tables/models"
+	"easelect/backend/core_components/general_tables/row_policies"
+	"easelect/backend/core_components/general_tables/row_version"
 	e_sessions "easelect/backend/core_components/sessions"
 )
 
@@ -135,6 +137,14 @@ func GetResultsVector(response_writer http.ResponseWriter, request *http.Request
 		http.Error(response_writer, "virhe WHERE-ehdon rakentamisessa", http.StatusInternalServerError)
 		return
 	}
+	// Käyttäjäryhmien rivitason säännöt
+	user_id, _ := e_sessions.GetUserIDFromSession(request)
+	where_clause, query_args, err = row_policies.AppendCondition(where_clause, query_args, user_id, table_name)
+	if err != nil {
+		log.Printf("\033[31mvirhe rivisääntöjen haussa: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "virhe rivisääntöjen haussa", http.StatusInternalServerError)
+		return
+	}
 	order_by_clause, err := buildOrderByClause(
 		request.URL.Query(),
 		table_name,
@@ -182,7 +192,12 @@ func GetResultsVector(response_writer http.ResponseWriter, request *http.Request
 			return
 		}
 
-		vectorVal, embErr := generateVectorParam(vector_query)
+		vectorVal, embErr := generateVectorParam(table_name, vector_query)
+		if errors.Is(embErr, embeddings.ErrModelMismatch) {
+			log.Printf("\033[31mvirhe generateVectorParam: %s\033[0m\n", embErr.Error())
+			http.Error(response_writer, embErr.Error(), http.StatusConflict)
+			return
+		}
 		if embErr != nil {
 			log.Printf("\033[31mvirhe generateVectorParam: %s\033[0m\n", embErr.Error())
 			http.Error(response_writer, fmt.Sprintf("virhe generateVectorParam: %v", embErr), http.StatusInternalServerError)
@@ -201,8 +216,9 @@ func GetResultsVector(response_writer http.ResponseWriter, request *http.Request
 	// ------------------------------------------------
 	// 8. Kootaan lopullinen SQL-kysely
 	query := fmt.Sprintf(
-		"SELECT %s FROM %s %s%s%s LIMIT %d OFFSET %d",
+		"SELECT %s%s FROM %s %s%s%s LIMIT %d OFFSET %d",
 		select_columns,
+		row_version.SelectColumn(pq.QuoteIdentifier(table_name)),
 		pq.QuoteIdentifier(table_name),
 		join_clauses,
 		where_clause,
@@ -279,7 +295,7 @@ func GetResultsVector(response_writer http.ResponseWriter, request *http.Request
 
 	// 10. Palautetaan tulokset JSON-muodossa
 	response_data := map[string]interface{}{
-		"columns":        result_columns,
+		"columns":        row_version.StripColumn(result_columns),
 		"data":           query_results,
 		"types":          column_data_types,
 		"resultsPerLoad": results_per_load,
@@ -293,34 +309,18 @@ func GetResultsVector(response_writer http.ResponseWriter, request *http.Request
 	}
 }
 
-// generateVectorParam hakee openai-embeddingin ja rakentaa pgvector.Vectorin
-func generateVectorParam(queryText string) (pgvector.Vector, error) {
-	openaiKey := os.Getenv("OPENAI_API_KEY")
-	if openaiKey == "" {
-		return pgvector.Vector{}, fmt.Errorf("missing OPENAI_API_KEY")
-	}
-	embeddingModel := os.Getenv("OPENAI_EMBEDDING_MODEL")
-	if embeddingModel == "" {
-		embeddingModel = "text-embedding-ada-002"
-	}
-
+// generateVectorParam muodostaa hakutekstin vektorin ympäristön embedding-palvelulla ja
+// tarkistaa, että taulun openai_embedding-sarake on tehty samalla mallilla.
+func generateVectorParam(tableName, queryText string) (pgvector.Vector, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	client := openai.NewClient(openaiKey)
-	embedReq := openai.EmbeddingRequest{
-		Model: openai.EmbeddingModel(embeddingModel),
-		Input: []string{queryText},
-	}
-	embedResp, err := client.CreateEmbeddings(ctx, embedReq)
+	vectorVal, provider, err := embeddings.EmbedText(ctx, queryText)
 	if err != nil {
-		return pgvector.Vector{}, fmt.Errorf("openai embedding error: %w", err)
+		return pgvector.Vector{}, err
 	}
-	if len(embedResp.Data) == 0 {
-		return pgvector.Vector{}, fmt.Errorf("embedding returned no data")
+	if err := embeddings.CheckColumnModel(tableName, "openai_embedding", provider, len(vectorVal.Slice())); err != nil {
+		return pgvector.Vector{}, err
 	}
-
-	float32Slice := embedResp.Data[0].Embedding
-	vectorVal := pgvector.NewVector(float32Slice)
 	return vectorVal, nil
 }
@@ -0,0 +1,206 @@
+// revert_row_change.go
+package gt_1_row_update
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_rules"
+	"easelect/backend/core_components/general_tables/row_audit"
+	"easelect/backend/core_components/general_tables/row_key"
+	e_sessions "easelect/backend/core_components/sessions"
+)
+
+// RevertRowChangeHandler peruu yhden historiaan kirjatun päivityksen.
+//
+//	POST /api/revert-row-change?table=customers
+//	{"audit_id": 123, "force": false}
+//
+// Peruminen palauttaa muuttuneiden sarakkeiden before-arvot tavallisen päivityspolun
+// kautta, joten sarakkeen muokattavuus, rooli ja rivitason säännöt pätevät kuten
+// UpdateRowHandlerissa. Kaikki sarakkeet palautetaan yhdellä päivityksellä samassa
+// transaktiossa rivin lukituksen ja historiakirjauksen kanssa. Jos sarakkeen arvo on muuttunut perumattoman muutoksen jälkeen,
+// palautetaan 409, ellei force=true. Muokkauskelvottomat sarakkeet (esim. triggerin
+// ylläpitämä updated) ohitetaan ja listataan vastauksessa.
+func RevertRowChangeHandler(response_writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(response_writer, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tableName := request.URL.Query().Get("table")
+	if tableName == "" {
+		http.Error(response_writer, "Missing ?table= parameter", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := e_sessions.GetUserIDFromSession(request)
+	if err != nil || userID <= 0 {
+		http.Error(response_writer, "Unauthorized: tarvitset kirjautumisen", http.StatusUnauthorized)
+		return
+	}
+	currentDb, sessErr := e_sessions.GetRoleDbFromSession(request)
+	if sessErr != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", sessErr.Error())
+		http.Error(response_writer, "virhe session haussa", http.StatusInternalServerError)
+		return
+	}
+
+	var revertRequest struct {
+		AuditID int64 `json:"audit_id"`
+		Force   bool  `json:"force"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&revertRequest); err != nil || revertRequest.AuditID <= 0 {
+		http.Error(response_writer, "audit_id is required", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := row_audit.GetEntry(revertRequest.AuditID)
+	if err == sql.ErrNoRows {
+		http.Error(response_writer, "Audit entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error fetching audit entry", http.StatusInternalServerError)
+		return
+	}
+	if entry.TableName != tableName {
+		http.Error(response_writer, "Audit entry belongs to another table", http.StatusBadRequest)
+		return
+	}
+	if entry.Operation != row_audit.OperationUpdate {
+		http.Error(response_writer, "Only updates can be reverted", http.StatusBadRequest)
+		return
+	}
+	key, err := row_key.ParseString(tableName, entry.RowID)
+	if errors.Is(err, row_key.ErrInvalidKey) {
+		http.Error(response_writer, "Audit entry has an invalid row key", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error fetching primary key", http.StatusInternalServerError)
+		return
+	}
+
+	changedBefore, changedAfter, err := row_audit.ChangedColumns(entry.Before, entry.After)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error reading audit entry", http.StatusInternalServerError)
+		return
+	}
+
+	tableUID, err := getTableUID(tableName, currentDb)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error fetching table information", http.StatusInternalServerError)
+		return
+	}
+	rules, err := column_rules.ForTable(tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error fetching validation rules", http.StatusInternalServerError)
+		return
+	}
+
+	// Konfliktitarkistus, päivitys ja historia tehdään samassa transaktiossa lukitulle riville
+	tx, err := backend.Db.Begin()
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error reverting change", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	current, updErr := lockRow(tx, currentDb, tableName, key, "", userID)
+	if updErr != nil {
+		writeRowUpdateError(response_writer, updErr)
+		return
+	}
+	var currentValues map[string]json.RawMessage
+	if err := json.Unmarshal(current, &currentValues); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error reading current row", http.StatusInternalServerError)
+		return
+	}
+
+	columns := make([]string, 0, len(changedBefore))
+	for col := range changedBefore {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	var revertColumns, skippedColumns, conflictColumns []string
+	var revertValues []interface{}
+	for _, col := range columns {
+		dataType, checkErr := editableColumnDataType(currentDb, tableUID, tableName, col)
+		if checkErr != nil {
+			skippedColumns = append(skippedColumns, col)
+			continue
+		}
+		if string(currentValues[col]) != string(changedAfter[col]) {
+			conflictColumns = append(conflictColumns, col)
+		}
+		var raw interface{}
+		if before := changedBefore[col]; len(before) > 0 {
+			if err := decodeJSON(bytes.NewReader(before), &raw); err != nil {
+				log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+				http.Error(response_writer, "Error reading audit value", http.StatusInternalServerError)
+				return
+			}
+		}
+		value, convErr := convertColumnValue(raw, dataType)
+		if convErr != nil {
+			http.Error(response_writer, fmt.Sprintf("%s (%s)", convErr.Message, col), convErr.Status)
+			return
+		}
+		revertColumns = append(revertColumns, col)
+		revertValues = append(revertValues, value)
+	}
+	if len(revertColumns) == 0 {
+		http.Error(response_writer, "Nothing to revert", http.StatusBadRequest)
+		return
+	}
+	if len(conflictColumns) > 0 && !revertRequest.Force {
+		response_writer.Header().Set("Content-Type", "application/json")
+		response_writer.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(response_writer).Encode(map[string]interface{}{
+			"message":          "Row has changed after this change",
+			"conflict_columns": conflictColumns,
+		})
+		return
+	}
+
+	// Versiota ei tarkisteta: ristiriidat on jo käsitelty sarakkeittain yllä
+	if _, updErr := updateLockedRow(tx, rules, rowUpdate{
+		TableName:       tableName,
+		Key:             key,
+		Columns:         revertColumns,
+		Values:          revertValues,
+		UserID:          userID,
+		Endpoint:        request.URL.Path,
+		RevertedAuditID: &entry.ID,
+	}, current); updErr != nil {
+		writeRowUpdateError(response_writer, updErr)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error reverting change", http.StatusInternalServerError)
+		return
+	}
+
+	response_writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(response_writer).Encode(map[string]interface{}{
+		"message":          "Change reverted successfully",
+		"reverted_columns": revertColumns,
+		"skipped_columns":  skippedColumns,
+	})
+}
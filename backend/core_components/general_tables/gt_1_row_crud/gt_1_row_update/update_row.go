@@ -4,9 +4,16 @@ package gt_1_row_update
 import (
 	"database/sql"
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_rules"
+	"easelect/backend/core_components/general_tables/row_audit"
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/general_tables/row_policies"
+	"easelect/backend/core_components/general_tables/row_version"
 	e_sessions "easelect/backend/core_components/sessions"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
@@ -67,78 +74,350 @@ func UpdateRowHandler(response_writer http.ResponseWriter, request *http.Request
 	}
 
 	// Puretaan update-pyynnön data
+	// id on pääavaimen arvo tai yhdistelmäavaimella olio {"sarake": arvo, ...}
+	// version on rivin _row_version haku- tai get-row-vastauksesta
 	var updateRequest struct {
-		ID     int64       `json:"id"`
-		Column string      `json:"column"`
-		Value  interface{} `json:"value"`
+		ID      interface{} `json:"id"`
+		Column  string      `json:"column"`
+		Value   interface{} `json:"value"`
+		Version *string     `json:"version"`
 	}
-	if err := json.NewDecoder(request.Body).Decode(&updateRequest); err != nil {
+	if err := decodeJSON(request.Body, &updateRequest); err != nil {
 		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
 		http.Error(response_writer, "Invalid request data", http.StatusBadRequest)
 		return
 	}
 
 	// Varmistetaan että on annettu ID ja sarake
-	if updateRequest.ID == 0 || updateRequest.Column == "" {
+	if updateRequest.ID == nil || updateRequest.Column == "" {
 		http.Error(response_writer, "ID and Column are required", http.StatusBadRequest)
 		return
 	}
+	if updateRequest.Version == nil || *updateRequest.Version == "" {
+		http.Error(response_writer, "Row version is required", http.StatusPreconditionRequired)
+		return
+	}
 
-	// Hae table_uid
-	tableUID, err := getTableUID(tableName, currentDb)
+	key, err := row_key.Parse(tableName, updateRequest.ID)
+	if errors.Is(err, row_key.ErrInvalidKey) {
+		http.Error(response_writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "Error fetching table information", http.StatusInternalServerError)
+		http.Error(response_writer, "Error fetching primary key", http.StatusInternalServerError)
 		return
 	}
 
-	// Tarkista, onko sarake sallittu muokattavaksi
-	editable, err := isColumnEditable(tableUID, updateRequest.Column, currentDb)
-	if err != nil {
-		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "Error checking column permissions", http.StatusInternalServerError)
+	// Päivitys kulkee yhteisen polun kautta (sarakeoikeus, tyyppimuunnos, rivisäännöt, historia)
+	newVersion, updErr := applyRowUpdate(currentDb, rowUpdate{
+		TableName: tableName,
+		Key:       key,
+		Columns:   []string{updateRequest.Column},
+		Version:   *updateRequest.Version,
+		UserID:    userID,
+		Endpoint:  request.URL.Path,
+	}, updateRequest.Value)
+	if updErr != nil {
+		writeRowUpdateError(response_writer, updErr)
 		return
 	}
-	if !editable {
-		http.Error(response_writer, "Column is not editable", http.StatusForbidden)
+
+	// Palautetaan vastaus; uusi versio seuraavaa muokkausta varten
+	response_writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(response_writer).Encode(map[string]string{
+		"message": "Row updated successfully",
+		"version": newVersion,
+	})
+}
+
+// writeRowUpdateError kirjoittaa virheen vastaukseen. Versioristiriidassa (409)
+// vastaus on JSON, jossa on rivin nykyiset arvot ja versio, ja validointivirheissä (422)
+// JSON, jossa on sarakekohtaiset virheet.
+func writeRowUpdateError(response_writer http.ResponseWriter, updErr *rowUpdateError) {
+	if len(updErr.FieldErrors) > 0 {
+		column_rules.WriteErrors(response_writer, updErr.FieldErrors)
+		return
+	}
+	if updErr.Conflict == nil {
+		http.Error(response_writer, updErr.Message, updErr.Status)
 		return
 	}
+	response_writer.Header().Set("Content-Type", "application/json")
+	response_writer.WriteHeader(updErr.Status)
+	_ = json.NewEncoder(response_writer).Encode(map[string]interface{}{
+		"message": updErr.Message,
+		"version": updErr.Conflict.Version,
+		"current": updErr.Conflict.Current,
+	})
+}
 
-	// Selvitetään sarakkeen tietotyyppi
-	dataType, err := getColumnDataType(tableName, updateRequest.Column, currentDb)
+// rowUpdate on yhden rivin päivitys. Columns ja Values ovat rinnakkaiset listat, ja arvot
+// on jo muunnettu sarakkeiden tyyppeihin. Jos Version on annettu, rivi päivitetään vain,
+// jos sen versio on yhä sama. RevertedAuditID asetetaan, kun päivitys peruu aiemman
+// muutoksen (ks. RevertRowChangeHandler).
+type rowUpdate struct {
+	TableName       string
+	Key             row_key.RowKey
+	Columns         []string
+	Values          []interface{}
+	Version         string
+	UserID          int
+	Endpoint        string
+	RevertedAuditID *int64
+}
+
+// rowUpdateError kertoo asiakkaalle palautettavan tilakoodin ja viestin.
+// Conflict on asetettu versioristiriidassa ja FieldErrors validointisääntöjen virheissä.
+type rowUpdateError struct {
+	Status      int
+	Message     string
+	Conflict    *row_version.Conflict
+	FieldErrors []column_rules.FieldError
+}
+
+func (e *rowUpdateError) Error() string {
+	return e.Message
+}
+
+// applyRowUpdate tarkistaa sarakkeen muokattavuuden, muuntaa arvon sarakkeen tyyppiin
+// ja päivittää rivin omassa transaktiossaan (ks. updateRow).
+// Palauttaa rivin uuden version.
+func applyRowUpdate(currentDb *sql.DB, upd rowUpdate, rawValue interface{}) (string, *rowUpdateError) {
+	// Hae table_uid
+	tableUID, err := getTableUID(upd.TableName, currentDb)
 	if err != nil {
 		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "Error fetching column data type", http.StatusInternalServerError)
-		return
+		return "", &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error fetching table information"}
 	}
 
-	// Muunnetaan arvo sen tietotyypin mukaisesti
-	value, err := convertValue(updateRequest.Value, dataType)
+	column := upd.Columns[0]
+	dataType, updErr := editableColumnDataType(currentDb, tableUID, upd.TableName, column)
+	if updErr != nil {
+		return "", updErr
+	}
+	value, updErr := convertColumnValue(rawValue, dataType)
+	if updErr != nil {
+		return "", updErr
+	}
+	upd.Values = []interface{}{value}
+
+	rules, err := column_rules.ForTable(upd.TableName)
 	if err != nil {
 		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "Invalid value type", http.StatusBadRequest)
-		return
+		return "", &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error fetching validation rules"}
+	}
+
+	tx, err := backend.Db.Begin()
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return "", &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error updating row"}
+	}
+	defer tx.Rollback()
+
+	newVersion, updErr := updateRow(tx, currentDb, rules, upd)
+	if updErr != nil {
+		return "", updErr
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return "", &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error updating row"}
+	}
+	return newVersion, nil
+}
+
+// updateRow lukitsee rivin, tarkistaa version ja validointisäännöt, päivittää rivin ja
+// kirjaa muutoksen historiaan samassa transaktiossa.
+// Palauttaa rivin uuden version.
+func updateRow(tx *sql.Tx, currentDb *sql.DB, rules column_rules.TableRules, upd rowUpdate) (string, *rowUpdateError) {
+	before, updErr := lockRow(tx, currentDb, upd.TableName, upd.Key, upd.Version, upd.UserID)
+	if updErr != nil {
+		return "", updErr
 	}
+	return updateLockedRow(tx, rules, upd, before)
+}
 
-	// Rakennetaan UPDATE-lause
-	query := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE id = $2",
-		pq.QuoteIdentifier(tableName),
-		pq.QuoteIdentifier(updateRequest.Column),
+// lockRow hakee rivin tilannekuvan ja lukitsee rivin (SELECT ... FOR UPDATE), jotta
+// sääntöjen ristiintarkistus ja historian before-arvo vastaavat päivitettävää riviä.
+// Rivitason säännöt rajaavat rivin: näkymätön rivi palauttaa 404. Jos version on annettu
+// ja rivin versio on muuttunut, palautetaan 409 nykyisine arvoineen.
+func lockRow(tx *sql.Tx, currentDb *sql.DB, tableName string, key row_key.RowKey, version string, userID int) (json.RawMessage, *rowUpdateError) {
+	safeTable := pq.QuoteIdentifier(tableName)
+	keyCond, keyArgs := key.Condition(safeTable, 1)
+	whereClause, queryArgs, err := row_policies.AppendCondition(" WHERE "+keyCond, keyArgs, userID, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error checking row policies"}
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s%s FOR UPDATE",
+		row_audit.SnapshotExpression(safeTable),
+		row_version.Expression(safeTable),
+		safeTable,
+		whereClause,
 	)
 
-	// Suoritetaan kysely oikeaa DB-yhteyttä vasten
-	_, err = currentDb.Exec(query, value, updateRequest.ID)
+	var before []byte
+	var currentVersion string
+	err = tx.QueryRow(query, queryArgs...).Scan(&before, &currentVersion)
+	if err == sql.ErrNoRows {
+		return nil, &rowUpdateError{Status: http.StatusNotFound, Message: "Row not found or not permitted"}
+	}
 	if err != nil {
 		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
-		http.Error(response_writer, "Error updating row", http.StatusInternalServerError)
-		return
+		return nil, &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error fetching row"}
 	}
+	if version != "" && version != currentVersion {
+		return nil, notUpdatedError(currentDb, tableName, key, version, userID)
+	}
+	return before, nil
+}
 
-	// Palautetaan vastaus
-	response_writer.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(response_writer).Encode(map[string]string{
-		"message": "Row updated successfully",
+// updateLockedRow tarkistaa muutokset validointisääntöjä vasten, päivittää lockRow:lla
+// lukitun rivin ja kirjaa muutoksen historiaan transaktiossa.
+func updateLockedRow(tx *sql.Tx, rules column_rules.TableRules, upd rowUpdate, before json.RawMessage) (string, *rowUpdateError) {
+	changes := make(map[string]interface{}, len(upd.Columns))
+	for i, column := range upd.Columns {
+		changes[column] = upd.Values[i]
+	}
+	if updErr := validateRowChanges(rules, before, changes); updErr != nil {
+		return "", updErr
+	}
+
+	safeTable := pq.QuoteIdentifier(upd.TableName)
+	setParts := make([]string, len(upd.Columns))
+	for i, column := range upd.Columns {
+		setParts[i] = fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(column), i+1)
+	}
+	keyCond, keyArgs := upd.Key.Condition(safeTable, len(upd.Columns)+1)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s RETURNING %s, %s",
+		safeTable,
+		strings.Join(setParts, ", "),
+		keyCond,
+		row_version.Expression(safeTable),
+		row_audit.SnapshotExpression(safeTable),
+	)
+
+	var newVersion string
+	var after []byte
+	err := tx.QueryRow(query, append(append([]interface{}{}, upd.Values...), keyArgs...)...).Scan(&newVersion, &after)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		message := "Error updating row"
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			message = fmt.Sprintf("Error updating row: %s", pqErr.Message)
+		}
+		return "", &rowUpdateError{Status: http.StatusBadRequest, Message: message}
+	}
+
+	err = row_audit.Record(tx, row_audit.Entry{
+		TableName:       upd.TableName,
+		RowID:           upd.Key.String(),
+		Operation:       row_audit.OperationUpdate,
+		Before:          before,
+		After:           after,
+		UserID:          upd.UserID,
+		Endpoint:        upd.Endpoint,
+		RevertedAuditID: upd.RevertedAuditID,
 	})
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return "", &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error recording row history"}
+	}
+	return newVersion, nil
+}
+
+// validateRowChanges tarkistaa muutokset sarakkeiden validointisääntöjä vasten.
+// before on rivin tilannekuva, jonka arvoihin compare-säännöt vertaavat.
+func validateRowChanges(rules column_rules.TableRules, before json.RawMessage, changes map[string]interface{}) *rowUpdateError {
+	if len(rules) == 0 {
+		return nil
+	}
+	current := map[string]interface{}{}
+	if err := json.Unmarshal(before, &current); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error reading current row"}
+	}
+	fieldErrors := rules.ValidateChanges(current, changes)
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+	return &rowUpdateError{
+		Status:      http.StatusUnprocessableEntity,
+		Message:     column_rules.ErrorMessage,
+		FieldErrors: fieldErrors,
+	}
+}
+
+// notUpdatedError selvittää, miksi rivi ei kelvannut päivitettäväksi: jos rivi näkyy
+// käyttäjälle mutta sen versio on muuttunut, palautetaan 409 nykyisine arvoineen, muuten 404.
+func notUpdatedError(currentDb *sql.DB, tableName string, key row_key.RowKey, version string, userID int) *rowUpdateError {
+	notFound := &rowUpdateError{Status: http.StatusNotFound, Message: "Row not found or not permitted"}
+	if version == "" {
+		return notFound
+	}
+	conflict, found, err := row_version.Current(currentDb, tableName, key, userID)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return notFound
+	}
+	if !found || conflict.Version == version {
+		return notFound
+	}
+	return &rowUpdateError{
+		Status:   http.StatusConflict,
+		Message:  "Row has been modified by another user",
+		Conflict: &conflict,
+	}
+}
+
+// editableColumnDataType tarkistaa, että sarake on muokattavissa, ja palauttaa sen tietotyypin.
+func editableColumnDataType(currentDb *sql.DB, tableUID int, tableName, column string) (string, *rowUpdateError) {
+	// Tarkista, onko sarake sallittu muokattavaksi
+	editable, err := isColumnEditable(tableUID, column, currentDb)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return "", &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error checking column permissions"}
+	}
+	if !editable {
+		return "", &rowUpdateError{Status: http.StatusForbidden, Message: "Column is not editable"}
+	}
+
+	// Selvitetään sarakkeen tietotyyppi
+	dataType, err := getColumnDataType(tableName, column, currentDb)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return "", &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error fetching column data type"}
+	}
+
+	// Päivitys ajetaan historian kanssa admin-yhteyden transaktiossa, joten roolin
+	// sarakekohtainen UPDATE-oikeus tarkistetaan roolin omalla yhteydellä
+	var permitted bool
+	err = currentDb.QueryRow(
+		`SELECT has_column_privilege($1, $2, 'UPDATE')`,
+		pq.QuoteIdentifier(tableName), column,
+	).Scan(&permitted)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return "", &rowUpdateError{Status: http.StatusInternalServerError, Message: "Error checking column permissions"}
+	}
+	if !permitted {
+		return "", &rowUpdateError{Status: http.StatusForbidden, Message: "Column is not editable"}
+	}
+	return dataType, nil
+}
+
+// convertColumnValue muuntaa arvon sarakkeen tietotyyppiin (null tyhjentää sarakkeen).
+func convertColumnValue(raw interface{}, dataType string) (interface{}, *rowUpdateError) {
+	if raw == nil {
+		return nil, nil
+	}
+	value, err := convertValue(raw, dataType)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		return nil, &rowUpdateError{Status: http.StatusBadRequest, Message: "Invalid value type"}
+	}
+	return value, nil
 }
 
 // getTableUID hakee system_db_tables-taulusta table_uid:in
@@ -188,13 +467,27 @@ func getColumnDataType(tableName, columnName string, db *sql.DB) (string, error)
 	return dataType, nil
 }
 
+// decodeJSON purkaa pyynnön niin, että luvut jäävät json.Number-arvoiksi. float64
+// menettäisi tarkkuuden yli 2^53:n kokonaisluvuilla (bigint-avaimet ja -arvot).
+func decodeJSON(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
 // convertValue muuntaa pyynnön arvon sarakkeen data_type:n perusteella
 func convertValue(value interface{}, dataType string) (interface{}, error) {
 	switch {
 	case strings.Contains(dataType, "integer"), strings.Contains(dataType, "bigint"), strings.Contains(dataType, "smallint"):
-		// Sallitaan float64 ja string
+		// Sallitaan luku ja string
 		var intValue int64
 		switch v := value.(type) {
+		case json.Number:
+			parsedInt, err := v.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer value")
+			}
+			intValue = parsedInt
 		case float64:
 			intValue = int64(v)
 		case string:
@@ -227,15 +520,23 @@ func convertValue(value interface{}, dataType string) (interface{}, error) {
 		if !ok {
 			return nil, fmt.Errorf("invalid date value")
 		}
-		parsedTime, err := time.Parse("2006-01-02", strValue)
-		if err != nil {
-			return nil, fmt.Errorf("invalid date format")
+		// Päivämäärä lomakkeelta tai koko aikaleima (esim. historiasta palautettu arvo)
+		for _, layout := range []string{"2006-01-02", time.RFC3339Nano, "2006-01-02T15:04:05.999999"} {
+			if parsedTime, err := time.Parse(layout, strValue); err == nil {
+				return parsedTime, nil
+			}
 		}
-		return parsedTime, nil
+		return nil, fmt.Errorf("invalid date format")
 
 	case strings.Contains(dataType, "numeric"), strings.Contains(dataType, "decimal"):
 		var floatValue float64
 		switch v := value.(type) {
+		case json.Number:
+			// Palautetaan teksti, jotta desimaalit säilyvät tarkkoina
+			if _, err := v.Float64(); err != nil {
+				return nil, fmt.Errorf("invalid numeric value")
+			}
+			return v.String(), nil
 		case float64:
 			floatValue = v
 		case string:
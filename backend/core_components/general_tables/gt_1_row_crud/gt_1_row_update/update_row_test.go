@@ -0,0 +1,54 @@
+package gt_1_row_update
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestConvertValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		dataType string
+		want     interface{}
+		wantErr  bool
+	}{
+		{name: "bigint above 2^53", value: json.Number("9007199254740993"), dataType: "bigint", want: int64(9007199254740993)},
+		{name: "integer from string", value: "-12", dataType: "integer", want: int64(-12)},
+		{name: "integer from float64", value: float64(5), dataType: "smallint", want: int64(5)},
+		{name: "integer with fraction", value: json.Number("1.5"), dataType: "integer", wantErr: true},
+		{name: "integer from bool", value: true, dataType: "integer", wantErr: true},
+		{name: "numeric keeps digits", value: json.Number("12345678901234567.89"), dataType: "numeric", want: "12345678901234567.89"},
+		{name: "numeric from string", value: "0.5", dataType: "numeric", want: 0.5},
+		{name: "numeric invalid", value: "abc", dataType: "numeric", wantErr: true},
+		{name: "boolean", value: false, dataType: "boolean", want: false},
+		{name: "text", value: "x", dataType: "character varying", want: "x"},
+		{name: "text from number", value: json.Number("1"), dataType: "text", wantErr: true},
+		{name: "date", value: "2024-02-29", dataType: "date", want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "other type as is", value: json.Number("2.5"), dataType: "double precision", want: json.Number("2.5")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertValue(tt.value, tt.dataType)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("convertValue(%v, %s) = %v, want error", tt.value, tt.dataType, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convertValue(%v, %s) error: %v", tt.value, tt.dataType, err)
+			}
+			if gotTime, ok := got.(time.Time); ok {
+				if !gotTime.Equal(tt.want.(time.Time)) {
+					t.Errorf("convertValue(%v, %s) = %v, want %v", tt.value, tt.dataType, got, tt.want)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("convertValue(%v, %s) = %#v, want %#v", tt.value, tt.dataType, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+// update_rows.go
+package gt_1_row_update
+
+import (
+	"database/sql"
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_rules"
+	"easelect/backend/core_components/general_tables/row_key"
+	e_sessions "easelect/backend/core_components/sessions"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// maxBulkUpdateRows rajaa yhden massapäivityksen rivimäärän.
+const maxBulkUpdateRows = 1000
+
+// bulkUpdateRequest on /api/update-rows -pyynnön runko. Päivitettävät rivit annetaan
+// joko ids + changes (samat muutokset kaikille) tai rows-listana (rivikohtaiset muutokset).
+// rows-listan rivillä voi olla version (_row_version), jolloin rivi päivitetään vain,
+// jos sen versio on yhä sama.
+// atomic (oletus true): jos yksikin rivi epäonnistuu, mitään ei tallenneta.
+type bulkUpdateRequest struct {
+	IDs     []interface{}          `json:"ids"`
+	Changes map[string]interface{} `json:"changes"`
+	Rows    []struct {
+		ID      interface{}            `json:"id"`
+		Changes map[string]interface{} `json:"changes"`
+		Version string                 `json:"version"`
+	} `json:"rows"`
+	Atomic *bool `json:"atomic"`
+}
+
+// bulkRowResult on yhden rivin tulos vastauksessa. OK kertoo, onnistuiko rivin päivitys;
+// atomic-tilassa muutokset on tallennettu vain, jos vastauksen committed on true.
+// Version on rivin uusi versio; versioristiriidassa (409) Current sisältää rivin
+// nykyiset arvot ja Version nykyisen version. Validointivirheissä (422) FieldErrors
+// sisältää sarakekohtaiset virheet.
+type bulkRowResult struct {
+	ID          interface{}               `json:"id"`
+	OK          bool                      `json:"ok"`
+	Status      int                       `json:"status,omitempty"`
+	Error       string                    `json:"error,omitempty"`
+	Version     string                    `json:"version,omitempty"`
+	Current     json.RawMessage           `json:"current,omitempty"`
+	FieldErrors []column_rules.FieldError `json:"field_errors,omitempty"`
+}
+
+// bulkRowUpdate on validoitu rivin päivitys.
+type bulkRowUpdate struct {
+	Key     row_key.RowKey
+	Columns []string
+	Values  []interface{}
+	Version string
+}
+
+// UpdateRowsHandler päivittää useita rivejä ja sarakkeita yhdessä transaktiossa.
+//
+//	POST /api/update-rows?table=tasks
+//	{"ids": [1, 2, 3], "changes": {"status": "done"}}
+//	{"rows": [{"id": 1, "changes": {"status": "done", "priority": 2}}], "atomic": false}
+//
+// Jokainen muutos tarkistetaan kuten UpdateRowHandlerissa (muokattavuus, tyyppimuunnos,
+// validointisäännöt, rivitason säännöt) ja kirjataan muutoshistoriaan. Jokainen rivi
+// ajetaan omassa savepointissaan: atomic-tilassa yksikin virhe peruu koko transaktion, muuten
+// onnistuneet rivit tallennetaan ja virheelliset ohitetaan. Vastaus sisältää rivikohtaiset
+// tulokset; atomic-tilan epäonnistuminen palauttaa ensimmäisen virheen statuksen.
+func UpdateRowsHandler(response_writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(response_writer, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tableName := request.URL.Query().Get("table")
+	if tableName == "" {
+		http.Error(response_writer, "Missing ?table= parameter", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := e_sessions.GetUserIDFromSession(request)
+	if err != nil || userID <= 0 {
+		http.Error(response_writer, "Unauthorized: tarvitset kirjautumisen", http.StatusUnauthorized)
+		return
+	}
+	currentDb, sessErr := e_sessions.GetRoleDbFromSession(request)
+	if sessErr != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", sessErr.Error())
+		http.Error(response_writer, "virhe session haussa", http.StatusInternalServerError)
+		return
+	}
+
+	var bulkRequest bulkUpdateRequest
+	if err := decodeJSON(request.Body, &bulkRequest); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Invalid request data", http.StatusBadRequest)
+		return
+	}
+	atomic := bulkRequest.Atomic == nil || *bulkRequest.Atomic
+
+	// Kootaan rivit yhteen muotoon
+	type rawRow struct {
+		ID      interface{}
+		Changes map[string]interface{}
+		Version string
+	}
+	var rawRows []rawRow
+	for _, id := range bulkRequest.IDs {
+		rawRows = append(rawRows, rawRow{ID: id, Changes: bulkRequest.Changes})
+	}
+	for _, row := range bulkRequest.Rows {
+		rawRows = append(rawRows, rawRow{ID: row.ID, Changes: row.Changes, Version: row.Version})
+	}
+	if len(rawRows) == 0 {
+		http.Error(response_writer, "ids+changes or rows are required", http.StatusBadRequest)
+		return
+	}
+	if len(rawRows) > maxBulkUpdateRows {
+		http.Error(response_writer, fmt.Sprintf("At most %d rows per request", maxBulkUpdateRows), http.StatusBadRequest)
+		return
+	}
+
+	tableUID, err := getTableUID(tableName, currentDb)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error fetching table information", http.StatusInternalServerError)
+		return
+	}
+
+	rules, err := column_rules.ForTable(tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(response_writer, "Error fetching validation rules", http.StatusInternalServerError)
+		return
+	}
+
+	// 1. Validointi: sarakkeen tiedot haetaan kerran saraketta kohden
+	type columnCheck struct {
+		DataType string
+		Err      *rowUpdateError
+	}
+	columnChecks := make(map[string]columnCheck)
+	results := make([]bulkRowResult, len(rawRows))
+	updates := make([]*bulkRowUpdate, len(rawRows))
+	for i, raw := range rawRows {
+		results[i] = bulkRowResult{ID: raw.ID}
+		fail := func(e *rowUpdateError) {
+			results[i].Status = e.Status
+			results[i].Error = e.Message
+			results[i].FieldErrors = e.FieldErrors
+		}
+
+		if raw.ID == nil {
+			fail(&rowUpdateError{Status: http.StatusBadRequest, Message: "ID is required"})
+			continue
+		}
+		if len(raw.Changes) == 0 {
+			fail(&rowUpdateError{Status: http.StatusBadRequest, Message: "Changes are required"})
+			continue
+		}
+		key, err := row_key.Parse(tableName, raw.ID)
+		if errors.Is(err, row_key.ErrInvalidKey) {
+			fail(&rowUpdateError{Status: http.StatusBadRequest, Message: err.Error()})
+			continue
+		}
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			fail(&rowUpdateError{Status: http.StatusInternalServerError, Message: "Error fetching primary key"})
+			continue
+		}
+		results[i].ID = key.String()
+
+		columns := make([]string, 0, len(raw.Changes))
+		for column := range raw.Changes {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		upd := &bulkRowUpdate{Key: key, Columns: columns, Values: make([]interface{}, len(columns)), Version: raw.Version}
+		var rowErr *rowUpdateError
+		for j, column := range columns {
+			check, seen := columnChecks[column]
+			if !seen {
+				check.DataType, check.Err = editableColumnDataType(currentDb, tableUID, tableName, column)
+				columnChecks[column] = check
+			}
+			if check.Err != nil {
+				rowErr = &rowUpdateError{Status: check.Err.Status, Message: fmt.Sprintf("%s: %s", column, check.Err.Message)}
+				break
+			}
+			value, convErr := convertColumnValue(raw.Changes[column], check.DataType)
+			if convErr != nil {
+				rowErr = &rowUpdateError{Status: convErr.Status, Message: fmt.Sprintf("%s: %s", column, convErr.Message)}
+				break
+			}
+			upd.Values[j] = value
+		}
+		if rowErr != nil {
+			fail(rowErr)
+			continue
+		}
+
+		updates[i] = upd
+	}
+
+	// 2. Päivitys transaktiossa, jokainen rivi omassa savepointissaan. Rivi lukitaan ja
+	// validointisäännöt tarkistetaan juuri ennen sen päivitystä, ja muutoshistoria
+	// kirjataan samassa transaktiossa.
+	committed, failed, txErr := runBulkUpdate(currentDb, tableName, userID, request.URL.Path, rules, updates, results, atomic)
+	if txErr != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", txErr.Error())
+		http.Error(response_writer, "Error updating rows", http.StatusInternalServerError)
+		return
+	}
+	updated := 0
+	if committed {
+		updated = len(results) - failed
+	}
+
+	status := http.StatusOK
+	if atomic && failed > 0 {
+		for _, res := range results {
+			if !res.OK && res.Status != 0 {
+				status = res.Status
+				break
+			}
+		}
+	}
+	response_writer.Header().Set("Content-Type", "application/json")
+	response_writer.WriteHeader(status)
+	_ = json.NewEncoder(response_writer).Encode(map[string]interface{}{
+		"atomic":    atomic,
+		"committed": committed,
+		"updated":   updated,
+		"failed":    failed,
+		"results":   results,
+	})
+}
+
+// runBulkUpdate ajaa validoidut päivitykset yhdessä admin-yhteyden transaktiossa
+// (ks. updateRow). Validoinnissa hylätyt rivit (nil) lasketaan epäonnistuneiksi;
+// atomic-tilassa transaktio perutaan, jos yksikin rivi epäonnistui. Palauttaa,
+// tallennettiinko transaktio, ja epäonnistuneiden rivien määrän.
+func runBulkUpdate(
+	currentDb *sql.DB,
+	tableName string,
+	userID int,
+	endpoint string,
+	rules column_rules.TableRules,
+	updates []*bulkRowUpdate,
+	results []bulkRowResult,
+	atomic bool,
+) (bool, int, error) {
+	failed := 0
+	for _, upd := range updates {
+		if upd == nil {
+			failed++
+		}
+	}
+	// Atomic-tilassakin kaikki rivit ajetaan, jotta vastaus kertoo jokaisen rivin virheet
+	tx, err := backend.Db.Begin()
+	if err != nil {
+		return false, failed, err
+	}
+	defer tx.Rollback()
+
+	for i, upd := range updates {
+		if upd == nil {
+			continue
+		}
+		if _, err := tx.Exec("SAVEPOINT bulk_row_update"); err != nil {
+			return false, failed, err
+		}
+		newVersion, updErr := updateRow(tx, currentDb, rules, rowUpdate{
+			TableName: tableName,
+			Key:       upd.Key,
+			Columns:   upd.Columns,
+			Values:    upd.Values,
+			Version:   upd.Version,
+			UserID:    userID,
+			Endpoint:  endpoint,
+		})
+		if updErr == nil {
+			results[i].OK = true
+			results[i].Version = newVersion
+			if _, err := tx.Exec("RELEASE SAVEPOINT bulk_row_update"); err != nil {
+				return false, failed, err
+			}
+			continue
+		}
+
+		results[i].Status = updErr.Status
+		results[i].Error = updErr.Message
+		results[i].FieldErrors = updErr.FieldErrors
+		if updErr.Conflict != nil {
+			results[i].Version = updErr.Conflict.Version
+			results[i].Current = updErr.Conflict.Current
+		}
+		failed++
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT bulk_row_update"); err != nil {
+			return false, failed, err
+		}
+	}
+
+	if atomic && failed > 0 {
+		// Perutun transaktion versioita ei ole tallennettu
+		for i := range results {
+			if results[i].OK {
+				results[i].Version = ""
+			}
+		}
+		return false, failed, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return false, failed, err
+	}
+	return true, failed, nil
+}
@@ -4,11 +4,30 @@ package gt_2_column_read
 import (
 	backend "easelect/backend/core_components"
 	"easelect/backend/core_components/general_tables/models"
+	"easelect/backend/core_components/metadata_cache"
 	"fmt"
 )
 
-// Päivitetty GetColumnsMapForTable-funktio
+// GetColumnsMapForTable palauttaa taulun sarakekartan metatietovälimuistista.
+// Palautettu kartta on kopio, joten kutsuja saa muokata sitä.
 func GetColumnsMapForTable(tableName string) (map[int]models.ColumnInfo, error) {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindColumns, tableName),
+		func() (interface{}, error) { return loadColumnsMapForTable(tableName) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	source := cached.(map[int]models.ColumnInfo)
+	columnsMap := make(map[int]models.ColumnInfo, len(source))
+	for uid, colInfo := range source {
+		columnsMap[uid] = colInfo
+	}
+	return columnsMap, nil
+}
+
+// loadColumnsMapForTable hakee sarakekartan tietokannasta
+func loadColumnsMapForTable(tableName string) (map[int]models.ColumnInfo, error) {
 	// Hae table_uid system_db_tables-taulusta
 	var tableUID int
 	err := backend.Db.QueryRow(`
@@ -4,6 +4,7 @@ package general_tables
 import (
 	"database/sql"
 	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/metadata_cache"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -23,6 +24,8 @@ func PermissionsHandler(w http.ResponseWriter, r *http.Request) {
 		getPermissions(w, r)
 	case http.MethodPost:
 		createPermissions(w, r)
+		// Välimuistissa olevat oikeustarkistukset luetaan uudelleen
+		metadata_cache.Invalidate(metadata_cache.KindPermission)
 	default:
 		http.Error(w, "metodi ei ole sallittu", http.StatusMethodNotAllowed)
 	}
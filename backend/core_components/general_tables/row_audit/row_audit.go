@@ -0,0 +1,236 @@
+// file: row_audit.go
+package row_audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/row_key"
+)
+
+// Rivimuutosten historia tallennetaan system_row_audit-tauluun. Jokaisesta lisäyksestä,
+// päivityksestä ja poistosta kirjataan rivin tila ennen ja jälkeen (JSONB), käyttäjä,
+// aika ja endpoint. Tilannekuvat otetaan admin-yhteydellä, jotta ne ovat täydellisiä;
+// lukijalle palautetaan vain sarakkeet, joihin hänen roolillaan on SELECT-oikeus.
+
+// Operaatiot system_row_audit.operation -sarakkeessa.
+const (
+	OperationInsert = "INSERT"
+	OperationUpdate = "UPDATE"
+	OperationDelete = "DELETE"
+)
+
+// snapshotExcludedColumns jätetään tilannekuvista pois (suuria tai johdettuja arvoja).
+var snapshotExcludedColumns = []string{"openai_embedding", "search_vector_simple"}
+
+// Entry on yksi historiarivi.
+type Entry struct {
+	ID              int64           `json:"id"`
+	TableName       string          `json:"table_name"`
+	RowID           string          `json:"row_id"`
+	Operation       string          `json:"operation"`
+	Before          json.RawMessage `json:"before"`
+	After           json.RawMessage `json:"after"`
+	UserID          int             `json:"user_id"`
+	Endpoint        string          `json:"endpoint"`
+	ChangedAt       time.Time       `json:"changed_at"`
+	RevertedAuditID *int64          `json:"reverted_audit_id,omitempty"`
+}
+
+// Querier kattaa sekä *sql.DB:n että *sql.Tx:n.
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// EnsureRowAuditTable luo system_row_audit-taulun, jos sitä ei vielä ole.
+func EnsureRowAuditTable() error {
+	_, err := backend.Db.Exec(`
+		CREATE TABLE IF NOT EXISTS system_row_audit (
+			id BIGSERIAL PRIMARY KEY,
+			table_name TEXT NOT NULL,
+			row_id TEXT NOT NULL,
+			operation TEXT NOT NULL CHECK (operation IN ('INSERT', 'UPDATE', 'DELETE')),
+			before JSONB,
+			after JSONB,
+			user_id INT,
+			endpoint TEXT NOT NULL DEFAULT '',
+			changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			reverted_audit_id BIGINT REFERENCES system_row_audit(id) ON DELETE SET NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("system_row_audit-taulun luonti epäonnistui: %w", err)
+	}
+	_, err = backend.Db.Exec(`
+		CREATE INDEX IF NOT EXISTS system_row_audit_row_idx
+		ON system_row_audit (table_name, row_id, changed_at)
+	`)
+	if err != nil {
+		return fmt.Errorf("system_row_audit-indeksin luonti epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// SnapshotExpression palauttaa lausekkeen, joka muuttaa taulun rivin JSONB:ksi
+// ilman snapshotExcludedColumns-sarakkeita. Käytetään myös RETURNING-osissa.
+func SnapshotExpression(tableRef string) string {
+	expr := fmt.Sprintf("to_jsonb(%s)", tableRef)
+	for _, col := range snapshotExcludedColumns {
+		expr += " - " + pq.QuoteLiteral(col)
+	}
+	return expr
+}
+
+// ColumnsSnapshotExpression palauttaa lausekkeen, joka kokoaa rivin annetuista
+// sarakkeista JSONB-olion. Toisin kuin to_jsonb(rivi), lauseke ei vaadi lukuoikeutta
+// muihin sarakkeisiin, joten sitä voi käyttää roolin yhteydellä sarakekohtaisten
+// oikeuksien kanssa. Sarakkeet jaetaan osiin, koska funktiolla on enintään 100 argumenttia.
+func ColumnsSnapshotExpression(tableRef string, columns []string) string {
+	const pairsPerCall = 50
+	var parts, pairs []string
+	for _, col := range columns {
+		if isSnapshotExcluded(col) {
+			continue
+		}
+		pairs = append(pairs, pq.QuoteLiteral(col)+", "+tableRef+"."+pq.QuoteIdentifier(col))
+		if len(pairs) == pairsPerCall {
+			parts = append(parts, "jsonb_build_object("+strings.Join(pairs, ", ")+")")
+			pairs = nil
+		}
+	}
+	if len(pairs) > 0 || len(parts) == 0 {
+		parts = append(parts, "jsonb_build_object("+strings.Join(pairs, ", ")+")")
+	}
+	return strings.Join(parts, " || ")
+}
+
+func isSnapshotExcluded(column string) bool {
+	for _, col := range snapshotExcludedColumns {
+		if col == column {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotRow hakee rivin nykyisen tilan pääavaimen perusteella. Puuttuva rivi palauttaa nil.
+func SnapshotRow(q Querier, tableName string, key row_key.RowKey) (json.RawMessage, error) {
+	safe := pq.QuoteIdentifier(tableName)
+	cond, args := key.Condition(safe, 1)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", SnapshotExpression(safe), safe, cond)
+
+	var snapshot []byte
+	err := q.QueryRow(query, args...).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rivin %s/%s tilannekuva epäonnistui: %w", tableName, key.String(), err)
+	}
+	return snapshot, nil
+}
+
+// Record kirjaa yhden muutoksen. before/after voivat olla nil (lisäys/poisto).
+func Record(q Querier, e Entry) error {
+	_, err := q.Exec(`
+		INSERT INTO system_row_audit
+			(table_name, row_id, operation, before, after, user_id, endpoint, reverted_audit_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`,
+		e.TableName,
+		e.RowID,
+		e.Operation,
+		nullableJSON(e.Before),
+		nullableJSON(e.After),
+		sql.NullInt64{Int64: int64(e.UserID), Valid: e.UserID > 0},
+		e.Endpoint,
+		e.RevertedAuditID,
+	)
+	if err != nil {
+		return fmt.Errorf("muutoshistorian kirjaus epäonnistui (%s/%s): %w", e.TableName, e.RowID, err)
+	}
+	return nil
+}
+
+// GetEntry hakee yhden historiarivin id:n perusteella.
+func GetEntry(id int64) (*Entry, error) {
+	row := backend.Db.QueryRow(`
+		SELECT id, table_name, row_id, operation, before, after,
+		       COALESCE(user_id, 0), endpoint, changed_at, reverted_audit_id
+		FROM system_row_audit
+		WHERE id = $1
+	`, id)
+	e, err := scanEntry(row)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEntry(s rowScanner) (*Entry, error) {
+	var e Entry
+	var before, after []byte
+	var reverted sql.NullInt64
+	if err := s.Scan(&e.ID, &e.TableName, &e.RowID, &e.Operation, &before, &after,
+		&e.UserID, &e.Endpoint, &e.ChangedAt, &reverted); err != nil {
+		return nil, err
+	}
+	if before != nil {
+		e.Before = before
+	}
+	if after != nil {
+		e.After = after
+	}
+	if reverted.Valid {
+		e.RevertedAuditID = &reverted.Int64
+	}
+	return &e, nil
+}
+
+// ChangedColumns palauttaa sarakkeet, joiden arvo eroaa before- ja after-tilojen välillä.
+func ChangedColumns(before, after json.RawMessage) (map[string]json.RawMessage, map[string]json.RawMessage, error) {
+	var b, a map[string]json.RawMessage
+	if len(before) > 0 {
+		if err := json.Unmarshal(before, &b); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(after) > 0 {
+		if err := json.Unmarshal(after, &a); err != nil {
+			return nil, nil, err
+		}
+	}
+	changedBefore := make(map[string]json.RawMessage)
+	changedAfter := make(map[string]json.RawMessage)
+	for col, av := range a {
+		if bv, ok := b[col]; !ok || string(bv) != string(av) {
+			changedBefore[col] = b[col]
+			changedAfter[col] = av
+		}
+	}
+	for col, bv := range b {
+		if _, ok := a[col]; !ok {
+			changedBefore[col] = bv
+			changedAfter[col] = nil
+		}
+	}
+	return changedBefore, changedAfter, nil
+}
+
+func nullableJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return []byte(raw)
+}
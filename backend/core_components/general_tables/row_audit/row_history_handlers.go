@@ -0,0 +1,249 @@
+// file: row_history_handlers.go
+package row_audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_grants"
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/general_tables/row_policies"
+	e_sessions "easelect/backend/core_components/sessions"
+)
+
+// maxHistoryEntries rajaa yhden rivin historiasta palautettavien muutosten määrän.
+const maxHistoryEntries = 500
+
+// GetRowHistoryHandler palauttaa rivin muutoshistorian uusin ensin.
+//
+//	GET /api/row-history?table=customers&id=12
+func GetRowHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	tableName, key, userID, currentDb, ok := readHistoryRequest(w, r)
+	if !ok {
+		return
+	}
+	rowID := key.String()
+
+	rows, err := backend.Db.Query(`
+		SELECT id, table_name, row_id, operation, before, after,
+		       COALESCE(user_id, 0), endpoint, changed_at, reverted_audit_id
+		FROM system_row_audit
+		WHERE table_name = $1 AND row_id = $2
+		ORDER BY changed_at DESC, id DESC
+		LIMIT $3
+	`, tableName, rowID, maxHistoryEntries)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe muutoshistorian haussa", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	allowed, err := column_grants.SelectableSet(currentDb, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe sarakeoikeuksien haussa", http.StatusInternalServerError)
+		return
+	}
+
+	history := make([]*Entry, 0)
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe muutoshistorian käsittelyssä", http.StatusInternalServerError)
+			return
+		}
+		e.Before = FilterSnapshot(e.Before, allowed)
+		e.After = FilterSnapshot(e.After, allowed)
+		history = append(history, e)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe muutoshistorian käsittelyssä", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("\033[36m[GetRowHistoryHandler] %s/%s: %d muutosta (user %d)\033[0m\n", tableName, rowID, len(history), userID)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"table":   tableName,
+		"id":      rowID,
+		"history": history,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// GetRowAsOfHandler palauttaa rivin tilan annettuna ajanhetkenä.
+//
+//	GET /api/row-history/as-of?table=customers&id=12&at=2025-03-01T12:00:00Z
+//
+// Tila on viimeisimmän ajanhetkeä edeltävän muutoksen after-arvo. Jos ennen ajanhetkeä
+// ei ole muutoksia, käytetään ensimmäisen muutoksen before-arvoa tai nykyistä riviä.
+func GetRowAsOfHandler(w http.ResponseWriter, r *http.Request) {
+	tableName, key, _, currentDb, ok := readHistoryRequest(w, r)
+	if !ok {
+		return
+	}
+	rowID := key.String()
+
+	at, err := time.Parse(time.RFC3339, r.URL.Query().Get("at"))
+	if err != nil {
+		http.Error(w, "at-parametrin on oltava RFC3339-aikaleima", http.StatusBadRequest)
+		return
+	}
+
+	var snapshot []byte
+	var source string
+	err = backend.Db.QueryRow(`
+		SELECT after
+		FROM system_row_audit
+		WHERE table_name = $1 AND row_id = $2 AND changed_at <= $3
+		ORDER BY changed_at DESC, id DESC
+		LIMIT 1
+	`, tableName, rowID, at).Scan(&snapshot)
+	switch {
+	case err == nil:
+		source = "history"
+	case err == sql.ErrNoRows:
+		// Ei muutoksia ennen ajanhetkeä: ensimmäisen muutoksen before-tila
+		err = backend.Db.QueryRow(`
+			SELECT before
+			FROM system_row_audit
+			WHERE table_name = $1 AND row_id = $2
+			ORDER BY changed_at ASC, id ASC
+			LIMIT 1
+		`, tableName, rowID).Scan(&snapshot)
+		if err == sql.ErrNoRows {
+			snapshot, err = SnapshotRow(backend.Db, tableName, key)
+			source = "current"
+		} else {
+			source = "history"
+		}
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe rivin tilan haussa", http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := column_grants.SelectableSet(currentDb, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe sarakeoikeuksien haussa", http.StatusInternalServerError)
+		return
+	}
+
+	var row interface{}
+	if filtered := FilterSnapshot(snapshot, allowed); filtered != nil {
+		row = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"table":  tableName,
+		"id":     rowID,
+		"at":     at,
+		"exists": row != nil,
+		"row":    row,
+		"source": source,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// readHistoryRequest lukee table- ja id-parametrit, käyttäjän roolin sekä tarkistaa,
+// että rivitason säännöt sallivat rivin. Virhetilanteessa vastaus on jo kirjoitettu.
+func readHistoryRequest(w http.ResponseWriter, r *http.Request) (string, row_key.RowKey, int, *sql.DB, bool) {
+	tableName := r.URL.Query().Get("table")
+	rawID := r.URL.Query().Get("id")
+	if tableName == "" || rawID == "" {
+		http.Error(w, "table- tai id-parametri puuttuu", http.StatusBadRequest)
+		return "", row_key.RowKey{}, 0, nil, false
+	}
+	// id on pääavaimen arvo tai yhdistelmäavaimella JSON-olio
+	key, err := row_key.ParseString(tableName, rawID)
+	if errors.Is(err, row_key.ErrInvalidKey) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", row_key.RowKey{}, 0, nil, false
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe pääavaimen haussa", http.StatusInternalServerError)
+		return "", row_key.RowKey{}, 0, nil, false
+	}
+
+	userID, err := e_sessions.GetUserIDFromSession(r)
+	if err != nil || userID <= 0 {
+		http.Error(w, "Unauthorized: tarvitset kirjautumisen", http.StatusUnauthorized)
+		return "", row_key.RowKey{}, 0, nil, false
+	}
+	currentDb, sessErr := e_sessions.GetRoleDbFromSession(r)
+	if sessErr != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", sessErr.Error())
+		http.Error(w, "virhe session haussa", http.StatusInternalServerError)
+		return "", row_key.RowKey{}, 0, nil, false
+	}
+
+	visible, err := rowVisibleToUser(userID, tableName, key)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe rivisääntöjen haussa", http.StatusInternalServerError)
+		return "", row_key.RowKey{}, 0, nil, false
+	}
+	if !visible {
+		http.Error(w, "403 - Forbidden (row)", http.StatusForbidden)
+		return "", row_key.RowKey{}, 0, nil, false
+	}
+	return tableName, key, userID, currentDb, true
+}
+
+// rowVisibleToUser tarkistaa rivitason säännöt. Poistetun rivin historian saa nähdä vain,
+// jos käyttäjää ei rajata taulussa lainkaan, koska säännön ehtoa ei voi enää arvioida.
+func rowVisibleToUser(userID int, tableName string, key row_key.RowKey) (bool, error) {
+	safe := pq.QuoteIdentifier(tableName)
+	keyCond, keyArgs := key.Condition(safe, 1)
+	cond, condArgs, err := row_policies.BuildCondition(userID, tableName, safe, len(keyArgs)+1)
+	if err != nil {
+		return false, err
+	}
+	if cond == "" {
+		return true, nil
+	}
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s AND %s)", safe, keyCond, cond)
+	var visible bool
+	if err := backend.Db.QueryRow(query, append(keyArgs, condArgs...)...).Scan(&visible); err != nil {
+		return false, err
+	}
+	return visible, nil
+}
+
+// FilterSnapshot poistaa tilannekuvasta sarakkeet, joita rooli ei saa lukea.
+func FilterSnapshot(snapshot json.RawMessage, allowed map[string]bool) json.RawMessage {
+	if len(snapshot) == 0 {
+		return nil
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(snapshot, &values); err != nil {
+		return nil
+	}
+	for col := range values {
+		if !allowed[col] {
+			delete(values, col)
+		}
+	}
+	filtered, err := json.Marshal(values)
+	if err != nil {
+		return nil
+	}
+	return filtered
+}
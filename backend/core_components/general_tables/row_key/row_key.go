@@ -0,0 +1,314 @@
+// file: row_key.go
+package row_key
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// Rivin tunniste luetaan taulun todellisesta pääavaimesta, joka voi olla kokonaisluku,
+// UUID, teksti tai useamman sarakkeen yhdistelmä. Asiakas antaa avaimen joko pelkkänä
+// arvona (yksisarakkeinen pääavain) tai oliona {"sarake": arvo, ...}. Arvot välitetään
+// kyselyihin tekstinä, ja Postgres muuntaa ne sarakkeen tyyppiin.
+//
+// Avaimen tekstimuoto (String) tallennetaan row_id-sarakkeisiin (muutoshistoria,
+// roskakori): yksisarakkeisella avaimella arvo sellaisenaan, yhdistelmäavaimella
+// JSON-olio pääavaimen sarakejärjestyksessä, esim. {"order_id":"12","line_no":"3"}.
+
+// ErrNoPrimaryKey palautetaan, kun taululla ei ole pääavainta eikä id-saraketta.
+var ErrNoPrimaryKey = errors.New("taululla ei ole pääavainta")
+
+// ErrInvalidKey palautetaan, kun asiakkaan antama avain ei vastaa pääavainta.
+var ErrInvalidKey = errors.New("virheellinen rivin avain")
+
+// RowKey on yhden rivin pääavain.
+type RowKey struct {
+	Columns []string
+	Values  []string
+}
+
+// PrimaryKeyColumns palauttaa taulun pääavainsarakkeet järjestyksessä. Jos taululla ei
+// ole pääavainta mutta on id-sarake, käytetään sitä kuten ennenkin.
+func PrimaryKeyColumns(tableName string) ([]string, error) {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindPrimaryKey, tableName),
+		func() (interface{}, error) { return loadPrimaryKeyColumns(tableName) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	columns := cached.([]string)
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoPrimaryKey, tableName)
+	}
+	return append([]string(nil), columns...), nil
+}
+
+func loadPrimaryKeyColumns(tableName string) ([]string, error) {
+	rows, err := backend.Db.Query(`
+		SELECT a.attname
+		FROM pg_index i
+		CROSS JOIN LATERAL unnest(i.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+		WHERE i.indrelid = to_regclass($1)
+		  AND i.indisprimary
+		ORDER BY k.ord
+	`, "public."+pq.QuoteIdentifier(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("pääavaimen haku taululle %s epäonnistui: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) > 0 {
+		return columns, nil
+	}
+
+	var hasID bool
+	err = backend.Db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = 'public' AND table_name = $1 AND column_name = 'id'
+		)
+	`, tableName).Scan(&hasID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if hasID {
+		columns = append(columns, "id")
+	}
+	return columns, nil
+}
+
+// Parse muodostaa avaimen asiakkaan antamasta JSON-arvosta: skalaari yksisarakkeiselle
+// pääavaimelle tai olio, jossa on jokainen pääavainsarake (muut kentät ohitetaan).
+// Yhdistelmäavaimen voi antaa myös JSON-olion sisältävänä merkkijonona.
+func Parse(tableName string, raw interface{}) (RowKey, error) {
+	columns, err := PrimaryKeyColumns(tableName)
+	if err != nil {
+		return RowKey{}, err
+	}
+
+	if s, ok := raw.(string); ok && len(columns) > 1 {
+		var obj map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		if err := decoder.Decode(&obj); err != nil {
+			return RowKey{}, fmt.Errorf("%w: yhdistelmäavain on annettava oliona (%s)", ErrInvalidKey, strings.Join(columns, ", "))
+		}
+		raw = obj
+	}
+
+	key := RowKey{Columns: columns, Values: make([]string, len(columns))}
+	if obj, ok := raw.(map[string]interface{}); ok {
+		for i, col := range columns {
+			value, found := obj[col]
+			if !found {
+				return RowKey{}, fmt.Errorf("%w: sarake %s puuttuu", ErrInvalidKey, col)
+			}
+			if key.Values[i], err = scalarText(value); err != nil {
+				return RowKey{}, fmt.Errorf("%w: sarake %s: %v", ErrInvalidKey, col, err)
+			}
+		}
+		return key, nil
+	}
+
+	if len(columns) > 1 {
+		return RowKey{}, fmt.Errorf("%w: yhdistelmäavain on annettava oliona (%s)", ErrInvalidKey, strings.Join(columns, ", "))
+	}
+	if key.Values[0], err = scalarText(raw); err != nil {
+		return RowKey{}, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return key, nil
+}
+
+// ParseString muodostaa avaimen tekstimuodosta (URL-parametri tai tallennettu row_id).
+func ParseString(tableName, s string) (RowKey, error) {
+	if s == "" {
+		return RowKey{}, fmt.Errorf("%w: tyhjä avain", ErrInvalidKey)
+	}
+	return Parse(tableName, s)
+}
+
+// FromSnapshot poimii avaimen rivin JSONB-tilannekuvasta (to_jsonb).
+func FromSnapshot(tableName string, snapshot map[string]json.RawMessage) (RowKey, error) {
+	columns, err := PrimaryKeyColumns(tableName)
+	if err != nil {
+		return RowKey{}, err
+	}
+	key := RowKey{Columns: columns, Values: make([]string, len(columns))}
+	for i, col := range columns {
+		raw := snapshot[col]
+		if len(raw) == 0 || string(raw) == "null" {
+			return RowKey{}, fmt.Errorf("%w: sarake %s puuttuu tilannekuvasta", ErrInvalidKey, col)
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			key.Values[i] = s
+		} else {
+			key.Values[i] = string(raw)
+		}
+	}
+	return key, nil
+}
+
+// String palauttaa avaimen tekstimuodon row_id-sarakkeita varten.
+func (k RowKey) String() string {
+	if len(k.Columns) == 1 {
+		return k.Values[0]
+	}
+	var b strings.Builder
+	b.WriteString("{")
+	for i, col := range k.Columns {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		name, _ := json.Marshal(col)
+		value, _ := json.Marshal(k.Values[i])
+		b.Write(name)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// Condition palauttaa ehdon "t.a = $n AND t.b = $n+1" ja sen parametrit. tableRef on
+// lainattu taulunimi tai alias; tyhjä jättää sarakkeet ilman etuliitettä.
+func (k RowKey) Condition(tableRef string, startIdx int) (string, []interface{}) {
+	parts := make([]string, len(k.Columns))
+	args := make([]interface{}, len(k.Columns))
+	for i, col := range k.Columns {
+		parts[i] = fmt.Sprintf("%s = $%d", columnRef(tableRef, col), startIdx+i)
+		args[i] = k.Values[i]
+	}
+	return strings.Join(parts, " AND "), args
+}
+
+// ConditionForKeys palauttaa ehdon, joka rajaa rivit annettuihin avaimiin.
+// Yksisarakkeisella avaimella ehto on IN-lista, muuten OR-ketju sulkeissa.
+func ConditionForKeys(tableRef string, keys []RowKey, startIdx int) (string, []interface{}) {
+	if len(keys) == 0 {
+		return "FALSE", nil
+	}
+	var args []interface{}
+	if len(keys[0].Columns) == 1 {
+		placeholders := make([]string, len(keys))
+		for i, key := range keys {
+			placeholders[i] = fmt.Sprintf("$%d", startIdx+i)
+			args = append(args, key.Values[0])
+		}
+		return fmt.Sprintf("%s IN (%s)", columnRef(tableRef, keys[0].Columns[0]), strings.Join(placeholders, ", ")), args
+	}
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		cond, keyArgs := key.Condition(tableRef, startIdx+len(args))
+		parts[i] = "(" + cond + ")"
+		args = append(args, keyArgs...)
+	}
+	return "(" + strings.Join(parts, " OR ") + ")", args
+}
+
+// ColumnList palauttaa sarakkeet pilkuin eroteltuna (ORDER BY -lista tai rivikonstruktori).
+func ColumnList(tableRef string, columns []string) string {
+	parts := make([]string, len(columns))
+	for i, col := range columns {
+		parts[i] = columnRef(tableRef, col)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ReturningClause palauttaa RETURNING-osan, joka palauttaa avainsarakkeet tekstinä
+// annetussa järjestyksessä.
+func ReturningClause(columns []string) string {
+	parts := make([]string, len(columns))
+	for i, col := range columns {
+		parts[i] = pq.QuoteIdentifier(col) + "::text"
+	}
+	return " RETURNING " + strings.Join(parts, ", ")
+}
+
+// InsertReturning suorittaa INSERT-lauseen (ilman RETURNING-osaa) ja palauttaa lisätyn
+// rivin pääavaimen.
+func InsertReturning(tx *sql.Tx, tableName, insertQuery string, args ...interface{}) (RowKey, error) {
+	columns, err := PrimaryKeyColumns(tableName)
+	if err != nil {
+		return RowKey{}, err
+	}
+	key := RowKey{Columns: columns, Values: make([]string, len(columns))}
+	dest := make([]interface{}, len(columns))
+	for i := range dest {
+		dest[i] = &key.Values[i]
+	}
+	if err := tx.QueryRow(insertQuery+ReturningClause(columns), args...).Scan(dest...); err != nil {
+		return RowKey{}, err
+	}
+	return key, nil
+}
+
+// SingleValue palauttaa yksisarakkeisen avaimen arvon viiteavainsarakkeeseen
+// asetettavaksi. Yhdistelmäavaimeen ei voi viitata yhdellä sarakkeella.
+func (k RowKey) SingleValue() (string, error) {
+	if len(k.Columns) != 1 {
+		return "", fmt.Errorf("%w: yhdistelmäavaimeen ei voi viitata yhdellä sarakkeella", ErrInvalidKey)
+	}
+	return k.Values[0], nil
+}
+
+// Map palauttaa avaimen sarake-arvo-pareina (esim. triggereille).
+func (k RowKey) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(k.Columns))
+	for i, col := range k.Columns {
+		m[col] = k.Values[i]
+	}
+	return m
+}
+
+func columnRef(tableRef, column string) string {
+	if tableRef == "" {
+		return pq.QuoteIdentifier(column)
+	}
+	return tableRef + "." + pq.QuoteIdentifier(column)
+}
+
+// scalarText muuntaa JSON-skalaarin tekstiksi. Kutsujan on purettava JSON UseNumber-asetuksella:
+// float64-arvo on jo pyöristetty, jos kokonaisluku on yli 2^53.
+func scalarText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", fmt.Errorf("arvo puuttuu")
+	default:
+		return "", fmt.Errorf("arvon tyyppiä %T ei tueta", value)
+	}
+}
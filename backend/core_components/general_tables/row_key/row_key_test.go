@@ -0,0 +1,188 @@
+package row_key
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// seedPrimaryKey tallentaa taulun pääavaimen välimuistiin, jottei testi tarvitse tietokantaa.
+func seedPrimaryKey(t *testing.T, tableName string, columns ...string) {
+	t.Helper()
+	_, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindPrimaryKey, tableName),
+		func() (interface{}, error) { return columns, nil },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decodeNumber purkaa JSON-arvon kuten käsittelijät (UseNumber).
+func decodeNumber(t *testing.T, raw string) interface{} {
+	t.Helper()
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestScalarText(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "string", value: "a1b2", want: "a1b2"},
+		{name: "json number above 2^53", value: json.Number("9007199254740993"), want: "9007199254740993"},
+		{name: "max bigint", value: json.Number("9223372036854775807"), want: "9223372036854775807"},
+		{name: "float64", value: float64(42), want: "42"},
+		{name: "int", value: 7, want: "7"},
+		{name: "int64", value: int64(-3), want: "-3"},
+		{name: "bool", value: true, want: "true"},
+		{name: "nil", value: nil, wantErr: true},
+		{name: "array", value: []interface{}{1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scalarText(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("scalarText(%v) = %q, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("scalarText(%v) = %q, %v, want %q", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	seedPrimaryKey(t, "test_single", "id")
+	seedPrimaryKey(t, "test_lines", "order_id", "line_no")
+
+	tests := []struct {
+		name    string
+		table   string
+		raw     string
+		want    RowKey
+		wantErr bool
+	}{
+		{
+			name:  "bigint stays exact",
+			table: "test_single",
+			raw:   `9007199254740993`,
+			want:  RowKey{Columns: []string{"id"}, Values: []string{"9007199254740993"}},
+		},
+		{
+			name:  "string key",
+			table: "test_single",
+			raw:   `"9007199254740993"`,
+			want:  RowKey{Columns: []string{"id"}, Values: []string{"9007199254740993"}},
+		},
+		{
+			name:  "object for single key",
+			table: "test_single",
+			raw:   `{"id": 5, "name": "ignored"}`,
+			want:  RowKey{Columns: []string{"id"}, Values: []string{"5"}},
+		},
+		{
+			name:  "composite object",
+			table: "test_lines",
+			raw:   `{"line_no": 3, "order_id": 9007199254740993}`,
+			want:  RowKey{Columns: []string{"order_id", "line_no"}, Values: []string{"9007199254740993", "3"}},
+		},
+		{
+			name:  "composite as JSON string",
+			table: "test_lines",
+			raw:   `"{\"order_id\": 9007199254740993, \"line_no\": \"3\"}"`,
+			want:  RowKey{Columns: []string{"order_id", "line_no"}, Values: []string{"9007199254740993", "3"}},
+		},
+		{name: "composite missing column", table: "test_lines", raw: `{"order_id": 1}`, wantErr: true},
+		{name: "composite scalar", table: "test_lines", raw: `12`, wantErr: true},
+		{name: "null value", table: "test_single", raw: `{"id": null}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.table, decodeNumber(t, tt.raw))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Fatalf("Parse(%s) = %+v, %v, want ErrInvalidKey", tt.raw, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%s) error: %v", tt.raw, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%s) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRowKeyString(t *testing.T) {
+	tests := []struct {
+		key  RowKey
+		want string
+	}{
+		{RowKey{Columns: []string{"id"}, Values: []string{"9007199254740993"}}, "9007199254740993"},
+		{RowKey{Columns: []string{"order_id", "line_no"}, Values: []string{"12", "3"}}, `{"order_id":"12","line_no":"3"}`},
+	}
+	for _, tt := range tests {
+		if got := tt.key.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestConditionForKeys(t *testing.T) {
+	single := []RowKey{
+		{Columns: []string{"id"}, Values: []string{"1"}},
+		{Columns: []string{"id"}, Values: []string{"2"}},
+	}
+	cond, args := ConditionForKeys(`"t"`, single, 3)
+	if want := `"t"."id" IN ($3, $4)`; cond != want || !reflect.DeepEqual(args, []interface{}{"1", "2"}) {
+		t.Errorf("ConditionForKeys(single) = %s %v, want %s", cond, args, want)
+	}
+
+	composite := []RowKey{
+		{Columns: []string{"a", "b"}, Values: []string{"1", "2"}},
+		{Columns: []string{"a", "b"}, Values: []string{"3", "4"}},
+	}
+	cond, args = ConditionForKeys("", composite, 1)
+	if want := `(("a" = $1 AND "b" = $2) OR ("a" = $3 AND "b" = $4))`; cond != want || len(args) != 4 {
+		t.Errorf("ConditionForKeys(composite) = %s %v, want %s", cond, args, want)
+	}
+
+	if cond, _ := ConditionForKeys("", nil, 1); cond != "FALSE" {
+		t.Errorf("ConditionForKeys(nil) = %s, want FALSE", cond)
+	}
+}
+
+func TestReturningClause(t *testing.T) {
+	if got, want := ReturningClause([]string{"order_id", "line no"}), ` RETURNING "order_id"::text, "line no"::text`; got != want {
+		t.Errorf("ReturningClause() = %s, want %s", got, want)
+	}
+}
+
+func TestSingleValue(t *testing.T) {
+	value, err := RowKey{Columns: []string{"uuid"}, Values: []string{"a1"}}.SingleValue()
+	if err != nil || value != "a1" {
+		t.Errorf("SingleValue(single) = %q, %v", value, err)
+	}
+	_, err = RowKey{Columns: []string{"a", "b"}, Values: []string{"1", "2"}}.SingleValue()
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("SingleValue(composite) err = %v, want ErrInvalidKey", err)
+	}
+}
@@ -0,0 +1,212 @@
+// file: row_policies.go
+package row_policies
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+)
+
+// Rivitason näkyvyyssäännöt tallennetaan auth_row_policies-tauluun käyttäjäryhmä- ja
+// taulukohtaisesti. Tuetut tyypit:
+//
+//	owner     – column_name = kirjautuneen käyttäjän id (esim. created_by)
+//	subquery  – column_name IN (subquery), jossa {user_id} korvataan käyttäjän id:n
+//	            $n-parametrilla, esim. "SELECT region_id FROM user_regions WHERE user_id = {user_id}"
+//
+// Käyttäjän id:tä ei koskaan kirjoiteta SQL-tekstiin, ja se sidotaan parametriksi vain,
+// jos jokin sääntö käyttää sitä. Tallennetussa alikyselyssä ei saa olla omia
+// $n-parametreja, koska ne osuisivat kutsujan kyselyn parametreihin.
+//
+// Saman ryhmän säännöt yhdistetään AND-ehdolla ja eri ryhmien säännöt OR-ehdolla.
+// Jos käyttäjä kuuluu johonkin ryhmään, jolla ei ole taululle sääntöjä, rivejä ei rajata.
+
+// PolicyTypeOwner ja PolicyTypeSubquery ovat auth_row_policies.policy_type -arvot.
+const (
+	PolicyTypeOwner    = "owner"
+	PolicyTypeSubquery = "subquery"
+)
+
+// userIDPlaceholder korvataan subquery-säännössä käyttäjän id-parametrilla.
+const userIDPlaceholder = "{user_id}"
+
+type rowPolicy struct {
+	PolicyType string
+	ColumnName string
+	Subquery   string
+}
+
+// EnsureRowPoliciesTable luo auth_row_policies-taulun, jos sitä ei vielä ole.
+func EnsureRowPoliciesTable() error {
+	_, err := backend.Db.Exec(`
+		CREATE TABLE IF NOT EXISTS auth_row_policies (
+			id SERIAL PRIMARY KEY,
+			auth_user_group_id INT NOT NULL REFERENCES auth_user_groups(id) ON DELETE CASCADE,
+			table_name TEXT NOT NULL,
+			policy_type TEXT NOT NULL CHECK (policy_type IN ('owner', 'subquery')),
+			column_name TEXT NOT NULL,
+			subquery TEXT,
+			created TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("auth_row_policies-taulun luonti epäonnistui: %w", err)
+	}
+	_, err = backend.Db.Exec(`
+		CREATE INDEX IF NOT EXISTS auth_row_policies_table_group_idx
+		ON auth_row_policies (table_name, auth_user_group_id)
+	`)
+	if err != nil {
+		return fmt.Errorf("auth_row_policies-indeksin luonti epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// BuildCondition palauttaa käyttäjän rivitason ehdon taululle. tableRef on sarakkeiden
+// eteen kirjoitettava (valmiiksi lainattu) taulun nimi tai alias ja argIdx ensimmäinen
+// vapaa $n-indeksi. Argumentteja palautetaan vain ehdon käyttämille parametreille.
+// Tyhjä ehto tarkoittaa, ettei rivejä rajata.
+func BuildCondition(userID int, tableName, tableRef string, argIdx int) (string, []interface{}, error) {
+	rows, err := backend.Db.Query(`
+		SELECT ug.group_id, p.policy_type, p.column_name, COALESCE(p.subquery, '')
+		FROM auth_user_group_memberships ug
+		LEFT JOIN auth_row_policies p
+			ON p.auth_user_group_id = ug.group_id
+			AND p.table_name = $2
+		WHERE ug.user_id = $1
+		ORDER BY ug.group_id, p.id
+	`, userID, tableName)
+	if err != nil {
+		return "", nil, fmt.Errorf("rivisääntöjen haku epäonnistui: %w", err)
+	}
+	defer rows.Close()
+
+	var groupOrder []int
+	policiesByGroup := make(map[int][]rowPolicy)
+	for rows.Next() {
+		var groupID int
+		var policyType, columnName sql.NullString
+		var subquery string
+		if err := rows.Scan(&groupID, &policyType, &columnName, &subquery); err != nil {
+			return "", nil, fmt.Errorf("rivisääntöjen luku epäonnistui: %w", err)
+		}
+		// Ryhmällä ei ole sääntöjä tälle taululle => ei rajausta
+		if !policyType.Valid {
+			return "", nil, nil
+		}
+		if _, seen := policiesByGroup[groupID]; !seen {
+			groupOrder = append(groupOrder, groupID)
+		}
+		policiesByGroup[groupID] = append(policiesByGroup[groupID], rowPolicy{
+			PolicyType: policyType.String,
+			ColumnName: columnName.String,
+			Subquery:   subquery,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("rivisääntöjen luku epäonnistui: %w", err)
+	}
+
+	// Käyttäjä ei kuulu mihinkään ryhmään: rajataan kaikki, jos taululla on sääntöjä
+	if len(groupOrder) == 0 {
+		var hasPolicies bool
+		err := backend.Db.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM auth_row_policies WHERE table_name = $1)`,
+			tableName,
+		).Scan(&hasPolicies)
+		if err != nil {
+			return "", nil, fmt.Errorf("rivisääntöjen haku epäonnistui: %w", err)
+		}
+		if hasPolicies {
+			return "FALSE", nil, nil
+		}
+		return "", nil, nil
+	}
+
+	groups := make([][]rowPolicy, len(groupOrder))
+	for i, groupID := range groupOrder {
+		groups[i] = policiesByGroup[groupID]
+	}
+	cond, args, err := policyCondition(userID, tableRef, argIdx, groups)
+	if err != nil {
+		return "", nil, fmt.Errorf("taulun %s rivisääntö: %w", tableName, err)
+	}
+	return cond, args, nil
+}
+
+// policyCondition yhdistää ryhmien säännöt ehdoksi: ryhmän säännöt AND-ehdolla ja ryhmät
+// OR-ehdolla. Käyttäjän id sidotaan $argIdx-parametriksi vain, jos jokin sääntö käyttää
+// sitä; käyttämätön parametri kaataisi kyselyn ("could not determine data type").
+func policyCondition(userID int, tableRef string, argIdx int, groups [][]rowPolicy) (string, []interface{}, error) {
+	userParam := fmt.Sprintf("$%d", argIdx)
+	usesUserID := false
+
+	var groupConds []string
+	for _, policies := range groups {
+		var policyConds []string
+		for _, p := range policies {
+			col := fmt.Sprintf("%s.%s", tableRef, pq.QuoteIdentifier(p.ColumnName))
+			switch p.PolicyType {
+			case PolicyTypeOwner:
+				policyConds = append(policyConds, fmt.Sprintf("%s = %s", col, userParam))
+				usesUserID = true
+			case PolicyTypeSubquery:
+				cond, err := subqueryCondition(col, p.Subquery, userParam)
+				if err != nil {
+					return "", nil, fmt.Errorf("subquery-sääntö: %w", err)
+				}
+				policyConds = append(policyConds, cond)
+				usesUserID = usesUserID || strings.Contains(p.Subquery, userIDPlaceholder)
+			default:
+				return "", nil, fmt.Errorf("tuntematon rivisääntötyyppi: %s", p.PolicyType)
+			}
+		}
+		groupConds = append(groupConds, "("+strings.Join(policyConds, " AND ")+")")
+	}
+
+	var args []interface{}
+	if usesUserID {
+		args = []interface{}{userID}
+	}
+	return "(" + strings.Join(groupConds, " OR ") + ")", args, nil
+}
+
+// positionalParam tunnistaa alikyselyyn kirjoitetun $n-parametrin.
+var positionalParam = regexp.MustCompile(`\$[0-9]`)
+
+// subqueryCondition muodostaa ehdon "col IN (alikysely)", jossa {user_id} on korvattu
+// userParam-parametrilla.
+func subqueryCondition(col, subquery, userParam string) (string, error) {
+	if strings.TrimSpace(subquery) == "" {
+		return "", fmt.Errorf("alikysely puuttuu")
+	}
+	if positionalParam.MatchString(subquery) {
+		return "", fmt.Errorf("alikyselyssä ei saa olla $n-parametreja, käytä %s", userIDPlaceholder)
+	}
+	sub := strings.ReplaceAll(subquery, userIDPlaceholder, userParam)
+	return fmt.Sprintf("%s IN (%s)", col, sub), nil
+}
+
+// AppendCondition lisää käyttäjän rivitason ehdon valmiiseen WHERE-lauseeseen
+// (" WHERE ..." tai tyhjä) ja palauttaa laajennetun lauseen ja argumentit.
+func AppendCondition(whereClause string, args []interface{}, userID int, tableName string) (string, []interface{}, error) {
+	cond, condArgs, err := BuildCondition(userID, tableName, pq.QuoteIdentifier(tableName), len(args)+1)
+	if err != nil {
+		return "", nil, err
+	}
+	if cond == "" {
+		return whereClause, args, nil
+	}
+	if strings.TrimSpace(whereClause) == "" {
+		whereClause = " WHERE " + cond
+	} else {
+		whereClause += " AND " + cond
+	}
+	return whereClause, append(args, condArgs...), nil
+}
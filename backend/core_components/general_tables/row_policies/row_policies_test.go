@@ -0,0 +1,184 @@
+package row_policies
+
+import (
+	"database/sql"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+func TestSubqueryCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		subquery string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "placeholder becomes parameter",
+			subquery: "SELECT region_id FROM user_regions WHERE user_id = {user_id}",
+			want:     `"t"."region_id" IN (SELECT region_id FROM user_regions WHERE user_id = $3)`,
+		},
+		{
+			name:     "placeholder used twice",
+			subquery: "SELECT id FROM teams WHERE lead_id = {user_id} OR deputy_id = {user_id}",
+			want:     `"t"."region_id" IN (SELECT id FROM teams WHERE lead_id = $3 OR deputy_id = $3)`,
+		},
+		{
+			name:     "no placeholder",
+			subquery: "SELECT id FROM public_regions",
+			want:     `"t"."region_id" IN (SELECT id FROM public_regions)`,
+		},
+		{name: "own positional parameter", subquery: "SELECT id FROM r WHERE user_id = $1", wantErr: true},
+		{name: "empty", subquery: "  ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := subqueryCondition(`"t"."region_id"`, tt.subquery, "$3")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("subqueryCondition(%q) = %q, want error", tt.subquery, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("subqueryCondition(%q) = %q, %v, want %q", tt.subquery, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// placeholderRe tunnistaa ehdon $n-parametrit.
+var placeholderRe = regexp.MustCompile(`\$([0-9]+)`)
+
+// assertParamsMatchArgs tarkistaa, että ehto viittaa täsmälleen parametreihin
+// $argIdx ... $argIdx+len(args)-1, kuten PostgreSQL vaatii.
+func assertParamsMatchArgs(t *testing.T, cond string, argIdx int, args []interface{}) {
+	t.Helper()
+	used := make(map[int]bool)
+	for _, m := range placeholderRe.FindAllStringSubmatch(cond, -1) {
+		n, _ := strconv.Atoi(m[1])
+		used[n] = true
+	}
+	for i := range args {
+		if !used[argIdx+i] {
+			t.Errorf("argument $%d is bound but not referenced in %q", argIdx+i, cond)
+		}
+	}
+	for n := range used {
+		if n < argIdx || n >= argIdx+len(args) {
+			t.Errorf("%q references $%d without a bound argument", cond, n)
+		}
+	}
+}
+
+func TestPolicyCondition(t *testing.T) {
+	owner := rowPolicy{PolicyType: PolicyTypeOwner, ColumnName: "created_by"}
+	public := rowPolicy{PolicyType: PolicyTypeSubquery, ColumnName: "region_id", Subquery: "SELECT 1 AS id"}
+	ownRegions := rowPolicy{PolicyType: PolicyTypeSubquery, ColumnName: "region_id", Subquery: "SELECT r FROM (VALUES (3), (7)) AS v(r) WHERE r = {user_id}"}
+
+	tests := []struct {
+		name     string
+		groups   [][]rowPolicy
+		want     string
+		wantArgs []interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "owner",
+			groups:   [][]rowPolicy{{owner}},
+			want:     `(("t"."created_by" = $2))`,
+			wantArgs: []interface{}{7},
+		},
+		{
+			name:   "subquery without user id binds nothing",
+			groups: [][]rowPolicy{{public}},
+			want:   `(("t"."region_id" IN (SELECT 1 AS id)))`,
+		},
+		{
+			name:     "subquery with user id",
+			groups:   [][]rowPolicy{{ownRegions}},
+			want:     `(("t"."region_id" IN (SELECT r FROM (VALUES (3), (7)) AS v(r) WHERE r = $2)))`,
+			wantArgs: []interface{}{7},
+		},
+		{
+			name:     "groups share the user id",
+			groups:   [][]rowPolicy{{owner, public}, {ownRegions}},
+			want:     `(("t"."created_by" = $2 AND "t"."region_id" IN (SELECT 1 AS id)) OR ("t"."region_id" IN (SELECT r FROM (VALUES (3), (7)) AS v(r) WHERE r = $2)))`,
+			wantArgs: []interface{}{7},
+		},
+		{
+			name:    "unknown type",
+			groups:  [][]rowPolicy{{{PolicyType: "role", ColumnName: "x"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, err := policyCondition(7, `"t"`, 2, tt.groups)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("policyCondition() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("policyCondition() = %q %v, want %q %v", got, args, tt.want, tt.wantArgs)
+			}
+			assertParamsMatchArgs(t, got, 2, args)
+		})
+	}
+}
+
+// TestPolicyConditionRunsInPostgres ajaa ehdot oikeassa tietokannassa. Ohitetaan, ellei
+// EASELECT_TEST_DATABASE_URL ole asetettu (esim. postgres://user:pw@localhost/db?sslmode=disable).
+func TestPolicyConditionRunsInPostgres(t *testing.T) {
+	dsn := os.Getenv("EASELECT_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("EASELECT_TEST_DATABASE_URL ei ole asetettu")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	owner := rowPolicy{PolicyType: PolicyTypeOwner, ColumnName: "created_by"}
+	public := rowPolicy{PolicyType: PolicyTypeSubquery, ColumnName: "region_id", Subquery: "SELECT 1 AS id"}
+	ownRegions := rowPolicy{PolicyType: PolicyTypeSubquery, ColumnName: "region_id", Subquery: "SELECT r FROM (VALUES (3), (7)) AS v(r) WHERE r = {user_id}"}
+
+	tests := []struct {
+		name   string
+		groups [][]rowPolicy
+		want   int
+	}{
+		{"owner", [][]rowPolicy{{owner}}, 2},
+		{"subquery without user id", [][]rowPolicy{{public}}, 2},
+		{"subquery with user id", [][]rowPolicy{{ownRegions}}, 1},
+		{"mixed groups", [][]rowPolicy{{public}, {owner}}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, args, err := policyCondition(7, `"t"`, 2, tt.groups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := `SELECT count(*) FROM (VALUES (1, 7, 1), (2, 8, 1), (3, 7, 7), (4, 9, 3))
+				AS t(id, created_by, region_id) WHERE "t"."id" > $1 AND ` + cond
+			var got int
+			if err := db.QueryRow(query, append([]interface{}{0}, args...)...).Scan(&got); err != nil {
+				t.Fatalf("query %q with %v: %v", query, args, err)
+			}
+			if got != tt.want {
+				t.Errorf("matching rows = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,521 @@
+// file: trash.go
+package trash
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/row_key"
+)
+
+// Roskakori: poistetut rivit ja pudotetut taulut säilytetään system_trash-taulussa,
+// josta ne voi palauttaa tai jotka siivotaan säilytysajan (system_config:
+// trash_retention_days) jälkeen.
+//
+// Rivipoistossa jokaisesta poistettavasta rivistä tallennetaan juurimerkintä ja
+// sen alle (root_id) vierasavainten kautta riippuvat rivit:
+//
+//	row   – rivin koko sisältö (to_jsonb); ON DELETE CASCADE -lapset rekursiivisesti
+//	link  – ON DELETE SET NULL -lapsen viittaus (link_column + alkuperäinen arvo),
+//	        jotta palautus voi liittää lapsen takaisin
+//
+// Pudotettu taulu siirretään trashSchema-skeemaan uudella nimellä ja sen kohdalle
+// tallennetaan muiden taulujen siihen viittaavat vierasavaimet, jotka pudotetaan
+// (kuten DROP ... CASCADE teki) ja luodaan palautuksessa uudelleen.
+
+// Merkintälajit system_trash.entry_kind -sarakkeessa.
+const (
+	EntryKindRow   = "row"
+	EntryKindLink  = "link"
+	EntryKindTable = "table"
+)
+
+// trashSchema on skeema, johon pudotetut taulut siirretään.
+const trashSchema = "easelect_trash"
+
+// defaultRetentionDays on säilytysaika, jos system_config ei sitä määrää.
+const defaultRetentionDays = 30
+
+// maxDependentDepth rajaa CASCADE-ketjujen seurannan syvyyden.
+const maxDependentDepth = 10
+
+// EnsureTrashTable luo system_trash-taulun ja roskakoriskeeman, jos niitä ei vielä ole.
+func EnsureTrashTable() error {
+	_, err := backend.Db.Exec(fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, pq.QuoteIdentifier(trashSchema)))
+	if err != nil {
+		return fmt.Errorf("roskakoriskeeman luonti epäonnistui: %w", err)
+	}
+	_, err = backend.Db.Exec(`
+		CREATE TABLE IF NOT EXISTS system_trash (
+			id BIGSERIAL PRIMARY KEY,
+			root_id BIGINT REFERENCES system_trash(id) ON DELETE CASCADE,
+			entry_kind TEXT NOT NULL CHECK (entry_kind IN ('row', 'link', 'table')),
+			table_name TEXT NOT NULL,
+			row_id TEXT NOT NULL DEFAULT '',
+			row_data JSONB,
+			link_column TEXT,
+			depth INT NOT NULL DEFAULT 0,
+			trash_table_name TEXT,
+			deleted_by INT,
+			deleted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			restored_at TIMESTAMPTZ
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("system_trash-taulun luonti epäonnistui: %w", err)
+	}
+	_, err = backend.Db.Exec(`
+		CREATE INDEX IF NOT EXISTS system_trash_table_idx
+		ON system_trash (table_name, deleted_at)
+		WHERE root_id IS NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("system_trash-indeksin luonti epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// fkDependent on vierasavain, jolla lapsitaulu viittaa poistettavaan tauluun.
+type fkDependent struct {
+	ConstraintName string
+	ChildTable     string
+	ChildColumn    string
+	ParentColumn   string
+	DeleteAction   string // confdeltype: c = cascade, n = set null, muut estävät poiston
+}
+
+// fetchDependents hakee yksisarakkeiset vierasavaimet, jotka viittaavat tauluun.
+func fetchDependents(tx *sql.Tx, tableName string) ([]fkDependent, error) {
+	rows, err := tx.Query(`
+		SELECT c.conname, cl.relname, a.attname, pa.attname, c.confdeltype::text
+		FROM pg_constraint c
+		JOIN pg_class cl ON cl.oid = c.conrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+		JOIN pg_attribute pa ON pa.attrelid = c.confrelid AND pa.attnum = c.confkey[1]
+		WHERE c.contype = 'f'
+		  AND c.confrelid = $1::regclass
+		  AND n.nspname = 'public'
+		  AND array_length(c.conkey, 1) = 1
+		ORDER BY c.conname
+	`, pq.QuoteIdentifier(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("vierasavainten haku taululle %s epäonnistui: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	var deps []fkDependent
+	for rows.Next() {
+		var d fkDependent
+		if err := rows.Scan(&d.ConstraintName, &d.ChildTable, &d.ChildColumn, &d.ParentColumn, &d.DeleteAction); err != nil {
+			return nil, err
+		}
+		deps = append(deps, d)
+	}
+	return deps, rows.Err()
+}
+
+// trashedRow on roskakoriin siirretty rivi.
+type trashedRow struct {
+	RowID string
+	Data  map[string]json.RawMessage
+	Raw   []byte
+}
+
+// MoveRowsToTrash tallentaa whereClausen rajaamat rivit riippuvuuksineen roskakoriin
+// samassa transaktiossa, jossa rivit poistetaan. Palauttaa juurimerkintöjen id:t.
+// whereClause on muotoa " WHERE ..." ja viittaa tauluun sen lainatulla nimellä.
+func MoveRowsToTrash(tx *sql.Tx, tableName, whereClause string, args []interface{}, userID int) ([]int64, error) {
+	safe := pq.QuoteIdentifier(tableName)
+	rows, err := tx.Query(
+		fmt.Sprintf("SELECT to_jsonb(%[1]s) FROM %[1]s%[2]s FOR UPDATE", safe, whereClause),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("poistettavien rivien haku epäonnistui: %w", err)
+	}
+	targets, err := scanTrashedRows(tableName, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	visited := make(map[string]bool)
+	var rootIDs []int64
+	for _, target := range targets {
+		var rootID int64
+		err := tx.QueryRow(`
+			INSERT INTO system_trash (entry_kind, table_name, row_id, row_data, deleted_by)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`, EntryKindRow, tableName, target.RowID, target.Raw, nullableUserID(userID)).Scan(&rootID)
+		if err != nil {
+			return nil, fmt.Errorf("roskakorimerkinnän luonti epäonnistui: %w", err)
+		}
+		visited[tableName+":"+target.RowID] = true
+		if err := trashDependents(tx, rootID, tableName, target, 1, userID, visited); err != nil {
+			return nil, err
+		}
+		rootIDs = append(rootIDs, rootID)
+	}
+	return rootIDs, nil
+}
+
+// trashDependents tallentaa rivin CASCADE-lapset (rekursiivisesti) ja SET NULL -linkit.
+func trashDependents(tx *sql.Tx, rootID int64, tableName string, parent trashedRow, depth, userID int, visited map[string]bool) error {
+	if depth > maxDependentDepth {
+		log.Printf("\033[31mvirhe: roskakori: riippuvuusketju taulusta %s on liian syvä\033[0m\n", tableName)
+		return nil
+	}
+	deps, err := fetchDependents(tx, tableName)
+	if err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		parentValue, ok := jsonText(parent.Data[dep.ParentColumn])
+		if !ok {
+			continue
+		}
+		switch dep.DeleteAction {
+		case "c":
+			rows, err := tx.Query(childRowsQuery(dep.ChildTable, dep.ChildColumn), parentValue)
+			if err != nil {
+				return fmt.Errorf("lapsirivien haku taulusta %s epäonnistui: %w", dep.ChildTable, err)
+			}
+			children, err := scanTrashedRows(dep.ChildTable, rows)
+			if err != nil {
+				return err
+			}
+			for _, child := range children {
+				key := dep.ChildTable + ":" + child.RowID
+				if child.RowID != "" && visited[key] {
+					continue
+				}
+				visited[key] = true
+				_, err := tx.Exec(`
+					INSERT INTO system_trash (root_id, entry_kind, table_name, row_id, row_data, depth, deleted_by)
+					VALUES ($1, $2, $3, $4, $5, $6, $7)
+				`, rootID, EntryKindRow, dep.ChildTable, child.RowID, child.Raw, depth, nullableUserID(userID))
+				if err != nil {
+					return fmt.Errorf("lapsirivin roskakorimerkintä epäonnistui: %w", err)
+				}
+				if err := trashDependents(tx, rootID, dep.ChildTable, child, depth+1, userID, visited); err != nil {
+					return err
+				}
+			}
+
+		case "n":
+			rows, err := tx.Query(childRowsQuery(dep.ChildTable, dep.ChildColumn), parentValue)
+			if err != nil {
+				return fmt.Errorf("linkitettyjen rivien haku taulusta %s epäonnistui: %w", dep.ChildTable, err)
+			}
+			linked, err := scanTrashedRows(dep.ChildTable, rows)
+			if err != nil {
+				return err
+			}
+			for _, child := range linked {
+				if child.RowID == "" {
+					continue
+				}
+				linkData, _ := json.Marshal(map[string]json.RawMessage{"value": parent.Data[dep.ParentColumn]})
+				_, err := tx.Exec(`
+					INSERT INTO system_trash (root_id, entry_kind, table_name, row_id, row_data, link_column, depth, deleted_by)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				`, rootID, EntryKindLink, dep.ChildTable, child.RowID, linkData, dep.ChildColumn, depth, nullableUserID(userID))
+				if err != nil {
+					return fmt.Errorf("linkin roskakorimerkintä epäonnistui: %w", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// childRowsQuery hakee lapsitaulun rivit, joiden viiteavain vastaa isäntärivin arvoa.
+// Parametrin tyyppi päätellään sarakkeesta, joten vertailu käyttää sarakkeen omaa
+// tyyppiä ja vierasavaimen indeksiä (tekstiksi muunnettu sarake ei käyttäisi).
+func childRowsQuery(childTable, childColumn string) string {
+	safeChild := pq.QuoteIdentifier(childTable)
+	return fmt.Sprintf("SELECT to_jsonb(%[1]s) FROM %[1]s WHERE %[1]s.%[2]s = $1", safeChild, pq.QuoteIdentifier(childColumn))
+}
+
+// MoveTableToTrash siirtää taulun roskakoriskeemaan DROP TABLE ... CASCADE -komennon sijaan.
+// Muiden taulujen siihen viittaavat vierasavaimet tallennetaan ja pudotetaan.
+func MoveTableToTrash(tx *sql.Tx, tableName string, userID int) (int64, error) {
+	var registryRow []byte
+	err := tx.QueryRow(`SELECT to_jsonb(t) FROM system_db_tables t WHERE t.table_name = $1 LIMIT 1`, tableName).Scan(&registryRow)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("taulun %s rekisteritietojen haku epäonnistui: %w", tableName, err)
+	}
+
+	// Viittaavat vierasavaimet muista tauluista
+	rows, err := tx.Query(`
+		SELECT cl.relname, c.conname, pg_get_constraintdef(c.oid)
+		FROM pg_constraint c
+		JOIN pg_class cl ON cl.oid = c.conrelid
+		WHERE c.contype = 'f'
+		  AND c.confrelid = $1::regclass
+		  AND c.conrelid <> c.confrelid
+	`, pq.QuoteIdentifier(tableName))
+	if err != nil {
+		return 0, fmt.Errorf("viittaavien vierasavainten haku epäonnistui: %w", err)
+	}
+	type savedConstraint struct {
+		Table      string `json:"table"`
+		Name       string `json:"name"`
+		Definition string `json:"definition"`
+	}
+	var constraints []savedConstraint
+	for rows.Next() {
+		var sc savedConstraint
+		if err := rows.Scan(&sc.Table, &sc.Name, &sc.Definition); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		constraints = append(constraints, sc)
+	}
+	rows.Close()
+
+	var trashID int64
+	err = tx.QueryRow(`
+		INSERT INTO system_trash (entry_kind, table_name, deleted_by)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, EntryKindTable, tableName, nullableUserID(userID)).Scan(&trashID)
+	if err != nil {
+		return 0, fmt.Errorf("roskakorimerkinnän luonti epäonnistui: %w", err)
+	}
+
+	for _, sc := range constraints {
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s",
+			pq.QuoteIdentifier(sc.Table), pq.QuoteIdentifier(sc.Name)))
+		if err != nil {
+			return 0, fmt.Errorf("vierasavaimen %s pudotus epäonnistui: %w", sc.Name, err)
+		}
+	}
+
+	trashTableName := truncateIdentifier(fmt.Sprintf("t%d_%s", trashID, tableName))
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s",
+		pq.QuoteIdentifier(tableName), pq.QuoteIdentifier(trashTableName)))
+	if err != nil {
+		return 0, fmt.Errorf("taulun %s uudelleennimeäminen epäonnistui: %w", tableName, err)
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s SET SCHEMA %s",
+		pq.QuoteIdentifier(trashTableName), pq.QuoteIdentifier(trashSchema)))
+	if err != nil {
+		return 0, fmt.Errorf("taulun %s siirto roskakoriin epäonnistui: %w", tableName, err)
+	}
+
+	rowData, err := json.Marshal(map[string]interface{}{
+		"constraints":  constraints,
+		"registry_row": json.RawMessage(nullJSON(registryRow)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`UPDATE system_trash SET trash_table_name = $1, row_data = $2 WHERE id = $3`,
+		trashTableName, rowData, trashID)
+	if err != nil {
+		return 0, fmt.Errorf("roskakorimerkinnän päivitys epäonnistui: %w", err)
+	}
+	return trashID, nil
+}
+
+// maxIdentifierBytes on Postgresin tunnisteiden enimmäispituus tavuina (NAMEDATALEN - 1).
+const maxIdentifierBytes = 63
+
+// truncateIdentifier lyhentää tunnisteen enintään maxIdentifierBytes tavuun
+// katkaisematta monitavuista merkkiä.
+func truncateIdentifier(name string) string {
+	if len(name) <= maxIdentifierBytes {
+		return name
+	}
+	end := 0
+	for i, r := range name {
+		if i+utf8.RuneLen(r) > maxIdentifierBytes {
+			break
+		}
+		end = i + utf8.RuneLen(r)
+	}
+	return name[:end]
+}
+
+// retentionDays lukee säilytysajan system_configista (trash_retention_days).
+func retentionDays() int {
+	var days sql.NullInt64
+	err := backend.Db.QueryRow(`SELECT int_value FROM system_config WHERE key = 'trash_retention_days'`).Scan(&days)
+	if err != nil || !days.Valid || days.Int64 <= 0 {
+		return defaultRetentionDays
+	}
+	return int(days.Int64)
+}
+
+// PurgeExpiredTrash poistaa säilytysajan ylittäneet merkinnät ja pudottaa niiden taulut.
+// Palauttaa poistettujen juurimerkintöjen määrän.
+func PurgeExpiredTrash() (int, error) {
+	return purgeExpired(trashScope{})
+}
+
+// trashScope rajaa tyhjennettävät juurimerkinnät. Tyhjä rajaus koskee koko roskakoria.
+type trashScope struct {
+	Kind      string // entry_kind, tyhjä = kaikki
+	TableName string // vain riveille, tyhjä = kaikki taulut
+	DeletedBy int    // > 0: vain tämän käyttäjän poistamat
+}
+
+// condition palauttaa rajauksen SQL-ehtona (alkaa AND:llä) parametreineen. prefix on
+// sarakkeiden eteen liitettävä taulun alias pisteineen, esim. "t.".
+func (s trashScope) condition(prefix string, args []interface{}) (string, []interface{}) {
+	cond := ""
+	if s.Kind != "" {
+		args = append(args, s.Kind)
+		cond += fmt.Sprintf(" AND %sentry_kind = $%d", prefix, len(args))
+	}
+	if s.TableName != "" {
+		args = append(args, s.TableName)
+		cond += fmt.Sprintf(" AND %stable_name = $%d", prefix, len(args))
+	}
+	if s.DeletedBy > 0 {
+		args = append(args, s.DeletedBy)
+		cond += fmt.Sprintf(" AND %sdeleted_by = $%d", prefix, len(args))
+	}
+	return cond, args
+}
+
+// purgeExpired poistaa rajauksen säilytysajan ylittäneet juurimerkinnät. Lapsimerkinnät
+// poistuvat root_id:n ON DELETE CASCADE -viittauksen kautta.
+func purgeExpired(scope trashScope) (int, error) {
+	days := retentionDays()
+	cond, args := scope.condition("", []interface{}{days})
+
+	// Pudotettava taulu on vain palauttamattomilla taulumerkinnöillä
+	rows, err := backend.Db.Query(`
+		SELECT id,
+		       CASE WHEN entry_kind = 'table' AND restored_at IS NULL THEN trash_table_name END
+		FROM system_trash
+		WHERE root_id IS NULL
+		  AND deleted_at < now() - make_interval(days => $1)
+	`+cond, args...)
+	if err != nil {
+		return 0, fmt.Errorf("vanhentuneiden roskakorimerkintöjen haku epäonnistui: %w", err)
+	}
+	type expiredEntry struct {
+		ID        int64
+		TableName sql.NullString
+	}
+	var expired []expiredEntry
+	for rows.Next() {
+		var ee expiredEntry
+		if err := rows.Scan(&ee.ID, &ee.TableName); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, ee)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Jokainen merkintä omassa transaktiossa: merkintä poistuu vain, jos taulun
+	// pudotus onnistui, eikä yksi epäonnistuminen estä muita
+	purged := 0
+	for _, ee := range expired {
+		if err := purgeRoot(ee.ID, ee.TableName); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeRoot pudottaa juurimerkinnän roskakoritaulun (jos on) ja poistaa merkinnän
+// lapsineen samassa transaktiossa.
+func purgeRoot(trashID int64, trashTableName sql.NullString) error {
+	tx, err := backend.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if trashTableName.Valid {
+		_, err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s.%s CASCADE",
+			pq.QuoteIdentifier(trashSchema), pq.QuoteIdentifier(trashTableName.String)))
+		if err != nil {
+			return fmt.Errorf("roskakorin taulun %s pudotus epäonnistui: %w", trashTableName.String, err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM system_trash WHERE id = $1`, trashID); err != nil {
+		return fmt.Errorf("roskakorimerkinnän %d poisto epäonnistui: %w", trashID, err)
+	}
+	return tx.Commit()
+}
+
+// StartTrashPurger siivoaa roskakorin käynnistyksessä ja sen jälkeen säännöllisesti.
+func StartTrashPurger(interval time.Duration) {
+	go func() {
+		for {
+			purged, err := PurgeExpiredTrash()
+			if err != nil {
+				log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			} else if purged > 0 {
+				fmt.Printf("\033[36m[StartTrashPurger] poistettiin %d vanhentunutta roskakorimerkintää\033[0m\n", purged)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// scanTrashedRows lukee rivien tilannekuvat ja poimii niistä pääavaimen row_id:ksi.
+// Taululla, jolla ei ole pääavainta, row_id jää tyhjäksi.
+func scanTrashedRows(tableName string, rows *sql.Rows) ([]trashedRow, error) {
+	defer rows.Close()
+	var result []trashedRow
+	for rows.Next() {
+		var tr trashedRow
+		if err := rows.Scan(&tr.Raw); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(tr.Raw, &tr.Data); err != nil {
+			return nil, err
+		}
+		if key, err := row_key.FromSnapshot(tableName, tr.Data); err == nil {
+			tr.RowID = key.String()
+		}
+		result = append(result, tr)
+	}
+	return result, rows.Err()
+}
+
+// jsonText palauttaa JSON-arvon tekstinä vertailua varten (null => false).
+func jsonText(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	return string(raw), true
+}
+
+func nullableUserID(userID int) interface{} {
+	if userID <= 0 {
+		return nil
+	}
+	return userID
+}
+
+func nullJSON(raw []byte) []byte {
+	if len(raw) == 0 {
+		return []byte("null")
+	}
+	return raw
+}
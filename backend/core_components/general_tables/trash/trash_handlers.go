@@ -0,0 +1,338 @@
+// file: trash_handlers.go
+package trash
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/column_grants"
+	"easelect/backend/core_components/general_tables/row_audit"
+	"easelect/backend/core_components/general_tables/row_policies"
+	e_sessions "easelect/backend/core_components/sessions"
+)
+
+// maxTrashEntries rajaa yhdellä kertaa listattavien merkintöjen määrän.
+const maxTrashEntries = 500
+
+// trashListItem on yksi listattava roskakorimerkintä.
+type trashListItem struct {
+	ID             int64           `json:"id"`
+	Kind           string          `json:"kind"`
+	TableName      string          `json:"table_name"`
+	RowID          string          `json:"row_id,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	CascadedRows   int             `json:"cascaded_rows"`
+	UnlinkedRows   int             `json:"unlinked_rows"`
+	DeletedBy      int             `json:"deleted_by"`
+	DeletedAt      time.Time       `json:"deleted_at"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+	TrashTableName string          `json:"trash_table_name,omitempty"`
+}
+
+// GetTrashHandler listaa taulun palauttamattomat roskakorimerkinnät uusin ensin.
+//
+//	GET /api/trash?table=customers
+//
+// system_db_tables-taululle listataan roskakoriin siirretyt taulut. Jos käyttäjää rajaa
+// jokin rivitason sääntö, hän näkee vain itse poistamansa rivit, koska poistetun rivin
+// sääntöehtoa ei voi enää arvioida.
+func GetTrashHandler(w http.ResponseWriter, r *http.Request) {
+	tableName := r.URL.Query().Get("table")
+	if tableName == "" {
+		http.Error(w, "table-parametri puuttuu", http.StatusBadRequest)
+		return
+	}
+	userID, currentDb, ok := readTrashSession(w, r)
+	if !ok {
+		return
+	}
+
+	scope, err := trashScopeFor(userID, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe rivisääntöjen haussa", http.StatusInternalServerError)
+		return
+	}
+	kind := scope.Kind
+
+	query := `
+		SELECT t.id, t.entry_kind, t.table_name, t.row_id, t.row_data, COALESCE(t.deleted_by, 0),
+		       t.deleted_at, COALESCE(t.trash_table_name, ''),
+		       (SELECT count(*) FROM system_trash c WHERE c.root_id = t.id AND c.entry_kind = 'row'),
+		       (SELECT count(*) FROM system_trash c WHERE c.root_id = t.id AND c.entry_kind = 'link')
+		FROM system_trash t
+		WHERE t.root_id IS NULL
+		  AND t.restored_at IS NULL
+	`
+	cond, args := scope.condition("t.", nil)
+	query += cond
+	query += fmt.Sprintf(" ORDER BY t.deleted_at DESC, t.id DESC LIMIT %d", maxTrashEntries)
+
+	rows, err := backend.Db.Query(query, args...)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe roskakorin haussa", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var allowed map[string]bool
+	if kind == EntryKindRow {
+		allowed, err = column_grants.SelectableSet(currentDb, tableName)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe sarakeoikeuksien haussa", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	retention := time.Duration(retentionDays()) * 24 * time.Hour
+	items := make([]trashListItem, 0)
+	for rows.Next() {
+		var item trashListItem
+		var data []byte
+		if err := rows.Scan(&item.ID, &item.Kind, &item.TableName, &item.RowID, &data, &item.DeletedBy,
+			&item.DeletedAt, &item.TrashTableName, &item.CascadedRows, &item.UnlinkedRows); err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe roskakorin käsittelyssä", http.StatusInternalServerError)
+			return
+		}
+		if kind == EntryKindRow {
+			item.Data = row_audit.FilterSnapshot(data, allowed)
+		}
+		item.ExpiresAt = item.DeletedAt.Add(retention)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe roskakorin käsittelyssä", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"table":          tableName,
+		"retention_days": retentionDays(),
+		"items":          items,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// RestoreTrashHandler palauttaa roskakorimerkinnän.
+//
+//	POST /api/trash/restore?table=customers
+//	{"trash_id": 42}
+//
+// Rivit palautetaan alkuperäisillä id:illä riippuvine riveineen ja SET NULL -linkit
+// liitetään takaisin. Jos samalla id:llä on jo rivi tai viitattu rivi puuttuu, palautetaan 409.
+func RestoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tableName := r.URL.Query().Get("table")
+	if tableName == "" {
+		http.Error(w, "table-parametri puuttuu", http.StatusBadRequest)
+		return
+	}
+	userID, _, ok := readTrashSession(w, r)
+	if !ok {
+		return
+	}
+
+	var restoreRequest struct {
+		TrashID int64 `json:"trash_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&restoreRequest); err != nil || restoreRequest.TrashID <= 0 {
+		http.Error(w, "trash_id is required", http.StatusBadRequest)
+		return
+	}
+	if !entryBelongsToTable(w, restoreRequest.TrashID, tableName) {
+		return
+	}
+
+	result, err := RestoreFromTrash(restoreRequest.TrashID, userID, r.URL.Path)
+	var rErr *restoreError
+	if errors.As(err, &rErr) {
+		http.Error(w, rErr.Message, rErr.Status)
+		return
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		if result == nil {
+			http.Error(w, "virhe palautuksessa", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	fmt.Printf("\033[36m[RestoreTrashHandler] palautettiin merkintä %d (%s)\033[0m\n", restoreRequest.TrashID, result.TableName)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Restored successfully",
+		"result":  result,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// PurgeTrashHandler tyhjentää roskakorista yhden merkinnän pysyvästi tai, ilman
+// trash_id:tä, taulun kaikki säilytysajan ylittäneet merkinnät. Rivitason säännön
+// rajaama käyttäjä voi tyhjentää vain itse poistamiaan rivejä, kuten listauksessakin.
+//
+//	POST /api/trash/purge?table=customers
+//	{"trash_id": 42}
+func PurgeTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tableName := r.URL.Query().Get("table")
+	if tableName == "" {
+		http.Error(w, "table-parametri puuttuu", http.StatusBadRequest)
+		return
+	}
+	userID, _, ok := readTrashSession(w, r)
+	if !ok {
+		return
+	}
+	scope, err := trashScopeFor(userID, tableName)
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe rivisääntöjen haussa", http.StatusInternalServerError)
+		return
+	}
+
+	var purgeRequest struct {
+		TrashID int64 `json:"trash_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&purgeRequest); err != nil {
+			http.Error(w, "Virheellinen data", http.StatusBadRequest)
+			return
+		}
+	}
+
+	purged := 0
+	if purgeRequest.TrashID > 0 {
+		if !entryBelongsToTable(w, purgeRequest.TrashID, tableName) {
+			return
+		}
+		found, err := purgeEntry(purgeRequest.TrashID, scope.DeletedBy)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe roskakorin tyhjennyksessä", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Trash entry not found", http.StatusNotFound)
+			return
+		}
+		purged = 1
+	} else {
+		purged, err = purgeExpired(scope)
+		if err != nil {
+			log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+			http.Error(w, "virhe roskakorin tyhjennyksessä", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Trash purged",
+		"purged":  purged,
+	}); err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+}
+
+// purgeEntry poistaa yhden juurimerkinnän ja pudottaa sen taulun pysyvästi. Kun deletedBy > 0,
+// merkinnän on oltava kyseisen käyttäjän poistama. Palauttaa false, jos merkintää ei löytynyt.
+func purgeEntry(trashID int64, deletedBy int) (bool, error) {
+	query := `
+		SELECT trash_table_name FROM system_trash
+		WHERE id = $1 AND root_id IS NULL AND restored_at IS NULL
+	`
+	args := []interface{}{trashID}
+	if deletedBy > 0 {
+		query += " AND deleted_by = $2"
+		args = append(args, deletedBy)
+	}
+	var trashTableName sql.NullString
+	err := backend.Db.QueryRow(query, args...).Scan(&trashTableName)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := purgeRoot(trashID, trashTableName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// trashScopeFor rajaa taulun roskakorimerkinnät käyttäjälle. system_db_tables-taululle
+// rajaus on roskakoriin siirretyt taulut. Jos käyttäjää rajaa jokin rivitason sääntö,
+// rajaus on hänen itse poistamansa rivit, koska poistetun rivin sääntöehtoa ei voi enää arvioida.
+func trashScopeFor(userID int, tableName string) (trashScope, error) {
+	if tableName == "system_db_tables" {
+		return trashScope{Kind: EntryKindTable}, nil
+	}
+	scope := trashScope{Kind: EntryKindRow, TableName: tableName}
+	cond, _, err := row_policies.BuildCondition(userID, tableName, pq.QuoteIdentifier(tableName), 1)
+	if err != nil {
+		return scope, err
+	}
+	if cond != "" {
+		scope.DeletedBy = userID
+	}
+	return scope, nil
+}
+
+// entryBelongsToTable varmistaa, että merkintä kuuluu tauluun, jonka oikeudet on tarkistettu.
+// Virhetilanteessa vastaus on jo kirjoitettu.
+func entryBelongsToTable(w http.ResponseWriter, trashID int64, tableName string) bool {
+	var entryTable, kind string
+	err := backend.Db.QueryRow(`SELECT table_name, entry_kind FROM system_trash WHERE id = $1`, trashID).Scan(&entryTable, &kind)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Trash entry not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+		http.Error(w, "virhe roskakorin haussa", http.StatusInternalServerError)
+		return false
+	}
+	if (kind == EntryKindTable && tableName != "system_db_tables") || (kind != EntryKindTable && entryTable != tableName) {
+		http.Error(w, "Trash entry belongs to another table", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// readTrashSession lukee käyttäjän ja roolin tietokantayhteyden. Virhetilanteessa
+// vastaus on jo kirjoitettu.
+func readTrashSession(w http.ResponseWriter, r *http.Request) (int, *sql.DB, bool) {
+	userID, err := e_sessions.GetUserIDFromSession(r)
+	if err != nil || userID <= 0 {
+		http.Error(w, "Unauthorized: tarvitset kirjautumisen", http.StatusUnauthorized)
+		return 0, nil, false
+	}
+	currentDb, sessErr := e_sessions.GetRoleDbFromSession(r)
+	if sessErr != nil {
+		log.Printf("\033[31mvirhe: %s\033[0m\n", sessErr.Error())
+		http.Error(w, "virhe session haussa", http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	return userID, currentDb, true
+}
@@ -0,0 +1,398 @@
+// file: trash_restore.go
+package trash
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+
+	backend "easelect/backend/core_components"
+	"easelect/backend/core_components/general_tables/crud_workflows"
+	"easelect/backend/core_components/general_tables/row_audit"
+	"easelect/backend/core_components/general_tables/row_key"
+	"easelect/backend/core_components/general_tables/row_policies"
+	"easelect/backend/core_components/metadata_cache"
+)
+
+// restoreError kertoo palautuksen epäonnistumisen syyn ja HTTP-statuksen.
+type restoreError struct {
+	Status  int
+	Message string
+}
+
+func (e *restoreError) Error() string { return e.Message }
+
+// trashEntry on yksi system_trash-rivi palautusta varten.
+type trashEntry struct {
+	ID             int64
+	Kind           string
+	TableName      string
+	RowID          string
+	RowData        []byte
+	LinkColumn     sql.NullString
+	Depth          int
+	TrashTableName sql.NullString
+}
+
+// restoreResult kertoo, mitä palautettiin.
+type restoreResult struct {
+	Kind          string   `json:"kind"`
+	TableName     string   `json:"table_name"`
+	RestoredRows  int      `json:"restored_rows"`
+	RelinkedRows  int      `json:"relinked_rows"`
+	SkippedLinks  int      `json:"skipped_links"`
+	RestoredTable string   `json:"restored_table,omitempty"`
+	Constraints   []string `json:"constraints,omitempty"`
+}
+
+// loadRoot hakee palauttamattoman juurimerkinnän.
+func loadRoot(tx *sql.Tx, trashID int64) (*trashEntry, error) {
+	var e trashEntry
+	var rootID sql.NullInt64
+	var restoredAt sql.NullTime
+	err := tx.QueryRow(`
+		SELECT id, root_id, entry_kind, table_name, row_id, row_data, link_column, depth,
+		       trash_table_name, restored_at
+		FROM system_trash
+		WHERE id = $1
+		FOR UPDATE
+	`, trashID).Scan(&e.ID, &rootID, &e.Kind, &e.TableName, &e.RowID, &e.RowData,
+		&e.LinkColumn, &e.Depth, &e.TrashTableName, &restoredAt)
+	if err == sql.ErrNoRows {
+		return nil, &restoreError{http.StatusNotFound, "Trash entry not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rootID.Valid {
+		return nil, &restoreError{http.StatusBadRequest, "Only root entries can be restored"}
+	}
+	if restoredAt.Valid {
+		return nil, &restoreError{http.StatusConflict, "Trash entry has already been restored"}
+	}
+	return &e, nil
+}
+
+// restoreRows palauttaa juuririvin, sen CASCADE-lapset ja SET NULL -linkit yhdessä
+// transaktiossa. Rivit lisätään syvyysjärjestyksessä, jotta vanhemmat ovat olemassa
+// ennen lapsia; linkit palautetaan vain, jos lapsen sarake on yhä NULL.
+func restoreRows(tx *sql.Tx, root *trashEntry, userID int) (*restoreResult, []row_audit.Entry, error) {
+	rows, err := tx.Query(`
+		SELECT id, entry_kind, table_name, row_id, row_data, link_column, depth
+		FROM system_trash
+		WHERE id = $1 OR root_id = $1
+		ORDER BY (entry_kind = 'link'), depth, id
+	`, root.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []trashEntry
+	for rows.Next() {
+		var e trashEntry
+		if err := rows.Scan(&e.ID, &e.Kind, &e.TableName, &e.RowID, &e.RowData, &e.LinkColumn, &e.Depth); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	result := &restoreResult{Kind: EntryKindRow, TableName: root.TableName}
+	var auditEntries []row_audit.Entry
+	for _, e := range entries {
+		switch e.Kind {
+		case EntryKindRow:
+			if err := insertSnapshot(tx, e.TableName, e.RowData); err != nil {
+				return nil, nil, err
+			}
+			result.RestoredRows++
+			auditEntries = append(auditEntries, row_audit.Entry{
+				TableName: e.TableName,
+				RowID:     e.RowID,
+				Operation: row_audit.OperationInsert,
+				UserID:    userID,
+			})
+
+		case EntryKindLink:
+			var link map[string]json.RawMessage
+			if err := json.Unmarshal(e.RowData, &link); err != nil {
+				return nil, nil, err
+			}
+			value, ok := jsonText(link["value"])
+			if !ok || !e.LinkColumn.Valid {
+				result.SkippedLinks++
+				continue
+			}
+			childKey, err := row_key.ParseString(e.TableName, e.RowID)
+			if err != nil {
+				result.SkippedLinks++
+				continue
+			}
+			safeChild := pq.QuoteIdentifier(e.TableName)
+			safeColumn := pq.QuoteIdentifier(e.LinkColumn.String)
+			keyCond, keyArgs := childKey.Condition(safeChild, 2)
+			res, err := tx.Exec(fmt.Sprintf(
+				"UPDATE %[1]s SET %[2]s = $1 WHERE %[3]s AND %[1]s.%[2]s IS NULL",
+				safeChild, safeColumn, keyCond,
+			), append([]interface{}{value}, keyArgs...)...)
+			if err != nil {
+				return nil, nil, fmt.Errorf("linkin palautus tauluun %s epäonnistui: %w", e.TableName, err)
+			}
+			if affected, _ := res.RowsAffected(); affected > 0 {
+				result.RelinkedRows++
+			} else {
+				// Lapsi on poistettu tai linkitetty muualle välillä
+				result.SkippedLinks++
+			}
+		}
+	}
+
+	// Palautetun juuririvin on oltava käyttäjälle näkyvä rivitason sääntöjen mukaan
+	safe := pq.QuoteIdentifier(root.TableName)
+	cond, condArgs, err := row_policies.BuildCondition(userID, root.TableName, safe, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cond != "" {
+		rootKey, err := row_key.ParseString(root.TableName, root.RowID)
+		if err != nil {
+			return nil, nil, &restoreError{http.StatusForbidden, "403 - Forbidden (row)"}
+		}
+		keyCond, keyArgs := rootKey.Condition(safe, len(condArgs)+1)
+		var visible bool
+		query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s AND %s)", safe, cond, keyCond)
+		if err := tx.QueryRow(query, append(condArgs, keyArgs...)...).Scan(&visible); err != nil {
+			return nil, nil, err
+		}
+		if !visible {
+			return nil, nil, &restoreError{http.StatusForbidden, "403 - Forbidden (row)"}
+		}
+	}
+
+	for i := range auditEntries {
+		key, err := row_key.ParseString(auditEntries[i].TableName, auditEntries[i].RowID)
+		if err != nil {
+			continue
+		}
+		snapshot, err := row_audit.SnapshotRow(tx, auditEntries[i].TableName, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		auditEntries[i].After = snapshot
+	}
+	return result, auditEntries, nil
+}
+
+// insertSnapshot lisää rivin takaisin tilannekuvasta. Vain nykyiset, ei-generoidut
+// sarakkeet, jotka löytyvät tilannekuvasta, kirjoitetaan; muut saavat oletusarvonsa.
+func insertSnapshot(tx *sql.Tx, tableName string, snapshot []byte) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(snapshot, &values); err != nil {
+		return err
+	}
+
+	colRows, err := tx.Query(`
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = 'public'
+		  AND table_name = $1
+		  AND is_generated = 'NEVER'
+		ORDER BY ordinal_position
+	`, tableName)
+	if err != nil {
+		return err
+	}
+	var quoted []string
+	for colRows.Next() {
+		var col string
+		if err := colRows.Scan(&col); err != nil {
+			colRows.Close()
+			return err
+		}
+		if _, ok := values[col]; ok {
+			quoted = append(quoted, pq.QuoteIdentifier(col))
+		}
+	}
+	colRows.Close()
+	if len(quoted) == 0 {
+		return &restoreError{http.StatusConflict, fmt.Sprintf("Table %s no longer has the deleted columns", tableName)}
+	}
+
+	columnList := strings.Join(quoted, ", ")
+	safe := pq.QuoteIdentifier(tableName)
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) OVERRIDING SYSTEM VALUE SELECT %s FROM jsonb_populate_record(NULL::%s, $1::jsonb)",
+		safe, columnList, columnList, safe,
+	)
+	if _, err := tx.Exec(query, snapshot); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505":
+				return &restoreError{http.StatusConflict, fmt.Sprintf("Row already exists in %s (%s)", tableName, pqErr.Constraint)}
+			case "23503":
+				return &restoreError{http.StatusConflict, fmt.Sprintf("Referenced row is missing for %s (%s)", tableName, pqErr.Constraint)}
+			}
+		}
+		return fmt.Errorf("rivin palautus tauluun %s epäonnistui: %w", tableName, err)
+	}
+	return nil
+}
+
+// restoreTable siirtää taulun takaisin public-skeemaan, luo sen vierasavaimet uudelleen
+// ja palauttaa rekisteririvin system_db_tables-tauluun.
+func restoreTable(tx *sql.Tx, root *trashEntry) (*restoreResult, error) {
+	if !root.TrashTableName.Valid {
+		return nil, &restoreError{http.StatusConflict, "Trashed table is missing"}
+	}
+	var payload struct {
+		Constraints []struct {
+			Table      string `json:"table"`
+			Name       string `json:"name"`
+			Definition string `json:"definition"`
+		} `json:"constraints"`
+		RegistryRow json.RawMessage `json:"registry_row"`
+	}
+	if err := json.Unmarshal(root.RowData, &payload); err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, "public."+pq.QuoteIdentifier(root.TableName)).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, &restoreError{http.StatusConflict, fmt.Sprintf("Table %s already exists", root.TableName)}
+	}
+
+	trashed := pq.QuoteIdentifier(trashSchema) + "." + pq.QuoteIdentifier(root.TrashTableName.String)
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s SET SCHEMA public", trashed)); err != nil {
+		return nil, fmt.Errorf("taulun palautus roskakorista epäonnistui: %w", err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s",
+		pq.QuoteIdentifier(root.TrashTableName.String), pq.QuoteIdentifier(root.TableName))); err != nil {
+		return nil, fmt.Errorf("taulun uudelleennimeäminen epäonnistui: %w", err)
+	}
+
+	result := &restoreResult{Kind: EntryKindTable, TableName: root.TableName, RestoredTable: root.TableName}
+	for _, c := range payload.Constraints {
+		var childExists bool
+		if err := tx.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, "public."+pq.QuoteIdentifier(c.Table)).Scan(&childExists); err != nil {
+			return nil, err
+		}
+		if !childExists {
+			continue
+		}
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s",
+			pq.QuoteIdentifier(c.Table), pq.QuoteIdentifier(c.Name), c.Definition))
+		if err != nil {
+			return nil, &restoreError{http.StatusConflict, fmt.Sprintf("Foreign key %s on %s could not be restored: %s", c.Name, c.Table, err.Error())}
+		}
+		result.Constraints = append(result.Constraints, c.Name)
+	}
+
+	if len(payload.RegistryRow) > 0 && string(payload.RegistryRow) != "null" {
+		if err := insertRegistryRow(tx, payload.RegistryRow); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// insertRegistryRow palauttaa system_db_tables-rivin; cached_oid säilyy, koska taulua
+// ei ole luotu uudelleen.
+func insertRegistryRow(tx *sql.Tx, registryRow json.RawMessage) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(registryRow, &values); err != nil {
+		return err
+	}
+	colRows, err := tx.Query(`
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = 'public'
+		  AND table_name = 'system_db_tables'
+		  AND is_generated = 'NEVER'
+		ORDER BY ordinal_position
+	`)
+	if err != nil {
+		return err
+	}
+	var quoted []string
+	for colRows.Next() {
+		var col string
+		if err := colRows.Scan(&col); err != nil {
+			colRows.Close()
+			return err
+		}
+		if _, ok := values[col]; ok {
+			quoted = append(quoted, pq.QuoteIdentifier(col))
+		}
+	}
+	colRows.Close()
+
+	columnList := strings.Join(quoted, ", ")
+	_, err = tx.Exec(fmt.Sprintf(
+		"INSERT INTO system_db_tables (%s) OVERRIDING SYSTEM VALUE SELECT %s FROM jsonb_populate_record(NULL::system_db_tables, $1::jsonb) ON CONFLICT DO NOTHING",
+		columnList, columnList,
+	), []byte(registryRow))
+	if err != nil {
+		return fmt.Errorf("system_db_tables-rivin palautus epäonnistui: %w", err)
+	}
+	return nil
+}
+
+// RestoreFromTrash palauttaa juurimerkinnän (rivin riippuvuuksineen tai taulun).
+func RestoreFromTrash(trashID int64, userID int, endpoint string) (*restoreResult, error) {
+	tx, err := backend.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	root, err := loadRoot(tx, trashID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *restoreResult
+	var auditEntries []row_audit.Entry
+	switch root.Kind {
+	case EntryKindRow:
+		result, auditEntries, err = restoreRows(tx, root, userID)
+	case EntryKindTable:
+		result, err = restoreTable(tx, root)
+	default:
+		err = &restoreError{http.StatusBadRequest, "Unknown trash entry kind"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE system_trash SET restored_at = now() WHERE id = $1 OR root_id = $1`, root.ID); err != nil {
+		return nil, err
+	}
+	// Muutoshistoria kirjataan samassa transaktiossa kuin palautus
+	for _, e := range auditEntries {
+		e.Endpoint = endpoint
+		if err := row_audit.Record(tx, e); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if root.Kind == EntryKindTable {
+		metadata_cache.InvalidateAll()
+		if err := crud_workflows.UpdateOidsAndTableNamesWithBridge(); err != nil {
+			return result, fmt.Errorf("taulu palautettiin, mutta OID-päivitys epäonnistui: %w", err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package trash
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateIdentifier(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want int // tavuja
+	}{
+		{"short", "t12_orders", 10},
+		{"ascii over limit", "t1_" + strings.Repeat("a", 70), 63},
+		// "t1_a" + 29 × "ä" = 62 tavua, seuraava "ä" ei mahdu kokonaan
+		{"multibyte at boundary", "t1_a" + strings.Repeat("ä", 40), 62},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateIdentifier(tt.in)
+			if len(got) != tt.want {
+				t.Errorf("len(truncateIdentifier()) = %d, want %d", len(got), tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateIdentifier() = %q is not valid UTF-8", got)
+			}
+			if !strings.HasPrefix(tt.in, got) {
+				t.Errorf("truncateIdentifier() = %q is not a prefix of the input", got)
+			}
+		})
+	}
+}
+
+func TestChildRowsQueryComparesColumnType(t *testing.T) {
+	got := childRowsQuery("order lines", "order_id")
+	want := `SELECT to_jsonb("order lines") FROM "order lines" WHERE "order lines"."order_id" = $1`
+	if got != want {
+		t.Errorf("childRowsQuery() = %s, want %s", got, want)
+	}
+}
+
+// TestChildRowsQueryUsesIndex tarkistaa, että tekstinä annettu arvo vertautuu
+// kokonaislukusarakkeeseen indeksin kautta. Ohitetaan, jos EASELECT_TEST_DATABASE_URL
+// ei ole asetettu.
+func TestChildRowsQueryUsesIndex(t *testing.T) {
+	dsn := os.Getenv("EASELECT_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("EASELECT_TEST_DATABASE_URL ei ole asetettu")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`CREATE TEMP TABLE trash_test_lines (id INT PRIMARY KEY, order_id BIGINT)`,
+		`CREATE INDEX trash_test_lines_order_idx ON trash_test_lines (order_id)`,
+		`INSERT INTO trash_test_lines SELECT g, g % 100 FROM generate_series(1, 1000) g`,
+		`SET LOCAL enable_seqscan = off`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := tx.Query("EXPLAIN "+childRowsQuery("trash_test_lines", "order_id"), "12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, line)
+	}
+	rows.Close()
+	if !strings.Contains(strings.Join(plan, "\n"), "trash_test_lines_order_idx") {
+		t.Errorf("plan does not use the foreign key index:\n%s", strings.Join(plan, "\n"))
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM ("+childRowsQuery("trash_test_lines", "order_id")+") c", "12").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Errorf("matching rows = %d, want 10", count)
+	}
+}
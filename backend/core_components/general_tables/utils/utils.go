@@ -67,6 +67,12 @@ func GetForeignKeysForTable(tableName string) (map[string]ForeignKey, error) {
 	return foreignKeys, nil
 }
 
+// GetNameColumnForTable palauttaa taulun näyttönimenä käytettävän sarakkeen
+// samalla päättelyllä kuin vierasavainten nimisarakkeet.
+func GetNameColumnForTable(tableName string) (string, error) {
+	return getReferencedTableNameColumn(tableName)
+}
+
 func getReferencedTableNameColumn(tableName string) (string, error) {
 	// Taulukohtaiset poikkeukset
 	tableSpecificNameColumns := map[string]string{
@@ -0,0 +1,238 @@
+// file: metadata_cache.go
+package metadata_cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prosessinsisäinen välimuisti metatiedoille, joita lähes jokainen pyyntö tarvitsee:
+// sarakekartat, 1-m-viittaukset, sarakeoikeudet, system_config-arvot, käyttäjänimet,
+// funktio-oikeudet, pääavaimet ja hakujen järjestysprofiilit. Arvot vanhenevat TTL:n jälkeen, ja skeemaa tai oikeuksia
+// muuttavat endpointit tyhjentävät ne heti (Invalidate / InvalidateTable / InvalidateAll).
+// Muutossyötteen kuuntelija tyhjentää lisäksi metatietotaulujen muutosten kohdalla
+// (ks. metadataTables).
+//
+// Avaimet muodostetaan Key-funktiolla: ensimmäinen osa on laji (esim. "columns"),
+// jonka perusteella lajin kaikki arvot voi tyhjentää kerralla.
+
+// Avainten lajit.
+const (
+	KindColumns      = "columns"
+	KindForeignKeys  = "fk_relations"
+	KindColumnGrants = "column_grants"
+	KindConfig       = "config"
+	KindUsername     = "username"
+	KindPermission   = "permission"
+	KindPrimaryKey   = "primary_key"
+	KindRanking      = "ranking_profile"
+)
+
+// DefaultTTL on arvon elinaika, ellei SetTTL muuta sitä.
+const DefaultTTL = 60 * time.Second
+
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+var (
+	mu      sync.RWMutex
+	entries = make(map[string]cacheEntry)
+	ttl     = DefaultTTL
+
+	// loadMu suojaa loadLocks-karttaa. Avaimen lukko estää saman avaimen rinnakkaiset
+	// lataukset (ensimmäinen lataa, muut odottavat); kartassa ovat vain käynnissä olevat.
+	loadMu    sync.Mutex
+	loadLocks = make(map[string]*loadLock)
+
+	// generation kasvaa jokaisessa tyhjennyksessä; ennen tyhjennystä alkanutta latausta
+	// ei tallenneta, jottei vanhentunut arvo jää välimuistiin
+	generation uint64
+
+	hits   uint64
+	misses uint64
+)
+
+// Key muodostaa välimuistiavaimen lajista ja osista, esim. Key(KindColumns, "customers").
+func Key(kind string, parts ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(kind)
+	for _, p := range parts {
+		b.WriteString("\x1f")
+		b.WriteString(fmt.Sprint(p))
+	}
+	return b.String()
+}
+
+// SetTTL asettaa uusien arvojen elinajan. 0 kytkee välimuistin pois.
+func SetTTL(d time.Duration) {
+	mu.Lock()
+	ttl = d
+	mu.Unlock()
+}
+
+// GetOrLoad palauttaa avaimen arvon välimuistista tai lataa sen load-funktiolla.
+// Virheitä ei tallenneta. Palautettua arvoa ei saa muokata, koska se on jaettu.
+func GetOrLoad(key string, load func() (interface{}, error)) (interface{}, error) {
+	if value, ok := get(key); ok {
+		return value, nil
+	}
+
+	lock := acquireLoadLock(key)
+	defer releaseLoadLock(key, lock)
+
+	// Toinen pyyntö on voinut ladata arvon sillä välin
+	if value, ok := get(key); ok {
+		return value, nil
+	}
+
+	atomic.AddUint64(&misses, 1)
+	mu.RLock()
+	currentTTL := ttl
+	startGeneration := generation
+	mu.RUnlock()
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if currentTTL > 0 {
+		mu.Lock()
+		if generation == startGeneration {
+			entries[key] = cacheEntry{value: value, expiresAt: time.Now().Add(currentTTL)}
+		}
+		mu.Unlock()
+	}
+	return value, nil
+}
+
+func get(key string) (interface{}, bool) {
+	mu.RLock()
+	entry, ok := entries[key]
+	mu.RUnlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	atomic.AddUint64(&hits, 1)
+	return entry.value, true
+}
+
+// loadLock on avaimen latauslukko ja sitä odottavien määrä.
+type loadLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+// acquireLoadLock lukitsee avaimen latauksen.
+func acquireLoadLock(key string) *loadLock {
+	loadMu.Lock()
+	lock, ok := loadLocks[key]
+	if !ok {
+		lock = &loadLock{}
+		loadLocks[key] = lock
+	}
+	lock.waiters++
+	loadMu.Unlock()
+
+	lock.mu.Lock()
+	return lock
+}
+
+// releaseLoadLock vapauttaa lukon ja poistaa sen kartasta, kun kukaan ei enää odota.
+func releaseLoadLock(key string, lock *loadLock) {
+	lock.mu.Unlock()
+	loadMu.Lock()
+	lock.waiters--
+	if lock.waiters == 0 {
+		delete(loadLocks, key)
+	}
+	loadMu.Unlock()
+}
+
+// Invalidate poistaa lajin kaikki arvot (tai vain annettuja osia vastaavat arvot,
+// jos osia annetaan avaimen alusta alkaen).
+func Invalidate(kind string, parts ...interface{}) {
+	prefix := Key(kind, parts...)
+	mu.Lock()
+	defer mu.Unlock()
+	generation++
+	for key := range entries {
+		if key == prefix || strings.HasPrefix(key, prefix+"\x1f") {
+			delete(entries, key)
+		}
+	}
+}
+
+// InvalidateTable poistaa kaikki arvot, joiden avaimen jokin osa on taulun nimi.
+func InvalidateTable(tableName string) {
+	mu.Lock()
+	defer mu.Unlock()
+	generation++
+	for key := range entries {
+		for _, part := range strings.Split(key, "\x1f")[1:] {
+			if part == tableName {
+				delete(entries, key)
+				break
+			}
+		}
+	}
+}
+
+// InvalidateAll tyhjentää koko välimuistin.
+func InvalidateAll() {
+	mu.Lock()
+	entries = make(map[string]cacheEntry)
+	generation++
+	mu.Unlock()
+}
+
+// metadataTables kertoo, minkä lajin arvot kukin metatietotaulu tuottaa. Muutossyöte
+// asentaa NOTIFY-triggerin jokaiseen näistä tauluista, joten muutokset (myös suoraan
+// kantaan tai toisesta prosessista tehdyt) tyhjentävät välimuistin heti.
+//
+// KindColumnGrants ja KindPrimaryKey luetaan järjestelmäkatalogista (GRANT, ALTER
+// TABLE), johon ei voi asentaa rivitriggereitä. Ne tyhjentävät skeemaa ja oikeuksia
+// muuttavat endpointit; muuten ainoa takuu on TTL. Rivitason säännöt (auth_row_policies)
+// luetaan joka pyynnöllä eivätkä ole välimuistissa.
+var metadataTables = map[string]string{
+	"system_column_details":        KindColumns,
+	"system_db_tables":             KindColumns,
+	"foreign_key_relations_1_m":    KindForeignKeys,
+	"system_config":                KindConfig,
+	"search_ranking_profiles":      KindRanking,
+	"auth_users":                   KindUsername,
+	"auth_group_table_func_rights": KindPermission,
+	"auth_user_group_memberships":  KindPermission,
+	"auth_user_groups":             KindPermission,
+	"functions":                    KindPermission,
+}
+
+// MetadataTables palauttaa taulut, joiden rivimuutokset tyhjentävät välimuistia.
+func MetadataTables() []string {
+	tables := make([]string, 0, len(metadataTables))
+	for t := range metadataTables {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// InvalidateForTableChange tyhjentää arvot, jotka riippuvat muuttuneen taulun riveistä.
+// Muutossyötteen kuuntelija kutsuu tätä jokaisesta rivimuutoksesta.
+func InvalidateForTableChange(tableName string) {
+	if kind, ok := metadataTables[tableName]; ok {
+		Invalidate(kind)
+	}
+}
+
+// Stats palauttaa osumat, ohitukset ja arvojen määrän seurantaa varten.
+func Stats() (uint64, uint64, int) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return atomic.LoadUint64(&hits), atomic.LoadUint64(&misses), len(entries)
+}
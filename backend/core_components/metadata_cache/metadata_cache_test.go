@@ -0,0 +1,143 @@
+package metadata_cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoadLoadsOnceAndReleasesLocks(t *testing.T) {
+	key := Key(KindColumns, "test_concurrent")
+	var loads int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := GetOrLoad(key, func() (interface{}, error) {
+				atomic.AddInt32(&loads, 1)
+				<-release
+				return "loaded", nil
+			})
+			if err != nil || value != "loaded" {
+				t.Errorf("GetOrLoad = %v, %v", value, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+	loadMu.Lock()
+	remaining := len(loadLocks)
+	loadMu.Unlock()
+	if remaining != 0 {
+		t.Errorf("len(loadLocks) = %d after loads, want 0", remaining)
+	}
+}
+
+func TestGetOrLoadDoesNotCacheErrors(t *testing.T) {
+	key := Key(KindConfig, "test_error")
+	if _, err := GetOrLoad(key, func() (interface{}, error) { return nil, errors.New("down") }); err == nil {
+		t.Fatal("GetOrLoad error = nil, want error")
+	}
+	value, err := GetOrLoad(key, func() (interface{}, error) { return 42, nil })
+	if err != nil || value != 42 {
+		t.Errorf("GetOrLoad after error = %v, %v, want 42", value, err)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	seed := func(key string) {
+		if _, err := GetOrLoad(key, func() (interface{}, error) { return key, nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cached := func(key string) bool {
+		_, ok := get(key)
+		return ok
+	}
+
+	tests := []struct {
+		name       string
+		invalidate func()
+		gone       []string
+		kept       []string
+	}{
+		{
+			name:       "kind",
+			invalidate: func() { Invalidate(KindPrimaryKey) },
+			gone:       []string{Key(KindPrimaryKey, "orders"), Key(KindPrimaryKey, "lines")},
+			kept:       []string{Key(KindColumns, "orders")},
+		},
+		{
+			name:       "kind and part",
+			invalidate: func() { Invalidate(KindPrimaryKey, "orders") },
+			gone:       []string{Key(KindPrimaryKey, "orders")},
+			kept:       []string{Key(KindPrimaryKey, "orders_archive"), Key(KindPrimaryKey, "lines")},
+		},
+		{
+			name:       "table",
+			invalidate: func() { InvalidateTable("orders") },
+			gone:       []string{Key(KindColumns, "orders"), Key(KindPermission, 1, "fn", "orders")},
+			kept:       []string{Key(KindColumns, "lines")},
+		},
+		{
+			name:       "metadata table change",
+			invalidate: func() { InvalidateForTableChange("system_config") },
+			gone:       []string{Key(KindConfig, "trash_retention_days")},
+			kept:       []string{Key(KindColumns, "orders")},
+		},
+		{
+			name:       "permission table change",
+			invalidate: func() { InvalidateForTableChange("auth_user_group_memberships") },
+			gone:       []string{Key(KindPermission, 1, "fn", "orders")},
+			kept:       []string{Key(KindUsername, 1), Key(KindColumns, "orders")},
+		},
+		{
+			name:       "ordinary table change",
+			invalidate: func() { InvalidateForTableChange("orders") },
+			kept:       []string{Key(KindColumns, "orders"), Key(KindPrimaryKey, "orders")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InvalidateAll()
+			for _, key := range append(append([]string{}, tt.gone...), tt.kept...) {
+				seed(key)
+			}
+			tt.invalidate()
+			for _, key := range tt.gone {
+				if cached(key) {
+					t.Errorf("%q still cached", key)
+				}
+			}
+			for _, key := range tt.kept {
+				if !cached(key) {
+					t.Errorf("%q was invalidated", key)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadataTablesCoverCachedKinds(t *testing.T) {
+	tables := MetadataTables()
+	kinds := make(map[string]bool)
+	for _, table := range tables {
+		kinds[metadataTables[table]] = true
+	}
+	// Katalogista luettavat lajit (KindColumnGrants, KindPrimaryKey) nojaavat TTL:ään
+	for _, kind := range []string{KindColumns, KindForeignKeys, KindConfig, KindRanking, KindUsername, KindPermission} {
+		if !kinds[kind] {
+			t.Errorf("no metadata table invalidates %s", kind)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	backend "easelect/backend/core_components" // Tarvitsemme vain Db
+	"easelect/backend/core_components/metadata_cache"
 	e_sessions "easelect/backend/core_components/sessions"
 	// Sessioiden Store
 )
@@ -65,8 +66,7 @@ func WithUserLogging(original_handler http.HandlerFunc) http.HandlerFunc {
 		}
 
 		// Haetaan käyttäjänimi
-		var user_name string
-		err_query := backend.Db.QueryRow(`SELECT username FROM auth_users WHERE id = $1`, user_id).Scan(&user_name)
+		user_name, err_query := cachedUsername(user_id)
 		if err_query != nil {
 			log_line += fmt.Sprintf(", user_id: %d, userName fetch error: %v]", user_id, err_query)
 			log.Println(log_line)
@@ -141,8 +141,46 @@ func WithLoginCheck(original_handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// Yhdistetty tarkistusfunktio: tarkistaa sekä function-level että (tarvittaessa) table-level -oikeudet.
+// UserHasFunctionPermission tarkistaa oikeuden toisen funktion nimissä, esim. kun
+// muutossyöte sallii vain taulut, joihin käyttäjällä on GetResults-oikeus.
+func UserHasFunctionPermission(userID int, functionName, tableName string) bool {
+	return userHasFunctionPermission(userID, functionName, tableName)
+}
+
+// cachedUsername hakee käyttäjänimen lokitusta varten metatietovälimuistin kautta.
+func cachedUsername(userID int) (string, error) {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindUsername, userID),
+		func() (interface{}, error) {
+			var username string
+			err := backend.Db.QueryRow(`SELECT username FROM auth_users WHERE id = $1`, userID).Scan(&username)
+			return username, err
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	return cached.(string), nil
+}
+
+// userHasFunctionPermission palauttaa oikeustarkistuksen tuloksen metatietovälimuistista.
+// SaveUserGroupRight ja oikeustaulujen muutokset tyhjentävät välimuistin.
 func userHasFunctionPermission(userID int, functionName, tableName string) bool {
+	cached, err := metadata_cache.GetOrLoad(
+		metadata_cache.Key(metadata_cache.KindPermission, userID, functionName, tableName),
+		func() (interface{}, error) {
+			return loadUserHasFunctionPermission(userID, functionName, tableName)
+		},
+	)
+	if err != nil {
+		log.Printf("\033[31m[userHasFunctionPermission] Tietokantavirhe: %v\033[0m", err)
+		return false
+	}
+	return cached.(bool)
+}
+
+// Yhdistetty tarkistusfunktio: tarkistaa sekä function-level että (tarvittaessa) table-level -oikeudet.
+func loadUserHasFunctionPermission(userID int, functionName, tableName string) (bool, error) {
 	var query string
 	var dummy int
 	var err error
@@ -178,15 +216,14 @@ func userHasFunctionPermission(userID int, functionName, tableName string) bool
 	if err == sql.ErrNoRows {
 		log.Printf("\033[31m[userHasFunctionPermission] Ei löytynyt oikeusriviä funktiolle='%s', taululle='%s' (userID=%d)\033[0m",
 			functionName, tableName, userID)
-		return false
+		return false, nil
 	} else if err != nil {
-		log.Printf("\033[31m[userHasFunctionPermission] Tietokantavirhe: %v\033[0m", err)
-		return false
+		return false, err
 	}
 
 	// log.Printf("\033[32m[userHasFunctionPermission] OK - Löytyi oikeus funktiolle='%s', taululle='%s' (userID=%d)\033[0m",
 	// 	functionName, tableName, userID)
-	return true
+	return true, nil
 }
 
 func WithAccessControl(handlerName string, originalHandler http.HandlerFunc) http.HandlerFunc {
@@ -215,15 +252,6 @@ func WithAccessControl(handlerName string, originalHandler http.HandlerFunc) htt
 			return
 		}
 
-		// Hae käyttäjänimi lokitusta varten
-		var username string
-		err = backend.Db.QueryRow("SELECT username FROM auth_users WHERE id = $1", userID).Scan(&username)
-		if err != nil {
-			log.Printf("\033[31m[WithAccessControl][%s] Käyttäjänimen haku epäonnistui, userID=%d: %v\033[0m",
-				handlerName, userID, err)
-			username = fmt.Sprintf("id:%d", userID) // fallback
-		}
-
 		// --- Tarkista, mitä tauluja (jos mitään) parametreissa on ---
 		tablesParam := r.URL.Query().Get("tables")
 		if tablesParam != "" {
@@ -234,8 +262,8 @@ func WithAccessControl(handlerName string, originalHandler http.HandlerFunc) htt
 			}
 
 			for _, tbl := range tableList {
-				// log.Printf("[WithAccessControl][%s] Tarkistetaan käyttäjän %s (id=%d) oikeus funktiolle='%s' tauluun='%s'",
-				// 	handlerName, username, userID, handlerName, tbl)
+				// log.Printf("[WithAccessControl][%s] Tarkistetaan käyttäjän id=%d oikeus funktiolle='%s' tauluun='%s'",
+				// 	handlerName, userID, handlerName, tbl)
 
 				if !userHasFunctionPermission(userID, handlerName, tbl) {
 					// log.Printf("\033[31m[WithAccessControl][%s] EI oikeutta -> 403\033[0m", handlerName)
@@ -250,8 +278,8 @@ func WithAccessControl(handlerName string, originalHandler http.HandlerFunc) htt
 
 			// Jos taulunimi on annettu, tarkistetaan oikeus sille
 			if tableName != "" {
-				// log.Printf("[WithAccessControl][%s] Tarkistetaan käyttäjän %s (id=%d) oikeus funktiolle='%s' tauluun='%s'",
-				// 	handlerName, username, userID, handlerName, tableName)
+				// log.Printf("[WithAccessControl][%s] Tarkistetaan käyttäjän id=%d oikeus funktiolle='%s' tauluun='%s'",
+				// 	handlerName, userID, handlerName, tableName)
 
 				if !userHasFunctionPermission(userID, handlerName, tableName) {
 					// log.Printf("\033[31m[WithAccessControl][%s] EI oikeutta -> 403\033[0m", handlerName)
@@ -260,8 +288,8 @@ func WithAccessControl(handlerName string, originalHandler http.HandlerFunc) htt
 				}
 			} else {
 				// Ei taulunimeä ollenkaan = "tauluton" kutsu
-				// log.Printf("[WithAccessControl][%s] Tarkistetaan käyttäjän %s (id=%d) tauluton oikeus funktiolle='%s'",
-				// 	handlerName, username, userID, handlerName)
+				// log.Printf("[WithAccessControl][%s] Tarkistetaan käyttäjän id=%d tauluton oikeus funktiolle='%s'",
+				// 	handlerName, userID, handlerName)
 
 				if !userHasFunctionPermission(userID, handlerName, "") {
 					// log.Printf("\033[31m[WithAccessControl][%s] EI oikeutta (tauluton) -> 403\033[0m", handlerName)
@@ -272,8 +300,8 @@ func WithAccessControl(handlerName string, originalHandler http.HandlerFunc) htt
 		}
 
 		// // Kaikki ok -> lokitetaan onnistuminen ja suoritetaan varsinainen handler
-		// log.Printf("\033[32m[WithAccessControl][%s] Käyttöoikeustarkastus onnistui käyttäjälle %s (id=%d)\033[0m",
-		// 	handlerName, username, userID)
+		// log.Printf("\033[32m[WithAccessControl][%s] Käyttöoikeustarkastus onnistui käyttäjälle id=%d\033[0m",
+		// 	handlerName, userID)
 		originalHandler(w, r)
 	}
 }
@@ -18,6 +18,7 @@ import (
 	"easelect/backend/core_components/auth"
 	devtools "easelect/backend/core_components/dev_tools"
 	"easelect/backend/core_components/general_tables"
+	"easelect/backend/core_components/general_tables/change_feed"
 	"easelect/backend/core_components/general_tables/crud_workflows"
 	"easelect/backend/core_components/general_tables/foreign_keys"
 	"easelect/backend/core_components/general_tables/gt_1_row_crud/gt_1_row_create"
@@ -27,7 +28,9 @@ import (
 	gt_2_column_crud "easelect/backend/core_components/general_tables/gt_2_column_crud"
 	"easelect/backend/core_components/general_tables/gt_3_table_crud/gt_3_table_delete"
 	"easelect/backend/core_components/general_tables/gt_3_table_crud/gt_3_table_read"
+	"easelect/backend/core_components/general_tables/row_audit"
 	"easelect/backend/core_components/general_tables/table_folders"
+	"easelect/backend/core_components/general_tables/trash"
 	gt_triggers "easelect/backend/core_components/general_tables/triggers"
 	lang "easelect/backend/core_components/lang"
 	"easelect/backend/core_components/middlewares"
@@ -133,6 +136,10 @@ func RegisterRoutes(frontendDir string, mediaPath string) {
 	functionRegisterHandler("/api/add-row-multipart", gt_1_row_create.AddRowMultipartHandlerWrapper, "gt_1_row_create.AddRowMultipartHandlerWrapper")
 	//get-add-row-metadata
 	functionRegisterHandler("/api/get-add-row-metadata", gt_1_row_create.GetAddRowMetadataHandlerWrapper, "gt_1_row_create.GetAddRowMetadataHandlerWrapper")
+	// Taulukkotuonti (CSV/XLSX): lataus, esikatselu, tallennus
+	functionRegisterHandler("/api/import-upload", gt_1_row_create.ImportUploadHandler, "gt_1_row_create.ImportUploadHandler")
+	functionRegisterHandler("/api/import-preview", gt_1_row_create.ImportPreviewHandler, "gt_1_row_create.ImportPreviewHandler")
+	functionRegisterHandler("/api/import-commit", gt_1_row_create.ImportCommitHandler, "gt_1_row_create.ImportCommitHandler")
 
 	functionRegisterHandler("/api/geocode-address", gt_1_row_create.GeocodeAddressHandler, "gt_1_row_create.GeocodeAddressHandler")
 	// --------------------------------------------------------------
@@ -140,19 +147,34 @@ func RegisterRoutes(frontendDir string, mediaPath string) {
 	// gt_-funktiot aakkosjärjestyksessä (muu sisältö)
 	functionRegisterHandler("/api/delete-rows", gt_1_row_delete.DeleteRowsHandlerWrapper, "gt_1_row_delete.DeleteRowsHandlerWrapper")
 	functionRegisterHandler("/api/drop-table", gt_3_table_delete.DropTableHandler, "gt_3_table_delete.DropTableHandler")
+	functionRegisterHandler("/api/export-results", gt_1_row_read.ExportResultsHandler, "gt_1_row_read.ExportResultsHandler")
 	functionRegisterHandler("/api/fetch-dynamic-children", gt_1_row_read.GetDynamicChildItemsHandler, "gt_1_row_read.GetDynamicChildItemsHandler")
+	functionRegisterHandler("/api/get-aggregates", gt_1_row_read.GetAggregatesHandler, "gt_1_row_read.GetAggregatesHandler")
+	functionRegisterHandler("/api/get-facets", gt_1_row_read.GetFacetsHandler, "gt_1_row_read.GetFacetsHandler")
+	functionRegisterHandler("/api/get-geo", gt_1_row_read.GetGeoHandler, "gt_1_row_read.GetGeoHandler")
+	functionRegisterHandler("/api/get-geo-clusters", gt_1_row_read.GetGeoClustersHandler, "gt_1_row_read.GetGeoClustersHandler")
 	functionRegisterHandler("/api/get-metadata", gt_3_table_read.GetTableViewHandlerWrapper, "gt_3_table_read.GetTableViewHandlerWrapper")
 	functionRegisterHandler("/api/get-results", gt_1_row_read.GetResultsHandlerWrapper, "gt_1_row_read.GetResultsHandlerWrapper")
 	functionRegisterHandler("/api/get-intelligent-results", gt_1_row_read.GetIntelligentResultsHandlerWrapper, "gt_1_row_read.GetIntelligentResultsHandlerWrapper")
+	functionRegisterHandler("/api/ranking-profile", gt_1_row_read.RankingProfileHandler, "gt_1_row_read.RankingProfileHandler")
 
 	functionRegisterHandler("/api/get-results-vector", gt_1_row_read.GetResultsVector, "gt_1_row_read.GetResultsVector")
+	functionRegisterHandler("/api/get-row", gt_1_row_read.GetRowHandler, "gt_1_row_read.GetRowHandler")
 	functionRegisterHandler("/api/get-row-count", gt_1_row_read.GetRowCountHandlerWrapper, "gt_1_row_read.GetRowCountHandlerWrapper")
 	functionRegisterHandler("/api/system_triggers/create", gt_triggers.CreateTriggerHandler, "gt_triggers.CreateTriggerHandler")
 	functionRegisterHandler("/api/system_triggers/list", gt_triggers.GetTriggersHandler, "gt_triggers.GetTriggersHandler")
 	functionRegisterHandler("/api/table-columns/", gt_2_column_crud.GetTableColumnsHandler, "gt_2_column_crud.GetTableColumnsHandler")
+	functionRegisterHandler("/api/revert-row-change", gt_1_row_update.RevertRowChangeHandler, "gt_1_row_update.RevertRowChangeHandler")
+	functionRegisterHandler("/api/row-history", row_audit.GetRowHistoryHandler, "row_audit.GetRowHistoryHandler")
+	functionRegisterHandler("/api/row-history/as-of", row_audit.GetRowAsOfHandler, "row_audit.GetRowAsOfHandler")
+	functionRegisterHandler("/api/trash", trash.GetTrashHandler, "trash.GetTrashHandler")
+	functionRegisterHandler("/api/trash/restore", trash.RestoreTrashHandler, "trash.RestoreTrashHandler")
+	functionRegisterHandler("/api/trash/purge", trash.PurgeTrashHandler, "trash.PurgeTrashHandler")
 	functionRegisterHandler("/api/update-row", gt_1_row_update.UpdateRowHandlerWrapper, "gt_1_row_update.UpdateRowHandlerWrapper")
+	functionRegisterHandler("/api/update-rows", gt_1_row_update.UpdateRowsHandler, "gt_1_row_update.UpdateRowsHandler")
 
 	// Muut reitit aakkosjärjestyksessä
+	functionRegisterHandler("/api/change-feed", change_feed.ChangeFeedHandler, "change_feed.ChangeFeedHandler")
 	functionRegisterHandler("/api/modify-columns", crud_workflows.ModifyColumnsHandler, "crud_workflows.ModifyColumnsHandler")
 	functionRegisterHandler("/api/refresh_file_structure", refresh_file_structure.RefreshFileStructureHandler, "refresh_file_structure.RefreshFileStructureHandler")
 	functionRegisterHandler("/api/translations", lang.GetTranslationsHandler, "lang.GetTranslationsHandler")
@@ -5,6 +5,8 @@ import (
     "encoding/json"
     "log"
     "net/http"
+
+    "easelect/backend/core_components/metadata_cache"
 )
 
 func SaveUserGroupRight(w http.ResponseWriter, r *http.Request) {
@@ -39,6 +41,7 @@ func SaveUserGroupRight(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "Virhe tallennettaessa oikeutta", http.StatusInternalServerError)
         return
     }
+    metadata_cache.Invalidate(metadata_cache.KindPermission)
 
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{
@@ -0,0 +1,32 @@
+package e_sessions
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	backend "easelect/backend/core_components"
+)
+
+// GetRoleDbFromSession valitsee sessiossa olevan user_role:n perusteella
+// tietokantayhteyden. Tuntematon tai puuttuva rooli saa guest-yhteyden.
+func GetRoleDbFromSession(r *http.Request) (*sql.DB, error) {
+	session, err := GetStore().Get(r, "session")
+	if err != nil {
+		return nil, fmt.Errorf("session get failed: %w", err)
+	}
+	userRole, _ := session.Values["user_role"].(string)
+	return RoleDb(userRole), nil
+}
+
+// RoleDb palauttaa roolin tietokantayhteyden (admin, basic, muut guest).
+func RoleDb(userRole string) *sql.DB {
+	switch userRole {
+	case "admin":
+		return backend.DbAdmin
+	case "basic":
+		return backend.DbBasic
+	default:
+		return backend.DbGuest
+	}
+}
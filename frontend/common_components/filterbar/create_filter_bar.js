@@ -22,6 +22,7 @@ let filterBarVisible = true;
  * Palauttaa:
  *   {
  *     rowCount:    number|null,
+ *     estimated:   boolean,            // rivimäärä on arvio (suuri taulu)
  *     hasGeo:      boolean,            // onko suoria tai FK-geom-viitteitä
  *     geomColumns: string[],           // tämän taulun geometry-sarakkeet
  *     geomSources: string[],           // viittauksen päässä olevat taulut, joissa geometry
@@ -45,13 +46,21 @@ async function fetchTableMeta(tableName) {
 
         const {
             row_count: rowCount,
+            estimated = false,
             has_geo: hasGeo = false,
             geom_columns: geomColumns = [],
             geom_sources: geomSources = [],
+            primary_key: primaryKey = null,
         } = await resp.json();
 
+        // Pääavainsarakkeet talteen, jotta valittujen rivien avaimet voidaan muodostaa
+        if (Array.isArray(primaryKey) && primaryKey.length > 0) {
+            localStorage.setItem(`${tableName}_primary_key`, JSON.stringify(primaryKey));
+        }
+
         return {
             rowCount:   typeof rowCount === "number" ? rowCount : null,
+            estimated:  Boolean(estimated),
             hasGeo:     Boolean(hasGeo),
             geomColumns,
             geomSources,
@@ -60,6 +69,7 @@ async function fetchTableMeta(tableName) {
         console.error("virhe fetchTableMeta-funktiossa:", err);
         return {
             rowCount:   null,
+            estimated:  false,
             hasGeo:     false,
             geomColumns: [],
             geomSources: [],
@@ -691,13 +701,14 @@ export function create_filter_bar(tableName, columns, dataTypes, currentView) {
     /* ---------- 2a) HAE METATIEDOT yhdellä kutsulla ---------- */
     fetchTableMeta(tableName)
         .then((meta) => {
-            const { rowCount, hasGeo } = meta;
+            const { rowCount, estimated, hasGeo } = meta;
             // Talleta cacheen → shouldRenderLocationCheckbox käyttää
             tableMetaCache[tableName] = meta;
 
             /* ----- Näytä rivimäärä ----- */
             const theNumber = rowCount ?? "?";
-            rowCountElement.textContent = `${theNumber} `;
+            // Suurten taulujen rivimäärä on arvio
+            rowCountElement.textContent = estimated ? `~${theNumber} ` : `${theNumber} `;
             const resultsText = document.createElement("span");
             resultsText.setAttribute(
                 "data-lang-key",
@@ -7,6 +7,11 @@ import {
 } from "../../../../common_components/modal/modal_factory.js";
 import { refreshTableUnified } from "../gt_1_2_row_read/table_refresh_collector.js";
 import { createVanillaDropdown } from "../../../../common_components/vanilla_dropdown/vanilla_dropdown.js";
+import {
+    validate_row,
+    show_field_errors,
+    clear_field_errors,
+} from "./validation_rules.js";
 
 var debug = true;
 
@@ -52,6 +57,19 @@ export async function open_add_row_modal(table_name) {
     if (!oneToManyRelations) oneToManyRelations = [];
     if (!manyToManyInfos) manyToManyInfos = [];
 
+    // Validointisäännöt ja oletusarvot: lomake tarkistaa samat säännöt kuin palvelin
+    const add_row_metadata = await fetchAddRowMetadata(table_name);
+    const validation_rules = { ...(add_row_metadata.validationRules || {}) };
+    // Tyhjäksi jätetty oletusarvolausekkeen sarake täytetään palvelimella ennen tarkistusta
+    for (const column_name of Object.keys(add_row_metadata.defaultExpressions || {})) {
+        if (validation_rules[column_name]) {
+            validation_rules[column_name] = {
+                ...validation_rules[column_name],
+                required: false,
+            };
+        }
+    }
+
     // 4) Rakennetaan lomake
     const form = buildMainForm(
         table_name,
@@ -60,8 +78,11 @@ export async function open_add_row_modal(table_name) {
         manyToManyInfos
     );
 
+    // 4b) Esitäytetään oletusarvolausekkeiden arvot
+    applyDefaultValues(form, add_row_metadata);
+
     // 5) Lomakkeen loppuun painikkeet ja submit
-    appendFormActions(form, table_name, columns);
+    appendFormActions(form, table_name, columns, validation_rules);
 
     // 6) Luodaan ja näytetään modaalinen ikkuna
     createModal({
@@ -97,6 +118,59 @@ async function fetchColumnsInfo(table_name) {
     }
 }
 
+/**
+ * Hakee rivinlisäysmetadatan: validointisäännöt ({ sarake: säännöt }) sekä
+ * oletusarvolausekkeet ja niiden esitäyttöarvot.
+ */
+async function fetchAddRowMetadata(table_name) {
+    try {
+        const response = await fetch(
+            `/api/get-add-row-metadata?table=${table_name}`
+        );
+        if (!response.ok) {
+            throw new Error(`http error! status: ${response.status}`);
+        }
+        return await response.json();
+    } catch (error) {
+        // Palvelin tarkistaa säännöt ja laskee oletusarvot joka tapauksessa
+        console.error(
+            `virhe rivinlisäysmetadatan haussa taululle ${table_name}:`,
+            error
+        );
+        return {};
+    }
+}
+
+/**
+ * Esitäyttää kentät oletusarvolausekkeiden arvoilla. Kentät, joita ei voi esitäyttää
+ * (esim. sekvenssit tai pudotusvalikot), saavat vihjeen: palvelin laskee arvon
+ * lisäyksessä, jos kenttä jätetään tyhjäksi.
+ */
+function applyDefaultValues(form, metadata) {
+    const default_values = metadata.defaultValues || {};
+    const default_expressions = metadata.defaultExpressions || {};
+    for (const column_name of Object.keys(default_expressions)) {
+        const field = form.elements[column_name];
+        if (!field || field.type === "hidden") continue;
+        const value = default_values[column_name];
+        if (value === undefined || value === null) {
+            if ("placeholder" in field) {
+                field.placeholder = default_expressions[column_name];
+            }
+            continue;
+        }
+        if (field.type === "checkbox") {
+            field.checked = value === true || value === "true";
+        } else if (field.type === "date") {
+            field.value = String(value).slice(0, 10);
+        } else if (field.type === "datetime-local") {
+            field.value = String(value).slice(0, 16);
+        } else {
+            field.value = value;
+        }
+    }
+}
+
 async function fetchOneToManyRelations(tableName) {
     try {
         const response = await fetch(
@@ -540,8 +614,9 @@ function buildFileUploadField(fieldset, fileUploadSpec, childObjectState) {
 }
 
 /** Lisää lomakkeen alalaitaan Peruuta- ja Lisää-painikkeet */
-function appendFormActions(form, table_name, columns) {
+function appendFormActions(form, table_name, columns, validation_rules) {
     const form_actions = document.createElement("div");
+    form_actions.className = "add_row_form_actions";
     form_actions.style.display = "flex";
     form_actions.style.justifyContent = "flex-end";
     form_actions.style.marginTop = "20px";
@@ -576,7 +651,7 @@ function appendFormActions(form, table_name, columns) {
         if (!e.submitter || e.submitter !== submit_button) {
             return;
         }
-        await submit_new_row(table_name, form, columns);
+        await submit_new_row(table_name, form, columns, validation_rules);
     });
 }
 
@@ -602,8 +677,9 @@ function get_input_type(data_type) {
 }
 
 /** Lomakkeen submit: lähetetään pään data, lapsidatat ja M2M-liitokset backendille */
-async function submit_new_row(table_name, form, columns) {
+async function submit_new_row(table_name, form, columns, validation_rules) {
     const formData = new FormData();
+    const form_actions = form.querySelector(".add_row_form_actions");
 
     const mainData = {};
     columns.forEach((column) => {
@@ -614,6 +690,14 @@ async function submit_new_row(table_name, form, columns) {
         mainData[column.column_name] = value;
     });
 
+    // Sarakkeiden validointisäännöt ennen lähetystä
+    const validation_errors = validate_row(validation_rules, mainData);
+    if (validation_errors.length > 0) {
+        show_field_errors(form, validation_errors, form_actions);
+        return;
+    }
+    clear_field_errors(form);
+
     let childRowsToSend = [];
     if (
         modal_form_state["_childRowsArray"] &&
@@ -694,7 +778,14 @@ async function submit_new_row(table_name, form, columns) {
         );
 
         if (!response.ok) {
-            const error_data = await response.json();
+            const error_data = await response
+                .json()
+                .catch(() => ({ message: response.statusText }));
+            // Sääntövirheet (422) näytetään kenttien kohdalla
+            if (Array.isArray(error_data.errors)) {
+                show_field_errors(form, error_data.errors, form_actions);
+                return;
+            }
             alert(`Virhe: ${error_data.message || response.statusText}`);
             return;
         }
@@ -0,0 +1,205 @@
+// validation_rules.js
+
+// Sarakkeiden validointisäännöt (system_column_details.validation_rules) tarkistetaan
+// lomakkeella samoin kuin palvelimella (column_rules.go), jotta käyttäjä näkee virheet
+// ennen lähetystä. Palvelin tarkistaa säännöt joka tapauksessa ja palauttaa 422-virheessä
+// saman muotoiset virheet: [{ column, rule, message }].
+
+const number_pattern = /^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$/;
+const date_pattern =
+    /^\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:\d{2})?)?$/;
+
+function is_empty(value) {
+    if (value === null || value === undefined) return true;
+    if (typeof value === "string") return value.trim() === "";
+    return false;
+}
+
+function to_number(value) {
+    if (typeof value === "number") return Number.isFinite(value) ? value : null;
+    if (typeof value === "string" && number_pattern.test(value.trim())) {
+        return parseFloat(value.trim());
+    }
+    return null;
+}
+
+function to_time(value) {
+    if (typeof value !== "string" || !date_pattern.test(value.trim())) return null;
+    let text = value.trim().replace(" ", "T");
+    // Ilman aikavyöhykettä tulkitaan UTC:nä kuten palvelimella
+    if (text.length === 10) {
+        text += "T00:00:00Z";
+    } else if (!/(Z|[+-]\d{2}:\d{2})$/.test(text)) {
+        text += "Z";
+    }
+    const time = Date.parse(text);
+    return Number.isNaN(time) ? null : time;
+}
+
+function to_text(value) {
+    if (value === null || value === undefined) return "";
+    return String(value);
+}
+
+/** Vertaa arvoja lukuina, päivämäärinä tai tekstinä; null, jos vertailu ei onnistu. */
+function compare_values(a, b) {
+    const num_a = to_number(a);
+    const num_b = to_number(b);
+    if (num_a !== null && num_b !== null) {
+        return Math.sign(num_a - num_b);
+    }
+    const time_a = to_time(a);
+    const time_b = to_time(b);
+    if (time_a !== null && time_b !== null) {
+        return Math.sign(time_a - time_b);
+    }
+    if (typeof a === "string" && typeof b === "string") {
+        return a < b ? -1 : a > b ? 1 : 0;
+    }
+    if (typeof a === "boolean" && typeof b === "boolean" && a === b) {
+        return 0;
+    }
+    return null;
+}
+
+function compare_holds(cmp, op) {
+    switch (op) {
+        case "=":
+            return cmp === 0;
+        case "!=":
+            return cmp !== 0;
+        case "<":
+            return cmp < 0;
+        case "<=":
+            return cmp <= 0;
+        case ">":
+            return cmp > 0;
+        case ">=":
+            return cmp >= 0;
+        default:
+            return false;
+    }
+}
+
+function validate_column(column, rules, row) {
+    const errors = [];
+    const value = row[column];
+    const fail = (rule, default_message) => {
+        errors.push({ column, rule, message: rules.message || default_message });
+    };
+
+    if (is_empty(value)) {
+        if (rules.required) {
+            fail("required", "kenttä on pakollinen");
+        }
+        return errors;
+    }
+
+    const text = to_text(value);
+    if (rules.pattern) {
+        try {
+            if (!new RegExp(rules.pattern).test(text)) {
+                fail("pattern", "arvo ei ole vaaditussa muodossa");
+            }
+        } catch (error) {
+            console.error(`virheellinen pattern sarakkeelle ${column}:`, error);
+        }
+    }
+    const length = [...text].length;
+    if (rules.min_length != null && length < rules.min_length) {
+        fail("min_length", `arvon on oltava vähintään ${rules.min_length} merkkiä`);
+    }
+    if (rules.max_length != null && length > rules.max_length) {
+        fail("max_length", `arvo saa olla enintään ${rules.max_length} merkkiä`);
+    }
+    if (rules.min != null) {
+        const cmp = compare_values(value, rules.min);
+        if (cmp === null || cmp < 0) {
+            fail("min", `arvon on oltava vähintään ${rules.min}`);
+        }
+    }
+    if (rules.max != null) {
+        const cmp = compare_values(value, rules.max);
+        if (cmp === null || cmp > 0) {
+            fail("max", `arvo saa olla enintään ${rules.max}`);
+        }
+    }
+    if (
+        Array.isArray(rules.allowed_values) &&
+        rules.allowed_values.length > 0 &&
+        !rules.allowed_values.some((allowed) => to_text(allowed) === text)
+    ) {
+        fail("allowed_values", "arvo ei ole sallittujen arvojen joukossa");
+    }
+
+    for (const compare of rules.compare || []) {
+        const other = row[compare.column];
+        if (is_empty(other)) continue;
+        const cmp = compare_values(value, other);
+        if (cmp !== null && compare_holds(cmp, compare.op)) continue;
+        errors.push({
+            column,
+            rule: "compare",
+            message:
+                compare.message ||
+                `arvon on oltava ${compare.op} sarakkeen ${compare.column} arvo`,
+        });
+    }
+    return errors;
+}
+
+/**
+ * Tarkistaa lisättävän rivin säännöt. rules on get-add-row-metadatan validationRules
+ * ({ sarake: säännöt }). Palauttaa virheet sarakkeiden aakkosjärjestyksessä.
+ */
+export function validate_row(rules, row) {
+    if (!rules) return [];
+    const errors = [];
+    for (const column of Object.keys(rules).sort()) {
+        errors.push(...validate_column(column, rules[column], row));
+    }
+    return errors;
+}
+
+/** Poistaa lomakkeelta aiemmin näytetyt validointivirheet. */
+export function clear_field_errors(form) {
+    form.querySelectorAll(".validation_error").forEach((el) => el.remove());
+    form.querySelectorAll(".validation_error_field").forEach((el) => {
+        el.classList.remove("validation_error_field");
+        el.style.outline = "";
+    });
+}
+
+/**
+ * Näyttää virheet kenttien alla. Piilokenttien (esim. pudotusvalikot) virheet
+ * näytetään lomakkeen lopussa ennen painikkeita.
+ */
+export function show_field_errors(form, errors, before_element = null) {
+    clear_field_errors(form);
+    const summary = [];
+    for (const error of errors) {
+        const field = form.elements[error.column];
+        const message = document.createElement("div");
+        message.className = "validation_error";
+        message.style.color = "var(--error_color, #c0392b)";
+        message.style.fontSize = "0.9em";
+        message.style.marginBottom = "8px";
+
+        if (field && field.type !== "hidden" && field.insertAdjacentElement) {
+            message.textContent = error.message;
+            field.classList.add("validation_error_field");
+            field.style.outline = "1px solid var(--error_color, #c0392b)";
+            field.insertAdjacentElement("afterend", message);
+        } else {
+            message.textContent = `${error.column}: ${error.message}`;
+            summary.push(message);
+        }
+    }
+    for (const message of summary) {
+        if (before_element && before_element.parentNode === form) {
+            form.insertBefore(message, before_element);
+        } else {
+            form.appendChild(message);
+        }
+    }
+}
@@ -1,6 +1,7 @@
 // edit_cell.js
 
 import { selectCell } from '../../../table_views/table_view/table_content_utils.js';
+import { get_row_key } from '../../../table_views/table_view/selection_helper.js';
 import { fetchReferencedData } from '../gt_1_1_row_create/add_row.js'; // Tarvitaan foreign key -tietojen hakemiseen
 
 export async function editCell(cell, columns, data, dataTypes, table_name) {
@@ -109,7 +110,7 @@ async function handleForeignKeyEditing(cell, columns, data, dataTypes, table_nam
             return;
         }
 
-        const id = rowData['id'];
+        const id = get_row_key(table_name, rowData);
 
         const updateData = {
             id: id,
@@ -118,7 +119,7 @@ async function handleForeignKeyEditing(cell, columns, data, dataTypes, table_nam
         };
 
         try {
-            await sendUpdateRequest(table_name, updateData);
+            await sendUpdateRequest(table_name, updateData, rowData);
 
             data[rowIndex][foreignKeyColumnName] = newValue;
             data[rowIndex][foreignKeyColumnName + '_name'] = displayValue;
@@ -140,7 +141,9 @@ async function handleForeignKeyEditing(cell, columns, data, dataTypes, table_nam
         } catch (error) {
             console.error('Error updating cell:', error);
             cell.textContent = originalContent;
-            alert('Error updating cell');
+            if (!error.conflict) {
+                alert(error.validation ? error.message : 'Error updating cell');
+            }
         } finally {
             selectCell(cell);
         }
@@ -299,7 +302,7 @@ async function handleRegularEditing(cell, columns, data, dataTypes, table_name,
             return;
         }
 
-        const id = rowData['id'];
+        const id = get_row_key(table_name, rowData);
 
         const updateData = {
             id: id,
@@ -308,14 +311,20 @@ async function handleRegularEditing(cell, columns, data, dataTypes, table_name,
         };
 
         try {
-            await sendUpdateRequest(table_name, updateData);
+            await sendUpdateRequest(table_name, updateData, rowData);
 
             data[rowIndex][columnName] = newValue;
 
         } catch (error) {
             console.error('Error updating cell:', error);
-            cell.textContent = originalContent;
-            alert('Error updating cell');
+            if (error.conflict) {
+                // Näytetään palvelimen nykyinen arvo
+                const currentValue = rowData[columnName];
+                cell.textContent = currentValue !== null && currentValue !== undefined ? currentValue : '';
+            } else {
+                cell.textContent = originalContent;
+                alert(error.validation ? error.message : 'Error updating cell');
+            }
         } finally {
             selectCell(cell);
         }
@@ -331,16 +340,42 @@ async function handleRegularEditing(cell, columns, data, dataTypes, table_name,
     });
 }
 
-async function sendUpdateRequest(table_name, updateData) {
+// Lähettää päivityksen rivin versiolla (_row_version). Jos rivi on muuttunut
+// lukemisen jälkeen (409), kysytään, korvataanko toisen käyttäjän muutos;
+// muuten rivin data päivitetään palvelimen nykyisillä arvoilla.
+async function sendUpdateRequest(table_name, updateData, rowData) {
     // const response = await fetch(`/tables/${table_name}/update`, {
     const response = await fetch(`/api/update-row?table=${table_name}`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify(updateData)
+        body: JSON.stringify({ ...updateData, version: rowData._row_version })
     });
-if (!response.ok) {
-    throw new Error('Update failed');
+    if (response.status === 409) {
+        const conflict = await response.json();
+        rowData._row_version = conflict.version;
+        const currentValue = conflict.current ? conflict.current[updateData.column] : undefined;
+        const overwrite = confirm(
+            `Toinen käyttäjä on muuttanut riviä. Nykyinen arvo: ${JSON.stringify(currentValue)}.\nKorvataanko se uudella arvolla?`
+        );
+        if (overwrite) {
+            return sendUpdateRequest(table_name, updateData, rowData);
+        }
+        Object.assign(rowData, conflict.current || {});
+        const error = new Error('Update conflict');
+        error.conflict = true;
+        throw error;
+    }
+    if (response.status === 422) {
+        // Sarakkeen validointisääntö hylkäsi arvon
+        const result = await response.json();
+        const error = new Error((result.errors || []).map((e) => e.message).join('\n') || result.message);
+        error.validation = true;
+        throw error;
+    }
+    if (!response.ok) {
+        throw new Error('Update failed');
+    }
+    const result = await response.json();
+    rowData._row_version = result.version;
+    console.log('Update successful:', result);
 }
-const result = await response.json();
-console.log('Update successful:', result);
-}
\ No newline at end of file
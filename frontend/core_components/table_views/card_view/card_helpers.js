@@ -130,16 +130,18 @@ export function format_column_name(column) {
 
 /**
  * Lähettää kerralla kortin päivittyneet sarake-arvot palvelimelle.
- * Käyttää yksitellen 'id + column + value' -formaattia.
+ * Käyttää yksitellen 'id + column + value + version' -formaattia; rivin versio
+ * (_row_version) päivitetään row_item-olioon jokaisen onnistuneen tallennuksen jälkeen.
  */
-export async function sendCardUpdates(table_name, rowId, updatedData) {
+export async function sendCardUpdates(table_name, rowId, updatedData, row_item = {}) {
     console.log(`[${table_name}] Lähetetään kortin uudet arvot, rowId=${rowId}`, updatedData);
 
     for (const [column, value] of Object.entries(updatedData)) {
         const payload = {
             id: rowId,
             column: column,
-            value: value
+            value: value,
+            version: row_item._row_version
         };
 
         try {
@@ -149,11 +151,22 @@ export async function sendCardUpdates(table_name, rowId, updatedData) {
                 body: JSON.stringify(payload)
             });
 
+            if (response.status === 409) {
+                const conflict = await response.json();
+                row_item._row_version = conflict.version;
+                throw new Error(`Row was modified by another user, column ${column} not saved`);
+            }
+            if (response.status === 422) {
+                const result = await response.json();
+                const messages = (result.errors || []).map((e) => `${e.column}: ${e.message}`).join(', ');
+                throw new Error(`Column ${column} not saved: ${messages || result.message}`);
+            }
             if (!response.ok) {
                 throw new Error(`Update failed for column ${column}: HTTP ${response.status}`);
             }
 
             const result = await response.json();
+            row_item._row_version = result.version;
             console.log(`[${table_name}] OK, sarake=${column} päivitetty, vastaus:`, result);
 
         } catch (err) {
@@ -67,7 +67,7 @@ export async function open_big_card_modal(row_item, table_name) {
             console.warn('could not parse data_types for table', table_name, err);
         }
 
-        const columns        = Object.keys(row_item);
+        const columns        = Object.keys(row_item).filter(col => col !== '_row_version');
         const sorted_columns = [...columns].sort((a, b) => {
             const rA = data_types[a]?.card_element || '';
             const rB = data_types[b]?.card_element || '';
@@ -429,7 +429,7 @@ export async function open_big_card_modal(row_item, table_name) {
                 const upd = disableEditing(card_modal_content_div);
                 if (row_item.id !== undefined) {
                     try {
-                        await sendCardUpdates(table_name, row_item.id, upd);
+                        await sendCardUpdates(table_name, row_item.id, upd, row_item);
                     } catch (err) {
                         console.error('virhe: %s', err.message);
                     }
@@ -1,7 +1,32 @@
 // selection_helper.js
 
+// Taulun pääavainsarakkeet (get-row-count tallentaa ne); oletuksena id
+function get_primary_key_columns(table_name) {
+    try {
+        const stored = JSON.parse(localStorage.getItem(`${table_name}_primary_key`));
+        if (Array.isArray(stored) && stored.length > 0) {
+            return stored;
+        }
+    } catch (e) {
+        // ei tallennettua avainta
+    }
+    return ['id'];
+}
+
+// Rivin avain päivityspyyntöön: pääavaimen arvo tai yhdistelmäavaimella olio
+export function get_row_key(table_name, row_data) {
+    const pk_columns = get_primary_key_columns(table_name);
+    if (pk_columns.length === 1) {
+        return row_data[pk_columns[0]];
+    }
+    const key = {};
+    pk_columns.forEach(col => { key[col] = row_data[col]; });
+    return key;
+}
+
 export function get_selected_ids(table_name) {
     const current_view = localStorage.getItem(`${table_name}_view`) || 'table';
+    const pk_columns = get_primary_key_columns(table_name);
     let ids = [];
 
     if (current_view === 'table') {
@@ -18,22 +43,30 @@ export function get_selected_ids(table_name) {
         }
         const columns = JSON.parse(table.dataset.columns);
     
-        const id_column_index = columns.indexOf('id');
-        if (id_column_index === -1) {
-            console.error("ID-saraketta ei löydy columns-taulukosta:", columns);
+        // Numerointi ja valinta huomioiden
+        const pk_cell_indexes = pk_columns.map(col => columns.indexOf(col) + 2);
+        if (pk_cell_indexes.some(index => index < 2)) {
+            console.error("Pääavainsaraketta ei löydy columns-taulukosta:", pk_columns, columns);
             return [];
         }
-        const id_cell_index = id_column_index + 2; // Numerointi ja valinta huomioiden
     
         selected_rows.forEach(row => {
             const cells = row.querySelectorAll('td');
-            if (cells.length > id_cell_index) {
-                const id_cell = cells[id_cell_index];
-                const id_text = id_cell.textContent;
-                const id_parsed = parseInt(id_text, 10);
-                if (!isNaN(id_parsed)) {
-                    ids.push(id_parsed);
-                }
+            if (pk_cell_indexes.some(index => cells.length <= index)) {
+                return;
+            }
+            const values = pk_cell_indexes.map(index => cells[index].textContent.trim());
+            if (values.some(value => value === '')) {
+                return;
+            }
+            // Avaimet lähetetään tekstinä: parseInt pyöristäisi yli 2^53:n bigint-avaimet
+            if (pk_columns.length === 1) {
+                ids.push(values[0]);
+            } else {
+                // Yhdistelmäavain lähetetään oliona {"sarake": arvo, ...}
+                const key = {};
+                pk_columns.forEach((col, i) => { key[col] = values[i]; });
+                ids.push(key);
             }
         });
     } else if (current_view === 'card') {
@@ -47,7 +80,7 @@ export function get_selected_ids(table_name) {
         selected_cards.forEach(card => {
             const id_from_card = card.getAttribute('data-id');
             if (id_from_card) {
-                ids.push(parseInt(id_from_card, 10));
+                ids.push(id_from_card);
             } else {
                 console.warn("Kortilla ei ole data-id-attribuuttia:", card);
             }
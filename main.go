@@ -8,12 +8,22 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	backend "easelect/backend/core_components"
 	"easelect/backend/core_components/auth"
+	"easelect/backend/core_components/embeddings"
 	"easelect/backend/core_components/general_tables"
+	"easelect/backend/core_components/general_tables/change_feed"
+	"easelect/backend/core_components/general_tables/column_defaults"
+	"easelect/backend/core_components/general_tables/column_rules"
 	"easelect/backend/core_components/general_tables/crud_workflows"
 	"easelect/backend/core_components/general_tables/foreign_keys"
+	"easelect/backend/core_components/general_tables/gt_1_row_crud/gt_1_row_create"
+	"easelect/backend/core_components/general_tables/gt_1_row_crud/gt_1_row_read"
+	"easelect/backend/core_components/general_tables/row_audit"
+	"easelect/backend/core_components/general_tables/row_policies"
+	"easelect/backend/core_components/general_tables/trash"
 	"easelect/backend/core_components/middlewares"
 	"easelect/backend/core_components/middlewares/firewall"
 	"easelect/backend/core_components/router"
@@ -57,6 +67,74 @@ func main() {
 		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
 	}
 
+	err = gt_1_row_read.EnsureLookupPathsColumn()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = column_rules.EnsureValidationRulesColumn()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = column_defaults.EnsureDefaultExpressionColumn()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = embeddings.EnsureEmbeddingModelColumns()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = embeddings.EnsureEmbeddingStagingTable()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = gt_1_row_read.EnsureM2MColumnSettingsTable()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = gt_1_row_read.EnsureRankingProfilesTable()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = gt_1_row_create.EnsureImportJobsTable()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = row_policies.EnsureRowPoliciesTable()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	err = row_audit.EnsureRowAuditTable()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
+	// Roskakori: poistetut rivit ja taulut, vanhentuneet siivotaan 6 tunnin välein
+	err = trash.EnsureTrashTable()
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	} else {
+		trash.StartTrashPurger(6 * time.Hour)
+	}
+
+	// Rivimuutosten LISTEN/NOTIFY-syöte (SSE: /api/change-feed)
+	err = change_feed.EnsureChangeFeedTriggers(backend.Db)
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+	err = change_feed.StartChangeListener(backend.DbAdminConnStr)
+	if err != nil {
+		fmt.Printf("\033[31mvirhe: %s\033[0m\n", err.Error())
+	}
+
 	// 5) Selvitetään frontendiin polku
 	exePath, err := os.Executable()
 	if err != nil {